@@ -9,10 +9,12 @@ import (
 	"log"
 	"time"
 
+	comm "github.com/citizenwallet/indexer/internal/common"
 	"github.com/citizenwallet/indexer/internal/config"
 	"github.com/citizenwallet/indexer/internal/services/db"
 	"github.com/citizenwallet/indexer/internal/services/ethrequest"
 	"github.com/citizenwallet/indexer/internal/services/firebase"
+	"github.com/citizenwallet/indexer/internal/services/signer"
 	"github.com/citizenwallet/indexer/internal/services/webhook"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	"github.com/citizenwallet/indexer/pkg/queue"
@@ -22,7 +24,7 @@ import (
 
 // @title           Citizen Wallet Indexer API
 // @version         1.0
-// @description     This is a server which handles token contract indexing, user operations, and other support functions for the app.
+// @description     This is a server which handles token contract indexing, user operations, and other support functions for the app. Error responses have an error object in their body when the request sets the X-Response-Version header to 2.
 // @termsOfService  https://citizenwallet.xyz
 
 // @contact.name   API Support
@@ -71,6 +73,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// the sponsor keys are either in the db of this process or with the signer, never both
+	err = conf.CheckSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if conf.SentryURL != "" && conf.SentryURL != "x" {
 		err = sentry.Init(sentry.ClientOptions{
 			Dsn: conf.SentryURL,
@@ -130,6 +138,11 @@ func main() {
 
 	log.Default().Println("starting internal db service...")
 
+	// the triggers that index transfer descriptions fail on inserts without fts5
+	if !db.FTS5 {
+		log.Fatal("built without fts5, build with -tags sqlite_fts5")
+	}
+
 	d, err := db.NewDB(chid, *dbpath, conf.DBSecret)
 	if err != nil {
 		log.Fatal(err)
@@ -153,21 +166,66 @@ func main() {
 
 	log.Default().Println("starting bundler service...")
 
-	op := queue.NewUserOpService(d, evm, fb)
+	var sg indexer.Signer
+	if conf.SignerURL != "" {
+		log.Default().Println("signing with remote signer...")
+		sg, err = signer.NewRemoteSigner(conf.SignerURL, conf.SignerToken, conf.SignerCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		sg = signer.NewLocalSigner(d)
+	}
 
-	useropq := queue.NewService("userop", 3, *useropqbf, ctx, w)
+	nonces := queue.NewNonceManager(evm, sg)
+
+	go func() {
+		quitAck <- nonces.Start(ctx)
+	}()
+
+	ledger := queue.NewGasLedger(d, w)
+
+	// entry points are v0.6 unless they are configured as v0.7, the configured ones are the supported entry points
+	eps, err := indexer.NewEntryPoints(conf.EntryPointsV06, conf.EntryPointsV07)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	op := queue.NewUserOpService(d, evm, fb, sg, nonces, ledger, eps)
+
+	// bundles that were sent before a restart keep their nonces until they are mined
+	err = op.Resume()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	useropq := queue.NewDurableService("userop", 3, *useropqbf, ctx, w, d.QueueDB)
 
 	go func() {
 		quitAck <- useropq.Start(op)
 	}()
 
-	api := router.NewServer(chid, evm, d)
+	// signed user operations that were scheduled are added to the queue once they are due
+	scheduler := queue.NewScheduler(d, evm, useropq, chid)
+
+	go func() {
+		quitAck <- scheduler.Start(ctx)
+	}()
+
+	// the admins manage the out of order sponsorships, the sponsor keys can stay with the signer
+	admins, err := comm.ParseAddresses(conf.Admins)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	api := router.NewServer(chid, evm, d, sg, eps, admins)
 
 	go func() {
 		router := api.CreateBaseRouter()
 
 		api.AddMiddleware(router)
 		api.AddBundlerRoutes(router, useropq)
+		api.AddSubscriptionRoutes(router)
 
 		if *port == 443 {
 			quitAck <- api.StartTLS(*certpath, router)
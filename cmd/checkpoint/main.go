@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/config"
+	"github.com/citizenwallet/indexer/internal/services/db"
+)
+
+func main() {
+	log.Default().Println("creating balance checkpoint...")
+
+	chainId := flag.Int("chain", 1, "chain id")
+
+	token := flag.String("token", "", "token address")
+
+	tokenId := flag.Int64("tokenId", 0, "token id")
+
+	at := flag.String("at", "", "date of the checkpoint (RFC3339, default: start of today)")
+
+	env := flag.String("env", "", "path to .env file")
+
+	confpath := flag.String("confpath", "./config", "path to config file")
+
+	dbpath := flag.String("dbpath", ".", "path to db")
+
+	flag.Parse()
+
+	if token == nil || *token == "" {
+		log.Fatal("token is required")
+	}
+
+	cpAt := time.Now().UTC().Truncate(24 * time.Hour)
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cpAt = t.UTC()
+	}
+
+	chid := big.NewInt(int64(*chainId))
+
+	ctx := context.Background()
+
+	conf, err := config.New(ctx, *env, *confpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d, err := db.NewDB(chid, *dbpath, conf.DBSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	tdb, ok := d.GetTransferDB(*token)
+	if !ok {
+		log.Fatal("no transfer db for token: ", *token)
+	}
+
+	err = tdb.CreateBalanceCheckpoint(*tokenId, cpAt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Default().Println("balance checkpoint created at: ", cpAt.Format(time.RFC3339))
+}
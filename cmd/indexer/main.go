@@ -105,6 +105,11 @@ func main() {
 
 	log.Default().Println("starting internal db service...")
 
+	// the triggers that index transfer descriptions fail on inserts without fts5
+	if !db.FTS5 {
+		log.Fatal("built without fts5, build with -tags sqlite_fts5")
+	}
+
 	d, err := db.NewDB(chid, *dbpath, conf.DBSecret)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/config"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+func main() {
+	chainId := flag.Int("chain", 1, "chain id")
+
+	paymaster := flag.String("paymaster", "", "paymaster address")
+
+	action := flag.String("action", "get", "what to do with the sponsorship policy of the paymaster: set, get or remove")
+
+	file := flag.String("file", "", "path to the json policy to set")
+
+	env := flag.String("env", "", "path to .env file")
+
+	confpath := flag.String("confpath", "./config", "path to config file")
+
+	dbpath := flag.String("dbpath", ".", "path to db")
+
+	flag.Parse()
+
+	if paymaster == nil || *paymaster == "" {
+		log.Fatal("paymaster is required")
+	}
+
+	pm := ethcommon.HexToAddress(*paymaster).Hex()
+
+	ctx := context.Background()
+
+	conf, err := config.New(ctx, *env, *confpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d, err := db.NewDB(big.NewInt(int64(*chainId)), *dbpath, conf.DBSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	switch *action {
+	case "set":
+		if *file == "" {
+			log.Fatal("file is required")
+		}
+
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var policy indexer.Policy
+		err = json.Unmarshal(b, &policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+
+		policy.Contract = pm
+		policy.CreatedAt = now
+		policy.UpdatedAt = now
+
+		err = policy.Validate()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = d.PolicyDB.SetPolicy(&policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Default().Println("set the sponsorship policy of: ", pm)
+	case "get":
+		policy, err := d.PolicyDB.GetPolicy(pm)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Default().Println(string(b))
+	case "remove":
+		err = d.PolicyDB.RemovePolicy(pm)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Default().Println("removed the sponsorship policy of: ", pm)
+	default:
+		log.Fatal("unsupported action (must be one of: set, get, remove)")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/citizenwallet/indexer/internal/config"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/internal/services/signer"
+)
+
+func main() {
+	log.Default().Println("launching signer...")
+
+	chainId := flag.Int("chain", 1, "chain id")
+
+	env := flag.String("env", "", "path to .env file")
+
+	confpath := flag.String("confpath", "./config", "path to config file")
+
+	dbpath := flag.String("dbpath", ".", "path to db")
+
+	addr := flag.String("addr", "127.0.0.1", "address to listen on, only processes on this machine can reach the signer by default")
+
+	port := flag.Int("port", 3002, "port to listen on")
+
+	certpath := flag.String("certpath", "", "cert folder path with fullchain.pem and privkey.pem, required unless the signer listens on a loopback address")
+
+	flag.Parse()
+
+	ctx := context.Background()
+
+	conf, err := config.New(ctx, *env, *confpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if conf.SignerToken == "" {
+		log.Fatal("SIGNER_TOKEN is required")
+	}
+
+	if conf.DBSecret == "" {
+		log.Fatal("DB_SECRET is required")
+	}
+
+	// the token and the signatures should not cross the network in plain text
+	if *certpath == "" && !signer.IsLoopback(*addr) {
+		log.Fatal("certpath is required when the signer listens on ", *addr)
+	}
+
+	d, err := db.NewDB(big.NewInt(int64(*chainId)), *dbpath, conf.DBSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(*addr, strconv.Itoa(*port)),
+		Handler: signer.NewHandler(signer.NewLocalSigner(d), conf.SignerToken),
+	}
+
+	log.Default().Println("listening on: ", server.Addr)
+
+	if *certpath == "" {
+		log.Fatal(server.ListenAndServe())
+	}
+
+	certFile := fmt.Sprintf("%s/fullchain.pem", *certpath)
+	keyFile := fmt.Sprintf("%s/privkey.pem", *certpath)
+
+	server.TLSConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			crt, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			return &crt, err
+		},
+	}
+
+	log.Fatal(server.ListenAndServeTLS("", ""))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/config"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/internal/services/ethrequest"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func main() {
+	chainId := flag.Int("chain", 1, "chain id")
+
+	paymaster := flag.String("paymaster", "", "paymaster address")
+
+	action := flag.String("action", "balance", "what to do with the submitter keys of the paymaster: add, retire or balance")
+
+	address := flag.String("address", "", "address of the key to retire")
+
+	key := flag.String("key", "", "hex private key to add (default: a new key is generated)")
+
+	min := flag.String("min", "0", "balance in wei below which a key is reported as low")
+
+	env := flag.String("env", "", "path to .env file")
+
+	confpath := flag.String("confpath", "./config", "path to config file")
+
+	dbpath := flag.String("dbpath", ".", "path to db")
+
+	flag.Parse()
+
+	if paymaster == nil || *paymaster == "" {
+		log.Fatal("paymaster is required")
+	}
+
+	pm := ethcommon.HexToAddress(*paymaster).Hex()
+
+	chid := big.NewInt(int64(*chainId))
+
+	ctx := context.Background()
+
+	conf, err := config.New(ctx, *env, *confpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if conf.DBSecret == "" {
+		log.Fatal("DB_SECRET is required")
+	}
+
+	d, err := db.NewDB(chid, *dbpath, conf.DBSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	switch *action {
+	case "add":
+		hexKey := strings.TrimPrefix(*key, "0x")
+		if hexKey == "" {
+			hexKey, _, err = indexer.GenerateHexPrivateKey()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		privateKey, err := common.HexToPrivateKey(hexKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		keyAddress := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+
+		err = d.SponsorDB.AddSponsorKey(&indexer.SponsorKey{
+			Contract:   pm,
+			Address:    keyAddress,
+			PrivateKey: hexKey,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Default().Println("added submitter key, fund it before it submits bundles: ", keyAddress)
+	case "retire":
+		if *address == "" {
+			log.Fatal("address is required")
+		}
+
+		err = d.SponsorDB.RetireSponsorKey(pm, ethcommon.HexToAddress(*address).Hex(), now)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Default().Println("retired submitter key: ", ethcommon.HexToAddress(*address).Hex())
+	case "balance":
+		minBalance, ok := new(big.Int).SetString(*min, 10)
+		if !ok {
+			log.Fatal("invalid min balance: ", *min)
+		}
+
+		evm, err := ethrequest.NewEthService(ctx, conf.RPCURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer evm.Close()
+
+		keys, err := d.SponsorDB.GetSponsorKeys(pm, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(keys) == 0 {
+			log.Default().Println("no submitter keys, bundles are submitted by the sponsor key")
+		}
+
+		for _, k := range keys {
+			balance, err := evm.BalanceAt(ctx, ethcommon.HexToAddress(k.Address), nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			status := "active"
+			if k.Retired {
+				status = "retired"
+			} else if balance.Cmp(minBalance) < 0 {
+				status = "active, low balance"
+			}
+
+			log.Default().Printf("%s: %s wei (%s)\n", k.Address, balance.String(), status)
+		}
+	default:
+		log.Fatal("unsupported action (must be one of: add, retire, balance)")
+	}
+}
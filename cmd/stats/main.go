@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/big"
+
+	"github.com/citizenwallet/indexer/internal/config"
+	"github.com/citizenwallet/indexer/internal/services/db"
+)
+
+func main() {
+	log.Default().Println("rebuilding stats...")
+
+	chainId := flag.Int("chain", 1, "chain id")
+
+	token := flag.String("token", "", "token address")
+
+	env := flag.String("env", "", "path to .env file")
+
+	confpath := flag.String("confpath", "./config", "path to config file")
+
+	dbpath := flag.String("dbpath", ".", "path to db")
+
+	flag.Parse()
+
+	if token == nil || *token == "" {
+		log.Fatal("token is required")
+	}
+
+	chid := big.NewInt(int64(*chainId))
+
+	ctx := context.Background()
+
+	conf, err := config.New(ctx, *env, *confpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d, err := db.NewDB(chid, *dbpath, conf.DBSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	sdb, ok := d.GetStatsDB(*token)
+	if !ok {
+		log.Fatal("no stats db for token: ", *token)
+	}
+
+	err = sdb.RebuildStats()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Default().Println("stats rebuilt")
+}
@@ -24,9 +24,981 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/balances/{token_address}/{acc_addr}": {
+            "get": {
+                "description": "get the balance of an account at a block or date, computed from the indexed transfers",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "balances"
+                ],
+                "summary": "Fetch a historical balance",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the account",
+                        "name": "acc_addr",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Block number or date (RFC3339), defaults to now",
+                        "name": "at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/gas": {
+            "get": {
+                "description": "get the gas that the bundles of each paymaster used and what it cost between two days. The request should be signed by a sponsor, only its paymasters are included.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "gas"
+                ],
+                "summary": "Fetch gas spend of the paymasters of a sponsor",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "First day of the report (2006-01-02), defaults to the start of the month",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last day of the report (2006-01-02), defaults to today",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/gas/{pm_address}": {
+            "get": {
+                "description": "get the gas that the bundles of a paymaster used and what it cost between two days, by day or by account. The request should be signed by the sponsor of the paymaster.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "gas"
+                ],
+                "summary": "Fetch gas spend of a paymaster",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Paymaster Contract Address",
+                        "name": "pm_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Grouping of the report (day or account)",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include the ops of an account",
+                        "name": "account",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First day of the report (2006-01-02), defaults to the start of the month",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last day of the report (2006-01-02), defaults to today",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/graphql": {
+            "post": {
+                "description": "query tokens, transfers, accounts, profiles and balances in a single request\nthe schema can be introspected, deep or large queries are rejected",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "graphql"
+                ],
+                "summary": "GraphQL",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Query, for GET requests",
+                        "name": "query",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operation name, for GET requests",
+                        "name": "operationName",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Variables as json, for GET requests",
+                        "name": "variables",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/holders/{token_address}": {
+            "get": {
+                "description": "get the accounts that held a token at a block or date sorted by balance, computed from the indexed transfers",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "balances"
+                ],
+                "summary": "Fetch token holders",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Block number or date (RFC3339), defaults to now",
+                        "name": "at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sort by balance (desc or asc), defaults to desc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/holders/{token_address}/distribution": {
+            "get": {
+                "description": "get the top holders of a token and how it is distributed: holder count, median balance, gini coefficient and share of the top 10",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "balances"
+                ],
+                "summary": "Fetch token distribution",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Amount of top holders to return, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/logs/transfers/{token_address}/{acc_addr}": {
             "get": {
-                "description": "get transfer logs for a given token and account",
+                "description": "get transfer logs for a given token and account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "logs"
+                ],
+                "summary": "Fetch transfer logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the account",
+                        "name": "acc_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Direction of the transfers (in or out)",
+                        "name": "direction",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the other side of the transfers",
+                        "name": "counterparty",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Minimum value of the transfers",
+                        "name": "minValue",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Maximum value of the transfers",
+                        "name": "maxValue",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated list of statuses",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include transfers after this date (RFC3339)",
+                        "name": "startDate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include transfers before this date (RFC3339)",
+                        "name": "endDate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Text that the description should contain",
+                        "name": "description",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/v2/transfers/{token_address}/search": {
+            "get": {
+                "description": "search the descriptions of transfer logs for a given token, best matches first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "logs"
+                ],
+                "summary": "Search transfer logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Text to search for in descriptions",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/v2/transfers/{token_address}/{acc_addr}/export": {
+            "get": {
+                "description": "export the successful transfers of an account between two dates with a running balance and totals\namounts are formatted with the decimals of the token, the rows are streamed as they are read",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/json"
+                ],
+                "tags": [
+                    "logs"
+                ],
+                "summary": "Export an account statement",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the account",
+                        "name": "acc_addr",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "csv (default), ndjson or json",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the statement (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the statement (RFC3339), defaults to now",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signed request body",
+                        "name": "body",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signature of the body",
+                        "name": "X-Signature",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the account",
+                        "name": "X-Address",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/v2/transfers/{token_address}/{acc_addr}/search": {
+            "get": {
+                "description": "search the descriptions of transfer logs for a given token and account, best matches first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "logs"
+                ],
+                "summary": "Search transfer logs of an account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the account",
+                        "name": "acc_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Text to search for in descriptions",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/v2/transfers/{token_address}/{acc_addr}/stream": {
+            "get": {
+                "description": "stream the transfers of an account as server-sent events as soon as they are written, including status changes\nreconnecting clients receive the events they missed by sending the Last-Event-ID header\na truncated event tells which missed transfers were not sent again when they are no longer in memory",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "logs"
+                ],
+                "summary": "Stream transfer logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Address of the account",
+                        "name": "acc_addr",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Id of the last event that was received",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/scheduled/{pm_address}/{acc_addr}": {
+            "get": {
+                "description": "get the user operations that an account scheduled with a paymaster, newest first. The request should be signed by the account.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "userops"
+                ],
+                "summary": "Fetch scheduled user operations of an account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Paymaster Contract Address",
+                        "name": "pm_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Account Address",
+                        "name": "acc_addr",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include the ops with a status (scheduled, submitted, cancelled or failed)",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Offset",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/scheduled/{pm_address}/{acc_addr}/{hash}": {
+            "delete": {
+                "description": "cancel a user operation that an account scheduled, as long as it was not submitted. The request should be signed by the account.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "userops"
+                ],
+                "summary": "Cancel a scheduled user operation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Paymaster Contract Address",
+                        "name": "pm_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Account Address",
+                        "name": "acc_addr",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User Operation Hash",
+                        "name": "hash",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/sponsorships/{pm_address}": {
+            "get": {
+                "description": "get the signatures that pm_ooSponsorUserOperation issued for a paymaster, newest first. The request should be signed by an admin.",
                 "consumes": [
                     "application/json"
                 ],
@@ -34,23 +1006,244 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "logs"
+                    "sponsorships"
                 ],
-                "summary": "Fetch transfer logs",
+                "summary": "Fetch out of order sponsorships of a paymaster",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Token Contract Address",
-                        "name": "token_address",
+                        "description": "Paymaster Contract Address",
+                        "name": "pm_address",
                         "in": "path",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Address of the account",
-                        "name": "acc_address",
+                        "description": "Only include the sponsorships of a batch",
+                        "name": "batch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include the sponsorships of an account",
+                        "name": "account",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include the sponsorship of a nonce key",
+                        "name": "nonce_key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only include the sponsorships with a status (outstanding, used, revoked or expired)",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Offset",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/sponsorships/{pm_address}/expire": {
+            "post": {
+                "description": "end the validity of the outstanding sponsorships that match a batch, an account or a nonce key now, and of the used ones whose op was not sent yet. The request should be signed by an admin.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sponsorships"
+                ],
+                "summary": "Expire out of order sponsorships of a paymaster",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Paymaster Contract Address",
+                        "name": "pm_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/sponsorships/{pm_address}/revoke": {
+            "post": {
+                "description": "revoke the outstanding sponsorships that match a batch, an account or a nonce key, and the used ones whose op was not sent yet. The bundler rejects their ops. The request should be signed by an admin.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sponsorships"
+                ],
+                "summary": "Revoke out of order sponsorships of a paymaster",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Paymaster Contract Address",
+                        "name": "pm_address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/{token_address}": {
+            "get": {
+                "description": "get aggregated stats of a token for every day or hour of a window",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "stats"
+                ],
+                "summary": "Fetch token stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Contract Address",
+                        "name": "token_address",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Size of the buckets (day or hour)",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the window (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the window (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -61,23 +1254,125 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Bad Request"
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
                     },
                     "404": {
-                        "description": "Not Found"
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/ws": {
+            "get": {
+                "description": "upgrade to a websocket connection on which transfers, user operation status updates and indexer progress can be subscribed to\nsubscriptions to the user operations of an account must be signed by the account",
+                "tags": [
+                    "ws"
+                ],
+                "summary": "Subscribe to updates",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Set to 2 to receive an error object in the body of error responses",
+                        "name": "X-Response-Version",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
                     },
                     "500": {
-                        "description": "Internal Server Error"
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/common.Response"
+                        }
                     }
                 }
             }
         }
     },
     "definitions": {
+        "common.ErrorCode": {
+            "type": "string",
+            "enum": [
+                "bad_request",
+                "invalid_param",
+                "invalid_address",
+                "missing_signature",
+                "invalid_signature",
+                "expired_signature",
+                "unauthorized",
+                "not_found",
+                "token_not_found",
+                "conflict",
+                "upstream_error",
+                "internal_error"
+            ],
+            "x-enum-comments": {
+                "ErrorCodeBadRequest": "the request could not be parsed",
+                "ErrorCodeConflict": "the resource already exists",
+                "ErrorCodeExpiredSignature": "the signed body has expired",
+                "ErrorCodeInvalidAddress": "an address is not a valid hex address",
+                "ErrorCodeInvalidParam": "a path or query param is invalid, details contains the param",
+                "ErrorCodeInvalidSignature": "the signature does not match the body and the address",
+                "ErrorCodeMissingSignature": "the signature or the address header is missing",
+                "ErrorCodeNotFound": "the resource does not exist",
+                "ErrorCodeTokenNotFound": "the token is not indexed, there is no table for it",
+                "ErrorCodeUnauthorized": "the caller is not allowed to access the resource",
+                "ErrorCodeUpstream": "a request to the chain or to another service failed"
+            },
+            "x-enum-varnames": [
+                "ErrorCodeBadRequest",
+                "ErrorCodeInvalidParam",
+                "ErrorCodeInvalidAddress",
+                "ErrorCodeMissingSignature",
+                "ErrorCodeInvalidSignature",
+                "ErrorCodeExpiredSignature",
+                "ErrorCodeUnauthorized",
+                "ErrorCodeNotFound",
+                "ErrorCodeTokenNotFound",
+                "ErrorCodeConflict",
+                "ErrorCodeUpstream",
+                "ErrorCodeInternal"
+            ]
+        },
+        "common.ErrorObject": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "$ref": "#/definitions/common.ErrorCode"
+                },
+                "details": {},
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
         "common.Response": {
             "type": "object",
             "properties": {
                 "array": {},
+                "error": {
+                    "$ref": "#/definitions/common.ErrorObject"
+                },
                 "meta": {},
                 "object": {},
                 "response_type": {
@@ -95,12 +1390,14 @@ const docTemplate = `{
             "enum": [
                 "object",
                 "array",
-                "secure"
+                "secure",
+                "error"
             ],
             "x-enum-varnames": [
                 "ResponseTypeObject",
                 "ResponseTypeArray",
-                "ResponseTypeSecure"
+                "ResponseTypeSecure",
+                "ResponseTypeError"
             ]
         }
     },
@@ -122,7 +1419,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{},
 	Title:            "Citizen Wallet Indexer API",
-	Description:      "This is a server which handles token contract indexing, user operations, and other support functions for the app.",
+	Description:      "This is a server which handles token contract indexing, user operations, and other support functions for the app. Error responses have an error object in their body when the request sets the X-Response-Version header to 2.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
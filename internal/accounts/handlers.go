@@ -3,7 +3,6 @@ package accounts
 import (
 	"bytes"
 	"context"
-	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
 	"math/big"
@@ -20,7 +19,6 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/go-chi/chi/v5"
 )
@@ -29,12 +27,15 @@ type Service struct {
 	evm indexer.EVMRequester
 
 	db *db.DB
+
+	signer indexer.Signer
 }
 
-func NewService(evm indexer.EVMRequester, db *db.DB) *Service {
+func NewService(evm indexer.EVMRequester, db *db.DB, signer indexer.Signer) *Service {
 	return &Service{
-		evm: evm,
-		db:  db,
+		evm:    evm,
+		db:     db,
+		signer: signer,
 	}
 }
 
@@ -47,19 +48,19 @@ func (s *Service) Exists(w http.ResponseWriter, r *http.Request) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), acc, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the account contract is already deployed
 	if len(bytecode) == 0 {
-		http.Error(w, "account contract does not exist", http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeNotFound, "account contract does not exist", nil)
 		return
 	}
 
 	err = com.Body(w, nil, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -78,7 +79,7 @@ func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the request body matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -88,7 +89,7 @@ func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
 	var req creationRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
@@ -96,7 +97,7 @@ func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
 	owner := common.HexToAddress(req.Owner)
 
 	if haccaddr != owner {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the owner", nil)
 		return
 	}
 
@@ -108,143 +109,130 @@ func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), af, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the account factory contract is deployed
 	if len(bytecode) == 0 {
-		http.Error(w, "account contract is missing", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeNotFound, "account contract is missing", nil)
 		return
 	}
 
 	// instantiate account factory contract
 	afcontract, err := accfactory.NewAccfactory(af, s.evm.Backend())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	// check if it already exists, don't allow to create again
 	accaddr, err := afcontract.GetAddress(nil, owner, &req.Salt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Get the contract's bytecode
 	bytecode, err = s.evm.CodeAt(context.Background(), accaddr, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the account contract is already deployed
 	if len(bytecode) > 0 {
-		http.Error(w, "account contract is already deployed", http.StatusConflict)
+		com.ErrorBody(w, http.StatusConflict, com.ErrorCodeConflict, "account contract is already deployed", nil)
 		return
 	}
 
 	// create account
 	chainId, err := s.evm.ChainID()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the chain id", nil)
 		return
 	}
 
 	// fetch the sponsor address from the paymaster contract
 	accimpl, err := afcontract.AccountImplementation(nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the account implementation", nil)
 		return
 	}
 
 	accContract, err := account.NewAccount(accimpl, s.evm.Backend())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not instantiate the account contract", nil)
 		return
 	}
 
-	var privateKey *ecdsa.PrivateKey
+	var paddr common.Address
 
 	tep, err := accContract.TokenEntryPoint(nil)
 	if err != nil {
 		e, ok := err.(rpc.Error)
 		if ok && e.ErrorCode() != -32000 {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the token entry point", nil)
 			return
 		}
 
 		// legacy account with a token entrypoint
 		// A hard migration was conducted in November 2023 for all accounts to have a token entrypoint
 		// this allows for 4337 transactions without the need for a full node
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, err.Error(), nil)
 		return
 
 	} else {
 		tepContract, err := tokenEntryPoint.NewTokenEntryPoint(tep, s.evm.Backend())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		paddr, err := tepContract.Paymaster(nil)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		// fetch corresponding private key from the db
-		sponsorKey, err := s.db.SponsorDB.GetSponsor(paddr.Hex())
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not instantiate the token entry point contract", nil)
 			return
 		}
 
-		// Generate ecdsa.PrivateKey from bytes
-		privateKey, err = com.HexToPrivateKey(sponsorKey.PrivateKey)
+		paddr, err = tepContract.Paymaster(nil)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the paymaster", nil)
 			return
 		}
 	}
 
-	// Get the public key from the private key
-	publicKey := privateKey.Public().(*ecdsa.PublicKey)
-
-	// Convert the public key to an Ethereum address
-	sponsor := crypto.PubkeyToAddress(*publicKey)
+	// fetch the sponsor address from the signer
+	sponsor, err := s.signer.Sponsor(paddr)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the sponsor", nil)
+		return
+	}
 
 	// Get the nonce for the sponsor's address
 	nonce, err := s.evm.NonceAt(context.Background(), sponsor, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the sponsor nonce", nil)
 		return
 	}
 
 	// Parse the contract ABI
 	parsedABI, err := accfactory.AccfactoryMetaData.GetAbi()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not parse the account factory abi", nil)
 		return
 	}
 
 	data, err := parsedABI.Pack("createAccount", owner, &req.Salt)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the call", nil)
 		return
 	}
 
 	// Create a new transaction
 	tx, err := s.evm.NewTx(nonce, sponsor, af, data, true)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the transaction", nil)
 		return
 	}
 
-	signedTx, err := types.SignTx(tx, types.NewLondonSigner(chainId), privateKey)
+	signedTx, err := s.signer.SignTx(paddr, sponsor, tx, chainId)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not sign the transaction", nil)
 		return
 	}
 
@@ -252,17 +240,17 @@ func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		e, ok := err.(rpc.Error)
 		if ok && e.ErrorCode() != -32000 {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not send the transaction", nil)
 			return
 		}
 
 		if !strings.Contains(e.Error(), "insufficient funds") {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not send the transaction", nil)
 			return
 		}
 
 		// insufficient funds
-		w.WriteHeader(http.StatusPreconditionFailed)
+		com.ErrorBody(w, http.StatusPreconditionFailed, com.ErrorCodeUpstream, "the sponsor has insufficient funds", nil)
 		return
 	}
 
@@ -270,13 +258,13 @@ func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
 	// wait for tx to be mined
 	err = s.evm.WaitForTx(tx, 3)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "the transaction was not mined", nil)
 		return
 	}
 
 	err = com.Body(w, &creationResponse{AccountAddress: accaddr.Hex()}, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -294,7 +282,7 @@ func (s *Service) Upgrade(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -305,7 +293,7 @@ func (s *Service) Upgrade(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -313,7 +301,7 @@ func (s *Service) Upgrade(w http.ResponseWriter, r *http.Request) {
 	var req upgradeRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
@@ -328,77 +316,77 @@ func (s *Service) Upgrade(w http.ResponseWriter, r *http.Request) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), af, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the account factory contract is deployed
 	if len(bytecode) == 0 {
-		http.Error(w, "account factory contract is missing", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeNotFound, "account factory contract is missing", nil)
 		return
 	}
 
 	// instantiate account factory contract
 	afcontract, err := accfactory.NewAccfactory(af, s.evm.Backend())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	// check if the account is already upgraded
 	impladdr, err := get1967ProxyImplementation(s.evm, acc)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	implebytecode, err := s.evm.CodeAt(context.Background(), *impladdr, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the account contract was deployed
 	if len(implebytecode) == 0 {
-		http.Error(w, "account implementation is missing", http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "account implementation is missing", nil)
 		return
 	}
 
 	afimpl, err := afcontract.AccountImplementation(nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Get the contract's bytecode
 	afbytecode, err := s.evm.CodeAt(context.Background(), afimpl, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the account factory contract is deployed
 	if len(afbytecode) == 0 {
-		http.Error(w, "account factory contract implementation is missing", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeNotFound, "account factory contract implementation is missing", nil)
 		return
 	}
 
 	if bytes.Equal(implebytecode, afbytecode) {
-		http.Error(w, "account is already upgraded", http.StatusConflict)
+		com.ErrorBody(w, http.StatusConflict, com.ErrorCodeConflict, "account is already upgraded", nil)
 		return
 	}
 
 	// check if it already exists, create if needed
 	accaddrv2, err := afcontract.GetAddress(nil, owner, &req.Salt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Get the contract's bytecode
 	acc2bytecode, err := s.evm.CodeAt(context.Background(), accaddrv2, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
@@ -409,57 +397,52 @@ func (s *Service) Upgrade(w http.ResponseWriter, r *http.Request) {
 		// upgrade account
 		chainId, err := s.evm.ChainID()
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the chain id", nil)
 			return
 		}
 
 		accContract, err := account.NewAccount(afimpl, s.evm.Backend())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not instantiate the account contract", nil)
 			return
 		}
 
 		tep, err := accContract.TokenEntryPoint(nil)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the token entry point", nil)
 			return
 		}
 
 		tepContract, err := tokenEntryPoint.NewTokenEntryPoint(tep, s.evm.Backend())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not instantiate the token entry point contract", nil)
 			return
 		}
 
 		paddr, err := tepContract.Paymaster(nil)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		// fetch corresponding private key from the db
-		sponsorKey, err := s.db.SponsorDB.GetSponsor(paddr.Hex())
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not get the paymaster", nil)
 			return
 		}
 
-		// Generate ecdsa.PrivateKey from bytes
-		privateKey, err := com.HexToPrivateKey(sponsorKey.PrivateKey)
+		// fetch the sponsor address from the signer
+		sponsor, err := s.signer.Sponsor(paddr)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the sponsor", nil)
 			return
 		}
 
-		transactor, err := bind.NewKeyedTransactorWithChainID(privateKey, chainId)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		transactor := &bind.TransactOpts{
+			From: sponsor,
+			Signer: func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+				return s.signer.SignTx(paddr, from, tx, chainId)
+			},
+			Context: context.Background(),
 		}
 
 		tx, err := afcontract.CreateAccount(transactor, owner, &req.Salt)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not create the account", nil)
 			return
 		}
 
@@ -467,20 +450,20 @@ func (s *Service) Upgrade(w http.ResponseWriter, r *http.Request) {
 		// wait for tx to be mined
 		err = s.evm.WaitForTx(tx, 10)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "the transaction was not mined", nil)
 			return
 		}
 	}
 
 	v2Impladdr, err := get1967ProxyImplementation(s.evm, accaddrv2)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	err = com.Body(w, &upgradeResponse{AccountImplementation: v2Impladdr.Hex()}, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -3,6 +3,8 @@ package auth
 import (
 	"net/http"
 	"strings"
+
+	com "github.com/citizenwallet/indexer/internal/common"
 )
 
 type Auth struct {
@@ -24,12 +26,12 @@ func (a *Auth) AuthMiddleware(next http.Handler) http.Handler {
 		apiKey := r.Header.Get("Authorization")
 		apiKey = strings.TrimPrefix(apiKey, "Bearer ")
 		if apiKey == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "missing api key", nil)
 			return
 		}
 
 		if apiKey != a.apiKey {
-			w.WriteHeader(http.StatusUnauthorized)
+			com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "invalid api key", nil)
 			return
 		}
 
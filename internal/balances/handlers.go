@@ -0,0 +1,327 @@
+package balances
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxTopHolders = 100
+)
+
+// distributionEntry is the cached distribution of a token, computing it only blocks the requests of that token
+type distributionEntry struct {
+	mu sync.Mutex
+	d  *indexer.Distribution
+}
+
+type Service struct {
+	evm indexer.EVMRequester
+	db  *db.DB
+
+	mu            sync.Mutex
+	distributions map[string]*distributionEntry
+}
+
+func NewService(evm indexer.EVMRequester, db *db.DB) *Service {
+	return &Service{
+		evm:           evm,
+		db:            db,
+		distributions: map[string]*distributionEntry{},
+	}
+}
+
+// parseAt parses the point in time to compute balances at, it can be a block number or a date (RFC3339)
+// a block includes all the transfers of that block, defaults to now
+func (s *Service) parseAt(q url.Values) (time.Time, error) {
+	atq, _ := url.QueryUnescape(q.Get("at"))
+	if atq == "" {
+		return time.Now().UTC(), nil
+	}
+
+	if blk, ok := new(big.Int).SetString(atq, 10); ok {
+		t, err := s.evm.BlockTime(blk)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Unix(int64(t), 0).UTC(), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, atq)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t.UTC(), nil
+}
+
+// Get godoc
+//
+//	@Summary		Fetch a historical balance
+//	@Description	get the balance of an account at a block or date, computed from the indexed transfers
+//	@Tags			balances
+//	@Accept			json
+//	@Produce		json
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			acc_addr	path		string	true	"Address of the account"
+//	@Param			at	query		string	false	"Block number or date (RFC3339), defaults to now"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/balances/{token_address}/{acc_addr} [get]
+func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	// parse address from url params
+	accaddr := chi.URLParam(r, "acc_addr")
+
+	if !common.IsHexAddress(accaddr) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid account address", nil)
+		return
+	}
+
+	at, err := s.parseAt(r.URL.Query())
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid at, expected a block number or an RFC3339 date", com.ParamDetails{Param: "at"})
+		return
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	tdb, ok := s.db.GetTransferDB(contractAddr)
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	chkaddr := com.ChecksumAddress(accaddr)
+
+	balance, err := tdb.GetBalanceAt(int64(tokenId), chkaddr, at)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not compute the balance", nil)
+		return
+	}
+
+	err = com.Body(w, &indexer.Balance{Address: chkaddr, TokenID: int64(tokenId), Balance: balance, At: at}, nil)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// GetHolders godoc
+//
+//	@Summary		Fetch token holders
+//	@Description	get the accounts that held a token at a block or date sorted by balance, computed from the indexed transfers
+//	@Tags			balances
+//	@Accept			json
+//	@Produce		json
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			at	query		string	false	"Block number or date (RFC3339), defaults to now"
+//	@Param			order	query		string	false	"Sort by balance (desc or asc), defaults to desc"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/holders/{token_address} [get]
+func (s *Service) GetHolders(w http.ResponseWriter, r *http.Request) {
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	at, err := s.parseAt(r.URL.Query())
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid at, expected a block number or an RFC3339 date", com.ParamDetails{Param: "at"})
+		return
+	}
+
+	var ascending bool
+	switch r.URL.Query().Get("order") {
+	case "", "desc":
+		ascending = false
+	case "asc":
+		ascending = true
+	default:
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid order, expected asc or desc", com.ParamDetails{Param: "order"})
+		return
+	}
+
+	// parse pagination params from url query
+	limitq := r.URL.Query().Get("limit")
+	offsetq := r.URL.Query().Get("offset")
+
+	limit, err := strconv.Atoi(limitq)
+	if err != nil {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(offsetq)
+	if err != nil {
+		offset = 0
+	}
+
+	if limit < 0 || offset < 0 {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "limit and offset must be positive", com.ParamDetails{Param: "limit"})
+		return
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	tdb, ok := s.db.GetTransferDB(contractAddr)
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	holders, total, err := tdb.GetHoldersAt(int64(tokenId), at, ascending, limit, offset)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the holders", nil)
+		return
+	}
+
+	err = com.BodyMultiple(w, holders, com.Pagination{Limit: limit, Offset: offset, Total: total})
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// distribution returns the distribution of a token, it is cached until the indexer processes a new block
+func (s *Service) distribution(tdb *db.TransferDB, contractAddr string, tokenId int64) (*indexer.Distribution, error) {
+	key := fmt.Sprintf("%s_%d", strings.ToLower(contractAddr), tokenId)
+
+	s.mu.Lock()
+	e, ok := s.distributions[key]
+	if !ok {
+		e = &distributionEntry{}
+		s.distributions[key] = e
+	}
+	s.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ev, err := s.db.EventDB.GetContractEvent(contractAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.d != nil && e.d.Block == ev.LastBlock {
+		return e.d, nil
+	}
+
+	holders, err := tdb.GetAllHoldersAt(tokenId, time.Now().UTC(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []*big.Int{}
+	circulating := big.NewInt(0)
+	for _, h := range holders {
+		balances = append(balances, h.Balance)
+		circulating.Add(circulating, h.Balance)
+	}
+
+	// the leaderboard starts with the largest balance
+	top := []*indexer.Balance{}
+	for i := len(holders) - 1; i >= 0 && len(top) < maxTopHolders; i-- {
+		top = append(top, holders[i])
+	}
+
+	d := &indexer.Distribution{
+		TokenID:       tokenId,
+		Block:         ev.LastBlock,
+		HolderCount:   len(holders),
+		Circulating:   circulating,
+		MedianBalance: com.Median(balances),
+		Gini:          com.Gini(balances),
+		Top10Share:    com.TopShare(balances, 10),
+		TopHolders:    top,
+	}
+
+	e.d = d
+
+	return d, nil
+}
+
+// GetDistribution godoc
+//
+//	@Summary		Fetch token distribution
+//	@Description	get the top holders of a token and how it is distributed: holder count, median balance, gini coefficient and share of the top 10
+//	@Tags			balances
+//	@Accept			json
+//	@Produce		json
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			limit	query		int	false	"Amount of top holders to return, at most 100"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/holders/{token_address}/distribution [get]
+func (s *Service) GetDistribution(w http.ResponseWriter, r *http.Request) {
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	limitq := r.URL.Query().Get("limit")
+	limit, err := strconv.Atoi(limitq)
+	if err != nil {
+		limit = 10
+	}
+
+	if limit < 0 || limit > maxTopHolders {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, fmt.Sprintf("limit must be between 0 and %d", maxTopHolders), com.ParamDetails{Param: "limit"})
+		return
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	tdb, ok := s.db.GetTransferDB(contractAddr)
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	d, err := s.distribution(tdb, contractAddr, int64(tokenId))
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not compute the distribution", nil)
+		return
+	}
+
+	// the cached distribution is shared, only the requested part of the leaderboard is returned
+	res := *d
+	if len(res.TopHolders) > limit {
+		res.TopHolders = res.TopHolders[:limit]
+	}
+
+	err = com.Body(w, &res, nil)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
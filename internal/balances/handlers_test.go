@@ -0,0 +1,99 @@
+package balances
+
+import (
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+const (
+	testToken = "0x0000000000000000000000000000000000000100"
+	testAlice = "0x0000000000000000000000000000000000000002"
+)
+
+func testMint(i int, value int64) *indexer.Transfer {
+	tx := &indexer.Transfer{
+		TxHash:    strconv.Itoa(i),
+		CreatedAt: time.Now().UTC().Add(-time.Minute),
+		From:      common.ZeroAddress,
+		To:        testAlice,
+		Value:     big.NewInt(value),
+		Status:    indexer.TransferStatusSuccess,
+	}
+
+	tx.Hash = tx.GenerateUniqueHash(0)
+	tx.FromTo = tx.CombineFromTo()
+
+	return tx
+}
+
+func TestDistributionCache(t *testing.T) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	err = d.EventDB.AddEvent(testToken, indexer.EventStateIndexed, 1, 10, indexer.ERC20, "Test", "TST", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdb, err := d.AddTransferDB(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, create := range []func() error{tdb.CreateTransferTable, tdb.CreateTransferSearchTable, tdb.CreateBalanceCheckpointTable} {
+		err = create()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add := func(tx *indexer.Transfer) {
+		err := tdb.AddTransfers([]*indexer.Transfer{tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewService(nil, d)
+
+	distribution := func() *indexer.Distribution {
+		dist, err := s.distribution(tdb, testToken, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return dist
+	}
+
+	add(testMint(1, 100))
+
+	if got := distribution().Circulating.Int64(); got != 100 {
+		t.Fatalf("got circulating %d, want 100", got)
+	}
+
+	// the distribution is cached until the indexer processes a new block
+	add(testMint(2, 50))
+
+	if got := distribution().Circulating.Int64(); got != 100 {
+		t.Errorf("got circulating %d before a new block, want the cached 100", got)
+	}
+
+	err = d.EventDB.SetEventLastBlock(testToken, indexer.ERC20, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dist := distribution()
+	if dist.Circulating.Int64() != 150 || dist.Block != 11 {
+		t.Errorf("got circulating %d at block %d, want 150 at block 11", dist.Circulating.Int64(), dist.Block)
+	}
+}
@@ -20,7 +20,7 @@ func NewService(evm indexer.EVMRequester, chid *big.Int) *Service {
 	}
 }
 
-func (s *Service) ChainId(r *http.Request) (any, int) {
+func (s *Service) ChainId(r *http.Request) (any, error) {
 	// Return the message ID
-	return s.chainId, http.StatusOK
+	return s.chainId, nil
 }
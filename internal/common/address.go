@@ -1,11 +1,15 @@
 package common
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// ZeroAddress is the address that tokens are minted from and burned to
+var ZeroAddress = common.Address{}.Hex()
+
 func IsSameHexAddress(a, b string) bool {
 	return strings.ToLower(a) == strings.ToLower(b)
 }
@@ -15,3 +19,22 @@ func ChecksumAddress(addr string) string {
 
 	return address.Hex()
 }
+
+// ParseAddresses parses a comma separated list of addresses, empty entries are skipped
+func ParseAddresses(list string) ([]common.Address, error) {
+	addrs := []common.Address{}
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("invalid address: %s", addr)
+		}
+
+		addrs = append(addrs, common.HexToAddress(addr))
+	}
+
+	return addrs, nil
+}
@@ -36,3 +36,19 @@ func TestChecksumAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAddresses(t *testing.T) {
+	addrs, err := ParseAddresses(" 0x480fbe37526226b6c6e2a7afa449cdf661939d2f,,0x1234567890123456789012345678901234567890 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(addrs) != 2 || addrs[0].Hex() != "0x480Fbe37526226b6c6E2a7AfA449cDf661939D2f" || addrs[1].Hex() != "0x1234567890123456789012345678901234567890" {
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+
+	_, err = ParseAddresses("0x01,not_an_address")
+	if err == nil {
+		t.Errorf("expected an invalid address to be rejected")
+	}
+}
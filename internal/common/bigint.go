@@ -1,6 +1,9 @@
 package common
 
-import "math/big"
+import (
+	"math/big"
+	"strings"
+)
 
 func HexToBigInt(hex string) *big.Int {
 	i, ok := new(big.Int).SetString(hex, 16)
@@ -10,3 +13,28 @@ func HexToBigInt(hex string) *big.Int {
 
 	return i
 }
+
+// FormatUnits formats an amount of the smallest unit of a token with the token's decimals, e.g. 1234500 with 6 decimals is 1.234500
+func FormatUnits(v *big.Int, decimals int) string {
+	if v == nil {
+		v = big.NewInt(0)
+	}
+
+	digits := new(big.Int).Abs(v).String()
+
+	sign := ""
+	if v.Sign() < 0 {
+		sign = "-"
+	}
+
+	if decimals <= 0 {
+		return sign + digits
+	}
+
+	// pad with zeros so that there is at least one digit before the decimal point
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+}
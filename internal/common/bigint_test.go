@@ -0,0 +1,31 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestFormatUnits(t *testing.T) {
+	cases := []struct {
+		v        *big.Int
+		decimals int
+		expected string
+	}{
+		{big.NewInt(1234500), 6, "1.234500"},
+		{big.NewInt(-1234500), 6, "-1.234500"},
+		{big.NewInt(1), 6, "0.000001"},
+		{big.NewInt(-1), 2, "-0.01"},
+		{big.NewInt(100), 2, "1.00"},
+		{big.NewInt(0), 6, "0.000000"},
+		{big.NewInt(42), 0, "42"},
+		{nil, 2, "0.00"},
+		{new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil), 18, "1000000000000.000000000000000000"},
+	}
+
+	for _, c := range cases {
+		output := FormatUnits(c.v, c.decimals)
+		if output != c.expected {
+			t.Errorf("FormatUnits(%v, %d) = %q, want %q", c.v, c.decimals, output, c.expected)
+		}
+	}
+}
@@ -16,74 +16,206 @@ import (
 var (
 	ErrInvalidCalldata = errors.New("invalid calldata")
 	ErrNotTransfer     = errors.New("not a transfer")
+	ErrUnknownCall     = errors.New("unknown account function")
 
-	executeSigSingle = crypto.Keccak256([]byte("execute(address,uint256,bytes)"))[:4]
+	executeSigSingle      = crypto.Keccak256([]byte("execute(address,uint256,bytes)"))[:4]
+	executeSigBatch       = crypto.Keccak256([]byte("executeBatch(address[],uint256[],bytes[])"))[:4]
+	execSigSafeFromModule = crypto.Keccak256([]byte("execTransactionFromModule(address,uint256,bytes,uint8)"))[:4]
 
 	transferSig = crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
 	mintSig     = crypto.Keccak256([]byte("mint(address,uint256)"))[:4]
 	withdrawSig = crypto.Keccak256([]byte("withdraw(bytes32,address,address,uint256)"))[:4]
+
+	addressTy, _   = abi.NewType("address", "address", nil)
+	uint256Ty, _   = abi.NewType("uint256", "uint256", nil)
+	bytesTy, _     = abi.NewType("bytes", "bytes", nil)
+	uint8Ty, _     = abi.NewType("uint8", "uint8", nil)
+	addressesTy, _ = abi.NewType("address[]", "address[]", nil)
+	uint256sTy, _  = abi.NewType("uint256[]", "uint256[]", nil)
+	bytesesTy, _   = abi.NewType("bytes[]", "bytes[]", nil)
+
+	executeSingleArgs = abi.Arguments{{Type: addressTy}, {Type: uint256Ty}, {Type: bytesTy}}
+	executeBatchArgs  = abi.Arguments{{Type: addressesTy}, {Type: uint256sTy}, {Type: bytesesTy}}
+	safeFromModArgs   = abi.Arguments{{Type: addressTy}, {Type: uint256Ty}, {Type: bytesTy}, {Type: uint8Ty}}
 )
 
-// ParseERC20Transfer parses the calldata of an ERC20 transfer from a smart contract Execute function
-func ParseERC20Transfer(calldata []byte, evm indexer.EVMRequester) (common.Address, common.Address, common.Address, *big.Int, error) {
-	if len(calldata) < 228 {
-		return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+// AccountCall is a call that a smart account makes to a contract
+type AccountCall struct {
+	To    common.Address
+	Value *big.Int
+	Data  []byte
+	// DelegateCall is true if a Safe runs the code of the contract in its own context instead of calling it
+	DelegateCall bool
+}
+
+// ParseAccountCalls parses the calls of the calldata of a smart account: execute, executeBatch or the
+// execTransactionFromModule of a Safe. A batch where the lengths of the arrays do not match is invalid
+// and ErrUnknownCall is returned for any other function.
+func ParseAccountCalls(calldata []byte) ([]AccountCall, error) {
+	if len(calldata) < 4 {
+		return nil, ErrInvalidCalldata
 	}
 
-	// The function selector is the first 4 bytes of the calldata
 	funcSelector := calldata[:4]
-	if !bytes.Equal(funcSelector, executeSigSingle) { // TODO: implement batch execute
-		return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+	args := calldata[4:]
+
+	switch {
+	case bytes.Equal(funcSelector, executeSigSingle):
+		values, err := executeSingleArgs.Unpack(args)
+		if err != nil {
+			return nil, ErrInvalidCalldata
+		}
+
+		return []AccountCall{{To: values[0].(common.Address), Value: values[1].(*big.Int), Data: values[2].([]byte)}}, nil
+	case bytes.Equal(funcSelector, executeSigBatch):
+		values, err := executeBatchArgs.Unpack(args)
+		if err != nil {
+			return nil, ErrInvalidCalldata
+		}
+
+		dests := values[0].([]common.Address)
+		amounts := values[1].([]*big.Int)
+		datas := values[2].([][]byte)
+
+		// the account only sends a value if there are as many values as destinations
+		if len(datas) != len(dests) || (len(amounts) != 0 && len(amounts) != len(dests)) {
+			return nil, ErrInvalidCalldata
+		}
+
+		calls := make([]AccountCall, len(dests))
+		for i, dest := range dests {
+			value := big.NewInt(0)
+			if len(amounts) != 0 {
+				value = amounts[i]
+			}
+
+			calls[i] = AccountCall{To: dest, Value: value, Data: datas[i]}
+		}
+
+		return calls, nil
+	case bytes.Equal(funcSelector, execSigSafeFromModule):
+		values, err := safeFromModArgs.Unpack(args)
+		if err != nil {
+			return nil, ErrInvalidCalldata
+		}
+
+		operation := values[3].(uint8)
+		if operation > 1 {
+			return nil, ErrInvalidCalldata
+		}
+
+		return []AccountCall{{To: values[0].(common.Address), Value: values[1].(*big.Int), Data: values[2].([]byte), DelegateCall: operation == 1}}, nil
 	}
 
-	// The rest of the calldata is the encoded arguments
-	args := calldata[4:]
+	return nil, ErrUnknownCall
+}
+
+// ERC20Transfer is a transfer of a token that a call of a smart account makes, the from address is only set
+// if the tokens are not sent by the account itself
+type ERC20Transfer struct {
+	Token  common.Address
+	From   common.Address
+	To     common.Address
+	Amount *big.Int
+}
 
-	// The first argument is the dest address, which is 32 bytes offset from the start of the args
-	dest := common.BytesToAddress(args[32-20 : 32])
-	if len(dest.Bytes()) == 0 {
-		return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+// ParseERC20Transfers parses every ERC20 transfer, mint or card withdrawal in the calldata of a smart account.
+// The calls that are not transfers are skipped, ErrNotTransfer is returned if there are none.
+func ParseERC20Transfers(calldata []byte, evm indexer.EVMRequester) ([]ERC20Transfer, error) {
+	calls, err := ParseAccountCalls(calldata)
+	if err != nil {
+		return nil, err
 	}
 
-	// The third argument is the funcData, which starts 96 bytes offset from the start of the args
-	funcData := args[128:132]
+	transfers := []ERC20Transfer{}
+	var callErr error
+	for _, call := range calls {
+		if call.DelegateCall {
+			// the account runs the code of the token, it does not call it
+			continue
+		}
+
+		t, err := parseERC20TransferCall(call, evm)
+		if err != nil {
+			if callErr == nil || callErr == ErrNotTransfer {
+				callErr = err
+			}
+			continue
+		}
+
+		transfers = append(transfers, *t)
+	}
+
+	if len(transfers) == 0 {
+		if callErr == nil {
+			callErr = ErrNotTransfer
+		}
+
+		return nil, callErr
+	}
+
+	return transfers, nil
+}
+
+// ParseERC20Transfer parses the calldata of an ERC20 transfer from a smart contract Execute function,
+// the first transfer is returned if the account makes several calls
+func ParseERC20Transfer(calldata []byte, evm indexer.EVMRequester) (common.Address, common.Address, common.Address, *big.Int, error) {
+	transfers, err := ParseERC20Transfers(calldata, evm)
+	if err != nil {
+		return common.Address{}, common.Address{}, common.Address{}, nil, err
+	}
+
+	t := transfers[0]
+
+	return t.Token, t.From, t.To, t.Amount, nil
+}
+
+// parseERC20TransferCall parses a transfer from a single call of a smart account
+func parseERC20TransferCall(call AccountCall, evm indexer.EVMRequester) (*ERC20Transfer, error) {
+	dest := call.To
+	if dest == (common.Address{}) {
+		return nil, ErrInvalidCalldata
+	}
+
+	// The first 4 bytes of the data of the call is the function selector
+	if len(call.Data) < 4 {
+		return nil, ErrNotTransfer
+	}
 
-	// The first 4 bytes of the funcData is the transfer function selector
-	trfFuncSelector := funcData[:4]
+	trfFuncSelector := call.Data[:4]
+	funcArgs := call.Data[4:]
 
 	// Depending on the function selector, the arguments are in different positions
 	switch string(trfFuncSelector) {
 	case string(transferSig), string(mintSig):
 		// Standard ERC20 transfer
-		funcArgs := args[132:]
+		if len(funcArgs) < 64 {
+			return nil, ErrInvalidCalldata
+		}
 
 		// The first argument of the funcData is the to address, which is 32 bytes offset from the start of the funcData
 		to := common.BytesToAddress(funcArgs[32-20 : 32])
-		if len(to.Bytes()) == 0 {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
-		}
 
 		// The second argument of the funcData is the amount, which is 64 bytes offset from the start of the funcData
 		amount := new(big.Int).SetBytes(funcArgs[64-32 : 64])
 		if amount.Cmp(big.NewInt(0)) == 0 {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+			return nil, ErrInvalidCalldata
 		}
 
-		return dest, common.Address{}, to, amount, nil
+		return &ERC20Transfer{Token: dest, To: to, Amount: amount}, nil
 	case string(withdrawSig):
 		// Withdraw function from the Card Manager
-		funcArgs := args[132:]
+		if len(funcArgs) < 128 {
+			return nil, ErrInvalidCalldata
+		}
 
 		// The first argument of the funcData is the card hash, which is 32 bytes offset from the start of the funcData
 		cardHash := [32]byte(funcArgs[0:32])
-		if len(cardHash) == 0 {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
-		}
 
 		// Load the contract ABI
 		contractAbi, err := abi.JSON(strings.NewReader(string(CardManagerABI)))
 		if err != nil {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+			return nil, ErrInvalidCalldata
 		}
 
 		// Set the contract address
@@ -92,7 +224,7 @@ func ParseERC20Transfer(calldata []byte, evm indexer.EVMRequester) (common.Addre
 		// Prepare the call
 		callData, err := contractAbi.Pack("getCardAddress", cardHash)
 		if err != nil {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+			return nil, ErrInvalidCalldata
 		}
 
 		// Create a call message
@@ -103,38 +235,32 @@ func ParseERC20Transfer(calldata []byte, evm indexer.EVMRequester) (common.Addre
 
 		result, err := evm.CallContract(msg, nil)
 		if err != nil {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+			return nil, ErrInvalidCalldata
 		}
 
 		// Parse the result
 		var cardAddress common.Address
 		err = contractAbi.UnpackIntoInterface(&cardAddress, "getCardAddress", result)
 		if err != nil {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+			return nil, ErrInvalidCalldata
 		}
 
 		// The second argument of the funcData is the token address, which is 64 bytes offset from the start of the funcData
-		dest := common.BytesToAddress(funcArgs[64-20 : 64])
-		if len(dest.Bytes()) == 0 {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
-		}
+		token := common.BytesToAddress(funcArgs[64-20 : 64])
 
 		// The third argument of the funcData is the to address, which is 96 bytes offset from the start of the funcData
 		to := common.BytesToAddress(funcArgs[96-20 : 96])
-		if len(to.Bytes()) == 0 {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
-		}
 
 		// The fourth argument of the funcData is the amount, which is 128 bytes offset from the start of the funcData
 		amount := new(big.Int).SetBytes(funcArgs[128-32 : 128])
 		if amount.Cmp(big.NewInt(0)) == 0 {
-			return common.Address{}, common.Address{}, common.Address{}, nil, ErrInvalidCalldata
+			return nil, ErrInvalidCalldata
 		}
 
-		return dest, cardAddress, to, amount, nil
+		return &ERC20Transfer{Token: token, From: cardAddress, To: to, Amount: amount}, nil
 	}
 
-	return common.Address{}, common.Address{}, common.Address{}, nil, ErrNotTransfer
+	return nil, ErrNotTransfer
 }
 
 const CardManagerABI = `[{"inputs":[{"internalType":"address","name":"_owner","type":"address"}],"stateMutability":"nonpayable","type":"constructor"},{"inputs":[],"name":"AlreadyInitializing","type":"error"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"address","name":"voucher","type":"address"}],"name":"CardCreated","type":"event"},{"inputs":[],"name":"cardImplementation","outputs":[{"internalType":"contract Card","name":"","type":"address"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"contractAddress","type":"address"}],"name":"contractExists","outputs":[{"internalType":"bool","name":"","type":"bool"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"bytes32","name":"cardHash","type":"bytes32"}],"name":"createCard","outputs":[{"internalType":"contract Card","name":"ret","type":"address"}],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"bytes32","name":"cardHash","type":"bytes32"}],"name":"getCardAddress","outputs":[{"internalType":"address","name":"","type":"address"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"uint256","name":"serial","type":"uint256"}],"name":"getCardHash","outputs":[{"internalType":"bytes32","name":"","type":"bytes32"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"contract IEntryPoint","name":"_entryPoint","type":"address"},{"internalType":"contract ITokenEntryPoint","name":"_tokenEntryPoint","type":"address"},{"internalType":"address[]","name":"_whitelistAddresses","type":"address[]"}],"name":"initialize","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"address","name":"addr","type":"address"}],"name":"isWhitelisted","outputs":[{"internalType":"bool","name":"","type":"bool"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"owner","outputs":[{"internalType":"address","name":"","type":"address"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"bytes32","name":"cardHash","type":"bytes32"},{"internalType":"address","name":"newOwner","type":"address"}],"name":"transferCardOwnership","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"address[]","name":"addresses","type":"address[]"}],"name":"updateWhitelist","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"bytes32","name":"cardHash","type":"bytes32"},{"internalType":"contract IERC20","name":"token","type":"address"},{"internalType":"address","name":"to","type":"address"},{"internalType":"uint256","name":"amount","type":"uint256"}],"name":"withdraw","outputs":[],"stateMutability":"nonpayable","type":"function"}]`
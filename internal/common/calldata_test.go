@@ -8,6 +8,7 @@ import (
 
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -89,6 +90,104 @@ func TestParseERC20Transfer(t *testing.T) {
 	}
 }
 
+func TestParseERC20Transfers(t *testing.T) {
+	token := common.HexToAddress("0x5815E61eF72c9E6107b5c5A05FD121F334f7a7f1")
+	profile := common.HexToAddress("0xEEc0F3257369c6bCD2Fd8755CbEf8A95b12Bc4c9")
+	alice := common.HexToAddress("0x29d755C17df3ED2eCAE6e42d694fb4F7E2ff6010")
+	bob := common.HexToAddress("0xcfa21B33D304D57c4E964e3819588Eb5ac06B4D9")
+
+	transfer := func(to common.Address, amount int64) []byte {
+		args, _ := abi.Arguments{{Type: addressTy}, {Type: uint256Ty}}.Pack(to, big.NewInt(amount))
+		return append(append([]byte{}, transferSig...), args...)
+	}
+	mint := func(to common.Address, amount int64) []byte {
+		args, _ := abi.Arguments{{Type: addressTy}, {Type: uint256Ty}}.Pack(to, big.NewInt(amount))
+		return append(append([]byte{}, mintSig...), args...)
+	}
+	batch := func(dests []common.Address, values []*big.Int, datas [][]byte) []byte {
+		args, err := executeBatchArgs.Pack(dests, values, datas)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(append([]byte{}, executeSigBatch...), args...)
+	}
+	safe := func(dest common.Address, data []byte, operation uint8) []byte {
+		args, err := safeFromModArgs.Pack(dest, big.NewInt(0), data, operation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(append([]byte{}, execSigSafeFromModule...), args...)
+	}
+
+	cases := []struct {
+		name     string
+		calldata []byte
+		expected []ERC20Transfer
+		err      error
+	}{
+		{
+			name:     "batch",
+			calldata: batch([]common.Address{token, profile, token}, []*big.Int{}, [][]byte{transfer(alice, 1), {0x01, 0x02, 0x03, 0x04}, mint(bob, 2)}),
+			expected: []ERC20Transfer{{Token: token, To: alice, Amount: big.NewInt(1)}, {Token: token, To: bob, Amount: big.NewInt(2)}},
+		},
+		{
+			name:     "batch with values",
+			calldata: batch([]common.Address{token, token}, []*big.Int{big.NewInt(0), big.NewInt(0)}, [][]byte{transfer(alice, 1), transfer(bob, 3)}),
+			expected: []ERC20Transfer{{Token: token, To: alice, Amount: big.NewInt(1)}, {Token: token, To: bob, Amount: big.NewInt(3)}},
+		},
+		{
+			name:     "batch with a repeated recipient",
+			calldata: batch([]common.Address{token, token, token}, []*big.Int{}, [][]byte{transfer(alice, 1), transfer(bob, 2), transfer(alice, 1)}),
+			expected: []ERC20Transfer{{Token: token, To: alice, Amount: big.NewInt(1)}, {Token: token, To: bob, Amount: big.NewInt(2)}, {Token: token, To: alice, Amount: big.NewInt(1)}},
+		},
+		{
+			name:     "batch without transfers",
+			calldata: batch([]common.Address{profile}, []*big.Int{}, [][]byte{{0x01, 0x02, 0x03, 0x04}}),
+			err:      ErrNotTransfer,
+		},
+		{
+			name:     "batch with mismatched arrays",
+			calldata: batch([]common.Address{token, token}, []*big.Int{}, [][]byte{transfer(alice, 1)}),
+			err:      ErrInvalidCalldata,
+		},
+		{
+			name:     "safe module",
+			calldata: safe(token, transfer(bob, 5), 0),
+			expected: []ERC20Transfer{{Token: token, To: bob, Amount: big.NewInt(5)}},
+		},
+		{
+			name:     "safe module delegate call",
+			calldata: safe(token, transfer(bob, 5), 1),
+			err:      ErrNotTransfer,
+		},
+		{
+			name:     "unknown function",
+			calldata: transfer(bob, 5),
+			err:      ErrUnknownCall,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transfers, err := ParseERC20Transfers(c.calldata, NewMockEVMRequester())
+			if err != c.err {
+				t.Fatalf("err = %v, want %v", err, c.err)
+			}
+
+			if len(transfers) != len(c.expected) {
+				t.Fatalf("got %d transfers, want %d", len(transfers), len(c.expected))
+			}
+
+			for i, tr := range transfers {
+				e := c.expected[i]
+				if tr.Token != e.Token || tr.From != e.From || tr.To != e.To || tr.Amount.Cmp(e.Amount) != 0 {
+					t.Errorf("transfer %d = %+v, want %+v", i, tr, e)
+				}
+			}
+		})
+	}
+}
+
 type MockEVMRequester struct{}
 
 func NewMockEVMRequester() indexer.EVMRequester {
@@ -180,6 +279,16 @@ func (m *MockEVMRequester) NonceAt(ctx context.Context, account common.Address,
 	panic("unimplemented")
 }
 
+// BalanceAt implements indexer.EVMRequester.
+func (m *MockEVMRequester) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	panic("unimplemented")
+}
+
+// PendingNonceAt implements indexer.EVMRequester.
+func (m *MockEVMRequester) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	panic("unimplemented")
+}
+
 // SendTransaction implements indexer.EVMRequester.
 func (m *MockEVMRequester) SendTransaction(tx *types.Transaction) error {
 	panic("unimplemented")
@@ -190,6 +299,16 @@ func (m *MockEVMRequester) StorageAt(addr common.Address, slot common.Hash) ([]b
 	panic("unimplemented")
 }
 
+// TransactionReceipt implements indexer.EVMRequester.
+func (m *MockEVMRequester) TransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	panic("unimplemented")
+}
+
+// TransactionByHash implements indexer.EVMRequester.
+func (m *MockEVMRequester) TransactionByHash(hash common.Hash) (*types.Transaction, bool, error) {
+	panic("unimplemented")
+}
+
 // WaitForTx implements indexer.EVMRequester.
 func (m *MockEVMRequester) WaitForTx(tx *types.Transaction, timeout int) error {
 	panic("unimplemented")
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"math/big"
+)
+
+// Median returns the median of values sorted in ascending order
+// for an even amount of values it is the average of the two middle values rounded down
+func Median(sorted []*big.Int) *big.Int {
+	n := len(sorted)
+	if n == 0 {
+		return big.NewInt(0)
+	}
+
+	if n%2 == 1 {
+		return new(big.Int).Set(sorted[n/2])
+	}
+
+	m := new(big.Int).Add(sorted[n/2-1], sorted[n/2])
+
+	return m.Div(m, big.NewInt(2))
+}
+
+// Gini returns the gini coefficient of values sorted in ascending order
+// 0 means that everyone holds the same amount, values close to 1 mean that one holder has almost everything
+func Gini(sorted []*big.Int) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+
+	// G = sum((2i - n - 1) * x_i) / (n * sum(x_i)) with i starting at 1
+	num := big.NewInt(0)
+	total := big.NewInt(0)
+	for i, x := range sorted {
+		w := big.NewInt(int64(2*(i+1) - n - 1))
+		num.Add(num, w.Mul(w, x))
+		total.Add(total, x)
+	}
+
+	if total.Sign() == 0 {
+		return 0
+	}
+
+	den := new(big.Int).Mul(total, big.NewInt(int64(n)))
+
+	g, _ := new(big.Rat).SetFrac(num, den).Float64()
+
+	return g
+}
+
+// TopShare returns the share of the total held by the top values, values are sorted in ascending order
+func TopShare(sorted []*big.Int, top int) float64 {
+	total := big.NewInt(0)
+	topTotal := big.NewInt(0)
+	for i, x := range sorted {
+		total.Add(total, x)
+
+		if i >= len(sorted)-top {
+			topTotal.Add(topTotal, x)
+		}
+	}
+
+	if total.Sign() == 0 {
+		return 0
+	}
+
+	s, _ := new(big.Rat).SetFrac(topTotal, total).Float64()
+
+	return s
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func bigInts(values ...int64) []*big.Int {
+	b := []*big.Int{}
+	for _, v := range values {
+		b = append(b, big.NewInt(v))
+	}
+
+	return b
+}
+
+func TestMedian(t *testing.T) {
+	cases := []struct {
+		input    []*big.Int
+		expected int64
+	}{
+		{bigInts(), 0},
+		{bigInts(5), 5},
+		{bigInts(1, 2, 10), 2},
+		{bigInts(1, 2, 3, 10), 2},
+		{bigInts(1, 3, 5, 10), 4},
+	}
+
+	for _, c := range cases {
+		output := Median(c.input)
+		if output.Int64() != c.expected {
+			t.Errorf("Median(%v) = %v, want %v", c.input, output, c.expected)
+		}
+	}
+}
+
+func TestGini(t *testing.T) {
+	cases := []struct {
+		input    []*big.Int
+		expected float64
+	}{
+		{bigInts(), 0},
+		{bigInts(0, 0), 0},
+		{bigInts(10, 10, 10, 10), 0},
+		{bigInts(0, 0, 0, 100), 0.75},
+		{bigInts(1, 2, 3, 4), 0.25},
+	}
+
+	for _, c := range cases {
+		output := Gini(c.input)
+		if math.Abs(output-c.expected) > 1e-9 {
+			t.Errorf("Gini(%v) = %v, want %v", c.input, output, c.expected)
+		}
+	}
+}
+
+func TestTopShare(t *testing.T) {
+	cases := []struct {
+		input    []*big.Int
+		top      int
+		expected float64
+	}{
+		{bigInts(), 10, 0},
+		{bigInts(1, 2, 3, 4), 10, 1},
+		{bigInts(1, 2, 3, 4), 1, 0.4},
+		{bigInts(1, 2, 3, 4), 2, 0.7},
+	}
+
+	for _, c := range cases {
+		output := TopShare(c.input, c.top)
+		if math.Abs(output-c.expected) > 1e-9 {
+			t.Errorf("TopShare(%v, %d) = %v, want %v", c.input, c.top, output, c.expected)
+		}
+	}
+}
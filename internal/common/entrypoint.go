@@ -0,0 +1,180 @@
+package common
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	pay "github.com/citizenwallet/smartcontracts/pkg/contracts/paymaster"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/tokenEntryPoint"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	ErrInvalidPaymasterData = errors.New("invalid paymaster data")
+	ErrGasOverflow          = errors.New("gas values of a v0.7 op should fit in 128 bits")
+)
+
+const (
+	paymasterValiditySize  = 64 // validUntil and validAfter, abi encoded as uint48
+	paymasterSignatureSize = 65
+)
+
+// PackedUserOperation is a user operation as the v0.7 entry point takes it
+type PackedUserOperation struct {
+	Sender             common.Address
+	Nonce              *big.Int
+	InitCode           []byte
+	CallData           []byte
+	AccountGasLimits   [32]byte // verificationGasLimit and callGasLimit
+	PreVerificationGas *big.Int
+	GasFees            [32]byte // maxPriorityFeePerGas and maxFeePerGas
+	PaymasterAndData   []byte
+	Signature          []byte
+}
+
+// PackUserOp packs the gas limits and the fees of an op in pairs of uint128 for the v0.7 entry point
+func PackUserOp(op indexer.UserOp) (PackedUserOperation, error) {
+	op = withGasDefaults(op)
+	if op.PreVerificationGas == nil {
+		op.PreVerificationGas = big.NewInt(0)
+	}
+
+	accountGasLimits, err := packUint128s(op.VerificationGasLimit, op.CallGasLimit)
+	if err != nil {
+		return PackedUserOperation{}, err
+	}
+
+	gasFees, err := packUint128s(op.MaxPriorityFeePerGas, op.MaxFeePerGas)
+	if err != nil {
+		return PackedUserOperation{}, err
+	}
+
+	return PackedUserOperation{
+		Sender:             op.Sender,
+		Nonce:              op.Nonce,
+		InitCode:           op.InitCode,
+		CallData:           op.CallData,
+		AccountGasLimits:   accountGasLimits,
+		PreVerificationGas: op.PreVerificationGas,
+		GasFees:            gasFees,
+		PaymasterAndData:   op.PaymasterAndData,
+		Signature:          op.Signature,
+	}, nil
+}
+
+// packUint128s packs two uint128 in a bytes32, the high one first
+func packUint128s(high, low *big.Int) ([32]byte, error) {
+	var packed [32]byte
+	if high.Sign() < 0 || low.Sign() < 0 || high.BitLen() > 128 || low.BitLen() > 128 {
+		return packed, ErrGasOverflow
+	}
+
+	high.FillBytes(packed[:16])
+	low.FillBytes(packed[16:])
+
+	return packed, nil
+}
+
+// HandleOpsData returns the calldata of a bundle for an entry point of the given version
+func HandleOpsData(version indexer.EntryPointVersion, ops []indexer.UserOp, beneficiary common.Address) ([]byte, error) {
+	if version == indexer.EntryPointV07 {
+		epAbi, err := abi.JSON(strings.NewReader(EntryPointV07ABI))
+		if err != nil {
+			return nil, err
+		}
+
+		packed := []PackedUserOperation{}
+		for _, op := range ops {
+			p, err := PackUserOp(op)
+			if err != nil {
+				return nil, err
+			}
+
+			packed = append(packed, p)
+		}
+
+		return epAbi.Pack("handleOps", packed, beneficiary)
+	}
+
+	epAbi, err := tokenEntryPoint.TokenEntryPointMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+
+	unpacked := []tokenEntryPoint.UserOperation{}
+	for _, op := range ops {
+		unpacked = append(unpacked, tokenEntryPoint.UserOperation(op))
+	}
+
+	return epAbi.Pack("handleOps", unpacked, beneficiary)
+}
+
+// PaymasterHash returns the hash that the sponsor of a paymaster signs for an op, the paymaster of a v0.7 op
+// includes its gas limits in the hash so they should already be in the paymaster data of the op
+func PaymasterHash(evm indexer.EVMRequester, paymaster common.Address, version indexer.EntryPointVersion, op indexer.UserOp, validUntil, validAfter *big.Int) ([32]byte, error) {
+	if version != indexer.EntryPointV07 {
+		pm, err := pay.NewPaymaster(paymaster, evm.Backend())
+		if err != nil {
+			return [32]byte{}, err
+		}
+
+		return pm.GetHash(nil, pay.UserOperation(op), validUntil, validAfter)
+	}
+
+	pmAbi, err := abi.JSON(strings.NewReader(PaymasterV07ABI))
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	packed, err := PackUserOp(op)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	data, err := pmAbi.Pack("getHash", packed, validUntil, validAfter)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	result, err := evm.CallContract(ethereum.CallMsg{
+		To:   &paymaster,
+		Data: data,
+	}, nil)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	values, err := pmAbi.Unpack("getHash", result)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	hash, ok := values[0].([32]byte)
+	if !ok {
+		return [32]byte{}, errors.New("invalid paymaster hash")
+	}
+
+	return hash, nil
+}
+
+// SplitPaymasterData returns the abi encoded validity and the signature of the paymaster data of an op
+func SplitPaymasterData(version indexer.EntryPointVersion, paymasterAndData []byte) ([]byte, []byte, error) {
+	offset := version.PaymasterDataOffset()
+	if len(paymasterAndData) != offset+paymasterValiditySize+paymasterSignatureSize {
+		return nil, nil, ErrInvalidPaymasterData
+	}
+
+	data := paymasterAndData[offset:]
+
+	return data[:paymasterValiditySize], data[paymasterValiditySize:], nil
+}
+
+// EntryPointV07ABI is the part of the v0.7 entry point that the bundler uses
+const EntryPointV07ABI = `[{"inputs":[{"components":[{"internalType":"address","name":"sender","type":"address"},{"internalType":"uint256","name":"nonce","type":"uint256"},{"internalType":"bytes","name":"initCode","type":"bytes"},{"internalType":"bytes","name":"callData","type":"bytes"},{"internalType":"bytes32","name":"accountGasLimits","type":"bytes32"},{"internalType":"uint256","name":"preVerificationGas","type":"uint256"},{"internalType":"bytes32","name":"gasFees","type":"bytes32"},{"internalType":"bytes","name":"paymasterAndData","type":"bytes"},{"internalType":"bytes","name":"signature","type":"bytes"}],"internalType":"struct PackedUserOperation[]","name":"ops","type":"tuple[]"},{"internalType":"address payable","name":"beneficiary","type":"address"}],"name":"handleOps","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"uint256","name":"opIndex","type":"uint256"},{"internalType":"string","name":"reason","type":"string"}],"name":"FailedOp","type":"error"},{"inputs":[{"internalType":"uint256","name":"opIndex","type":"uint256"},{"internalType":"string","name":"reason","type":"string"},{"internalType":"bytes","name":"inner","type":"bytes"}],"name":"FailedOpWithRevert","type":"error"}]`
+
+// PaymasterV07ABI is the part of a v0.7 verifying paymaster that the paymaster service uses
+const PaymasterV07ABI = `[{"inputs":[{"components":[{"internalType":"address","name":"sender","type":"address"},{"internalType":"uint256","name":"nonce","type":"uint256"},{"internalType":"bytes","name":"initCode","type":"bytes"},{"internalType":"bytes","name":"callData","type":"bytes"},{"internalType":"bytes32","name":"accountGasLimits","type":"bytes32"},{"internalType":"uint256","name":"preVerificationGas","type":"uint256"},{"internalType":"bytes32","name":"gasFees","type":"bytes32"},{"internalType":"bytes","name":"paymasterAndData","type":"bytes"},{"internalType":"bytes","name":"signature","type":"bytes"}],"internalType":"struct PackedUserOperation","name":"userOp","type":"tuple"},{"internalType":"uint48","name":"validUntil","type":"uint48"},{"internalType":"uint48","name":"validAfter","type":"uint48"}],"name":"getHash","outputs":[{"internalType":"bytes32","name":"","type":"bytes32"}],"stateMutability":"view","type":"function"}]`
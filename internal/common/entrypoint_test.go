@@ -0,0 +1,210 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testUserOpV07() indexer.UserOp {
+	paymaster := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	return indexer.UserOp{
+		Sender:               common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		Nonce:                big.NewInt(7),
+		InitCode:             append(common.HexToAddress("0x3333333333333333333333333333333333333333").Bytes(), 0xaa, 0xbb),
+		CallData:             []byte{0xb6, 0x1d, 0x27, 0xf6},
+		CallGasLimit:         big.NewInt(150000),
+		VerificationGasLimit: big.NewInt(300000),
+		PreVerificationGas:   big.NewInt(50000),
+		MaxFeePerGas:         big.NewInt(2000000000),
+		MaxPriorityFeePerGas: big.NewInt(1000000000),
+		PaymasterAndData:     indexer.PackPaymasterAndData(paymaster, big.NewInt(60000), big.NewInt(1000), []byte{0x01, 0x02}),
+		Signature:            []byte{0x04},
+	}
+}
+
+func TestPackUserOp(t *testing.T) {
+	op := testUserOpV07()
+
+	packed, err := PackUserOp(op)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if new(big.Int).SetBytes(packed.AccountGasLimits[:16]).Cmp(op.VerificationGasLimit) != 0 ||
+		new(big.Int).SetBytes(packed.AccountGasLimits[16:]).Cmp(op.CallGasLimit) != 0 {
+		t.Errorf("accountGasLimits = %x, want the verification then the call gas limit", packed.AccountGasLimits)
+	}
+
+	if new(big.Int).SetBytes(packed.GasFees[:16]).Cmp(op.MaxPriorityFeePerGas) != 0 ||
+		new(big.Int).SetBytes(packed.GasFees[16:]).Cmp(op.MaxFeePerGas) != 0 {
+		t.Errorf("gasFees = %x, want the priority fee then the max fee", packed.GasFees)
+	}
+
+	// gas values of a v0.7 op are uint128
+	op.CallGasLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+	_, err = PackUserOp(op)
+	if err != ErrGasOverflow {
+		t.Errorf("err = %v, want %v", err, ErrGasOverflow)
+	}
+}
+
+func TestUserOpHashV07(t *testing.T) {
+	op := testUserOpV07()
+	entryPoint := indexer.EntryPointV07Address
+
+	hash, err := UserOpHash(op, entryPoint, indexer.EntryPointV07, big.NewInt(1337))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// getUserOpHash of the v0.7 entry point for the op on chain 1337, computed outside of go-ethereum as
+	// keccak256(abi.encode(keccak256(abi.encode(sender, nonce, keccak256(initCode), keccak256(callData),
+	// accountGasLimits, preVerificationGas, gasFees, keccak256(paymasterAndData))), entryPoint, chainId))
+	expected := common.HexToHash("0x715877eb808565d14e23b135ebcb1a80ef8332334b4fa390f502cbcf37a58cb5")
+	if hash != expected {
+		t.Errorf("UserOpHash() = %s, want %s", hash.Hex(), expected.Hex())
+	}
+
+	v06, err := UserOpHash(op, entryPoint, indexer.EntryPointV06, big.NewInt(1337))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hash == v06 {
+		t.Errorf("UserOpHash() of v0.7 = %s, want a different hash than v0.6", hash.Hex())
+	}
+
+	// the signature is not part of the hash
+	op.Signature = []byte{0x05, 0x06}
+
+	other, err := UserOpHash(op, entryPoint, indexer.EntryPointV07, big.NewInt(1337))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if other != hash {
+		t.Errorf("UserOpHash() with another signature = %s, want %s", other.Hex(), hash.Hex())
+	}
+
+	// the paymaster gas limits are part of it
+	op.PaymasterAndData[35]++
+
+	other, err = UserOpHash(op, entryPoint, indexer.EntryPointV07, big.NewInt(1337))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if other == hash {
+		t.Errorf("UserOpHash() with other paymaster gas limits = %s, want a different hash", other.Hex())
+	}
+}
+
+func TestUserOpV07JSON(t *testing.T) {
+	op := testUserOpV07()
+
+	b, err := json.Marshal((*indexer.UserOpV07)(&op))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var fields map[string]any
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fields["factory"] != "0x3333333333333333333333333333333333333333" || fields["paymasterVerificationGasLimit"] != "0xea60" || fields["paymasterData"] != "0x0102" {
+		t.Errorf("unexpected v0.7 fields: %s", b)
+	}
+
+	var decoded indexer.UserOp
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(decoded.InitCode, op.InitCode) || !bytes.Equal(decoded.PaymasterAndData, op.PaymasterAndData) {
+		t.Errorf("decoded op = %+v, want %+v", decoded, op)
+	}
+
+	// an op without a factory or a paymaster has null fields
+	op.InitCode = nil
+	op.PaymasterAndData = nil
+
+	b, err = json.Marshal((*indexer.UserOpV07)(&op))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(string(b), `"factory":null`) || !strings.Contains(string(b), `"paymaster":null`) {
+		t.Errorf("expected null factory and paymaster: %s", b)
+	}
+}
+
+func TestSplitPaymasterData(t *testing.T) {
+	paymaster := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	validity := bytes.Repeat([]byte{0x01}, 64)
+	sig := bytes.Repeat([]byte{0x02}, 65)
+
+	cases := []struct {
+		name    string
+		version indexer.EntryPointVersion
+		data    []byte
+		err     error
+	}{
+		{name: "v0.6", version: indexer.EntryPointV06, data: append(append(paymaster.Bytes(), validity...), sig...)},
+		{name: "v0.7", version: indexer.EntryPointV07, data: indexer.PackPaymasterAndData(paymaster, big.NewInt(60000), big.NewInt(0), append(append([]byte{}, validity...), sig...))},
+		{name: "v0.6 data with v0.7", version: indexer.EntryPointV07, data: append(append(paymaster.Bytes(), validity...), sig...), err: ErrInvalidPaymasterData},
+		{name: "short", version: indexer.EntryPointV06, data: paymaster.Bytes(), err: ErrInvalidPaymasterData},
+	}
+
+	for _, c := range cases {
+		v, s, err := SplitPaymasterData(c.version, c.data)
+		if err != c.err {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.err)
+			continue
+		}
+
+		if c.err == nil && (!bytes.Equal(v, validity) || !bytes.Equal(s, sig)) {
+			t.Errorf("%s: got %x and %x", c.name, v, s)
+		}
+	}
+}
+
+func TestHandleOpsDataV07(t *testing.T) {
+	op := testUserOpV07()
+	beneficiary := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	data, err := HandleOpsData(indexer.EntryPointV07, []indexer.UserOp{op, op}, beneficiary)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	epAbi, err := abi.JSON(strings.NewReader(EntryPointV07ABI))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	method := epAbi.Methods["handleOps"]
+	if !bytes.Equal(data[:4], method.ID) {
+		t.Fatalf("selector = %x, want %x", data[:4], method.ID)
+	}
+
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if values[1].(common.Address) != beneficiary {
+		t.Errorf("beneficiary = %s, want %s", values[1], beneficiary)
+	}
+}
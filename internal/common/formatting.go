@@ -1,6 +1,14 @@
 package common
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	highlightContext = 32  // runes kept before the first highlighted term
+	highlightLength  = 128 // maximum runes kept in a highlighted text
+)
 
 func ShortenName(s string, length int) string {
 	if len(s) <= length*2 {
@@ -11,3 +19,66 @@ func ShortenName(s string, length int) string {
 	lastSix := s[len(s)-length:]
 	return fmt.Sprintf("%s__%s", firstSix, lastSix)
 }
+
+// HighlightTerms wraps every case-insensitive occurrence of the terms in s with open and close
+// long texts are shortened to a window around the first occurrence
+func HighlightTerms(s string, terms []string, open, close string) string {
+	text := []rune(s)
+	lower := []rune(strings.ToLower(s))
+
+	marked := make([]bool, len(text))
+	first := -1
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != string(t) {
+				continue
+			}
+
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > highlightContext {
+		start = first - highlightContext
+	}
+
+	end := len(text)
+	if end-start > highlightLength {
+		end = start + highlightLength
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString(open)
+		}
+
+		b.WriteRune(text[i])
+
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString(close)
+		}
+	}
+
+	if end < len(text) {
+		b.WriteString("...")
+	}
+
+	return b.String()
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -27,3 +28,26 @@ func TestShortenName(t *testing.T) {
 		}
 	}
 }
+
+func TestHighlightTerms(t *testing.T) {
+	long := strings.Repeat("a", 40) + " Table 12 " + strings.Repeat("b", 200)
+
+	cases := []struct {
+		input    string
+		terms    []string
+		expected string
+	}{
+		{"payment for table 12", []string{"table", "12"}, "payment for <b>table</b> <b>12</b>"},
+		{"Table 12, TABLE 13", []string{"table"}, "<b>Table</b> 12, <b>TABLE</b> 13"},
+		{"coffee", []string{"table"}, "coffee"},
+		{"café crème", []string{"crè"}, "café <b>crè</b>me"},
+		{long, []string{"table"}, "..." + strings.Repeat("a", 31) + " <b>Table</b> 12 " + strings.Repeat("b", 87) + "..."},
+	}
+
+	for _, c := range cases {
+		output := HighlightTerms(c.input, c.terms, "<b>", "</b>")
+		if output != c.expected {
+			t.Errorf("HighlightTerms(%q, %v) = %q, want %q", c.input, c.terms, output, c.expected)
+		}
+	}
+}
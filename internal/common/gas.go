@@ -0,0 +1,373 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/entrypoint"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// pre verification gas overheads, these match the reference bundler
+	pvgFixed       = 21000 // cost of the bundle transaction, shared by the ops of a bundle
+	pvgPerUserOp   = 18300 // entry point overhead of each user op
+	pvgPerWord     = 4     // cost of each word of the packed user op
+	pvgZeroByte    = 4     // calldata cost of a zero byte
+	pvgNonZeroByte = 16    // calldata cost of a non-zero byte
+	pvgBundleSize  = 1
+	pvgSigSize     = 65
+
+	// DefaultVerificationGasLimit is used when the entry point cannot simulate the validation of an op
+	DefaultVerificationGasLimit = 300000
+	// DefaultCallGasLimit is used when the call of an op cannot be estimated, the account is not deployed yet
+	DefaultCallGasLimit = 150000
+
+	// estimates are increased by this percentage to leave room for state changes before the op is bundled
+	gasEstimateBuffer = 10
+
+	// gas limits given to the op while simulating it
+	simulationVerificationGasLimit = 5000000
+	simulationCallGasLimit         = 5000000
+)
+
+var (
+	// DummySignature is a well formed ecdsa signature, accounts and paymasters can recover it without reverting
+	DummySignature = hexutil.MustDecode("0xfffffffffffffffffffffffffffffff0000000000000000000000000000000007aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa1c")
+
+	errSimulationUnsupported = errors.New("the entry point does not support simulation")
+
+	// ErrEstimateUnsupported is returned for ops of v0.7 entry points, they can only be simulated with the
+	// EntryPointSimulations contract in a state override which the node does not have
+	ErrEstimateUnsupported = indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "gas estimation is not supported for v0.7 entry points", nil)
+)
+
+type UserOpGasEstimate struct {
+	PreVerificationGas   *big.Int `json:"preVerificationGas"`
+	VerificationGasLimit *big.Int `json:"verificationGasLimit"`
+	CallGasLimit         *big.Int `json:"callGasLimit"`
+}
+
+// MarshalJSON encodes the gas values as hex strings
+func (e UserOpGasEstimate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PreVerificationGas   string `json:"preVerificationGas"`
+		VerificationGasLimit string `json:"verificationGasLimit"`
+		CallGasLimit         string `json:"callGasLimit"`
+	}{
+		hexutil.EncodeBig(e.PreVerificationGas),
+		hexutil.EncodeBig(e.VerificationGasLimit),
+		hexutil.EncodeBig(e.CallGasLimit),
+	})
+}
+
+// PreVerificationGas calculates the gas that the bundler spends on a user op before it is validated,
+// this is the calldata cost of the op and its share of the bundle transaction.
+// Missing gas values and signatures are replaced by dummy values so that incomplete ops can be estimated.
+func PreVerificationGas(op indexer.UserOp, version indexer.EntryPointVersion) (*big.Int, error) {
+	op = withGasDefaults(op)
+	if op.PreVerificationGas == nil {
+		op.PreVerificationGas = big.NewInt(pvgFixed)
+	}
+
+	if len(op.Signature) == 0 {
+		op.Signature = bytes.Repeat([]byte{1}, pvgSigSize)
+	}
+
+	packed, err := packUserOp(op, version)
+	if err != nil {
+		return nil, err
+	}
+
+	cost := int64(0)
+	for _, b := range packed {
+		if b == 0 {
+			cost += pvgZeroByte
+			continue
+		}
+
+		cost += pvgNonZeroByte
+	}
+
+	// the reference bundler rounds (len + 31) / 32 words to the nearest integer
+	words := (pvgPerWord*int64(len(packed)+31) + 16) / 32
+
+	return big.NewInt(cost + pvgFixed/pvgBundleSize + pvgPerUserOp + words), nil
+}
+
+// EstimateUserOpGas estimates the gas values of a user op.
+// The validation is simulated through simulateHandleOp on the entry point, entry points which do not support
+// simulation are given a default verification gas limit. The call is estimated as if the entry point made it.
+// v0.7 entry points moved the simulation to a separate contract, their ops return ErrEstimateUnsupported.
+// Ops that would be rejected return a *indexer.JSONRPCError.
+func EstimateUserOpGas(evm indexer.EVMRequester, entryPoint common.Address, version indexer.EntryPointVersion, op indexer.UserOp) (*UserOpGasEstimate, error) {
+	if version == indexer.EntryPointV07 {
+		return nil, ErrEstimateUnsupported
+	}
+
+	pvg, err := PreVerificationGas(op, version)
+	if err != nil {
+		return nil, err
+	}
+
+	op = withGasDefaults(op)
+	op.PreVerificationGas = pvg
+
+	vgl, err := estimateVerificationGas(evm, entryPoint, op)
+	if err != nil {
+		return nil, err
+	}
+
+	cgl, err := estimateCallGas(evm, entryPoint, op)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserOpGasEstimate{
+		PreVerificationGas:   pvg,
+		VerificationGasLimit: vgl,
+		CallGasLimit:         cgl,
+	}, nil
+}
+
+// estimateVerificationGas simulates the op with fees of 0, no prefund is needed and failed signatures do not revert
+func estimateVerificationGas(evm indexer.EVMRequester, entryPoint common.Address, op indexer.UserOp) (*big.Int, error) {
+	sim := op
+	sim.VerificationGasLimit = big.NewInt(simulationVerificationGasLimit)
+	sim.CallGasLimit = big.NewInt(simulationCallGasLimit)
+	sim.MaxFeePerGas = big.NewInt(0)
+	sim.MaxPriorityFeePerGas = big.NewInt(0)
+	if len(sim.Signature) == 0 {
+		sim.Signature = DummySignature
+	}
+
+	preOpGas, err := simulateHandleOp(evm, entryPoint, sim)
+	if errors.Is(err, errSimulationUnsupported) {
+		return defaultVerificationGas(evm, entryPoint, op)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the pre op gas includes the pre verification gas
+	vgl := new(big.Int).Sub(preOpGas, op.PreVerificationGas)
+	if vgl.Sign() < 0 {
+		vgl = big.NewInt(0)
+	}
+
+	return withGasBuffer(vgl), nil
+}
+
+// defaultVerificationGas adds the cost of deploying the account to the default verification gas limit
+func defaultVerificationGas(evm indexer.EVMRequester, entryPoint common.Address, op indexer.UserOp) (*big.Int, error) {
+	vgl := big.NewInt(DefaultVerificationGasLimit)
+	if len(op.InitCode) < common.AddressLength {
+		return vgl, nil
+	}
+
+	factory := common.BytesToAddress(op.InitCode[:common.AddressLength])
+
+	gas, err := evm.EstimateGasLimit(ethereum.CallMsg{
+		From: entryPoint,
+		To:   &factory,
+		Data: op.InitCode[common.AddressLength:],
+	})
+	if err != nil {
+		return nil, RejectedError("account could not be deployed", err)
+	}
+
+	return vgl.Add(vgl, withGasBuffer(new(big.Int).SetUint64(gas))), nil
+}
+
+// estimateCallGas estimates the call of the op from the entry point, ops of accounts which are not deployed yet get the default
+func estimateCallGas(evm indexer.EVMRequester, entryPoint common.Address, op indexer.UserOp) (*big.Int, error) {
+	if len(op.CallData) == 0 {
+		return big.NewInt(0), nil
+	}
+
+	code, err := evm.CodeAt(evm.Context(), op.Sender, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(code) == 0 {
+		return big.NewInt(DefaultCallGasLimit), nil
+	}
+
+	gas, err := evm.EstimateGasLimit(ethereum.CallMsg{
+		From: entryPoint,
+		To:   &op.Sender,
+		Data: op.CallData,
+	})
+	if err != nil {
+		return nil, RejectedError("call data reverted", err)
+	}
+
+	return withGasBuffer(new(big.Int).SetUint64(gas)), nil
+}
+
+// simulateHandleOp calls simulateHandleOp on the entry point and returns the pre op gas of the execution result
+func simulateHandleOp(evm indexer.EVMRequester, entryPoint common.Address, op indexer.UserOp) (*big.Int, error) {
+	epAbi, err := entrypoint.EntrypointMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := epAbi.Pack("simulateHandleOp", entrypoint.UserOperation(op), common.Address{}, []byte{})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = evm.CallContract(ethereum.CallMsg{
+		To:   &entryPoint,
+		Data: data,
+	}, nil)
+	if err == nil {
+		// simulateHandleOp always reverts
+		return nil, errSimulationUnsupported
+	}
+
+	revert, ok := revertData(err)
+	if !ok {
+		var rpcErr rpc.Error
+		if errors.As(err, &rpcErr) {
+			return nil, errSimulationUnsupported
+		}
+
+		return nil, err
+	}
+
+	if len(revert) < 4 {
+		return nil, errSimulationUnsupported
+	}
+
+	for _, e := range epAbi.Errors {
+		if !bytes.Equal(revert[:4], e.ID[:4]) {
+			continue
+		}
+
+		values, err := e.Inputs.Unpack(revert[4:])
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.Name {
+		case "ExecutionResult":
+			preOpGas, ok := values[0].(*big.Int)
+			if !ok {
+				return nil, errors.New("invalid execution result")
+			}
+
+			return preOpGas, nil
+		case "FailedOp":
+			reason, _ := values[1].(string)
+
+			return nil, failedOpError(reason)
+		}
+	}
+
+	return nil, errSimulationUnsupported
+}
+
+// withGasDefaults returns a copy of the op where missing values are set, this allows packing incomplete ops
+func withGasDefaults(op indexer.UserOp) indexer.UserOp {
+	if op.Nonce == nil {
+		op.Nonce = big.NewInt(0)
+	}
+	if op.CallGasLimit == nil {
+		op.CallGasLimit = big.NewInt(0)
+	}
+	if op.VerificationGasLimit == nil {
+		op.VerificationGasLimit = big.NewInt(0)
+	}
+	if op.MaxFeePerGas == nil {
+		op.MaxFeePerGas = big.NewInt(0)
+	}
+	if op.MaxPriorityFeePerGas == nil {
+		op.MaxPriorityFeePerGas = big.NewInt(0)
+	}
+
+	return op
+}
+
+// withGasBuffer increases gas by the estimate buffer
+func withGasBuffer(gas *big.Int) *big.Int {
+	buffer := new(big.Int).Mul(gas, big.NewInt(gasEstimateBuffer))
+	buffer.Div(buffer, big.NewInt(100))
+
+	return buffer.Add(buffer, gas)
+}
+
+// packUserOp abi encodes all the fields of the op as the entry point takes them, this is what the op costs as calldata
+func packUserOp(op indexer.UserOp, version indexer.EntryPointVersion) ([]byte, error) {
+	addressTy, _ := abi.NewType("address", "address", nil)
+	uint256Ty, _ := abi.NewType("uint256", "uint256", nil)
+	bytesTy, _ := abi.NewType("bytes", "bytes", nil)
+	bytes32Ty, _ := abi.NewType("bytes32", "bytes32", nil)
+
+	if version == indexer.EntryPointV07 {
+		p, err := PackUserOp(op)
+		if err != nil {
+			return nil, err
+		}
+
+		args := abi.Arguments{
+			{Type: addressTy},
+			{Type: uint256Ty},
+			{Type: bytesTy},
+			{Type: bytesTy},
+			{Type: bytes32Ty},
+			{Type: uint256Ty},
+			{Type: bytes32Ty},
+			{Type: bytesTy},
+			{Type: bytesTy},
+		}
+
+		return args.Pack(
+			p.Sender,
+			p.Nonce,
+			p.InitCode,
+			p.CallData,
+			p.AccountGasLimits,
+			p.PreVerificationGas,
+			p.GasFees,
+			p.PaymasterAndData,
+			p.Signature,
+		)
+	}
+
+	args := abi.Arguments{
+		{Type: addressTy},
+		{Type: uint256Ty},
+		{Type: bytesTy},
+		{Type: bytesTy},
+		{Type: uint256Ty},
+		{Type: uint256Ty},
+		{Type: uint256Ty},
+		{Type: uint256Ty},
+		{Type: uint256Ty},
+		{Type: bytesTy},
+		{Type: bytesTy},
+	}
+
+	return args.Pack(
+		op.Sender,
+		op.Nonce,
+		op.InitCode,
+		op.CallData,
+		op.CallGasLimit,
+		op.VerificationGasLimit,
+		op.PreVerificationGas,
+		op.MaxFeePerGas,
+		op.MaxPriorityFeePerGas,
+		op.PaymasterAndData,
+		op.Signature,
+	)
+}
@@ -0,0 +1,264 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/entrypoint"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestPreVerificationGas(t *testing.T) {
+	cases := []struct {
+		name     string
+		op       indexer.UserOp
+		expected int64
+	}{
+		{
+			name:     "empty op with dummy values",
+			op:       indexer.UserOp{},
+			expected: 42592,
+		},
+		{
+			name: "non-zero call data",
+			op: indexer.UserOp{
+				CallData: bytes.Repeat([]byte{0xff}, 32),
+			},
+			expected: 43120,
+		},
+		{
+			name: "signature of zero bytes",
+			op: indexer.UserOp{
+				Signature: make([]byte, 65),
+			},
+			expected: 42592 - 65*12,
+		},
+	}
+
+	for _, c := range cases {
+		output, err := PreVerificationGas(c.op, indexer.EntryPointV06)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		if output.Cmp(big.NewInt(c.expected)) != 0 {
+			t.Errorf("%s: PreVerificationGas() = %v, want %d", c.name, output, c.expected)
+		}
+	}
+}
+
+func TestPreVerificationGasKeepsOp(t *testing.T) {
+	op := indexer.UserOp{}
+
+	_, err := PreVerificationGas(op, indexer.EntryPointV06)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if op.Signature != nil || op.PreVerificationGas != nil || op.Nonce != nil {
+		t.Errorf("PreVerificationGas() modified the op: %+v", op)
+	}
+}
+
+// TestGasEVM only implements what the estimation uses, other calls panic
+type TestGasEVM struct {
+	indexer.EVMRequester
+
+	simulation  error  // returned by simulateHandleOp
+	code        []byte // code of every address
+	gas         uint64 // estimate of every call
+	estimateErr error
+
+	simulated []byte // call data of the last simulation
+}
+
+func (e *TestGasEVM) Context() context.Context {
+	return context.Background()
+}
+
+func (e *TestGasEVM) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return e.code, nil
+}
+
+func (e *TestGasEVM) EstimateGasLimit(msg ethereum.CallMsg) (uint64, error) {
+	return e.gas, e.estimateErr
+}
+
+func (e *TestGasEVM) CallContract(call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	e.simulated = call.Data
+	return nil, e.simulation
+}
+
+// entryPointRevert encodes an error of the v0.6 entry point as revert data
+func entryPointRevert(t *testing.T, name string, args ...any) error {
+	epAbi, err := entrypoint.EntrypointMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := epAbi.Errors[name]
+
+	data, err := e.Inputs.Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testRevertError{data: hexutil.Encode(append(e.ID[:4], data...))}
+}
+
+func TestEstimateUserOpGas(t *testing.T) {
+	entryPoint := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+
+	op := indexer.UserOp{
+		Sender:           common.HexToAddress("0x01"),
+		Nonce:            big.NewInt(1),
+		InitCode:         []byte{},
+		CallData:         []byte{0xb6, 0x1d, 0x27, 0xf6},
+		PaymasterAndData: append(common.HexToAddress("0x02").Bytes(), bytes.Repeat([]byte{1}, 64+65)...),
+	}
+
+	pvg, err := PreVerificationGas(op, indexer.EntryPointV06)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("simulated", func(t *testing.T) {
+		preOpGas := new(big.Int).Add(pvg, big.NewInt(100000))
+
+		evm := &TestGasEVM{
+			simulation: entryPointRevert(t, "ExecutionResult", preOpGas, big.NewInt(0), big.NewInt(0), big.NewInt(0), false, []byte{}),
+			code:       []byte{1},
+			gas:        50000,
+		}
+
+		estimate, err := EstimateUserOpGas(evm, entryPoint, indexer.EntryPointV06, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if estimate.PreVerificationGas.Cmp(pvg) != 0 {
+			t.Errorf("PreVerificationGas = %v, want %v", estimate.PreVerificationGas, pvg)
+		}
+
+		// the gas above the pre verification gas, with a buffer of 10%
+		if estimate.VerificationGasLimit.Cmp(big.NewInt(110000)) != 0 {
+			t.Errorf("VerificationGasLimit = %v, want 110000", estimate.VerificationGasLimit)
+		}
+
+		if estimate.CallGasLimit.Cmp(big.NewInt(55000)) != 0 {
+			t.Errorf("CallGasLimit = %v, want 55000", estimate.CallGasLimit)
+		}
+
+		// the paymaster is part of the simulation, its validation is included in the verification gas
+		if !bytes.Contains(evm.simulated, op.PaymasterAndData) {
+			t.Error("the paymaster data was not simulated")
+		}
+	})
+
+	t.Run("paymaster rejection", func(t *testing.T) {
+		evm := &TestGasEVM{
+			simulation: entryPointRevert(t, "FailedOp", big.NewInt(0), "AA33 reverted (or OOG)"),
+			code:       []byte{1},
+		}
+
+		_, err := EstimateUserOpGas(evm, entryPoint, indexer.EntryPointV06, op)
+
+		var rpcErr *indexer.JSONRPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != indexer.JSONRPCErrorCodePaymasterRejected {
+			t.Fatalf("err = %v, want a paymaster rejection", err)
+		}
+	})
+
+	t.Run("account rejection", func(t *testing.T) {
+		evm := &TestGasEVM{
+			simulation: entryPointRevert(t, "FailedOp", big.NewInt(0), "AA23 reverted (or OOG)"),
+			code:       []byte{1},
+		}
+
+		_, err := EstimateUserOpGas(evm, entryPoint, indexer.EntryPointV06, op)
+
+		var rpcErr *indexer.JSONRPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != indexer.JSONRPCErrorCodeRejected {
+			t.Fatalf("err = %v, want a rejection", err)
+		}
+	})
+
+	t.Run("simulation unsupported", func(t *testing.T) {
+		evm := &TestGasEVM{
+			code: []byte{1},
+			gas:  50000,
+		}
+
+		estimate, err := EstimateUserOpGas(evm, entryPoint, indexer.EntryPointV06, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if estimate.VerificationGasLimit.Cmp(big.NewInt(DefaultVerificationGasLimit)) != 0 {
+			t.Errorf("VerificationGasLimit = %v, want %d", estimate.VerificationGasLimit, DefaultVerificationGasLimit)
+		}
+	})
+
+	t.Run("account not deployed", func(t *testing.T) {
+		undeployed := op
+		undeployed.Nonce = big.NewInt(0)
+		undeployed.InitCode = append(common.HexToAddress("0x03").Bytes(), 0x5f, 0xbf, 0xb9, 0xcf)
+
+		// without simulation, the deployment is estimated on its own
+		evm := &TestGasEVM{
+			gas: 200000,
+		}
+
+		estimate, err := EstimateUserOpGas(evm, entryPoint, indexer.EntryPointV06, undeployed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if estimate.VerificationGasLimit.Cmp(big.NewInt(DefaultVerificationGasLimit+220000)) != 0 {
+			t.Errorf("VerificationGasLimit = %v, want %d", estimate.VerificationGasLimit, DefaultVerificationGasLimit+220000)
+		}
+
+		if estimate.CallGasLimit.Cmp(big.NewInt(DefaultCallGasLimit)) != 0 {
+			t.Errorf("CallGasLimit = %v, want %d", estimate.CallGasLimit, DefaultCallGasLimit)
+		}
+	})
+
+	t.Run("v0.7", func(t *testing.T) {
+		_, err := EstimateUserOpGas(&TestGasEVM{}, entryPoint, indexer.EntryPointV07, op)
+		if !errors.Is(err, ErrEstimateUnsupported) {
+			t.Fatalf("err = %v, want %v", err, ErrEstimateUnsupported)
+		}
+	})
+
+	t.Run("call reverts", func(t *testing.T) {
+		evm := &TestGasEVM{
+			code:        []byte{1},
+			estimateErr: testRevertError{data: "0x08c379a0"},
+		}
+
+		_, err := EstimateUserOpGas(evm, entryPoint, indexer.EntryPointV06, op)
+
+		var rpcErr *indexer.JSONRPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != indexer.JSONRPCErrorCodeRejected || rpcErr.Data != "0x08c379a0" {
+			t.Fatalf("err = %v, want a rejection with the revert data", err)
+		}
+	})
+}
+
+func TestRejectedError(t *testing.T) {
+	err := errors.New("connection refused")
+	if RejectedError("call data reverted", err) != err {
+		t.Error("RejectedError() changed an error that is not from the node")
+	}
+
+	var rpcErr *indexer.JSONRPCError
+	if !errors.As(RejectedError("call data reverted", testRevertError{data: "0x01"}), &rpcErr) || rpcErr.Message != "call data reverted" {
+		t.Errorf("RejectedError() = %v, want a rejection", rpcErr)
+	}
+}
@@ -15,6 +15,25 @@ const (
 	ResponseTypeObject ResponseType = "object"
 	ResponseTypeArray  ResponseType = "array"
 	ResponseTypeSecure ResponseType = "secure"
+	ResponseTypeError  ResponseType = "error"
+)
+
+// ErrorCode is a machine readable reason for an error response
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest       ErrorCode = "bad_request"       // the request could not be parsed
+	ErrorCodeInvalidParam     ErrorCode = "invalid_param"     // a path or query param is invalid, details contains the param
+	ErrorCodeInvalidAddress   ErrorCode = "invalid_address"   // an address is not a valid hex address
+	ErrorCodeMissingSignature ErrorCode = "missing_signature" // the signature or the address header is missing
+	ErrorCodeInvalidSignature ErrorCode = "invalid_signature" // the signature does not match the body and the address
+	ErrorCodeExpiredSignature ErrorCode = "expired_signature" // the signed body has expired
+	ErrorCodeUnauthorized     ErrorCode = "unauthorized"      // the caller is not allowed to access the resource
+	ErrorCodeNotFound         ErrorCode = "not_found"         // the resource does not exist
+	ErrorCodeTokenNotFound    ErrorCode = "token_not_found"   // the token is not indexed, there is no table for it
+	ErrorCodeConflict         ErrorCode = "conflict"          // the resource already exists
+	ErrorCodeUpstream         ErrorCode = "upstream_error"    // a request to the chain or to another service failed
+	ErrorCodeInternal         ErrorCode = "internal_error"
 )
 
 type AddressResponse struct {
@@ -27,6 +46,13 @@ type Pagination struct {
 	Total  int `json:"total"`
 }
 
+// ErrorObject describes why a request failed
+type ErrorObject struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Details any       `json:"details,omitempty"`
+}
+
 // Response is the default response object
 // swagger:response defaultResponse
 type Response struct {
@@ -36,6 +62,7 @@ type Response struct {
 	Object       any          `json:"object,omitempty"`
 	Array        any          `json:"array,omitempty"`
 	Meta         any          `json:"meta,omitempty"`
+	Error        *ErrorObject `json:"error,omitempty"`
 }
 
 func Body(w http.ResponseWriter, body any, meta any) error {
@@ -72,6 +99,42 @@ func BodyMultiple(w http.ResponseWriter, body any, meta any) error {
 	return nil
 }
 
+// ResponseVersionErrors is the response version from which errors are responded with an ErrorObject
+const ResponseVersionErrors = "2"
+
+// ErrorBody responds with the given status and an error, details are optional
+// clients that did not opt in to ResponseVersionErrors only get the status
+func ErrorBody(w http.ResponseWriter, status int, code ErrorCode, message string, details any) error {
+	if w.Header().Get(indexer.ResponseVersionHeader) != ResponseVersionErrors {
+		w.WriteHeader(status)
+		return nil
+	}
+
+	b, err := json.Marshal(&Response{
+		ResponseType: ResponseTypeError,
+		Error: &ErrorObject{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+
+	return nil
+}
+
+// ParamDetails are the details of an ErrorCodeInvalidParam error
+type ParamDetails struct {
+	Param string `json:"param"`
+}
+
 func StreamedBody(w http.ResponseWriter, body string) error {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -89,13 +152,10 @@ func StreamedBody(w http.ResponseWriter, body string) error {
 	return nil
 }
 
-func JSONRPCBody(w http.ResponseWriter, id int, body any, meta any) error {
+// JSONRPCBody responds to a single JSON-RPC call, errors are part of the response so the status is always 200
+func JSONRPCBody(w http.ResponseWriter, resp *indexer.JsonRPCResponse) error {
 
-	b, err := json.Marshal(&indexer.JsonRPCResponse{
-		Version: "2.0",
-		ID:      id,
-		Result:  body,
-	})
+	b, err := json.Marshal(resp)
 	if err != nil {
 		return err
 	}
@@ -106,23 +166,10 @@ func JSONRPCBody(w http.ResponseWriter, id int, body any, meta any) error {
 	return nil
 }
 
-func JSONRPCMultiBody(w http.ResponseWriter, ids []int, bodies []any, meta any) error {
-
-	if len(ids) != len(bodies) {
-		return errors.New("ids and bodies must have the same length")
-	}
-
-	responses := make([]indexer.JsonRPCResponse, len(ids))
-
-	for i, id := range ids {
-		responses[i] = indexer.JsonRPCResponse{
-			Version: "2.0",
-			ID:      id,
-			Result:  bodies[i],
-		}
-	}
+// JSONRPCMultiBody responds to a batch of JSON-RPC calls, in the order of the calls
+func JSONRPCMultiBody(w http.ResponseWriter, resps []*indexer.JsonRPCResponse) error {
 
-	b, err := json.Marshal(&responses)
+	b, err := json.Marshal(&resps)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+func TestErrorBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set(indexer.ResponseVersionHeader, ResponseVersionErrors)
+
+	err := ErrorBody(w, http.StatusBadRequest, ErrorCodeInvalidParam, "invalid limit", ParamDetails{Param: "limit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected json, got %s", w.Header().Get("Content-Type"))
+	}
+
+	var resp struct {
+		ResponseType ResponseType `json:"response_type"`
+		Error        struct {
+			Code    ErrorCode    `json:"code"`
+			Message string       `json:"message"`
+			Details ParamDetails `json:"details"`
+		} `json:"error"`
+	}
+
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ResponseType != ResponseTypeError {
+		t.Fatalf("expected %s, got %s", ResponseTypeError, resp.ResponseType)
+	}
+
+	if resp.Error.Code != ErrorCodeInvalidParam || resp.Error.Message != "invalid limit" || resp.Error.Details.Param != "limit" {
+		t.Fatalf("unexpected error %+v", resp.Error)
+	}
+}
+
+func TestErrorBodyLegacy(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	err := ErrorBody(w, http.StatusNotFound, ErrorCodeNotFound, "transfer not found", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w.Body.Len() != 0 {
+		t.Fatalf("expected no body, got %s", w.Body.String())
+	}
+}
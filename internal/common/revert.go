@@ -0,0 +1,129 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/entrypoint"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// IsRevert returns true if the error of a call or an estimation is a revert of the contract
+func IsRevert(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := revertData(err); ok {
+		return true
+	}
+
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(rpcErr.Error()), "revert")
+}
+
+// RevertError decodes the revert of a call to the entry point into a rejection of the op,
+// FailedOp errors and revert strings are given as the message and the revert data is kept as data
+func RevertError(err error) *indexer.JSONRPCError {
+	data, ok := revertData(err)
+	if !ok || len(data) < 4 {
+		return indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeRejected, err.Error(), nil)
+	}
+
+	hexData := hexutil.Encode(data)
+
+	epAbi, aerr := entrypoint.EntrypointMetaData.GetAbi()
+	if aerr == nil {
+		failedOp := epAbi.Errors["FailedOp"]
+		if bytes.Equal(data[:4], failedOp.ID[:4]) {
+			values, uerr := failedOp.Inputs.Unpack(data[4:])
+			if uerr == nil {
+				reason, _ := values[1].(string)
+
+				rejection := failedOpError(reason)
+				rejection.Data = hexData
+
+				return rejection
+			}
+		}
+	}
+
+	// v0.7 entry points also return the revert of the account or the paymaster
+	v07Abi, aerr := abi.JSON(strings.NewReader(EntryPointV07ABI))
+	if aerr == nil {
+		failedOp := v07Abi.Errors["FailedOpWithRevert"]
+		if bytes.Equal(data[:4], failedOp.ID[:4]) {
+			values, uerr := failedOp.Inputs.Unpack(data[4:])
+			if uerr == nil {
+				reason, _ := values[1].(string)
+
+				rejection := failedOpError(reason)
+				rejection.Data = hexData
+
+				return rejection
+			}
+		}
+	}
+
+	reason, uerr := abi.UnpackRevert(data)
+	if uerr == nil {
+		return indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeRejected, reason, hexData)
+	}
+
+	return indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeRejected, "execution reverted", hexData)
+}
+
+// failedOpError maps the reason of a FailedOp to a rejection, AA3x reasons come from the paymaster
+func failedOpError(reason string) *indexer.JSONRPCError {
+	code := indexer.JSONRPCErrorCodeRejected
+	if len(reason) >= 4 && reason[:3] == "AA3" {
+		code = indexer.JSONRPCErrorCodePaymasterRejected
+	}
+
+	return indexer.NewJSONRPCError(code, reason, nil)
+}
+
+// RejectedError returns the errors of the node as a rejection of the op with the given message and the revert data if there is any,
+// other errors are returned as is
+func RejectedError(message string, err error) error {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+
+	var data any
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		data = dataErr.ErrorData()
+	}
+
+	return indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeRejected, message, data)
+}
+
+// revertData returns the revert data of a failed call if the node returned any
+func revertData(err error) ([]byte, bool) {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return nil, false
+	}
+
+	v, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+
+	b, err := hexutil.Decode(v)
+	if err != nil {
+		return nil, false
+	}
+
+	return b, true
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/entrypoint"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type testRevertError struct {
+	data string
+}
+
+func (e testRevertError) Error() string {
+	return "execution reverted"
+}
+
+func (e testRevertError) ErrorCode() int {
+	return 3
+}
+
+func (e testRevertError) ErrorData() interface{} {
+	return e.data
+}
+
+func TestRevertError(t *testing.T) {
+	epAbi, err := entrypoint.EntrypointMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failedOp := epAbi.Errors["FailedOp"]
+
+	packArgs := func(reason string) string {
+		args, err := failedOp.Inputs.Pack(big.NewInt(0), reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return hexutil.Encode(append(failedOp.ID[:4], args...))
+	}
+
+	// Error(string) with "not allowed"
+	revertString := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"000000000000000000000000000000000000000000000000000000000000000b" +
+		"6e6f7420616c6c6f776564000000000000000000000000000000000000000000"
+
+	cases := []struct {
+		name    string
+		err     error
+		revert  bool
+		code    int
+		message string
+	}{
+		{
+			name:    "failed op",
+			err:     testRevertError{packArgs("AA21 didn't pay prefund")},
+			revert:  true,
+			code:    indexer.JSONRPCErrorCodeRejected,
+			message: "AA21 didn't pay prefund",
+		},
+		{
+			name:    "failed op from the paymaster",
+			err:     testRevertError{packArgs("AA33 reverted")},
+			revert:  true,
+			code:    indexer.JSONRPCErrorCodePaymasterRejected,
+			message: "AA33 reverted",
+		},
+		{
+			name:    "revert string",
+			err:     testRevertError{revertString},
+			revert:  true,
+			code:    indexer.JSONRPCErrorCodeRejected,
+			message: "not allowed",
+		},
+		{
+			name:    "unknown revert data",
+			err:     testRevertError{"0xdeadbeef"},
+			revert:  true,
+			code:    indexer.JSONRPCErrorCodeRejected,
+			message: "execution reverted",
+		},
+		{
+			name:   "not a revert",
+			err:    errors.New("connection refused"),
+			revert: false,
+		},
+	}
+
+	for _, c := range cases {
+		if IsRevert(c.err) != c.revert {
+			t.Errorf("%s: IsRevert() = %v, want %v", c.name, !c.revert, c.revert)
+		}
+
+		if !c.revert {
+			continue
+		}
+
+		rejection := RevertError(c.err)
+		if rejection.Code != c.code || rejection.Message != c.message {
+			t.Errorf("%s: RevertError() = %d %s, want %d %s", c.name, rejection.Code, rejection.Message, c.code, c.message)
+		}
+	}
+}
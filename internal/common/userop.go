@@ -0,0 +1,177 @@
+package common
+
+import (
+	"math/big"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/entrypoint"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// UserOpResult is the result of a user operation as emitted by the entry point in its UserOperationEvent
+type UserOpResult struct {
+	Paymaster     common.Address
+	Success       bool
+	ActualGasCost *big.Int
+	ActualGasUsed *big.Int
+	Reason        string       // the hex encoded revert reason of the call
+	Logs          []*types.Log // the logs between the start of the execution or the previous op and the event
+}
+
+// UserOpHash returns the hash of a user operation as defined by ERC-4337 for the version of its entry point,
+// it is unique per entry point and chain and is what clients use to look up their op
+func UserOpHash(op indexer.UserOp, entryPoint common.Address, version indexer.EntryPointVersion, chainId *big.Int) (common.Hash, error) {
+	op = withGasDefaults(op)
+	if op.PreVerificationGas == nil {
+		op.PreVerificationGas = big.NewInt(0)
+	}
+
+	addressTy, _ := abi.NewType("address", "address", nil)
+	uint256Ty, _ := abi.NewType("uint256", "uint256", nil)
+	bytes32Ty, _ := abi.NewType("bytes32", "bytes32", nil)
+
+	var packed []byte
+	var err error
+
+	// the dynamic fields are hashed and the signature is left out
+	if version == indexer.EntryPointV07 {
+		p, perr := PackUserOp(op)
+		if perr != nil {
+			return common.Hash{}, perr
+		}
+
+		opArgs := abi.Arguments{
+			{Type: addressTy},
+			{Type: uint256Ty},
+			{Type: bytes32Ty},
+			{Type: bytes32Ty},
+			{Type: bytes32Ty},
+			{Type: uint256Ty},
+			{Type: bytes32Ty},
+			{Type: bytes32Ty},
+		}
+
+		packed, err = opArgs.Pack(
+			p.Sender,
+			p.Nonce,
+			crypto.Keccak256Hash(p.InitCode),
+			crypto.Keccak256Hash(p.CallData),
+			p.AccountGasLimits,
+			p.PreVerificationGas,
+			p.GasFees,
+			crypto.Keccak256Hash(p.PaymasterAndData),
+		)
+	} else {
+		opArgs := abi.Arguments{
+			{Type: addressTy},
+			{Type: uint256Ty},
+			{Type: bytes32Ty},
+			{Type: bytes32Ty},
+			{Type: uint256Ty},
+			{Type: uint256Ty},
+			{Type: uint256Ty},
+			{Type: uint256Ty},
+			{Type: uint256Ty},
+			{Type: bytes32Ty},
+		}
+
+		packed, err = opArgs.Pack(
+			op.Sender,
+			op.Nonce,
+			crypto.Keccak256Hash(op.InitCode),
+			crypto.Keccak256Hash(op.CallData),
+			op.CallGasLimit,
+			op.VerificationGasLimit,
+			op.PreVerificationGas,
+			op.MaxFeePerGas,
+			op.MaxPriorityFeePerGas,
+			crypto.Keccak256Hash(op.PaymasterAndData),
+		)
+	}
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	hashArgs := abi.Arguments{
+		{Type: bytes32Ty},
+		{Type: addressTy},
+		{Type: uint256Ty},
+	}
+
+	encoded, err := hashArgs.Pack(crypto.Keccak256Hash(packed), entryPoint, chainId)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	return crypto.Keccak256Hash(encoded), nil
+}
+
+// ParseUserOpResult finds the result of the user operation with the given hash in the logs of a bundle,
+// nil is returned if the entry point did not emit a UserOperationEvent for it
+func ParseUserOpResult(logs []*types.Log, hash common.Hash) (*UserOpResult, error) {
+	epAbi, err := entrypoint.EntrypointMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+
+	opEvent := epAbi.Events["UserOperationEvent"]
+	revertEvent := epAbi.Events["UserOperationRevertReason"]
+	beforeEvent := epAbi.Events["BeforeExecution"]
+
+	reason := ""
+	start := 0
+	for i, l := range logs {
+		if len(l.Topics) == 0 {
+			continue
+		}
+
+		switch l.Topics[0] {
+		case beforeEvent.ID:
+			start = i + 1
+		case revertEvent.ID:
+			if len(l.Topics) < 2 || l.Topics[1] != hash {
+				continue
+			}
+
+			values, err := revertEvent.Inputs.NonIndexed().Unpack(l.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			if r, ok := values[1].([]byte); ok {
+				reason = hexutil.Encode(r)
+			}
+		case opEvent.ID:
+			if len(l.Topics) < 4 || l.Topics[1] != hash {
+				start = i + 1
+				continue
+			}
+
+			values, err := opEvent.Inputs.NonIndexed().Unpack(l.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			res := &UserOpResult{
+				Paymaster: common.BytesToAddress(l.Topics[3].Bytes()),
+				Logs:      logs[start:i],
+			}
+
+			res.Success, _ = values[1].(bool)
+			res.ActualGasCost, _ = values[2].(*big.Int)
+			res.ActualGasUsed, _ = values[3].(*big.Int)
+
+			if !res.Success {
+				res.Reason = reason
+			}
+
+			return res, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// the expected hash was computed by getUserOpHash of a v0.6 entry point deployed on a chain with id 1337
+func TestUserOpHash(t *testing.T) {
+	op := indexer.UserOp{
+		Sender:               common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		Nonce:                big.NewInt(7),
+		InitCode:             []byte{0xaa, 0xbb},
+		CallData:             []byte{0xb6, 0x1d, 0x27, 0xf6},
+		CallGasLimit:         big.NewInt(150000),
+		VerificationGasLimit: big.NewInt(300000),
+		PreVerificationGas:   big.NewInt(50000),
+		MaxFeePerGas:         big.NewInt(2000000000),
+		MaxPriorityFeePerGas: big.NewInt(1000000000),
+		PaymasterAndData:     []byte{0x01, 0x02, 0x03},
+		Signature:            []byte{0x04},
+	}
+
+	entryPoint := common.HexToAddress("0x68D4314A27F3Dc45C7bC2d5Dd4b36d096098A8Ec")
+	expected := common.HexToHash("0x32037d15698cf3c26e57491523f1a692ecfc0a89a08d8eaeba540510380e3701")
+
+	hash, err := UserOpHash(op, entryPoint, indexer.EntryPointV06, big.NewInt(1337))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hash != expected {
+		t.Errorf("UserOpHash() = %s, want %s", hash.Hex(), expected.Hex())
+	}
+
+	// the signature is not part of the hash
+	op.Signature = []byte{0x05, 0x06}
+
+	hash, err = UserOpHash(op, entryPoint, indexer.EntryPointV06, big.NewInt(1337))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hash != expected {
+		t.Errorf("UserOpHash() with another signature = %s, want %s", hash.Hex(), expected.Hex())
+	}
+
+	// the hash is unique per chain
+	hash, err = UserOpHash(op, entryPoint, indexer.EntryPointV06, big.NewInt(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hash == expected {
+		t.Errorf("UserOpHash() on another chain = %s, want a different hash", hash.Hex())
+	}
+}
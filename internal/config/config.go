@@ -20,8 +20,15 @@ type Config struct {
 	PinataBaseURL   string `env:"PINATA_BASE_URL"`
 	PinataAPIKey    string `env:"PINATA_API_KEY"`
 	PinataAPISecret string `env:"PINATA_API_SECRET"`
+	IPFSURL         string `env:"IPFS_URL,default=https://ipfs.io"`
 	DiscordURL      string `env:"DISCORD_URL,required"`
-	DBSecret        string `env:"DB_SECRET,required"`
+	DBSecret        string `env:"DB_SECRET"`  // decrypts the sponsor keys, only set where the keys are used
+	SignerURL       string `env:"SIGNER_URL"` // the sponsor keys are used by the signer at this url instead
+	SignerToken     string `env:"SIGNER_TOKEN"`
+	SignerCA        string `env:"SIGNER_CA"`       // path to the certificate that the certificate of the signer is verified with
+	EntryPointsV06  string `env:"ENTRYPOINTS_V06"` // comma separated, the canonical v0.6 entry point is always included
+	EntryPointsV07  string `env:"ENTRYPOINTS_V07"` // comma separated, the canonical v0.7 entry point is always included
+	Admins          string `env:"ADMINS"`          // comma separated, the addresses that can manage the out of order sponsorships
 }
 
 func New(ctx context.Context, envpath, confpath string) (*Config, error) {
@@ -62,3 +69,17 @@ func New(ctx context.Context, envpath, confpath string) (*Config, error) {
 
 	return cfg, nil
 }
+
+// CheckSigner checks that the sponsor keys are either decrypted with DB_SECRET or used by the signer at SIGNER_URL.
+// A process that uses a signer should not be able to decrypt the keys.
+func (c *Config) CheckSigner() error {
+	if c.SignerURL != "" && c.DBSecret != "" {
+		return fmt.Errorf("DB_SECRET should not be set when SIGNER_URL is set")
+	}
+
+	if c.SignerURL == "" && c.DBSecret == "" {
+		return fmt.Errorf("DB_SECRET or SIGNER_URL is required")
+	}
+
+	return nil
+}
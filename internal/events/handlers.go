@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	com "github.com/citizenwallet/indexer/internal/common"
 	"github.com/citizenwallet/indexer/internal/services/db"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 )
@@ -25,7 +26,7 @@ func (s *Service) AddEvent(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(ev)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
@@ -33,29 +34,29 @@ func (s *Service) AddEvent(w http.ResponseWriter, r *http.Request) {
 	// check whether event already exists
 	name, err := s.db.TableNameSuffix(ev.Contract)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	exists, err := s.db.TransferTableExists(name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not check the transfer table", nil)
 		return
 	}
 
 	if exists {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeConflict, "the event already exists", nil)
 		return
 	}
 
 	exists, err = s.db.PushTokenTableExists(name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not check the push token table", nil)
 		return
 	}
 
 	if exists {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeConflict, "the event already exists", nil)
 		return
 	}
 
@@ -65,45 +66,76 @@ func (s *Service) AddEvent(w http.ResponseWriter, r *http.Request) {
 	// create transfer db for event
 	txdb, err := s.db.AddTransferDB(ev.Contract)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the transfer db", nil)
 		return
 	}
 
 	err = txdb.CreateTransferTable()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the transfer db", nil)
 		return
 	}
 
 	err = txdb.CreateTransferTableIndexes()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the transfer db", nil)
+		return
+	}
+
+	err = txdb.CreateTransferSearchTable()
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the transfer db", nil)
+		return
+	}
+
+	err = txdb.CreateBalanceCheckpointTable()
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the transfer db", nil)
+		return
+	}
+
+	// create stats db for event
+	sdb, err := s.db.AddStatsDB(ev.Contract)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the stats db", nil)
+		return
+	}
+
+	err = sdb.CreateStatsTables()
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the stats db", nil)
+		return
+	}
+
+	err = sdb.CreateStatsTablesIndexes()
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the stats db", nil)
 		return
 	}
 
 	// create push token db for event
 	ptdb, err := s.db.AddPushTokenDB(ev.Contract)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the push token db", nil)
 		return
 	}
 
 	err = ptdb.CreatePushTable()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the push token db", nil)
 		return
 	}
 
 	err = ptdb.CreatePushTableIndexes()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not create the push token db", nil)
 		return
 	}
 
 	// add event to database
 	err = s.db.EventDB.AddEvent(ev.Contract, ev.State, ev.StartBlock, ev.LastBlock, ev.Standard, ev.Name, ev.Symbol, ev.Decimals)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not add the event", nil)
 		return
 	}
 }
@@ -0,0 +1,208 @@
+package gas
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxGasDays = 366
+)
+
+type Service struct {
+	db     *db.DB
+	signer indexer.Signer
+}
+
+func NewService(db *db.DB, signer indexer.Signer) *Service {
+	return &Service{
+		db:     db,
+		signer: signer,
+	}
+}
+
+// GetAll godoc
+//
+//	@Summary		Fetch gas spend of the paymasters of a sponsor
+//	@Description	get the gas that the bundles of each paymaster used and what it cost between two days. The request should be signed by a sponsor, only its paymasters are included.
+//	@Tags			gas
+//	@Accept			json
+//	@Produce		json
+//	@Param			from	query		string	false	"First day of the report (2006-01-02), defaults to the start of the month"
+//	@Param			to	query		string	false	"Last day of the report (2006-01-02), defaults to today"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/gas [get]
+func (s *Service) GetAll(w http.ResponseWriter, r *http.Request) {
+	addr, ok := com.GetContextAddress(r.Context())
+	if !ok {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
+		return
+	}
+
+	from, to, ok := parseDays(w, r)
+	if !ok {
+		return
+	}
+
+	spend, err := s.db.GasDB.GetGasSpend(indexer.GasGroupPaymaster, "", "", from, to)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the gas spend", nil)
+		return
+	}
+
+	// only the paymasters that the signed address sponsors
+	sponsored := []*indexer.GasSpend{}
+	for _, sp := range spend {
+		sponsor, err := s.signer.Sponsor(common.HexToAddress(sp.Key))
+		if err != nil || sponsor != common.HexToAddress(addr) {
+			continue
+		}
+
+		sponsored = append(sponsored, sp)
+	}
+
+	s.respond(w, sponsored)
+}
+
+// Get godoc
+//
+//	@Summary		Fetch gas spend of a paymaster
+//	@Description	get the gas that the bundles of a paymaster used and what it cost between two days, by day or by account. The request should be signed by the sponsor of the paymaster.
+//	@Tags			gas
+//	@Accept			json
+//	@Produce		json
+//	@Param			pm_address	path		string	true	"Paymaster Contract Address"
+//	@Param			group	query		string	false	"Grouping of the report (day or account)"
+//	@Param			account	query		string	false	"Only include the ops of an account"
+//	@Param			from	query		string	false	"First day of the report (2006-01-02), defaults to the start of the month"
+//	@Param			to	query		string	false	"Last day of the report (2006-01-02), defaults to today"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/gas/{pm_address} [get]
+func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
+	paymaster, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	// parse group from url query
+	groupq := r.URL.Query().Get("group")
+	if groupq == "" {
+		groupq = string(indexer.GasGroupDay)
+	}
+
+	group, err := indexer.GasGroupFromString(groupq)
+	if err != nil || group == indexer.GasGroupPaymaster {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid group, expected day or account", com.ParamDetails{Param: "group"})
+		return
+	}
+
+	account := ""
+	if accq := r.URL.Query().Get("account"); accq != "" {
+		if !common.IsHexAddress(accq) {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid account address", com.ParamDetails{Param: "account"})
+			return
+		}
+
+		account = common.HexToAddress(accq).Hex()
+	}
+
+	from, to, ok := parseDays(w, r)
+	if !ok {
+		return
+	}
+
+	spend, err := s.db.GasDB.GetGasSpend(group, paymaster, account, from, to)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the gas spend", nil)
+		return
+	}
+
+	s.respond(w, spend)
+}
+
+func (s *Service) respond(w http.ResponseWriter, spend []*indexer.GasSpend) {
+	err := com.BodyMultiple(w, spend, com.Pagination{Limit: len(spend), Offset: 0, Total: len(spend)})
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// authorize returns the paymaster in the url, an error response is written if the request was not signed by its sponsor
+func (s *Service) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	addr, ok := com.GetContextAddress(r.Context())
+	if !ok {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
+		return "", false
+	}
+
+	// parse paymaster address from url params
+	pmq := chi.URLParam(r, "pm_address")
+	if !common.IsHexAddress(pmq) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid paymaster address", com.ParamDetails{Param: "pm_address"})
+		return "", false
+	}
+
+	paymaster := common.HexToAddress(pmq)
+
+	sponsor, err := s.signer.Sponsor(paymaster)
+	if err != nil {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeNotFound, "the paymaster has no sponsor", nil)
+		return "", false
+	}
+
+	if common.HexToAddress(addr) != sponsor {
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address is not the sponsor of the paymaster", nil)
+		return "", false
+	}
+
+	return paymaster.Hex(), true
+}
+
+// parseDays parses the days of a report from the url query, an error response is written if they are invalid
+func parseDays(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	to := time.Now().UTC()
+	if toq, _ := url.QueryUnescape(r.URL.Query().Get("to")); toq != "" {
+		t, err := time.Parse(indexer.GasDayLayout, toq)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid to date, expected 2006-01-02", com.ParamDetails{Param: "to"})
+			return "", "", false
+		}
+
+		to = t
+	}
+
+	from := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if fromq, _ := url.QueryUnescape(r.URL.Query().Get("from")); fromq != "" {
+		t, err := time.Parse(indexer.GasDayLayout, fromq)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid from date, expected 2006-01-02", com.ParamDetails{Param: "from"})
+			return "", "", false
+		}
+
+		from = t
+	}
+
+	if from.After(to) || to.Sub(from) >= maxGasDays*24*time.Hour {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, fmt.Sprintf("from must be before to and the report at most %d days", maxGasDays), com.ParamDetails{Param: "from"})
+		return "", "", false
+	}
+
+	return from.Format(indexer.GasDayLayout), to.Format(indexer.GasDayLayout), true
+}
@@ -0,0 +1,330 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/profile"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/graph-gophers/graphql-go"
+)
+
+const (
+	maxDepth       = 10
+	maxComplexity  = 1000 // every item of a list and every lookup outside of the db costs 1
+	maxPageSize    = 100
+	profileTimeout = 10 * time.Second
+)
+
+var (
+	ErrTooComplex = errors.New("query is too complex")
+)
+
+type Service struct {
+	evm     indexer.EVMRequester
+	db      *db.DB
+	ipfsURL string
+	client  *http.Client
+
+	schema *graphql.Schema
+}
+
+func NewService(evm indexer.EVMRequester, db *db.DB, ipfsURL string) *Service {
+	s := &Service{
+		evm:     evm,
+		db:      db,
+		ipfsURL: strings.TrimSuffix(ipfsURL, "/"),
+		client:  &http.Client{Timeout: profileTimeout},
+	}
+
+	s.schema = graphql.MustParseSchema(schema, &query{s: s},
+		graphql.UseFieldResolvers(),
+		graphql.MaxDepth(maxDepth),
+		graphql.MaxParallelism(maxPageSize), // a page of items is resolved at once so that its lookups are batched
+	)
+
+	return s
+}
+
+type contextKey string
+
+const contextKeyState contextKey = "graph_state"
+
+type profileKey struct {
+	contract string
+	account  string
+}
+
+type balanceKey struct {
+	token   string
+	tokenId int64
+	account string
+	at      int64 // unix nano
+}
+
+// state is what the resolvers of a single query share
+type state struct {
+	now       time.Time
+	remaining atomic.Int64
+
+	events   *loader[string, *indexer.Event]
+	code     *loader[string, bool]
+	profiles *loader[profileKey, *indexer.Profile]
+	balances *loader[balanceKey, *big.Int]
+}
+
+// newState creates the state of a query
+func (s *Service) newState() *state {
+	st := &state{
+		now:      time.Now().UTC(),
+		events:   newLoader(s.fetchEvents),
+		code:     newLoader(s.fetchCode),
+		profiles: newLoader(s.fetchProfiles),
+		balances: newLoader(s.fetchBalances),
+	}
+	st.remaining.Store(maxComplexity)
+
+	return st
+}
+
+func getState(ctx context.Context) *state {
+	return ctx.Value(contextKeyState).(*state)
+}
+
+// spend uses up some of the complexity a query is allowed
+func spend(ctx context.Context, cost int32) error {
+	if getState(ctx).remaining.Add(-int64(cost)) < 0 {
+		return ErrTooComplex
+	}
+
+	return nil
+}
+
+// fetchEvents gets the events of contracts, keyed by lowercase contract address
+func (s *Service) fetchEvents(ctx context.Context, keys []string) ([]*indexer.Event, []error) {
+	events, err := s.db.EventDB.GetEvents()
+	if err != nil {
+		return nil, batchErrors(len(keys), err)
+	}
+
+	byContract := map[string]*indexer.Event{}
+	for _, ev := range events {
+		// a contract can have several standards, the first one is used
+		if _, ok := byContract[strings.ToLower(ev.Contract)]; !ok {
+			byContract[strings.ToLower(ev.Contract)] = ev
+		}
+	}
+
+	values := make([]*indexer.Event, len(keys))
+	for i, key := range keys {
+		values[i] = byContract[key]
+	}
+
+	return values, nil
+}
+
+// fetchCode checks which addresses have a contract deployed
+func (s *Service) fetchCode(ctx context.Context, keys []string) ([]bool, []error) {
+	values := make([]bool, len(keys))
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+
+			bytecode, err := s.evm.CodeAt(ctx, common.HexToAddress(key), nil)
+			values[i], errs[i] = len(bytecode) > 0, err
+		}(i, key)
+	}
+	wg.Wait()
+
+	return values, errs
+}
+
+// fetchProfiles gets the profiles that are pinned for the accounts on the profile contracts
+func (s *Service) fetchProfiles(ctx context.Context, keys []profileKey) ([]*indexer.Profile, []error) {
+	values := make([]*indexer.Profile, len(keys))
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key profileKey) {
+			defer wg.Done()
+
+			values[i], errs[i] = s.fetchProfile(ctx, key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	return values, errs
+}
+
+// fetchProfile gets the uri of a profile from the profile contract and the profile from ipfs
+func (s *Service) fetchProfile(ctx context.Context, key profileKey) (*indexer.Profile, error) {
+	prf, err := profile.NewProfile(common.HexToAddress(key.contract), s.evm.Backend())
+	if err != nil {
+		return nil, err
+	}
+
+	uri, err := prf.Get(&bind.CallOpts{Context: ctx}, common.HexToAddress(key.account))
+	if err != nil {
+		return nil, err
+	}
+
+	// the account has no profile
+	if uri == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/ipfs/%s", s.ipfsURL, strings.TrimPrefix(uri, "ipfs://")), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching profile from ipfs: %s", resp.Status)
+	}
+
+	var p indexer.Profile
+	err = json.NewDecoder(resp.Body).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// fetchBalances computes the balances with one query for all the accounts of the same token and time
+func (s *Service) fetchBalances(ctx context.Context, keys []balanceKey) ([]*big.Int, []error) {
+	values := make([]*big.Int, len(keys))
+	errs := make([]error, len(keys))
+
+	type group struct {
+		token   string
+		tokenId int64
+		at      int64
+	}
+
+	groups := map[group][]int{}
+	for i, key := range keys {
+		g := group{key.token, key.tokenId, key.at}
+		groups[g] = append(groups[g], i)
+	}
+
+	for g, indexes := range groups {
+		// the token is known to be indexed when its balances are loaded
+		tdb, ok := s.db.GetTransferDB(g.token)
+		if !ok {
+			continue
+		}
+
+		addrs := []string{}
+		for _, i := range indexes {
+			addrs = append(addrs, keys[i].account)
+		}
+
+		balances, err := tdb.GetAddressBalancesAt(g.tokenId, addrs, time.Unix(0, g.at).UTC())
+		for _, i := range indexes {
+			values[i], errs[i] = balances[keys[i].account], err
+		}
+	}
+
+	return values, errs
+}
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Query godoc
+//
+//	@Summary		GraphQL
+//	@Description	query tokens, transfers, accounts, profiles and balances in a single request
+//	@Description	the schema can be introspected, deep or large queries are rejected
+//	@Tags			graphql
+//	@Accept			json
+//	@Produce		json
+//	@Param			query	query		string	false	"Query, for GET requests"
+//	@Param			operationName	query		string	false	"Operation name, for GET requests"
+//	@Param			variables	query		string	false	"Variables as json, for GET requests"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200
+//	@Failure		400	{object}	common.Response
+//	@Router			/graphql [post]
+func (s *Service) Query(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+
+		if v := q.Get("variables"); v != "" {
+			err := json.Unmarshal([]byte(v), &req.Variables)
+			if err != nil {
+				com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid variables", com.ParamDetails{Param: "variables"})
+				return
+			}
+		}
+	default:
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	if req.Query == "" {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "missing query", com.ParamDetails{Param: "query"})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeyState, s.newState())
+
+	resp := s.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// notFound returns nil instead of an error when nothing was found
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	return err
+}
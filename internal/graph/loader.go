@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	loaderWait     = 2 * time.Millisecond // how long a loader waits for more keys before fetching
+	loaderMaxBatch = 100
+)
+
+// loaderResult is the value of a key once it was fetched
+type loaderResult[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// fetchFunc returns the values and errors of the keys, in the same order as the keys
+type fetchFunc[K comparable, V any] func(ctx context.Context, keys []K) ([]V, []error)
+
+// loader batches the keys that are loaded around the same time into a single fetch
+// values are cached for the lifetime of the loader, which is a single request
+type loader[K comparable, V any] struct {
+	fetch fetchFunc[K, V]
+
+	mu      sync.Mutex
+	cache   map[K]*loaderResult[V]
+	pending []K
+}
+
+func newLoader[K comparable, V any](fetch fetchFunc[K, V]) *loader[K, V] {
+	return &loader[K, V]{
+		fetch: fetch,
+		cache: map[K]*loaderResult[V]{},
+	}
+}
+
+// Load returns the value of a key
+func (l *loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	r, ok := l.cache[key]
+	if !ok {
+		r = &loaderResult[V]{done: make(chan struct{})}
+		l.cache[key] = r
+		l.pending = append(l.pending, key)
+
+		switch len(l.pending) {
+		case 1:
+			// first key of a new batch
+			time.AfterFunc(loaderWait, func() { l.dispatch(ctx) })
+		case loaderMaxBatch:
+			go l.dispatch(ctx)
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// dispatch fetches the pending keys
+func (l *loader[K, V]) dispatch(ctx context.Context) {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+
+	results := make([]*loaderResult[V], len(keys))
+	for i, key := range keys {
+		results[i] = l.cache[key]
+	}
+	l.mu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	values, errs := l.fetch(ctx, keys)
+
+	for i, r := range results {
+		if i < len(values) {
+			r.value = values[i]
+		}
+
+		if i < len(errs) {
+			r.err = errs[i]
+		}
+
+		close(r.done)
+	}
+}
+
+// batchErrors returns the errors of a fetch that failed or succeeded for all its keys at once
+func batchErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+
+	return errs
+}
@@ -0,0 +1,116 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestLoaderBatches(t *testing.T) {
+	var mu sync.Mutex
+	batches := [][]int{}
+
+	l := newLoader(func(ctx context.Context, keys []int) ([]int, []error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+
+		values := make([]int, len(keys))
+		for i, k := range keys {
+			values[i] = k * 2
+		}
+
+		return values, nil
+	})
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// every key is loaded twice
+			v, err := l.Load(ctx, i%5)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if v != (i%5)*2 {
+				t.Errorf("expected %d, got %d", (i%5)*2, v)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// every key is only fetched once
+	fetched := func() int {
+		n := 0
+		for _, b := range batches {
+			n += len(b)
+		}
+
+		return n
+	}
+
+	if fetched() != 5 {
+		t.Fatalf("expected 5 fetched keys, got %d", fetched())
+	}
+
+	// cached values are not fetched again
+	v, err := l.Load(ctx, 3)
+	if err != nil || v != 6 {
+		t.Fatalf("expected 6, got %d (%v)", v, err)
+	}
+
+	if fetched() != 5 {
+		t.Fatalf("expected 5 fetched keys, got %d", fetched())
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+
+	l := newLoader(func(ctx context.Context, keys []string) ([]string, []error) {
+		values := make([]string, len(keys))
+		errs := make([]error, len(keys))
+		for i, k := range keys {
+			if k == "bad" {
+				errs[i] = errFetch
+				continue
+			}
+
+			values[i] = k
+		}
+
+		return values, errs
+	})
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var good, bad error
+	var value string
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		value, good = l.Load(ctx, "good")
+	}()
+	go func() {
+		defer wg.Done()
+		_, bad = l.Load(ctx, "bad")
+	}()
+	wg.Wait()
+
+	if good != nil || value != "good" {
+		t.Fatalf("expected good, got %s (%v)", value, good)
+	}
+
+	if !errors.Is(bad, errFetch) {
+		t.Fatalf("expected %v, got %v", errFetch, bad)
+	}
+}
@@ -0,0 +1,406 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/graph-gophers/graphql-go"
+)
+
+var (
+	ErrInvalidAddress    = errors.New("invalid address")
+	ErrInvalidPagination = errors.New("first must be between 0 and 100 and offset positive")
+	ErrTokenNotFound     = errors.New("token is not indexed")
+	ErrFilterNeedsAcc    = errors.New("filter requires an account")
+)
+
+type query struct {
+	s *Service
+}
+
+type transferFilterInput struct {
+	Direction    *string
+	Counterparty *string
+	MinValue     *string
+	MaxValue     *string
+	Status       *[]string
+	StartDate    *graphql.Time
+	EndDate      *graphql.Time
+	Description  *string
+}
+
+// toFilter converts the input to a db filter
+func (f *transferFilterInput) toFilter() (*db.TransferFilter, error) {
+	filter := &db.TransferFilter{}
+	if f == nil {
+		return filter, nil
+	}
+
+	if f.Direction != nil {
+		direction, err := indexer.TransferDirectionFromString(*f.Direction)
+		if err != nil {
+			return nil, err
+		}
+		filter.Direction = direction
+	}
+
+	if f.Counterparty != nil {
+		if !common.IsHexAddress(*f.Counterparty) {
+			return nil, ErrInvalidAddress
+		}
+		filter.Counterparty = com.ChecksumAddress(*f.Counterparty)
+	}
+
+	if f.MinValue != nil {
+		min, ok := new(big.Int).SetString(*f.MinValue, 10)
+		if !ok || min.Sign() < 0 {
+			return nil, errors.New("invalid minValue")
+		}
+		filter.MinValue = min
+	}
+
+	if f.MaxValue != nil {
+		max, ok := new(big.Int).SetString(*f.MaxValue, 10)
+		if !ok || max.Sign() < 0 {
+			return nil, errors.New("invalid maxValue")
+		}
+		filter.MaxValue = max
+	}
+
+	if f.Status != nil {
+		for _, st := range *f.Status {
+			status, err := indexer.TransferStatusFromString(st)
+			if err != nil {
+				return nil, err
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	if f.StartDate != nil {
+		startDate := f.StartDate.UTC()
+		filter.StartDate = &startDate
+	}
+
+	if f.EndDate != nil {
+		endDate := f.EndDate.UTC()
+		filter.EndDate = &endDate
+	}
+
+	if f.Description != nil {
+		filter.Description = strings.TrimSpace(*f.Description)
+	}
+
+	return filter, nil
+}
+
+// transfers returns a page of the transfers of a token, or of an account of a token
+func (s *Service) transfers(ctx context.Context, token string, tokenId int32, account *string, f *transferFilterInput, maxDate *graphql.Time, first, offset int32) ([]*transferResolver, error) {
+	if first < 0 || first > maxPageSize || offset < 0 {
+		return nil, ErrInvalidPagination
+	}
+
+	err := spend(ctx, first)
+	if err != nil {
+		return nil, err
+	}
+
+	tdb, ok := s.db.GetTransferDB(token)
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	max := getState(ctx).now
+	if maxDate != nil {
+		max = maxDate.UTC()
+	}
+
+	var txs []*indexer.Transfer
+	if account == nil {
+		if f != nil {
+			return nil, ErrFilterNeedsAcc
+		}
+
+		txs, err = tdb.GetAllPaginatedTransfers(int64(tokenId), max, int(first), int(offset))
+	} else {
+		if !common.IsHexAddress(*account) {
+			return nil, ErrInvalidAddress
+		}
+
+		filter, ferr := f.toFilter()
+		if ferr != nil {
+			return nil, ferr
+		}
+
+		txs, err = tdb.GetPaginatedTransfers(int64(tokenId), com.ChecksumAddress(*account), max, filter, int(first), int(offset))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*transferResolver{}
+	for _, tx := range txs {
+		resolvers = append(resolvers, &transferResolver{s: s, token: token, tx: tx})
+	}
+
+	return resolvers, nil
+}
+
+// token returns the token of a contract, nil if it is not indexed
+func (s *Service) token(ctx context.Context, contract string) (*tokenResolver, error) {
+	ev, err := getState(ctx).events.Load(ctx, strings.ToLower(contract))
+	if err != nil || ev == nil {
+		return nil, err
+	}
+
+	return &tokenResolver{s: s, ev: ev}, nil
+}
+
+func (q *query) Tokens(ctx context.Context) ([]*tokenResolver, error) {
+	events, err := q.s.db.EventDB.GetEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	err = spend(ctx, int32(len(events)))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*tokenResolver{}
+	for _, ev := range events {
+		resolvers = append(resolvers, &tokenResolver{s: q.s, ev: ev})
+	}
+
+	return resolvers, nil
+}
+
+func (q *query) Token(ctx context.Context, args struct{ Address string }) (*tokenResolver, error) {
+	if !common.IsHexAddress(args.Address) {
+		return nil, ErrInvalidAddress
+	}
+
+	return q.s.token(ctx, args.Address)
+}
+
+func (q *query) Transfer(ctx context.Context, args struct{ Token, Hash string }) (*transferResolver, error) {
+	tdb, ok := q.s.db.GetTransferDB(args.Token)
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	tx, err := tdb.GetTransfer(args.Hash)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &transferResolver{s: q.s, token: args.Token, tx: tx}, nil
+}
+
+func (q *query) Transfers(ctx context.Context, args struct {
+	Token   string
+	TokenId int32
+	Account *string
+	Filter  *transferFilterInput
+	MaxDate *graphql.Time
+	First   int32
+	Offset  int32
+}) ([]*transferResolver, error) {
+	return q.s.transfers(ctx, args.Token, args.TokenId, args.Account, args.Filter, args.MaxDate, args.First, args.Offset)
+}
+
+func (q *query) Account(args struct{ Address string }) (*accountResolver, error) {
+	if !common.IsHexAddress(args.Address) {
+		return nil, ErrInvalidAddress
+	}
+
+	return &accountResolver{s: q.s, address: com.ChecksumAddress(args.Address)}, nil
+}
+
+type tokenResolver struct {
+	s  *Service
+	ev *indexer.Event
+}
+
+func (t *tokenResolver) Address() string   { return t.ev.Contract }
+func (t *tokenResolver) Standard() string  { return string(t.ev.Standard) }
+func (t *tokenResolver) Name() string      { return t.ev.Name }
+func (t *tokenResolver) Symbol() string    { return t.ev.Symbol }
+func (t *tokenResolver) Decimals() int32   { return int32(t.ev.Decimals) }
+func (t *tokenResolver) State() string     { return string(t.ev.State) }
+func (t *tokenResolver) StartBlock() int32 { return int32(t.ev.StartBlock) }
+func (t *tokenResolver) LastBlock() int32  { return int32(t.ev.LastBlock) }
+
+func (t *tokenResolver) Transfers(ctx context.Context, args struct {
+	TokenId int32
+	Account *string
+	Filter  *transferFilterInput
+	MaxDate *graphql.Time
+	First   int32
+	Offset  int32
+}) ([]*transferResolver, error) {
+	return t.s.transfers(ctx, t.ev.Contract, args.TokenId, args.Account, args.Filter, args.MaxDate, args.First, args.Offset)
+}
+
+func (t *tokenResolver) Holders(ctx context.Context, args struct {
+	TokenId int32
+	At      *graphql.Time
+	First   int32
+	Offset  int32
+}) ([]*balanceResolver, error) {
+	if args.First < 0 || args.First > maxPageSize || args.Offset < 0 {
+		return nil, ErrInvalidPagination
+	}
+
+	err := spend(ctx, args.First)
+	if err != nil {
+		return nil, err
+	}
+
+	tdb, ok := t.s.db.GetTransferDB(t.ev.Contract)
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	at := getState(ctx).now
+	if args.At != nil {
+		at = args.At.UTC()
+	}
+
+	holders, _, err := tdb.GetHoldersAt(int64(args.TokenId), at, false, int(args.First), int(args.Offset))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*balanceResolver{}
+	for _, b := range holders {
+		resolvers = append(resolvers, &balanceResolver{s: t.s, b: b})
+	}
+
+	return resolvers, nil
+}
+
+type transferResolver struct {
+	s     *Service
+	token string
+	tx    *indexer.Transfer
+}
+
+func (t *transferResolver) Hash() string            { return t.tx.Hash }
+func (t *transferResolver) TxHash() string          { return t.tx.TxHash }
+func (t *transferResolver) TokenId() int32          { return int32(t.tx.TokenID) }
+func (t *transferResolver) CreatedAt() graphql.Time { return graphql.Time{Time: t.tx.CreatedAt} }
+func (t *transferResolver) Nonce() string           { return big.NewInt(t.tx.Nonce).String() }
+func (t *transferResolver) Status() string          { return string(t.tx.Status) }
+func (t *transferResolver) From() *accountResolver {
+	return &accountResolver{s: t.s, address: t.tx.From}
+}
+func (t *transferResolver) To() *accountResolver { return &accountResolver{s: t.s, address: t.tx.To} }
+
+func (t *transferResolver) Value() string {
+	if t.tx.Value == nil {
+		return "0"
+	}
+
+	return t.tx.Value.String()
+}
+
+func (t *transferResolver) Description() *string {
+	if t.tx.Data == nil {
+		return nil
+	}
+
+	return &t.tx.Data.Description
+}
+
+func (t *transferResolver) Token(ctx context.Context) (*tokenResolver, error) {
+	return t.s.token(ctx, t.token)
+}
+
+type accountResolver struct {
+	s       *Service
+	address string
+}
+
+func (a *accountResolver) Address() string { return a.address }
+
+func (a *accountResolver) Deployed(ctx context.Context) (bool, error) {
+	err := spend(ctx, 1)
+	if err != nil {
+		return false, err
+	}
+
+	return getState(ctx).code.Load(ctx, a.address)
+}
+
+func (a *accountResolver) Profile(ctx context.Context, args struct{ Contract string }) (*indexer.Profile, error) {
+	if !common.IsHexAddress(args.Contract) {
+		return nil, ErrInvalidAddress
+	}
+
+	err := spend(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return getState(ctx).profiles.Load(ctx, profileKey{contract: com.ChecksumAddress(args.Contract), account: a.address})
+}
+
+func (a *accountResolver) Balance(ctx context.Context, args struct {
+	Token   string
+	TokenId int32
+	At      *graphql.Time
+}) (*string, error) {
+	if _, ok := a.s.db.GetTransferDB(args.Token); !ok {
+		return nil, nil
+	}
+
+	at := getState(ctx).now
+	if args.At != nil {
+		at = args.At.UTC()
+	}
+
+	balance, err := getState(ctx).balances.Load(ctx, balanceKey{
+		token:   strings.ToLower(args.Token),
+		tokenId: int64(args.TokenId),
+		account: a.address,
+		at:      at.UnixNano(),
+	})
+	if err != nil || balance == nil {
+		return nil, err
+	}
+
+	b := balance.String()
+
+	return &b, nil
+}
+
+func (a *accountResolver) Transfers(ctx context.Context, args struct {
+	Token   string
+	TokenId int32
+	Filter  *transferFilterInput
+	MaxDate *graphql.Time
+	First   int32
+	Offset  int32
+}) ([]*transferResolver, error) {
+	return a.s.transfers(ctx, args.Token, args.TokenId, &a.address, args.Filter, args.MaxDate, args.First, args.Offset)
+}
+
+type balanceResolver struct {
+	s *Service
+	b *indexer.Balance
+}
+
+func (b *balanceResolver) Account() *accountResolver {
+	return &accountResolver{s: b.s, address: b.b.Address}
+}
+
+func (b *balanceResolver) Balance() string {
+	return b.b.Balance.String()
+}
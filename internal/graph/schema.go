@@ -0,0 +1,86 @@
+package graph
+
+// schema describes the data that can be queried, amounts are strings because they do not fit in an Int
+const schema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	# the tokens that are indexed
+	tokens: [Token!]!
+	token(address: String!): Token
+	transfer(token: String!, hash: String!): Transfer
+	# the transfers of a token, newest first, filters require an account
+	transfers(token: String!, tokenId: Int = 0, account: String, filter: TransferFilter, maxDate: Time, first: Int = 20, offset: Int = 0): [Transfer!]!
+	account(address: String!): Account!
+}
+
+type Token {
+	address: String!
+	standard: String!
+	name: String!
+	symbol: String!
+	decimals: Int!
+	state: String!
+	startBlock: Int!
+	lastBlock: Int!
+	transfers(tokenId: Int = 0, account: String, filter: TransferFilter, maxDate: Time, first: Int = 20, offset: Int = 0): [Transfer!]!
+	# the accounts with a positive balance, largest first
+	holders(tokenId: Int = 0, at: Time, first: Int = 20, offset: Int = 0): [Balance!]!
+}
+
+type Transfer {
+	hash: String!
+	txHash: String!
+	tokenId: Int!
+	createdAt: Time!
+	from: Account!
+	to: Account!
+	nonce: String!
+	value: String!
+	status: String!
+	description: String
+	token: Token
+}
+
+type Account {
+	address: String!
+	# true if there is a contract at the address
+	deployed: Boolean!
+	# the profile pinned for the account on the given profile contract
+	profile(contract: String!): Profile
+	# the balance computed from the indexed transfers, null if the token is not indexed
+	balance(token: String!, tokenId: Int = 0, at: Time): String
+	transfers(token: String!, tokenId: Int = 0, filter: TransferFilter, maxDate: Time, first: Int = 20, offset: Int = 0): [Transfer!]!
+}
+
+type Profile {
+	account: String!
+	username: String!
+	name: String!
+	description: String!
+	image: String!
+	imageMedium: String!
+	imageSmall: String!
+}
+
+type Balance {
+	account: Account!
+	balance: String!
+}
+
+input TransferFilter {
+	# in or out
+	direction: String
+	counterparty: String
+	minValue: String
+	maxValue: String
+	status: [String!]
+	startDate: Time
+	endDate: Time
+	description: String
+}
+`
@@ -0,0 +1,441 @@
+package logs
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	exportFlushRows = 100 // rows written between flushes to the client
+
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+	exportFormatJSON   = "json"
+
+	directionSelf = "self" // the account sent to itself
+)
+
+type statementToken struct {
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int64  `json:"decimals"`
+}
+
+// statement is the header of an account statement
+type statement struct {
+	Account        string         `json:"account"`
+	Token          statementToken `json:"token"`
+	TokenID        int64          `json:"token_id"`
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	OpeningBalance string         `json:"opening_balance"`
+}
+
+// statementRow is a transfer of an account statement, amounts are signed from the point of view of the account
+type statementRow struct {
+	Date         time.Time `json:"date"`
+	Hash         string    `json:"hash"`
+	TxHash       string    `json:"tx_hash"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Description  string    `json:"description"`
+	Amount       string    `json:"amount"`
+	Balance      string    `json:"balance"`
+}
+
+// statementTotals are the totals of an account statement
+type statementTotals struct {
+	Count          int    `json:"count"`
+	TotalIn        string `json:"total_in"`
+	TotalOut       string `json:"total_out"`
+	OpeningBalance string `json:"opening_balance"`
+	ClosingBalance string `json:"closing_balance"`
+}
+
+// statementWriter writes the sections of a statement in a format
+type statementWriter interface {
+	header(st *statement) error
+	row(r *statementRow) error
+	footer(t *statementTotals) error
+	flush() error
+}
+
+// csvStatementWriter writes the header, the transfers and the totals as sections separated by an empty line
+type csvStatementWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvStatementWriter) header(st *statement) error {
+	records := [][]string{
+		{"account", st.Account},
+		{"token", st.Token.Address, st.Token.Name, st.Token.Symbol},
+		{"token_id", strconv.FormatInt(st.TokenID, 10)},
+		{"from", st.From.Format(time.RFC3339)},
+		{"to", st.To.Format(time.RFC3339)},
+		{"opening_balance", st.OpeningBalance},
+		{},
+		{"date", "hash", "tx_hash", "direction", "counterparty", "description", "amount", "balance"},
+	}
+
+	return c.w.WriteAll(records)
+}
+
+func (c *csvStatementWriter) row(r *statementRow) error {
+	return c.w.Write([]string{r.Date.Format(time.RFC3339), r.Hash, r.TxHash, r.Direction, r.Counterparty, r.Description, r.Amount, r.Balance})
+}
+
+func (c *csvStatementWriter) footer(t *statementTotals) error {
+	records := [][]string{
+		{},
+		{"count", strconv.Itoa(t.Count)},
+		{"total_in", t.TotalIn},
+		{"total_out", t.TotalOut},
+		{"opening_balance", t.OpeningBalance},
+		{"closing_balance", t.ClosingBalance},
+	}
+
+	return c.w.WriteAll(records)
+}
+
+func (c *csvStatementWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonStatementWriter writes one json object per line, each with a type: statement, transfer or totals
+type ndjsonStatementWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonStatementWriter) header(st *statement) error {
+	return n.enc.Encode(struct {
+		Type string `json:"type"`
+		*statement
+	}{"statement", st})
+}
+
+func (n *ndjsonStatementWriter) row(r *statementRow) error {
+	return n.enc.Encode(struct {
+		Type string `json:"type"`
+		*statementRow
+	}{"transfer", r})
+}
+
+func (n *ndjsonStatementWriter) footer(t *statementTotals) error {
+	return n.enc.Encode(struct {
+		Type string `json:"type"`
+		*statementTotals
+	}{"totals", t})
+}
+
+func (n *ndjsonStatementWriter) flush() error {
+	return nil
+}
+
+// jsonStatementWriter writes a single document which can be rendered as is, the transfers are written as they are read
+type jsonStatementWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonStatementWriter) header(st *statement) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(j.w, `{"statement":%s,"transfers":[`, b)
+	return err
+}
+
+func (j *jsonStatementWriter) row(r *statementRow) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if j.count > 0 {
+		_, err = j.w.Write([]byte(","))
+		if err != nil {
+			return err
+		}
+	}
+	j.count++
+
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *jsonStatementWriter) footer(t *statementTotals) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(j.w, `],"totals":%s}`, b)
+	return err
+}
+
+func (j *jsonStatementWriter) flush() error {
+	return nil
+}
+
+// Export godoc
+//
+//	@Summary		Export an account statement
+//	@Description	export the successful transfers of an account between two dates with a running balance and totals
+//	@Description	amounts are formatted with the decimals of the token, the rows are streamed as they are read
+//	@Tags			logs
+//	@Produce		text/csv
+//	@Produce		application/x-ndjson
+//	@Produce		json
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			acc_addr	path		string	true	"Address of the account"
+//	@Param			format	query		string	false	"csv (default), ndjson or json"
+//	@Param			from	query		string	false	"Start of the statement (RFC3339)"
+//	@Param			to	query		string	false	"End of the statement (RFC3339), defaults to now"
+//	@Param			body	query		string	true	"Signed request body"
+//	@Param			X-Signature	header		string	true	"Signature of the body"
+//	@Param			X-Address	header		string	true	"Address of the account"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/logs/v2/transfers/{token_address}/{acc_addr}/export [get]
+func (s *Service) Export(w http.ResponseWriter, r *http.Request) {
+	// ensure that the address in the url matches the one in the headers
+	addr, ok := com.GetContextAddress(r.Context())
+	if !ok {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
+		return
+	}
+
+	// parse address from url params
+	accaddr := chi.URLParam(r, "acc_addr")
+
+	if !common.IsHexAddress(accaddr) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid account address", nil)
+		return
+	}
+
+	if common.HexToAddress(addr) != common.HexToAddress(accaddr) {
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
+		return
+	}
+
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	// parse the period from url query
+	var from time.Time
+	if fromq, _ := url.QueryUnescape(r.URL.Query().Get("from")); fromq != "" {
+		from, err = time.Parse(time.RFC3339, fromq)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid from date, expected RFC3339", com.ParamDetails{Param: "from"})
+			return
+		}
+	}
+	from = from.UTC()
+
+	to := time.Now().UTC()
+	if toq, _ := url.QueryUnescape(r.URL.Query().Get("to")); toq != "" {
+		to, err = time.Parse(time.RFC3339, toq)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid to date, expected RFC3339", com.ParamDetails{Param: "to"})
+			return
+		}
+	}
+	to = to.UTC()
+
+	if to.Before(from) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "to is before from", com.ParamDetails{Param: "to"})
+		return
+	}
+
+	name, err := s.db.TableNameSuffix(contractAddr)
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
+		return
+	}
+
+	tdb, ok := s.db.TransferDB[name]
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	// the decimals of the token are needed to format the amounts
+	ev, err := s.db.EventDB.GetContractEvent(contractAddr)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+			return
+		}
+
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the token", nil)
+		return
+	}
+
+	chkaddr := com.ChecksumAddress(accaddr)
+
+	// the balance before the first transfer of the statement
+	balance := big.NewInt(0)
+	if !from.IsZero() {
+		balance, err = tdb.GetBalanceAt(int64(tokenId), chkaddr, from.Add(-time.Nanosecond))
+		if err != nil {
+			com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not compute the opening balance", nil)
+			return
+		}
+	}
+
+	decimals := int(ev.Decimals)
+
+	filename := fmt.Sprintf("statement_%s_%s_%s_%s", ev.Symbol, chkaddr, from.Format("20060102"), to.Format("20060102"))
+
+	var sw statementWriter
+	switch format {
+	case exportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+		sw = &csvStatementWriter{w: csv.NewWriter(w)}
+	case exportFormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.ndjson", filename))
+		sw = &ndjsonStatementWriter{enc: json.NewEncoder(w)}
+	case exportFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		sw = &jsonStatementWriter{w: w}
+	default:
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "unsupported format, expected csv, ndjson or json", com.ParamDetails{Param: "format"})
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	flush := func() error {
+		err := sw.flush()
+		if err != nil {
+			return err
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	opening := com.FormatUnits(balance, decimals)
+
+	err = sw.header(&statement{
+		Account: chkaddr,
+		Token: statementToken{
+			Address:  ev.Contract,
+			Name:     ev.Name,
+			Symbol:   ev.Symbol,
+			Decimals: ev.Decimals,
+		},
+		TokenID:        int64(tokenId),
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+	})
+	if err != nil {
+		return
+	}
+
+	totalIn := big.NewInt(0)
+	totalOut := big.NewInt(0)
+	count := 0
+
+	// the response has started, errors can only end it early
+	err = tdb.ForEachSuccessfulTransfer(int64(tokenId), chkaddr, from, to, func(tx *indexer.Transfer) error {
+		row := &statementRow{
+			Date:   tx.CreatedAt,
+			Hash:   tx.Hash,
+			TxHash: tx.TxHash,
+		}
+
+		if tx.Data != nil {
+			row.Description = tx.Data.Description
+		}
+
+		amount := new(big.Int)
+		switch {
+		case tx.From == chkaddr && tx.To == chkaddr:
+			row.Direction = directionSelf
+			row.Counterparty = chkaddr
+		case tx.From == chkaddr:
+			row.Direction = string(indexer.TransferDirectionOut)
+			row.Counterparty = tx.To
+			amount.Neg(tx.Value)
+			totalOut.Add(totalOut, tx.Value)
+		default:
+			row.Direction = string(indexer.TransferDirectionIn)
+			row.Counterparty = tx.From
+			amount.Set(tx.Value)
+			totalIn.Add(totalIn, tx.Value)
+		}
+
+		balance.Add(balance, amount)
+
+		row.Amount = com.FormatUnits(amount, decimals)
+		row.Balance = com.FormatUnits(balance, decimals)
+
+		err := sw.row(row)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushRows == 0 {
+			return flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = sw.footer(&statementTotals{
+		Count:          count,
+		TotalIn:        com.FormatUnits(totalIn, decimals),
+		TotalOut:       com.FormatUnits(totalOut, decimals),
+		OpeningBalance: opening,
+		ClosingBalance: com.FormatUnits(balance, decimals),
+	})
+	if err != nil {
+		return
+	}
+
+	flush()
+}
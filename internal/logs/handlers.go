@@ -1,20 +1,41 @@
 package logs
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math/big"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	com "github.com/citizenwallet/indexer/internal/common"
 	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/go-chi/chi/v5"
 )
 
+const (
+	streamRetry        = 3 * time.Second  // how long clients wait before reconnecting
+	streamHeartbeat    = 15 * time.Second // keeps idle connections from being closed by proxies
+	streamReplayMargin = time.Minute      // transfers can be written a little after they were created
+	streamReplayLimit  = 100
+
+	streamEventTruncated = "truncated" // the replay of a reconnecting client did not include every missed transfer
+)
+
+// streamTruncated is the data of a truncated event, the transfers between the dates were not sent
+// and can be fetched with the paginated logs
+type streamTruncated struct {
+	StartDate time.Time `json:"startDate"`
+	MaxDate   time.Time `json:"maxDate"`
+}
+
 type Service struct {
 	chainID *big.Int
 	db      *db.DB
@@ -38,31 +59,36 @@ func (s *Service) GetSingle(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 
 	if hash == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "missing hash", com.ParamDetails{Param: "hash"})
 		return
 	}
 
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	tx, err := tdb.GetTransfer(hash)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		if errors.Is(err, sql.ErrNoRows) {
+			com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeNotFound, "transfer not found", nil)
+			return
+		}
+
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the transfer", nil)
 		return
 	}
 
 	err = com.Body(w, tx, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -101,20 +127,20 @@ func (s *Service) GetAll(w http.ResponseWriter, r *http.Request) {
 
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	// get logs from db
 	logs, err := tdb.GetAllPaginatedTransfers(int64(tokenId), maxDate, limit, offset)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the transfers", nil)
 		return
 	}
 
@@ -123,7 +149,7 @@ func (s *Service) GetAll(w http.ResponseWriter, r *http.Request) {
 
 	err = com.BodyMultiple(w, logs, com.Pagination{Limit: limit, Offset: offset, Total: total})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -162,20 +188,20 @@ func (s *Service) GetAllNew(w http.ResponseWriter, r *http.Request) {
 
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	// get logs from db
 	logs, err := tdb.GetAllNewTransfers(int64(tokenId), fromDate, limit, offset)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the transfers", nil)
 		return
 	}
 
@@ -184,7 +210,7 @@ func (s *Service) GetAllNew(w http.ResponseWriter, r *http.Request) {
 
 	err = com.BodyMultiple(w, logs, com.Pagination{Limit: limit, Offset: offset, Total: total})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -197,10 +223,19 @@ func (s *Service) GetAllNew(w http.ResponseWriter, r *http.Request) {
 //		@Produce		json
 //		@Param			token_address	path		string	true	"Token Contract Address"
 //	 	@Param			acc_address	path		string	true	"Address of the account"
+//		@Param			direction	query		string	false	"Direction of the transfers (in or out)"
+//		@Param			counterparty	query		string	false	"Address of the other side of the transfers"
+//		@Param			minValue	query		string	false	"Minimum value of the transfers"
+//		@Param			maxValue	query		string	false	"Maximum value of the transfers"
+//		@Param			status	query		string	false	"Comma separated list of statuses"
+//		@Param			startDate	query		string	false	"Only include transfers after this date (RFC3339)"
+//		@Param			endDate	query		string	false	"Only include transfers before this date (RFC3339)"
+//		@Param			description	query		string	false	"Text that the description should contain"
+//		@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
 //		@Success		200	{object}	common.Response
-//		@Failure		400
-//		@Failure		404
-//		@Failure		500
+//		@Failure		400	{object}	common.Response
+//		@Failure		404	{object}	common.Response
+//		@Failure		500	{object}	common.Response
 //		@Router			/logs/transfers/{token_address}/{acc_addr} [get]
 func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
 	// parse contract address from url params
@@ -240,22 +275,29 @@ func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
 
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	chkaddr := com.ChecksumAddress(accaddr)
 
+	// parse optional filters from url query
+	filter, err := parseTransferFilter(r.URL.Query())
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, err.Error(), nil)
+		return
+	}
+
 	// get logs from db
-	logs, err := tdb.GetPaginatedTransfers(int64(tokenId), chkaddr, maxDate, limit, offset)
+	logs, err := tdb.GetPaginatedTransfers(int64(tokenId), chkaddr, maxDate, filter, limit, offset)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the transfers", nil)
 		return
 	}
 
@@ -264,7 +306,7 @@ func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
 
 	err = com.BodyMultiple(w, logs, com.Pagination{Limit: limit, Offset: offset, Total: total})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -306,22 +348,29 @@ func (s *Service) GetNew(w http.ResponseWriter, r *http.Request) {
 
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	chkaddr := com.ChecksumAddress(accaddr)
 
+	// parse optional filters from url query
+	filter, err := parseTransferFilter(r.URL.Query())
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, err.Error(), nil)
+		return
+	}
+
 	// get logs from db
-	logs, err := tdb.GetNewTransfers(int64(tokenId), chkaddr, fromDate, limit, offset)
+	logs, err := tdb.GetNewTransfers(int64(tokenId), chkaddr, fromDate, filter, limit, offset)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the transfers", nil)
 		return
 	}
 
@@ -330,15 +379,345 @@ func (s *Service) GetNew(w http.ResponseWriter, r *http.Request) {
 
 	err = com.BodyMultiple(w, logs, com.Pagination{Limit: limit, Offset: offset, Total: total})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// Search godoc
+//
+//	@Summary		Search transfer logs
+//	@Description	search the descriptions of transfer logs for a given token, best matches first
+//	@Tags			logs
+//	@Accept			json
+//	@Produce		json
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			q	query		string	true	"Text to search for in descriptions"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/logs/v2/transfers/{token_address}/search [get]
+func (s *Service) Search(w http.ResponseWriter, r *http.Request) {
+	s.search(w, r, "")
+}
+
+// SearchAccount godoc
+//
+//		@Summary		Search transfer logs of an account
+//		@Description	search the descriptions of transfer logs for a given token and account, best matches first
+//		@Tags			logs
+//		@Accept			json
+//		@Produce		json
+//		@Param			token_address	path		string	true	"Token Contract Address"
+//	 	@Param			acc_address	path		string	true	"Address of the account"
+//		@Param			q	query		string	true	"Text to search for in descriptions"
+//		@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//		@Success		200	{object}	common.Response
+//		@Failure		400	{object}	common.Response
+//		@Failure		404	{object}	common.Response
+//		@Failure		500	{object}	common.Response
+//		@Router			/logs/v2/transfers/{token_address}/{acc_addr}/search [get]
+func (s *Service) SearchAccount(w http.ResponseWriter, r *http.Request) {
+	// parse address from url params
+	accaddr := chi.URLParam(r, "acc_addr")
+
+	if !common.IsHexAddress(accaddr) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid account address", nil)
+		return
+	}
+
+	s.search(w, r, com.ChecksumAddress(accaddr))
+}
+
+func (s *Service) search(w http.ResponseWriter, r *http.Request, addr string) {
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	// parse search query from url query
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "missing search query", com.ParamDetails{Param: "q"})
+		return
+	}
+
+	// parse pagination params from url query
+	limitq := r.URL.Query().Get("limit")
+	offsetq := r.URL.Query().Get("offset")
+
+	limit, err := strconv.Atoi(limitq)
+	if err != nil {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(offsetq)
+	if err != nil {
+		offset = 0
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	name, err := s.db.TableNameSuffix(contractAddr)
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
+		return
+	}
+
+	tdb, ok := s.db.TransferDB[name]
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	// search logs in db
+	results, err := tdb.SearchTransfers(int64(tokenId), addr, query, limit, offset)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not search the transfers", nil)
+		return
+	}
+
+	// TODO: remove legacy support
+	total := offset + limit
+
+	err = com.BodyMultiple(w, results, com.Pagination{Limit: limit, Offset: offset, Total: total})
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
+// Stream godoc
+//
+//	@Summary		Stream transfer logs
+//	@Description	stream the transfers of an account as server-sent events as soon as they are written, including status changes
+//	@Description	reconnecting clients receive the events they missed by sending the Last-Event-ID header
+//	@Description	a truncated event tells which missed transfers were not sent again when they are no longer in memory
+//	@Tags			logs
+//	@Produce		text/event-stream
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			acc_addr	path		string	true	"Address of the account"
+//	@Param			Last-Event-ID	header		string	false	"Id of the last event that was received"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200
+//	@Failure		400	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/logs/v2/transfers/{token_address}/{acc_addr}/stream [get]
+func (s *Service) Stream(w http.ResponseWriter, r *http.Request) {
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	// parse address from url params
+	accaddr := chi.URLParam(r, "acc_addr")
+
+	if !common.IsHexAddress(accaddr) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid account address", nil)
+		return
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	// browsers send the header when they reconnect, other clients can use the query
+	var lastEventID *int64
+	lastEventIDq := r.Header.Get("Last-Event-ID")
+	if lastEventIDq == "" {
+		lastEventIDq = r.URL.Query().Get("lastEventId")
+	}
+
+	if lastEventIDq != "" {
+		id, err := strconv.ParseInt(lastEventIDq, 10, 64)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid last event id", com.ParamDetails{Param: "Last-Event-ID"})
+			return
+		}
+
+		lastEventID = &id
+	}
+
+	name, err := s.db.TableNameSuffix(contractAddr)
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
+		return
+	}
+
+	tdb, ok := s.db.TransferDB[name]
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || s.db.Broker == nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "streaming is not supported", nil)
+		return
+	}
+
+	chkaddr := com.ChecksumAddress(accaddr)
+
+	// subscribe before catching up so that nothing is missed in between
+	sub := s.db.Broker.Subscribe(pubsub.TransferTopic(name, chkaddr))
+	defer s.db.Broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	flusher.Flush()
+
+	var lastSent int64
+
+	// events that hold a transfer as it was replayed from the db are skipped
+	replayed := replayedTransfers{}
+
+	if lastEventID != nil {
+		lastSent = *lastEventID
+
+		events, ok := s.db.Broker.Since(*lastEventID, sub)
+		if !ok {
+			// the events are no longer in memory, send the transfers written since then instead
+			// they are sent without an id so that the client keeps its last event id until a new event arrives
+			since := time.Unix(0, *lastEventID).UTC().Add(-streamReplayMargin)
+
+			txs, err := tdb.GetNewTransfers(int64(tokenId), chkaddr, since, nil, streamReplayLimit, 0)
+			if err != nil {
+				return
+			}
+
+			// only the newest transfers are replayed, the client is told which ones it should fetch itself
+			if len(txs) == streamReplayLimit {
+				err := writeEvent(w, nil, streamEventTruncated, &streamTruncated{StartDate: since, MaxDate: txs[len(txs)-1].CreatedAt})
+				if err != nil {
+					return
+				}
+			}
+
+			// oldest first
+			for i := len(txs) - 1; i >= 0; i-- {
+				err := writeEvent(w, nil, pubsub.EventTypeTransfer, txs[i])
+				if err != nil {
+					return
+				}
+
+				replayed[txs[i].Hash] = txs[i].Status
+			}
+		}
+
+		for _, e := range events {
+			if !isTokenEvent(e, int64(tokenId)) {
+				continue
+			}
+
+			lastSent = e.ID
+
+			if replayed.duplicate(e) {
+				continue
+			}
+
+			err := writeEvent(w, &e.ID, e.Type, e.Data)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+
+			flusher.Flush()
+		case e, ok := <-sub.C:
+			if !ok {
+				// the client fell behind, it will reconnect and catch up
+				return
+			}
+
+			if e.ID <= lastSent || !isTokenEvent(e, int64(tokenId)) {
+				continue
+			}
+
+			// the subscription started before the replay, so it can hold transfers that were already sent
+			if replayed.duplicate(e) {
+				continue
+			}
+
+			err := writeEvent(w, &e.ID, e.Type, e.Data)
+			if err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// replayedTransfers are the transfers that were sent again from the db, with their status
+type replayedTransfers map[string]indexer.TransferStatus
+
+// duplicate returns true if an event holds a transfer as it was replayed, only the first event of a transfer is checked
+func (r replayedTransfers) duplicate(e pubsub.Event) bool {
+	tx, ok := e.Data.(*indexer.Transfer)
+	if !ok {
+		return false
+	}
+
+	status, seen := r[tx.Hash]
+	delete(r, tx.Hash)
+
+	return seen && e.Type == pubsub.EventTypeTransfer && status == tx.Status
+}
+
+// isTokenEvent returns true if the event is about a transfer of the given token id
+func isTokenEvent(e pubsub.Event, tokenId int64) bool {
+	tx, ok := e.Data.(*indexer.Transfer)
+
+	return ok && tx.TokenID == tokenId
+}
+
+// writeEvent writes a server-sent event, the id is optional
+func writeEvent(w http.ResponseWriter, id *int64, eventType string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != nil {
+		_, err = fmt.Fprintf(w, "id: %d\n", *id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, b)
+
+	return err
+}
+
 func (s *Service) AddSending(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -350,7 +729,7 @@ func (s *Service) AddSending(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -360,14 +739,14 @@ func (s *Service) AddSending(w http.ResponseWriter, r *http.Request) {
 	var log indexer.Transfer
 	err := json.NewDecoder(r.Body).Decode(&log)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
 
 	// check that the log is from the sender of the transaction
 	if !com.IsSameHexAddress(log.From, accaddr) {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the transfer is not from the signed address", nil)
 		return
 	}
 
@@ -378,27 +757,30 @@ func (s *Service) AddSending(w http.ResponseWriter, r *http.Request) {
 	log.From = com.ChecksumAddress(log.From)
 	log.FromTo = log.CombineFromTo()
 
+	// the hash is the one the transfer gets once it is indexed, as the first transfer between its from and to in the transaction
+	log.Hash = log.GenerateUniqueHash(0)
+
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	err = tdb.AddTransfer(&log)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not add the transfer", nil)
 		return
 	}
 
 	err = com.Body(w, log, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -411,7 +793,7 @@ func (s *Service) SetStatus(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -423,7 +805,7 @@ func (s *Service) SetStatus(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -436,48 +818,125 @@ func (s *Service) SetStatus(w http.ResponseWriter, r *http.Request) {
 	var req setStatusRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
 
 	if hash == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "missing hash", com.ParamDetails{Param: "hash"})
 		return
 	}
 
 	name, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	tdb, ok := s.db.TransferDB[name]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	tx, err := tdb.GetTransfer(hash)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		if errors.Is(err, sql.ErrNoRows) {
+			com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeNotFound, "transfer not found", nil)
+			return
+		}
+
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the transfer", nil)
 		return
 	}
 
 	// check that the log is from the sender of the transaction
 	if !com.IsSameHexAddress(tx.From, accaddr) {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the transfer is not from the signed address", nil)
 		return
 	}
 
 	err = tdb.SetStatus(string(req.Status), hash)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not set the status", nil)
 		return
 	}
 
 	err = com.Body(w, []byte("{}"), nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// parseTransferFilter parses the optional transfer filters from the url query
+func parseTransferFilter(q url.Values) (*db.TransferFilter, error) {
+	f := &db.TransferFilter{}
+
+	direction, err := indexer.TransferDirectionFromString(q.Get("direction"))
+	if err != nil {
+		return nil, err
 	}
+	f.Direction = direction
+
+	if cp := q.Get("counterparty"); cp != "" {
+		if !common.IsHexAddress(cp) {
+			return nil, errors.New("invalid counterparty address")
+		}
+
+		f.Counterparty = com.ChecksumAddress(cp)
+	}
+
+	if v := q.Get("minValue"); v != "" {
+		min, ok := new(big.Int).SetString(v, 10)
+		if !ok || min.Sign() < 0 {
+			return nil, errors.New("invalid minValue")
+		}
+
+		f.MinValue = min
+	}
+
+	if v := q.Get("maxValue"); v != "" {
+		max, ok := new(big.Int).SetString(v, 10)
+		if !ok || max.Sign() < 0 {
+			return nil, errors.New("invalid maxValue")
+		}
+
+		f.MaxValue = max
+	}
+
+	if v := q.Get("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			status, err := indexer.TransferStatusFromString(strings.TrimSpace(st))
+			if err != nil {
+				return nil, err
+			}
+
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+
+	if v, _ := url.QueryUnescape(q.Get("startDate")); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, err
+		}
+
+		startDate := t.UTC()
+		f.StartDate = &startDate
+	}
+
+	if v, _ := url.QueryUnescape(q.Get("endDate")); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, err
+		}
+
+		endDate := t.UTC()
+		f.EndDate = &endDate
+	}
+
+	f.Description = strings.TrimSpace(q.Get("description"))
+
+	return f, nil
 }
@@ -0,0 +1,171 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	testToken = "0x0000000000000000000000000000000000000100"
+	testAlice = "0x0000000000000000000000000000000000000002"
+	testBob   = "0x0000000000000000000000000000000000000003"
+)
+
+type testEvent struct {
+	Type string
+	Data string
+}
+
+// readEvents reads n server-sent events, comments and retries are skipped
+func readEvents(t *testing.T, r *bufio.Reader, n int) []testEvent {
+	events := []testEvent{}
+
+	var e testEvent
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			e.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.Data = strings.TrimPrefix(line, "data: ")
+		case line == "" && e.Type != "":
+			events = append(events, e)
+			e = testEvent{}
+		}
+	}
+
+	return events
+}
+
+func testStreamTransfer(i int, createdAt time.Time) *indexer.Transfer {
+	tx := &indexer.Transfer{
+		TxHash:    strconv.Itoa(i),
+		CreatedAt: createdAt,
+		From:      testAlice,
+		To:        testBob,
+		Value:     big.NewInt(1),
+		Data:      &indexer.TransferData{},
+		Status:    indexer.TransferStatusSuccess,
+	}
+
+	tx.Hash = tx.GenerateUniqueHash(0)
+	tx.FromTo = tx.CombineFromTo()
+
+	return tx
+}
+
+func TestStreamReplay(t *testing.T) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	txdb, err := d.AddTransferDB(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, create := range []func() error{txdb.CreateTransferTable, txdb.CreateTransferSearchTable, txdb.CreateBalanceCheckpointTable} {
+		err = create()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// more transfers were missed than are replayed
+	start := time.Now().UTC().Add(-30 * time.Minute)
+
+	txs := []*indexer.Transfer{}
+	for i := 0; i <= streamReplayLimit; i++ {
+		txs = append(txs, testStreamTransfer(i, start.Add(time.Duration(i)*time.Second)))
+	}
+
+	err = txdb.AddTransfers(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(big.NewInt(1337), d, nil)
+
+	r := chi.NewRouter()
+	r.Get("/logs/v2/transfers/{token_address}/{acc_addr}/stream", s.Stream)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/logs/v2/transfers/"+testToken+"/"+testAlice+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events since then are no longer in memory
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(start.Add(-time.Hour).UnixNano(), 10))
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body := bufio.NewReader(resp.Body)
+
+	events := readEvents(t, body, streamReplayLimit+1)
+
+	if events[0].Type != streamEventTruncated {
+		t.Fatalf("expected a truncated event first, got %s", events[0].Type)
+	}
+
+	var truncated streamTruncated
+	err = json.Unmarshal([]byte(events[0].Data), &truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest transfer was not replayed, it can be fetched up to the oldest one that was
+	if !truncated.MaxDate.Equal(txs[1].CreatedAt) {
+		t.Errorf("got max date %s, want %s", truncated.MaxDate, txs[1].CreatedAt)
+	}
+
+	for _, e := range events[1:] {
+		if e.Type != "transfer" {
+			t.Fatalf("expected a replayed transfer, got %s", e.Type)
+		}
+	}
+
+	// the events of the transfers that are still in memory follow, the replayed ones are not sent again
+	next := testStreamTransfer(len(txs), time.Now().UTC())
+
+	err = txdb.AddTransfers([]*indexer.Transfer{next})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{txs[0].Hash, next.Hash} {
+		var tx indexer.Transfer
+		err = json.Unmarshal([]byte(readEvents(t, body, 1)[0].Data), &tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tx.Hash != want {
+			t.Errorf("got transfer %s, want %s", tx.Hash, want)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package paymaster
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"net/http"
@@ -13,36 +15,43 @@ import (
 	comm "github.com/citizenwallet/indexer/internal/common"
 	"github.com/citizenwallet/indexer/internal/services/db"
 	"github.com/citizenwallet/indexer/pkg/indexer"
-	pay "github.com/citizenwallet/smartcontracts/pkg/contracts/paymaster"
-	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/go-chi/chi/v5"
 )
 
 var (
-	// Allowed function signatures
-	funcSigSingle             = crypto.Keccak256([]byte("execute(address,uint256,bytes)"))[:4]
-	funcSigBatch              = crypto.Keccak256([]byte("executeBatch(address[],uint256[],bytes[])"))[:4]
-	funcSigSafeExecFromModule = crypto.Keccak256([]byte("execTransactionFromModule(address,uint256,bytes,uint8)"))[:4]
-
-	// OO Signature limit in seconds
+	// OO Signature limit in seconds, the policy of a paymaster can change it
 	ooSigLimit = int64(60 * 60 * 24 * 7)
+
+	// the most signatures that pm_ooSponsorUserOperation returns per call
+	ooMaxAmount = 100
+)
+
+const (
+	// gas limits of the paymaster of a v0.7 op, it verifies a signature and has no post op
+	paymasterVerificationGasLimit = 60000
+	paymasterPostOpGasLimit       = 0
 )
 
 type Service struct {
 	evm indexer.EVMRequester
 
 	db *db.DB
+
+	signer indexer.Signer
+
+	eps indexer.EntryPoints
 }
 
 // NewService
-func NewService(evm indexer.EVMRequester, db *db.DB) *Service {
+func NewService(evm indexer.EVMRequester, db *db.DB, signer indexer.Signer, eps indexer.EntryPoints) *Service {
 	return &Service{
-		evm,
-		db,
+		evm:    evm,
+		db:     db,
+		signer: signer,
+		eps:    eps,
 	}
 }
 
@@ -55,9 +64,15 @@ type paymasterData struct {
 	PreVerificationGas   string `json:"preVerificationGas"`
 	VerificationGasLimit string `json:"verificationGasLimit"`
 	CallGasLimit         string `json:"callGasLimit"`
+
+	// the unpacked paymaster fields of a v0.7 op
+	Paymaster                     string `json:"paymaster,omitempty"`
+	PaymasterVerificationGasLimit string `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       string `json:"paymasterPostOpGasLimit,omitempty"`
+	PaymasterData                 string `json:"paymasterData,omitempty"`
 }
 
-func (s *Service) Sponsor(r *http.Request) (any, int) {
+func (s *Service) Sponsor(r *http.Request) (any, error) {
 	// parse contract address from url params
 	contractAddr := chi.URLParam(r, "pm_address")
 
@@ -66,18 +81,12 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), addr, nil)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
 	// Check if the contract is deployed
 	if len(bytecode) == 0 {
-		return nil, http.StatusBadRequest
-	}
-
-	// instantiate paymaster contract
-	pm, err := pay.NewPaymaster(addr, s.evm.Backend())
-	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "paymaster is not deployed", nil)
 	}
 
 	// parse the incoming params
@@ -85,7 +94,7 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 	var params []any
 	err = json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "params should be an array", nil)
 	}
 
 	var userop indexer.UserOp
@@ -97,46 +106,49 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 		case 0:
 			v, ok := param.(map[string]interface{})
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 			b, err := json.Marshal(v)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 
 			err = json.Unmarshal(b, &userop)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 		case 1:
 			v, ok := param.(string)
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid entry point", nil)
 			}
 
 			epAddr = v
 		case 2:
 			v, ok := param.(map[string]interface{})
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid paymaster type", nil)
 			}
 
 			b, err := json.Marshal(v)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid paymaster type", nil)
 			}
 
 			err = json.Unmarshal(b, &pt)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid paymaster type", nil)
 			}
 		}
 	}
 
 	if epAddr == "" {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "missing entry point", nil)
 	}
 
+	entryPoint := common.HexToAddress(epAddr)
+	version := s.eps.Version(entryPoint)
+
 	// verify the nonce
 
 	// get nonce using the account factory since we are not sure if the account has been created yet
@@ -147,7 +159,7 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 
 	// if the nonce is not 0, then the init code should be empty
 	if nonce.Cmp(big.NewInt(0)) == 1 && initCode != "0x" {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewPolicyRejection(indexer.PolicyReasonInvalidInitCode, "init code is only allowed with a nonce of 0")
 	}
 
 	// if the nonce is 0, then check that the factory exists
@@ -158,77 +170,19 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 		bytecode, err := s.evm.CodeAt(context.Background(), factoryaddr, nil)
 		if err != nil {
 			fmt.Println(err)
-			return nil, http.StatusInternalServerError
+			return nil, err
 		}
 
 		// Check if the contract is deployed
 		if len(bytecode) == 0 {
-			return nil, http.StatusBadRequest
-		}
-	}
-
-	// verify the calldata, it should only be allowed to contain the function signatures we allow
-	funcSig := userop.CallData[:4]
-	if !bytes.Equal(funcSig, funcSigSingle) && !bytes.Equal(funcSig, funcSigBatch) && !bytes.Equal(funcSig, funcSigSafeExecFromModule) {
-		return nil, http.StatusBadRequest
-	}
-
-	addressArg, _ := abi.NewType("address", "address", nil)
-	uint256Arg, _ := abi.NewType("uint256", "uint256", nil)
-	bytesArg, _ := abi.NewType("bytes", "bytes", nil)
-	callArgs := abi.Arguments{
-		abi.Argument{
-			Type: addressArg,
-		},
-		abi.Argument{
-			Type: uint256Arg,
-		},
-		abi.Argument{
-			Type: bytesArg,
-		},
-	}
-	if pt.Type == "cw-safe" {
-		operationArg, _ := abi.NewType("uint8", "uint8", nil)
-
-		callArgs = abi.Arguments{
-			abi.Argument{
-				Type: addressArg,
-			},
-			abi.Argument{
-				Type: uint256Arg,
-			},
-			abi.Argument{
-				Type: bytesArg,
-			},
-			abi.Argument{
-				Type: operationArg,
-			},
+			return nil, indexer.NewPolicyRejection(indexer.PolicyReasonFactoryNotDeployed, "account factory is not deployed")
 		}
 	}
 
-	// Unpack the values
-	callValues, err := callArgs.Unpack(userop.CallData[4:])
-	if err != nil {
-		return nil, http.StatusBadRequest
-	}
-
-	// destination address
-	_, ok := callValues[0].(common.Address)
-	if !ok {
-		return nil, http.StatusBadRequest
-	}
-
-	// value in uint256
-	callValue, ok := callValues[1].(*big.Int)
-	if !ok || callValue.Cmp(big.NewInt(0)) != 0 {
-		// shouldn't have any value
-		return nil, http.StatusBadRequest
-	}
-
-	// data in bytes
-	_, ok = callValues[2].([]byte)
-	if !ok {
-		return nil, http.StatusBadRequest
+	// verify the calldata, it should only be allowed to contain the account functions we allow
+	calls, rejection := accountCalls(userop.CallData)
+	if rejection != nil {
+		return nil, rejection
 	}
 
 	// validity period
@@ -238,7 +192,7 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 
 	// Ensure the values fit within 48 bits
 	if validUntil.BitLen() > 48 || validAfter.BitLen() > 48 {
-		return nil, http.StatusInternalServerError
+		return nil, errors.New("validity does not fit in 48 bits")
 	}
 
 	// Define the arguments
@@ -255,40 +209,68 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 	// Encode the values
 	validity, err := args.Pack(validUntil, validAfter)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
-	hash, err := pm.GetHash(nil, pay.UserOperation(userop), validUntil, validAfter)
-	if err != nil {
-		return nil, http.StatusInternalServerError
+	// the paymaster data starts with the paymaster and, for v0.7, its gas limits
+	prefix := s.paymasterPrefix(addr, version, &userop)
+
+	// estimate the gas of the op with paymaster data of the right size since it has not signed yet,
+	// the validation of the paymaster is part of the verification gas. The values of the client are only kept if they are higher
+	estimateOp := userop
+	estimateOp.PaymasterAndData = append(append(append([]byte{}, prefix...), validity...), comm.DummySignature...)
+
+	estimate, err := comm.EstimateUserOpGas(s.evm, entryPoint, version, estimateOp)
+	switch {
+	case errors.Is(err, comm.ErrEstimateUnsupported):
+		// v0.7 ops cannot be simulated, they are sponsored with the gas limits of the client
+		if userop.VerificationGasLimit == nil || userop.CallGasLimit == nil {
+			return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "v0.7 ops need a verificationGasLimit and a callGasLimit", nil)
+		}
+
+		estimate = &comm.UserOpGasEstimate{
+			VerificationGasLimit: userop.VerificationGasLimit,
+			CallGasLimit:         userop.CallGasLimit,
+		}
+	case err != nil:
+		return nil, err
 	}
 
-	// Convert the hash to an Ethereum signed message hash
-	hhash := accounts.TextHash(hash[:])
+	// the paymaster data is part of the calldata of the op, estimate it with a signature of the right size
+	estimateOp.PaymasterAndData = append(append(append([]byte{}, prefix...), validity...), bytes.Repeat([]byte{1}, 65)...)
 
-	// fetch the sponsor's corresponding private key from the db
-	sponsorKey, err := s.db.SponsorDB.GetSponsor(addr.Hex())
+	pvg, err := comm.PreVerificationGas(estimateOp, version)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
-	// Generate ecdsa.PrivateKey from bytes
-	privateKey, err := comm.HexToPrivateKey(sponsorKey.PrivateKey)
+	userop.PreVerificationGas = maxGas(userop.PreVerificationGas, pvg)
+	userop.VerificationGasLimit = maxGas(userop.VerificationGasLimit, estimate.VerificationGasLimit)
+	userop.CallGasLimit = maxGas(userop.CallGasLimit, estimate.CallGasLimit)
+
+	// the policy of the paymaster decides if it sponsors the op, with the gas it could use
+	err = s.applyPolicy(addr, userop.Sender, calls, 1, indexer.OpsGas(&userop, 1))
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
+	}
+
+	if version == indexer.EntryPointV07 {
+		// the paymaster of a v0.7 op signs its own gas limits
+		userop.PaymasterAndData = prefix
 	}
 
-	sig, err := crypto.Sign(hhash, privateKey)
+	hash, err := comm.PaymasterHash(s.evm, addr, version, userop, validUntil, validAfter)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
-	// Ensure the v value is 27 or 28, this is because of the way Ethereum signature recovery works
-	if sig[crypto.RecoveryIDOffset] == 0 || sig[crypto.RecoveryIDOffset] == 1 {
-		sig[crypto.RecoveryIDOffset] += 27
+	// the sponsor signs the hash as an Ethereum signed message
+	sig, err := s.signer.SignHash(addr, hash[:])
+	if err != nil {
+		return nil, err
 	}
 
-	data := append(addr.Bytes(), validity...)
+	data := append(append([]byte{}, prefix...), validity...)
 	data = append(data, sig...)
 
 	pd := &paymasterData{
@@ -298,11 +280,21 @@ func (s *Service) Sponsor(r *http.Request) (any, int) {
 		CallGasLimit:         hexutil.EncodeBig(userop.CallGasLimit),
 	}
 
-	return pd, http.StatusOK
+	if version == indexer.EntryPointV07 {
+		userop.PaymasterAndData = data
+		pvgl, pogl := userop.PaymasterGasLimits()
+
+		pd.Paymaster = addr.Hex()
+		pd.PaymasterVerificationGasLimit = hexutil.EncodeBig(pvgl)
+		pd.PaymasterPostOpGasLimit = hexutil.EncodeBig(pogl)
+		pd.PaymasterData = hexutil.Encode(data[version.PaymasterDataOffset():])
+	}
+
+	return pd, nil
 }
 
 // OOSponsor generates multiple signatures that can be used to send user operations in the future
-func (s *Service) OOSponsor(r *http.Request) (any, int) {
+func (s *Service) OOSponsor(r *http.Request) (any, error) {
 	// parse contract address from url params
 	contractAddr := chi.URLParam(r, "pm_address")
 
@@ -311,18 +303,12 @@ func (s *Service) OOSponsor(r *http.Request) (any, int) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), addr, nil)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
 	// Check if the contract is deployed
 	if len(bytecode) == 0 {
-		return nil, http.StatusBadRequest
-	}
-
-	// instantiate paymaster contract
-	pm, err := pay.NewPaymaster(addr, s.evm.Backend())
-	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "paymaster is not deployed", nil)
 	}
 
 	// parse the incoming params
@@ -330,51 +316,52 @@ func (s *Service) OOSponsor(r *http.Request) (any, int) {
 	var params []any
 	err = json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "params should be an array", nil)
 	}
 
 	var userop indexer.UserOp
 	var epAddr string
 	var pt paymasterType
 	var amount int
+	var use string
 
 	for i, param := range params {
 		switch i {
 		case 0:
 			v, ok := param.(map[string]interface{})
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 			b, err := json.Marshal(v)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 
 			err = json.Unmarshal(b, &userop)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 		case 1:
 			v, ok := param.(string)
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid entry point", nil)
 			}
 
 			epAddr = v
 		case 2:
 			v, ok := param.(map[string]interface{})
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid paymaster type", nil)
 			}
 
 			b, err := json.Marshal(v)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid paymaster type", nil)
 			}
 
 			err = json.Unmarshal(b, &pt)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid paymaster type", nil)
 			}
 		case 3:
 			v, ok := param.(float64) // json marshalling converts numbers to float64
@@ -391,87 +378,57 @@ func (s *Service) OOSponsor(r *http.Request) (any, int) {
 			} else {
 				amount = int(v)
 			}
+		case 4:
+			v, ok := param.(string)
+			if !ok {
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid intended use", nil)
+			}
+
+			use = v
 		}
 	}
 
 	if epAddr == "" {
-		return nil, http.StatusBadRequest
-	}
-
-	// verify the calldata, it should only be allowed to contain the function signatures we allow
-	funcSig := userop.CallData[:4]
-	if !bytes.Equal(funcSig, funcSigSingle) && !bytes.Equal(funcSig, funcSigBatch) && !bytes.Equal(funcSig, funcSigSafeExecFromModule) {
-		return nil, http.StatusBadRequest
-
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "missing entry point", nil)
 	}
 
-	addressArg, _ := abi.NewType("address", "address", nil)
-	uint256Arg, _ := abi.NewType("uint256", "uint256", nil)
-	bytesArg, _ := abi.NewType("bytes", "bytes", nil)
-	callArgs := abi.Arguments{
-		abi.Argument{
-			Type: addressArg,
-		},
-		abi.Argument{
-			Type: uint256Arg,
-		},
-		abi.Argument{
-			Type: bytesArg,
-		},
-	}
-	if pt.Type == "cw-safe" {
-		operationArg, _ := abi.NewType("uint8", "uint8", nil)
-
-		callArgs = abi.Arguments{
-			abi.Argument{
-				Type: addressArg,
-			},
-			abi.Argument{
-				Type: uint256Arg,
-			},
-			abi.Argument{
-				Type: bytesArg,
-			},
-			abi.Argument{
-				Type: operationArg,
-			},
-		}
-	}
+	entryPoint := common.HexToAddress(epAddr)
+	version := s.eps.Version(entryPoint)
 
-	// Unpack the values
-	callValues, err := callArgs.Unpack(userop.CallData[4:])
-	if err != nil {
-		return nil, http.StatusBadRequest
+	if amount < 0 {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid amount", nil)
 	}
 
-	// destination address
-	_, ok := callValues[0].(common.Address)
-	if !ok {
-		return nil, http.StatusBadRequest
+	if amount > ooMaxAmount {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "amount should be at most "+strconv.Itoa(ooMaxAmount), nil)
 	}
 
-	// value in uint256
-	callValue, ok := callValues[1].(*big.Int)
-	if !ok || callValue.Cmp(big.NewInt(0)) != 0 {
-		// shouldn't have any value
-		return nil, http.StatusBadRequest
+	// verify the calldata, it should only be allowed to contain the account functions we allow
+	calls, rejection := accountCalls(userop.CallData)
+	if rejection != nil {
+		return nil, rejection
 	}
 
-	// data in bytes
-	_, ok = callValues[2].([]byte)
-	if !ok {
-		return nil, http.StatusBadRequest
+	sigLimit, err := s.ooValidity(addr)
+	if err != nil {
+		return nil, err
 	}
 
 	// validity period
 	now := time.Now().Unix()
 
-	validUntil := big.NewInt(now + ooSigLimit)
+	validUntil := big.NewInt(now + sigLimit)
 	validAfter := big.NewInt(now - 10)
 
 	// Ensure the values fit within 48 bits
 	if validUntil.BitLen() > 48 || validAfter.BitLen() > 48 {
-		return nil, http.StatusInternalServerError
+		return nil, errors.New("validity does not fit in 48 bits")
+	}
+
+	// the signatures have to fit in what is left of the budgets of the policy, they count towards them once their ops are submitted
+	err = s.applyPolicy(addr, userop.Sender, calls, int64(amount), indexer.OpsGas(&userop, int64(amount)))
+	if err != nil {
+		return nil, err
 	}
 
 	// Define the arguments
@@ -488,22 +445,19 @@ func (s *Service) OOSponsor(r *http.Request) (any, int) {
 	// Encode the values
 	validity, err := args.Pack(validUntil, validAfter)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
-	// fetch the sponsor's corresponding private key from the db
-	sponsorKey, err := s.db.SponsorDB.GetSponsor(addr.Hex())
-	if err != nil {
-		return nil, http.StatusInternalServerError
-	}
+	prefix := s.paymasterPrefix(addr, version, &userop)
 
-	// Generate ecdsa.PrivateKey from bytes
-	privateKey, err := comm.HexToPrivateKey(sponsorKey.PrivateKey)
+	// every signature is recorded with the batch it was issued in, so that a batch can be revoked
+	batch, err := comm.GenerateKey()
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
 	userops := []*indexer.UserOp{}
+	sponsorships := []*indexer.Sponsorship{}
 
 	// generate an amount of nonces equivalent to the amount requested
 	for i := 0; i < amount; i++ {
@@ -511,36 +465,100 @@ func (s *Service) OOSponsor(r *http.Request) (any, int) {
 
 		nonce, err := comm.NewNonce()
 		if err != nil {
-			return nil, http.StatusInternalServerError
+			return nil, err
 		}
 
 		op.Nonce = nonce.BigInt()
 
-		hash, err := pm.GetHash(nil, pay.UserOperation(op), validUntil, validAfter)
-		if err != nil {
-			return nil, http.StatusInternalServerError
+		if version == indexer.EntryPointV07 {
+			// the paymaster of a v0.7 op signs its own gas limits
+			op.PaymasterAndData = prefix
 		}
 
-		// Convert the hash to an Ethereum signed message hash
-		hhash := accounts.TextHash(hash[:])
-
-		sig, err := crypto.Sign(hhash, privateKey)
+		hash, err := comm.PaymasterHash(s.evm, addr, version, op, validUntil, validAfter)
 		if err != nil {
-			return nil, http.StatusInternalServerError
+			return nil, err
 		}
 
-		// Ensure the v value is 27 or 28, this is because of the way Ethereum signature recovery works
-		if sig[crypto.RecoveryIDOffset] == 0 || sig[crypto.RecoveryIDOffset] == 1 {
-			sig[crypto.RecoveryIDOffset] += 27
+		// the sponsor signs the hash as an Ethereum signed message
+		sig, err := s.signer.SignHash(addr, hash[:])
+		if err != nil {
+			return nil, err
 		}
 
-		data := append(addr.Bytes(), validity...)
+		data := append(append([]byte{}, prefix...), validity...)
 		data = append(data, sig...)
 
 		op.PaymasterAndData = data
 
 		userops = append(userops, &op)
+		sponsorships = append(sponsorships, &indexer.Sponsorship{
+			Contract:   addr.Hex(),
+			Batch:      hexutil.Encode(batch[:16]),
+			Account:    op.Sender.Hex(),
+			NonceKey:   indexer.SponsorshipNonceKey(op.Nonce),
+			EntryPoint: entryPoint.Hex(),
+			Use:        use,
+			ValidAfter: validAfter.Int64(),
+			ValidUntil: validUntil.Int64(),
+		})
+	}
+
+	// the signatures are only returned once they are recorded
+	err = s.db.SponsorshipDB.AddSponsorships(sponsorships)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == indexer.EntryPointV07 {
+		// v0.7 clients expect the unpacked fields
+		packed := []*indexer.UserOpV07{}
+		for _, op := range userops {
+			packed = append(packed, (*indexer.UserOpV07)(op))
+		}
+
+		return packed, nil
+	}
+
+	return userops, nil
+}
+
+// ooValidity returns how many seconds the out of order signatures of a paymaster are valid
+func (s *Service) ooValidity(paymaster common.Address) (int64, error) {
+	p, err := s.db.PolicyDB.GetPolicy(paymaster.Hex())
+	if err == sql.ErrNoRows {
+		return ooSigLimit, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if p.OOValidity == 0 {
+		return ooSigLimit, nil
+	}
+
+	return p.OOValidity, nil
+}
+
+// paymasterPrefix returns what the paymaster data of an op starts with: the paymaster address and, for a v0.7 op,
+// the gas limits of the paymaster. The limits of the client are only kept if they are higher than the defaults.
+func (s *Service) paymasterPrefix(addr common.Address, version indexer.EntryPointVersion, op *indexer.UserOp) []byte {
+	if version != indexer.EntryPointV07 {
+		return addr.Bytes()
+	}
+
+	pvgl, pogl := op.PaymasterGasLimits()
+	pvgl = maxGas(pvgl, big.NewInt(paymasterVerificationGasLimit))
+	pogl = maxGas(pogl, big.NewInt(paymasterPostOpGasLimit))
+
+	return indexer.PackPaymasterAndData(addr, pvgl, pogl, nil)
+}
+
+// maxGas returns the highest gas value, a missing value counts as 0
+func maxGas(a, b *big.Int) *big.Int {
+	if a == nil || a.Cmp(b) < 0 {
+		return b
 	}
 
-	return userops, http.StatusOK
+	return a
 }
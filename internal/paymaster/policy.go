@@ -0,0 +1,164 @@
+package paymaster
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"time"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// policyCall is a call that a user operation makes through the account
+type policyCall struct {
+	target   common.Address
+	selector []byte // the function called on the target, empty if the call has no data
+}
+
+// newPolicyCall creates a policyCall from the destination and the data of an account call
+func newPolicyCall(target common.Address, data []byte) policyCall {
+	c := policyCall{target: target}
+	if len(data) >= 4 {
+		c.selector = data[:4]
+	}
+
+	return c
+}
+
+// accountCalls decodes the calls that the calldata of an op makes through the account, they should not send any value
+func accountCalls(calldata []byte) ([]policyCall, *indexer.JSONRPCError) {
+	accCalls, err := comm.ParseAccountCalls(calldata)
+	if err == comm.ErrUnknownCall {
+		return nil, indexer.NewPolicyRejection(indexer.PolicyReasonCallNotAllowed, "call data is not allowed")
+	}
+	if err != nil {
+		return nil, indexer.NewPolicyRejection(indexer.PolicyReasonInvalidCallData, "call data could not be decoded")
+	}
+
+	calls := make([]policyCall, len(accCalls))
+	for i, c := range accCalls {
+		if c.Value.Sign() != 0 {
+			// shouldn't have any value
+			return nil, indexer.NewPolicyRejection(indexer.PolicyReasonValueNotAllowed, "calls with a value are not allowed")
+		}
+
+		calls[i] = newPolicyCall(c.To, c.Data)
+	}
+
+	return calls, nil
+}
+
+// checkPolicy returns the rejection of a policy for ops of a sender, or nil if they are sponsored.
+// The usage is what the sender and the paymaster were sponsored on the day of now and spend is what their bundles cost.
+func checkPolicy(p *indexer.Policy, sender common.Address, calls []policyCall, ops, gas int64, account, daily *indexer.PolicyUsage, spend *indexer.SpendTotals, now time.Time) *indexer.JSONRPCError {
+	for _, denied := range p.Denied {
+		if common.HexToAddress(denied) == sender {
+			return indexer.NewPolicyRejection(indexer.PolicyReasonSenderDenied, "the sender is not sponsored")
+		}
+	}
+
+	if len(p.Windows) > 0 {
+		in := false
+		for _, w := range p.Windows {
+			if w.Contains(now) {
+				in = true
+				break
+			}
+		}
+
+		if !in {
+			return indexer.NewPolicyRejection(indexer.PolicyReasonOutsideWindow, "ops are not sponsored at this time")
+		}
+	}
+
+	if len(p.Targets) > 0 {
+		for _, c := range calls {
+			selectors, ok := policyTarget(p, c.target)
+			if !ok {
+				return indexer.NewPolicyRejection(indexer.PolicyReasonTargetNotAllowed, "calls to "+c.target.Hex()+" are not sponsored")
+			}
+
+			if len(selectors) > 0 && !policySelector(selectors, c.selector) {
+				return indexer.NewPolicyRejection(indexer.PolicyReasonSelectorNotAllowed, "the function called on "+c.target.Hex()+" is not sponsored")
+			}
+		}
+	}
+
+	if rejection := p.CheckBudgets(ops, gas, account, daily); rejection != nil {
+		return rejection
+	}
+
+	if p.Spend.Refuse && spend != nil {
+		for _, budget := range p.Spend.Over(spend, 100) {
+			if strings.HasPrefix(budget, "account") {
+				return indexer.NewPolicyRejection(indexer.PolicyReasonAccountSpend, "the sender has spent its "+budget+" budget")
+			}
+
+			return indexer.NewPolicyRejection(indexer.PolicyReasonSpend, "the paymaster has spent its "+budget+" budget")
+		}
+	}
+
+	return nil
+}
+
+// policyTarget returns the selectors allowed on a target, ok is false if the target is not allowed
+func policyTarget(p *indexer.Policy, target common.Address) ([]string, bool) {
+	for t, selectors := range p.Targets {
+		if common.HexToAddress(t) == target {
+			return selectors, true
+		}
+	}
+
+	return nil, false
+}
+
+// policySelector returns true if the selector is one of the allowed selectors
+func policySelector(selectors []string, selector []byte) bool {
+	for _, s := range selectors {
+		b, err := hexutil.Decode(s)
+		if err == nil && len(selector) > 0 && bytes.Equal(b, selector) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyPolicy checks the ops of a sender against the policy of the paymaster. The ops only count towards
+// the budgets once they are submitted, see queue.UseBudget.
+// A paymaster without a policy sponsors every op that passes the other checks.
+func (s *Service) applyPolicy(paymaster, sender common.Address, calls []policyCall, ops, gas int64) error {
+	p, err := s.db.PolicyDB.GetPolicy(paymaster.Hex())
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	day := now.Format(indexer.GasDayLayout)
+
+	account, daily, err := s.db.PolicyDB.GetUsage(paymaster.Hex(), sender.Hex(), day)
+	if err != nil {
+		return err
+	}
+
+	var spend *indexer.SpendTotals
+	if p.Spend.Refuse {
+		spend, err = s.db.GasDB.GetSpendTotals(paymaster.Hex(), sender.Hex(), day)
+		if err != nil {
+			return err
+		}
+	}
+
+	rejection := checkPolicy(p, sender, calls, ops, gas, account, daily, spend, now)
+	if rejection != nil {
+		return rejection
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package paymaster
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	token := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	other := common.HexToAddress("0x0000000000000000000000000000000000000003")
+
+	transfer := []byte{0xa9, 0x05, 0x9c, 0xbb, 0x00}
+	approve := []byte{0x09, 0x5e, 0xa7, 0xb3, 0x00}
+
+	// a wednesday at noon
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	policy := &indexer.Policy{
+		Contract: "0x0000000000000000000000000000000000000004",
+		Targets: map[string][]string{
+			token.Hex(): {"0xa9059cbb"},
+		},
+		Denied:  []string{other.Hex()},
+		Account: indexer.PolicyBudget{Ops: 2, Gas: 1000},
+		Daily:   indexer.PolicyBudget{Ops: 10},
+		Spend:   indexer.PolicySpend{Day: "1000", AccountDay: "100", Refuse: true},
+		Windows: []indexer.PolicyWindow{
+			{Days: []time.Weekday{time.Monday, time.Wednesday}, Start: "08:00", End: "18:00"},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		sender  common.Address
+		calls   []policyCall
+		gas     int64
+		account indexer.PolicyUsage
+		daily   indexer.PolicyUsage
+		spend   indexer.SpendTotals
+		now     time.Time
+		reason  indexer.PolicyReason
+	}{
+		{name: "allowed", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, gas: 500, now: noon},
+		{name: "denied sender", sender: other, calls: []policyCall{newPolicyCall(token, transfer)}, now: noon, reason: indexer.PolicyReasonSenderDenied},
+		{name: "outside window", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, now: noon.Add(8 * time.Hour), reason: indexer.PolicyReasonOutsideWindow},
+		{name: "other day", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, now: noon.Add(24 * time.Hour), reason: indexer.PolicyReasonOutsideWindow},
+		{name: "target", sender: sender, calls: []policyCall{newPolicyCall(other, transfer)}, now: noon, reason: indexer.PolicyReasonTargetNotAllowed},
+		{name: "selector", sender: sender, calls: []policyCall{newPolicyCall(token, approve)}, now: noon, reason: indexer.PolicyReasonSelectorNotAllowed},
+		{name: "no data", sender: sender, calls: []policyCall{newPolicyCall(token, nil)}, now: noon, reason: indexer.PolicyReasonSelectorNotAllowed},
+		{name: "account ops", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, account: indexer.PolicyUsage{Ops: 2}, now: noon, reason: indexer.PolicyReasonAccountOps},
+		{name: "account gas", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, gas: 600, account: indexer.PolicyUsage{Ops: 1, Gas: 500}, now: noon, reason: indexer.PolicyReasonAccountGas},
+		{name: "daily ops", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, daily: indexer.PolicyUsage{Ops: 10}, now: noon, reason: indexer.PolicyReasonDailyOps},
+		{name: "spend", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, spend: indexer.SpendTotals{Day: big.NewInt(1000)}, now: noon, reason: indexer.PolicyReasonSpend},
+		{name: "account spend", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, spend: indexer.SpendTotals{Day: big.NewInt(500), AccountDay: big.NewInt(100)}, now: noon, reason: indexer.PolicyReasonAccountSpend},
+		{name: "under spend", sender: sender, calls: []policyCall{newPolicyCall(token, transfer)}, spend: indexer.SpendTotals{Day: big.NewInt(999), AccountDay: big.NewInt(99)}, now: noon},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkPolicy(policy, c.sender, c.calls, 1, c.gas, &c.account, &c.daily, &c.spend, c.now)
+			if c.reason == "" {
+				if err != nil {
+					t.Fatalf("expected the op to be sponsored, got %s", err.Message)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected the op to be rejected with %s", c.reason)
+			}
+
+			rejection, ok := err.Data.(*indexer.PolicyRejection)
+			if !ok || rejection.Reason != c.reason {
+				t.Fatalf("expected the reason %s, got %v", c.reason, err.Data)
+			}
+		})
+	}
+}
+
+func TestPolicyWindow(t *testing.T) {
+	// a window past midnight belongs to the day it starts on
+	w := indexer.PolicyWindow{Days: []time.Weekday{time.Friday}, Start: "22:00", End: "02:00"}
+
+	friday := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		at       time.Time
+		expected bool
+	}{
+		{friday.Add(23 * time.Hour), true},
+		{friday.Add(25 * time.Hour), true},
+		{friday.Add(26 * time.Hour), false},
+		{friday.Add(1 * time.Hour), false},
+		{friday.Add(21 * time.Hour), false},
+	}
+
+	for _, c := range cases {
+		if w.Contains(c.at) != c.expected {
+			t.Fatalf("expected %s to be in the window: %v", c.at, c.expected)
+		}
+	}
+}
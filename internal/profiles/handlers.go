@@ -35,7 +35,7 @@ func (s *Service) PinProfile(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -47,7 +47,7 @@ func (s *Service) PinProfile(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -59,27 +59,27 @@ func (s *Service) PinProfile(w http.ResponseWriter, r *http.Request) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), prf, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the profile contract is deployed
 	if len(bytecode) == 0 {
-		http.Error(w, "profile contract is missing", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeNotFound, "profile contract is missing", nil)
 		return
 	}
 
 	// instantiate profile contract
 	prfcontract, err := profile.NewProfile(prf, s.evm.Backend())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	var profile indexer.Profile
 	err = json.NewDecoder(r.Body).Decode(&profile)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
@@ -87,20 +87,20 @@ func (s *Service) PinProfile(w http.ResponseWriter, r *http.Request) {
 	praddr := common.HexToAddress(profile.Account)
 
 	if acc != praddr {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
 	// pin profile to ipfs
 	b, err := json.Marshal(profile)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	uri, err := s.b.PinJSONToIPFS(r.Context(), b)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
@@ -119,7 +119,7 @@ func (s *Service) PinProfile(w http.ResponseWriter, r *http.Request) {
 
 	err = com.Body(w, &pinResponse{IpfsURL: uri}, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -128,14 +128,14 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	// Parse the form data to get the uploaded file
 	err := r.ParseMultipartForm(10 << 20) // 10 MB limit (adjust as needed)
 	if err != nil {
-		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "Unable to parse form", nil)
 		return
 	}
 
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -147,7 +147,7 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -159,26 +159,26 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), prf, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the profile contract is deployed
 	if len(bytecode) == 0 {
-		http.Error(w, "profile contract is missing", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeNotFound, "profile contract is missing", nil)
 		return
 	}
 
 	// instantiate profile contract
 	prfcontract, err := profile.NewProfile(prf, s.evm.Backend())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	file, _, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, err.Error(), com.ParamDetails{Param: "file"})
 		return
 	}
 	defer file.Close()
@@ -186,33 +186,33 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	// parse image
 	si, err := com.ParseImage(file)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, err.Error(), com.ParamDetails{Param: "file"})
 		return
 	}
 
 	strbody := r.MultipartForm.Value["body"][0]
 	if strbody == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "missing body", com.ParamDetails{Param: "body"})
 		return
 	}
 
 	var profile indexer.Profile
 	if err := json.Unmarshal([]byte(strbody), &profile); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 
 	praddr := common.HexToAddress(profile.Account)
 
 	if acc != praddr {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
 	// pin image to ipfs
 	uri, err := s.b.PinFileToIPFS(r.Context(), si.Big, "big.jpg")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
@@ -221,7 +221,7 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	// pin medium image to ipfs
 	uri, err = s.b.PinFileToIPFS(r.Context(), si.Medium, "medium.jpg")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
@@ -230,7 +230,7 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	// pin small image to ipfs
 	uri, err = s.b.PinFileToIPFS(r.Context(), si.Small, "small.jpg")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
@@ -239,13 +239,13 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 	// pin profile to ipfs
 	b, err := json.Marshal(profile)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	uri, err = s.b.PinJSONToIPFS(r.Context(), b)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
@@ -264,7 +264,7 @@ func (s *Service) PinMultiPartProfile(w http.ResponseWriter, r *http.Request) {
 
 	err = com.Body(w, &pinResponse{IpfsURL: uri}, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -273,7 +273,7 @@ func (s *Service) Unpin(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -285,7 +285,7 @@ func (s *Service) Unpin(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -297,33 +297,33 @@ func (s *Service) Unpin(w http.ResponseWriter, r *http.Request) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), prf, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeUpstream, err.Error(), nil)
 		return
 	}
 
 	// Check if the profile contract is deployed
 	if len(bytecode) == 0 {
-		http.Error(w, "profile contract is missing", http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeNotFound, "profile contract is missing", nil)
 		return
 	}
 
 	// instantiate profile contract
 	prfcontract, err := profile.NewProfile(prf, s.evm.Backend())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, err.Error(), nil)
 		return
 	}
 
 	// get the hash from the profile contract, makes sure that users can only delete their own profile
 	hash, err := prfcontract.Get(nil, acc)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeNotFound, "profile not found", nil)
 		return
 	}
 
 	err = s.b.Unpin(r.Context(), hash)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeUpstream, "could not unpin the profile", nil)
 		return
 	}
 }
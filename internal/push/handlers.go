@@ -25,7 +25,7 @@ func (s *Service) AddToken(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -37,7 +37,7 @@ func (s *Service) AddToken(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -47,7 +47,7 @@ func (s *Service) AddToken(w http.ResponseWriter, r *http.Request) {
 	var pt indexer.PushToken
 	err := json.NewDecoder(r.Body).Decode(&pt)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
 		return
 	}
 	defer r.Body.Close()
@@ -57,31 +57,31 @@ func (s *Service) AddToken(w http.ResponseWriter, r *http.Request) {
 
 	// check that the push token is from the sender of the transaction
 	if !com.IsSameHexAddress(pt.Account, acc.Hex()) {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the transfer is not from the signed address", nil)
 		return
 	}
 
 	tname, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	pdb, ok := s.db.PushTokenDB[tname]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	err = pdb.AddToken(&pt)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not add the push token", nil)
 		return
 	}
 
 	err = com.Body(w, pt, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
 
@@ -89,7 +89,7 @@ func (s *Service) RemoveAccountToken(w http.ResponseWriter, r *http.Request) {
 	// ensure that the address in the url matches the one in the headers
 	addr, ok := com.GetContextAddress(r.Context())
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
 		return
 	}
 
@@ -101,7 +101,7 @@ func (s *Service) RemoveAccountToken(w http.ResponseWriter, r *http.Request) {
 	acc := common.HexToAddress(accaddr)
 
 	if haccaddr != acc {
-		w.WriteHeader(http.StatusUnauthorized)
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
 		return
 	}
 
@@ -112,30 +112,30 @@ func (s *Service) RemoveAccountToken(w http.ResponseWriter, r *http.Request) {
 	token := chi.URLParam(r, "token")
 
 	if token == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "missing token", com.ParamDetails{Param: "token"})
 		return
 	}
 
 	tname, err := s.db.TableNameSuffix(contractAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid token address", nil)
 		return
 	}
 
 	pdb, ok := s.db.PushTokenDB[tname]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
 		return
 	}
 
 	err = pdb.RemoveAccountPushToken(token, accaddr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not remove the push token", nil)
 		return
 	}
 
 	err = com.Body(w, []byte("{}"), nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
@@ -0,0 +1,456 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+// CreateBalanceCheckpointTable creates a table to store balance checkpoints in the given db
+// a checkpoint contains every non-zero balance at a point in time so that balances don't need to be computed from the first transfer
+func (db *TransferDB) CreateBalanceCheckpointTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_balance_checkpoints_%s(
+		token_id integer NOT NULL,
+		at timestamp NOT NULL,
+		address text NOT NULL,
+		balance text NOT NULL,
+		PRIMARY KEY (token_id, at, address)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// latestCheckpoint returns the time of the latest checkpoint at or before the given time
+func (db *TransferDB) latestCheckpoint(tokenId int64, at time.Time) (*time.Time, error) {
+	var cp time.Time
+
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+		SELECT at FROM t_balance_checkpoints_%s
+		WHERE token_id = $1 AND at <= $2
+		ORDER BY at DESC
+		LIMIT 1
+		`, db.suffix), tokenId, at).Scan(&cp)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &cp, nil
+}
+
+// GetBalanceAt returns the balance of an address at the given time from the successful transfers
+func (db *TransferDB) GetBalanceAt(tokenId int64, addr string, at time.Time) (*big.Int, error) {
+	balance := big.NewInt(0)
+
+	cp, err := db.latestCheckpoint(tokenId, at)
+	if err != nil {
+		return nil, err
+	}
+
+	// start from the checkpoint if there is one
+	since := time.Time{}
+	if cp != nil {
+		since = *cp
+
+		var value string
+		err := db.rdb.QueryRow(fmt.Sprintf(`
+			SELECT balance FROM t_balance_checkpoints_%s
+			WHERE token_id = $1 AND at = $2 AND address = $3
+			`, db.suffix), tokenId, since, addr).Scan(&value)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		if err == nil {
+			balance.SetString(value, 10)
+		}
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT from_addr, to_addr, value
+		FROM t_transfers_%s
+		WHERE token_id = $1 AND status = 'success' AND created_at > $2 AND created_at <= $3 AND (from_addr = $4 OR to_addr = $4)
+		`, db.suffix), tokenId, since, at, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var from, to, value string
+
+		err := rows.Scan(&from, &to, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid transfer value: %s", value)
+		}
+
+		if from == addr {
+			balance.Sub(balance, v)
+		}
+
+		if to == addr {
+			balance.Add(balance, v)
+		}
+	}
+
+	return balance, rows.Err()
+}
+
+// GetAddressBalancesAt returns the balances of the given addresses at the given time from the successful transfers
+// every address is in the result, with a zero balance if it never received anything
+func (db *TransferDB) GetAddressBalancesAt(tokenId int64, addrs []string, at time.Time) (map[string]*big.Int, error) {
+	balances := map[string]*big.Int{}
+	for _, addr := range addrs {
+		balances[addr] = big.NewInt(0)
+	}
+
+	if len(addrs) == 0 {
+		return balances, nil
+	}
+
+	cp, err := db.latestCheckpoint(tokenId, at)
+	if err != nil {
+		return nil, err
+	}
+
+	// start from the checkpoint if there is one
+	since := time.Time{}
+	if cp != nil {
+		since = *cp
+
+		q := &queryArgs{}
+		tokenIdArg, sinceArg := q.add(tokenId), q.add(since)
+
+		placeholders := []string{}
+		for _, addr := range addrs {
+			placeholders = append(placeholders, q.add(addr))
+		}
+
+		rows, err := db.rdb.Query(fmt.Sprintf(`
+			SELECT address, balance FROM t_balance_checkpoints_%s
+			WHERE token_id = %s AND at = %s AND address IN (%s)
+			`, db.suffix, tokenIdArg, sinceArg, strings.Join(placeholders, ", ")), q.args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var addr, value string
+
+			err := rows.Scan(&addr, &value)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			balances[addr].SetString(value, 10)
+		}
+		rows.Close()
+
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	q := &queryArgs{}
+	tokenIdArg, sinceArg, atArg := q.add(tokenId), q.add(since), q.add(at)
+
+	placeholders := []string{}
+	for _, addr := range addrs {
+		placeholders = append(placeholders, q.add(addr))
+	}
+	in := strings.Join(placeholders, ", ")
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT from_addr, to_addr, value
+		FROM t_transfers_%s
+		WHERE token_id = %s AND status = 'success' AND created_at > %s AND created_at <= %s AND (from_addr IN (%s) OR to_addr IN (%s))
+		`, db.suffix, tokenIdArg, sinceArg, atArg, in, in), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var from, to, value string
+
+		err := rows.Scan(&from, &to, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid transfer value: %s", value)
+		}
+
+		if b, ok := balances[from]; ok {
+			b.Sub(b, v)
+		}
+
+		if b, ok := balances[to]; ok {
+			b.Add(b, v)
+		}
+	}
+
+	return balances, rows.Err()
+}
+
+// GetBalancesAt returns the non-zero balances of all addresses at the given time from the successful transfers
+// the zero address has a negative balance which is the amount that was minted minus the amount that was burned
+func (db *TransferDB) GetBalancesAt(tokenId int64, at time.Time) (map[string]*big.Int, error) {
+	balances := map[string]*big.Int{}
+
+	cp, err := db.latestCheckpoint(tokenId, at)
+	if err != nil {
+		return nil, err
+	}
+
+	// start from the checkpoint if there is one
+	since := time.Time{}
+	if cp != nil {
+		since = *cp
+
+		rows, err := db.rdb.Query(fmt.Sprintf(`
+			SELECT address, balance FROM t_balance_checkpoints_%s
+			WHERE token_id = $1 AND at = $2
+			`, db.suffix), tokenId, since)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var addr, value string
+
+			err := rows.Scan(&addr, &value)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			balances[addr], _ = new(big.Int).SetString(value, 10)
+		}
+		rows.Close()
+
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT from_addr, to_addr, value
+		FROM t_transfers_%s
+		WHERE token_id = $1 AND status = 'success' AND created_at > $2 AND created_at <= $3
+		`, db.suffix), tokenId, since, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balance := func(addr string) *big.Int {
+		b, ok := balances[addr]
+		if !ok {
+			b = big.NewInt(0)
+			balances[addr] = b
+		}
+
+		return b
+	}
+
+	for rows.Next() {
+		var from, to, value string
+
+		err := rows.Scan(&from, &to, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid transfer value: %s", value)
+		}
+
+		balance(from).Sub(balance(from), v)
+		balance(to).Add(balance(to), v)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for addr, b := range balances {
+		if b.Sign() == 0 {
+			delete(balances, addr)
+		}
+	}
+
+	return balances, nil
+}
+
+// GetAllHoldersAt returns all the addresses with a positive balance at the given time sorted by balance
+func (db *TransferDB) GetAllHoldersAt(tokenId int64, at time.Time, ascending bool) ([]*indexer.Balance, error) {
+	balances, err := db.GetBalancesAt(tokenId, at)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := []*indexer.Balance{}
+	for addr, b := range balances {
+		if addr == common.ZeroAddress || b.Sign() <= 0 {
+			continue
+		}
+
+		holders = append(holders, &indexer.Balance{
+			Address: addr,
+			TokenID: tokenId,
+			Balance: b,
+			At:      at,
+		})
+	}
+
+	sort.Slice(holders, func(i, j int) bool {
+		c := holders[i].Balance.Cmp(holders[j].Balance)
+		if c == 0 {
+			return holders[i].Address < holders[j].Address
+		}
+
+		if ascending {
+			return c < 0
+		}
+
+		return c > 0
+	})
+
+	return holders, nil
+}
+
+// GetHoldersAt returns the addresses with a positive balance at the given time sorted by balance, and the total amount of holders
+func (db *TransferDB) GetHoldersAt(tokenId int64, at time.Time, ascending bool, limit, offset int) ([]*indexer.Balance, int, error) {
+	holders, err := db.GetAllHoldersAt(tokenId, at, ascending)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(holders)
+
+	if offset >= total {
+		return []*indexer.Balance{}, total, nil
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	return holders[offset:end], total, nil
+}
+
+// CreateBalanceCheckpoint stores the balances of all addresses at the given time
+func (db *TransferDB) CreateBalanceCheckpoint(tokenId int64, at time.Time) error {
+	balances, err := db.GetBalancesAt(tokenId, at)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(`
+	DELETE FROM t_balance_checkpoints_%s WHERE token_id = $1 AND at = $2
+	`, db.suffix), tokenId, at)
+	if err != nil {
+		return err
+	}
+
+	for addr, b := range balances {
+		_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO t_balance_checkpoints_%s (token_id, at, address, balance)
+		VALUES ($1, $2, $3, $4)
+		`, db.suffix), tokenId, at, addr, b.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CheckpointBalances creates a checkpoint at the given time for every token id transferred before it that doesn't have one yet
+func (db *TransferDB) CheckpointBalances(at time.Time) error {
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT DISTINCT token_id FROM t_transfers_%s
+		WHERE status = 'success' AND created_at <= $1
+		`, db.suffix), at)
+	if err != nil {
+		return err
+	}
+
+	var tokenIds []int64
+	for rows.Next() {
+		var tokenId int64
+
+		err := rows.Scan(&tokenId)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		tokenIds = append(tokenIds, tokenId)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, tokenId := range tokenIds {
+		cp, err := db.latestCheckpoint(tokenId, at)
+		if err != nil {
+			return err
+		}
+
+		if cp != nil && cp.Equal(at) {
+			continue
+		}
+
+		err = db.CreateBalanceCheckpoint(tokenId, at)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// invalidateBalanceCheckpoints removes the checkpoints that were made at or after the given time
+// they no longer match the transfers when a transfer is added before them
+func (db *TransferDB) invalidateBalanceCheckpoints(from time.Time) error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	DELETE FROM t_balance_checkpoints_%s WHERE at >= $1
+	`, db.suffix), from)
+
+	return err
+}
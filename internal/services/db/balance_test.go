@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+func TestCheckpointBalances(t *testing.T) {
+	d := newTestDB(t)
+	txdb, _ := newTestStatsDB(t, d)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	next := day.Add(24 * time.Hour)
+
+	err := txdb.AddTransfers([]*indexer.Transfer{
+		testTransfer(1, common.ZeroAddress, testAlice, 100, day.Add(10*time.Hour)),
+		testTransfer(2, testAlice, testBob, 10, day.Add(11*time.Hour)),
+		testTransfer(3, testBob, testAlice, 5, next.Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		var n int
+		err := txdb.rdb.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM t_balance_checkpoints_%s WHERE at = $1", txdb.suffix), next).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return n
+	}
+
+	// creating the checkpoint again doesn't change it
+	for range 2 {
+		err = txdb.CheckpointBalances(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := count(); n != 3 {
+			t.Fatalf("expected a checkpoint of 3 balances, got %d", n)
+		}
+	}
+
+	cp, err := txdb.latestCheckpoint(0, next.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cp == nil || !cp.Equal(next) {
+		t.Fatalf("expected the checkpoint at %s, got %v", next, cp)
+	}
+
+	balances, err := txdb.GetAddressBalancesAt(0, []string{testAlice, testBob}, next.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balances[testAlice].Int64() != 95 || balances[testBob].Int64() != 5 {
+		t.Errorf("unexpected balances after the checkpoint: %v", balances)
+	}
+
+	// a transfer indexed before the checkpoint removes it until the next one is created
+	err = txdb.AddTransfers([]*indexer.Transfer{testTransfer(4, testAlice, testBob, 1, day.Add(12*time.Hour))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(); n != 0 {
+		t.Fatalf("expected the checkpoint to be removed, got %d balances", n)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"database/sql"
 
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
 	"github.com/citizenwallet/indexer/internal/storage"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -19,6 +20,8 @@ const (
 	dbBaseFolder         = "data"
 	dbWriterConfigString = "cache=private&_journal=WAL&mode=rwc"
 	dbReaderConfigString = "cache=private&_journal=WAL&mode=ro"
+
+	brokerHistorySize = 10000 // transfer events kept in memory for subscribers that reconnect
 )
 
 type DB struct {
@@ -27,10 +30,19 @@ type DB struct {
 	db      *sql.DB
 	rdb     *sql.DB
 
-	EventDB     *EventDB
-	SponsorDB   *SponsorDB
-	TransferDB  map[string]*TransferDB
-	PushTokenDB map[string]*PushTokenDB
+	EventDB       *EventDB
+	SponsorDB     *SponsorDB
+	UserOpDB      *UserOpDB
+	QueueDB       *QueueDB
+	PolicyDB      *PolicyDB
+	GasDB         *GasDB
+	SponsorshipDB *SponsorshipDB
+	ScheduleDB    *ScheduleDB
+	TransferDB    map[string]*TransferDB
+	PushTokenDB   map[string]*PushTokenDB
+	StatsDB       map[string]*StatsDB
+
+	Broker *pubsub.Broker
 }
 
 // NewDB instantiates a new DB
@@ -99,12 +111,49 @@ func NewDB(chainID *big.Int, basePath, secret string) (*DB, error) {
 		return nil, err
 	}
 
+	userOpDB, err := NewUserOpDB(db, rdb, evname)
+	if err != nil {
+		return nil, err
+	}
+
+	queueDB, err := NewQueueDB(db, rdb, evname)
+	if err != nil {
+		return nil, err
+	}
+
+	policyDB, err := NewPolicyDB(db, rdb, evname)
+	if err != nil {
+		return nil, err
+	}
+
+	gasDB, err := NewGasDB(db, rdb, evname)
+	if err != nil {
+		return nil, err
+	}
+
+	sponsorshipDB, err := NewSponsorshipDB(db, rdb, evname)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduleDB, err := NewScheduleDB(db, rdb, evname)
+	if err != nil {
+		return nil, err
+	}
+
 	d := &DB{
-		chainID:   chainID,
-		db:        db,
-		rdb:       rdb,
-		EventDB:   eventDB,
-		SponsorDB: sponsorDB,
+		chainID:       chainID,
+		db:            db,
+		rdb:           rdb,
+		EventDB:       eventDB,
+		SponsorDB:     sponsorDB,
+		UserOpDB:      userOpDB,
+		QueueDB:       queueDB,
+		PolicyDB:      policyDB,
+		GasDB:         gasDB,
+		SponsorshipDB: sponsorshipDB,
+		ScheduleDB:    scheduleDB,
+		Broker:        pubsub.NewBroker(brokerHistorySize),
 	}
 
 	// check if db exists before opening, since we use rwc mode
@@ -147,8 +196,86 @@ func NewDB(chainID *big.Int, basePath, secret string) (*DB, error) {
 		}
 	}
 
+	// keys that submit the bundles of the paymasters
+	err = sponsorDB.CreateSponsorKeysTable()
+	if err != nil {
+		return nil, err
+	}
+
+	// user operations submitted through the bundler
+	err = userOpDB.CreateUserOpsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = userOpDB.CreateUserOpsTableIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	// messages waiting in the queues, they are replayed on boot
+	err = queueDB.CreateQueueTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = queueDB.CreateQueueTableIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	// sponsorship policies of the paymasters and what they sponsored
+	err = policyDB.CreatePolicyTables()
+	if err != nil {
+		return nil, err
+	}
+
+	err = policyDB.CreatePolicyTablesIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	// gas that the bundles used, attributed to their user operations
+	err = gasDB.CreateGasLedgerTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gasDB.CreateGasLedgerTableIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gasDB.CreateGasTotalsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	// out of order sponsorships that the paymasters issued
+	err = sponsorshipDB.CreateSponsorshipsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sponsorshipDB.CreateSponsorshipsTableIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	// user operations that are submitted once they are due
+	err = scheduleDB.CreateScheduledUserOpsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = scheduleDB.CreateScheduledUserOpsTableIndexes()
+	if err != nil {
+		return nil, err
+	}
+
 	txdb := map[string]*TransferDB{}
 	ptdb := map[string]*PushTokenDB{}
+	sdb := map[string]*StatsDB{}
 
 	evs, err := eventDB.GetEvents()
 	if err != nil {
@@ -163,7 +290,7 @@ func NewDB(chainID *big.Int, basePath, secret string) (*DB, error) {
 
 		log.Default().Println("creating transfer db for: ", name)
 
-		txdb[name], err = NewTransferDB(db, rdb, name)
+		txdb[name], err = NewTransferDB(db, rdb, name, d.Broker)
 		if err != nil {
 			return nil, err
 		}
@@ -180,12 +307,24 @@ func NewDB(chainID *big.Int, basePath, secret string) (*DB, error) {
 			if err != nil {
 				return nil, err
 			}
+		}
 
-			// create indexes
-			err = txdb[name].CreateTransferTableIndexes()
-			if err != nil {
-				return nil, err
-			}
+		// create indexes, existing tables receive any index that was added since they were created
+		err = txdb[name].CreateTransferTableIndexes()
+		if err != nil {
+			return nil, err
+		}
+
+		// descriptions are indexed for searching
+		err = txdb[name].CreateTransferSearchTable()
+		if err != nil {
+			return nil, err
+		}
+
+		// balances can be checkpointed
+		err = txdb[name].CreateBalanceCheckpointTable()
+		if err != nil {
+			return nil, err
 		}
 
 		log.Default().Println("creating push token db for: ", name)
@@ -214,10 +353,29 @@ func NewDB(chainID *big.Int, basePath, secret string) (*DB, error) {
 				return nil, err
 			}
 		}
+
+		log.Default().Println("creating stats db for: ", name)
+
+		sdb[name], err = NewStatsDB(db, rdb, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// create tables and indexes, they only contain aggregates and are cheap to check
+		err = sdb[name].CreateStatsTables()
+		if err != nil {
+			return nil, err
+		}
+
+		err = sdb[name].CreateStatsTablesIndexes()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	d.TransferDB = txdb
 	d.PushTokenDB = ptdb
+	d.StatsDB = sdb
 
 	return d, nil
 }
@@ -343,6 +501,22 @@ func (d *DB) GetPushTokenDB(contract string) (*PushTokenDB, bool) {
 	return ptdb, true
 }
 
+// GetStatsDB returns true if the stats db for the given contract exists, returns the db if it exists
+func (d *DB) GetStatsDB(contract string) (*StatsDB, bool) {
+	name, err := d.TableNameSuffix(contract)
+	if err != nil {
+		return nil, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sdb, ok := d.StatsDB[name]
+	if !ok {
+		return nil, false
+	}
+	return sdb, true
+}
+
 // AddTransferDB adds a new transfer db for the given contract
 func (d *DB) AddTransferDB(contract string) (*TransferDB, error) {
 	name, err := d.TableNameSuffix(contract)
@@ -355,7 +529,7 @@ func (d *DB) AddTransferDB(contract string) (*TransferDB, error) {
 	if txdb, ok := d.TransferDB[name]; ok {
 		return txdb, nil
 	}
-	txdb, err := NewTransferDB(d.db, d.rdb, name)
+	txdb, err := NewTransferDB(d.db, d.rdb, name, d.Broker)
 	if err != nil {
 		return nil, err
 	}
@@ -382,7 +556,26 @@ func (d *DB) AddPushTokenDB(contract string) (*PushTokenDB, error) {
 	return ptdb, nil
 }
 
-// Close closes the db and all its transfer and push dbs
+// AddStatsDB adds a new stats db for the given contract
+func (d *DB) AddStatsDB(contract string) (*StatsDB, error) {
+	name, err := d.TableNameSuffix(contract)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if sdb, ok := d.StatsDB[name]; ok {
+		return sdb, nil
+	}
+	sdb, err := NewStatsDB(d.db, d.rdb, name)
+	if err != nil {
+		return nil, err
+	}
+	d.StatsDB[name] = sdb
+	return sdb, nil
+}
+
+// Close closes the db and all its transfer, push and stats dbs
 func (d *DB) Close() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -404,10 +597,49 @@ func (d *DB) Close() error {
 		delete(d.PushTokenDB, i)
 	}
 
+	for i, sdb := range d.StatsDB {
+		err := sdb.Close()
+		if err != nil {
+			return err
+		}
+
+		delete(d.StatsDB, i)
+	}
+
 	err := d.SponsorDB.Close()
 	if err != nil {
 		return err
 	}
 
+	err = d.UserOpDB.Close()
+	if err != nil {
+		return err
+	}
+
+	err = d.QueueDB.Close()
+	if err != nil {
+		return err
+	}
+
+	err = d.PolicyDB.Close()
+	if err != nil {
+		return err
+	}
+
+	err = d.GasDB.Close()
+	if err != nil {
+		return err
+	}
+
+	err = d.SponsorshipDB.Close()
+	if err != nil {
+		return err
+	}
+
+	err = d.ScheduleDB.Close()
+	if err != nil {
+		return err
+	}
+
 	return d.EventDB.Close()
 }
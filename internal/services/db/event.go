@@ -96,6 +96,23 @@ func (db *EventDB) GetEvent(contract string, standard indexer.Standard) (*indexe
 	return &event, nil
 }
 
+// GetContractEvent gets the first event of a contract from the db, regardless of its standard
+func (db *EventDB) GetContractEvent(contract string) (*indexer.Event, error) {
+	var event indexer.Event
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT contract, state, created_at, updated_at, start_block, last_block, standard, name, symbol, decimals
+	FROM t_events_%s
+	WHERE lower(contract) = lower($1)
+	ORDER BY created_at ASC
+	LIMIT 1
+	`, db.suffix), contract).Scan(&event.Contract, &event.State, &event.CreatedAt, &event.UpdatedAt, &event.StartBlock, &event.LastBlock, &event.Standard, &event.Name, &event.Symbol, &event.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
 // GetEvents gets all events from the db
 func (db *EventDB) GetEvents() ([]*indexer.Event, error) {
 	rows, err := db.rdb.Query(fmt.Sprintf(`
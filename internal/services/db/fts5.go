@@ -0,0 +1,6 @@
+//go:build sqlite_fts5
+
+package db
+
+// FTS5 is true when sqlite is built with the fts5 extension that searching transfer descriptions uses
+const FTS5 = true
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+type GasDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// NewGasDB creates a new DB
+func NewGasDB(db, rdb *sql.DB, name string) (*GasDB, error) {
+	gdb := &GasDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return gdb, nil
+}
+
+// Close closes the db
+func (db *GasDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *GasDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreateGasLedgerTable creates a table to store the gas that the bundles used, attributed to their user operations.
+// Costs are stored as text since their sums do not fit in an integer.
+func (db *GasDB) CreateGasLedgerTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_gas_ledger_%s(
+		tx_hash TEXT NOT NULL,
+		user_op_hash TEXT NOT NULL,
+		paymaster TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		gas_used INTEGER NOT NULL,
+		gas_price TEXT NOT NULL,
+		cost TEXT NOT NULL,
+		day TEXT NOT NULL,
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		PRIMARY KEY (tx_hash, user_op_hash)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreateGasLedgerTableIndexes creates the indexes for the gas ledger in the given db
+func (db *GasDB) CreateGasLedgerTableIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// reports of a paymaster over a range of days
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_gas_ledger_%s_paymaster_day ON t_gas_ledger_%s (paymaster, day);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// spend of an account of a paymaster
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_gas_ledger_%s_paymaster_sender_day ON t_gas_ledger_%s (paymaster, sender, day);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// reports of all paymasters over a range of days
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_gas_ledger_%s_day ON t_gas_ledger_%s (day);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateGasTotalsTable creates a table to store what the bundles of each paymaster cost per day, in total and for each account.
+// The totals of all the accounts of a paymaster have an empty sender.
+func (db *GasDB) CreateGasTotalsTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_gas_totals_%s(
+		paymaster TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		day TEXT NOT NULL,
+		cost TEXT NOT NULL,
+		PRIMARY KEY (paymaster, sender, day)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// AddGasEntries adds the entries of a bundle to the ledger and their cost to the totals, entries that were already added are skipped
+func (db *GasDB) AddGasEntries(entries []*indexer.GasEntry) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range entries {
+		res, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO t_gas_ledger_%s(tx_hash, user_op_hash, paymaster, sender, gas_used, gas_price, cost, day, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT(tx_hash, user_op_hash) DO NOTHING
+		`, db.suffix), e.TxHash, e.UserOpHash, e.Paymaster, e.Sender, e.GasUsed, e.GasPrice.String(), e.Cost.String(), e.Day, e.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		for _, sender := range []string{"", e.Sender} {
+			err = db.addTotal(tx, e.Paymaster, sender, e.Day, e.Cost)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// addTotal adds a cost to the total of a paymaster and a sender on a day
+func (db *GasDB) addTotal(tx *sql.Tx, paymaster, sender, day string, cost *big.Int) error {
+	total := new(big.Int)
+
+	var current string
+	err := tx.QueryRow(fmt.Sprintf(`
+	SELECT cost FROM t_gas_totals_%s WHERE paymaster = $1 AND sender = $2 AND day = $3
+	`, db.suffix), paymaster, sender, day).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == nil {
+		total.SetString(current, 10)
+	}
+
+	total.Add(total, cost)
+
+	_, err = tx.Exec(fmt.Sprintf(`
+	INSERT INTO t_gas_totals_%s(paymaster, sender, day, cost)
+	VALUES($1, $2, $3, $4)
+	ON CONFLICT(paymaster, sender, day) DO UPDATE SET cost = excluded.cost
+	`, db.suffix), paymaster, sender, day, total.String())
+
+	return err
+}
+
+// GetGasSpend gets the gas that was spent between two days, both included, grouped by paymaster, account or day.
+// The entries can be limited to a paymaster and an account, an empty value includes all of them.
+func (db *GasDB) GetGasSpend(group indexer.GasGroup, paymaster, account, from, to string) ([]*indexer.GasSpend, error) {
+	var key string
+	switch group {
+	case indexer.GasGroupPaymaster:
+		key = "paymaster"
+	case indexer.GasGroupAccount:
+		key = "sender"
+	case indexer.GasGroupDay:
+		key = "day"
+	default:
+		return nil, fmt.Errorf("invalid group: %s", group)
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT %s, gas_used, cost
+	FROM t_gas_ledger_%s
+	WHERE day >= $1 AND day <= $2 AND ($3 = '' OR paymaster = $3) AND ($4 = '' OR sender = $4)
+	`, key, db.suffix), from, to, paymaster, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// costs are added up here since sqlite sums integers in 64 bits
+	spends := map[string]*indexer.GasSpend{}
+	costs := map[string]*big.Int{}
+	for rows.Next() {
+		var k, cost string
+		var gasUsed int64
+
+		err := rows.Scan(&k, &gasUsed, &cost)
+		if err != nil {
+			return nil, err
+		}
+
+		spend, ok := spends[k]
+		if !ok {
+			spend = &indexer.GasSpend{Key: k}
+			spends[k] = spend
+			costs[k] = new(big.Int)
+		}
+
+		spend.Ops++
+		spend.GasUsed += gasUsed
+
+		c, ok := new(big.Int).SetString(cost, 10)
+		if ok {
+			costs[k].Add(costs[k], c)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*indexer.GasSpend{}
+	for k, spend := range spends {
+		spend.Cost = costs[k].String()
+		result = append(result, spend)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result, nil
+}
+
+// GetSpendTotals gets what the bundles of a paymaster cost on a day and in its month, in total and for an account
+func (db *GasDB) GetSpendTotals(paymaster, account, day string) (*indexer.SpendTotals, error) {
+	// days are formatted as 2006-01-02, the month starts on the first
+	monthStart := day[:8] + "01"
+
+	// at most one row per day of the month for the paymaster and for the account
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT sender, day, cost
+	FROM t_gas_totals_%s
+	WHERE paymaster = $1 AND sender IN ('', $2) AND day >= $3 AND day <= $4
+	`, db.suffix), paymaster, account, monthStart, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := &indexer.SpendTotals{
+		Day:          new(big.Int),
+		Month:        new(big.Int),
+		AccountDay:   new(big.Int),
+		AccountMonth: new(big.Int),
+	}
+
+	for rows.Next() {
+		var sender, d, cost string
+
+		err := rows.Scan(&sender, &d, &cost)
+		if err != nil {
+			return nil, err
+		}
+
+		c, ok := new(big.Int).SetString(cost, 10)
+		if !ok {
+			continue
+		}
+
+		month, today := totals.Month, totals.Day
+		if sender != "" {
+			month, today = totals.AccountMonth, totals.AccountDay
+		}
+
+		month.Add(month, c)
+		if d == day {
+			today.Add(today, c)
+		}
+	}
+
+	return totals, rows.Err()
+}
@@ -0,0 +1,7 @@
+//go:build !sqlite_fts5
+
+package db
+
+// FTS5 is true when sqlite is built with the fts5 extension that searching transfer descriptions uses,
+// build with -tags sqlite_fts5 to include it
+const FTS5 = false
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+type PolicyDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// NewPolicyDB creates a new DB
+func NewPolicyDB(db, rdb *sql.DB, name string) (*PolicyDB, error) {
+	pdb := &PolicyDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return pdb, nil
+}
+
+// Close closes the db
+func (db *PolicyDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *PolicyDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreatePolicyTables creates the tables to store the sponsorship policies of the paymasters and what they sponsored each day
+func (db *PolicyDB) CreatePolicyTables() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_sponsor_policies_%s(
+		contract TEXT NOT NULL PRIMARY KEY,
+		policy TEXT NOT NULL,
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		updated_at timestamp NOT NULL DEFAULT current_timestamp
+	);
+	`, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_sponsor_usage_%s(
+		contract TEXT NOT NULL,
+		account TEXT NOT NULL,
+		day TEXT NOT NULL,
+		ops INTEGER NOT NULL DEFAULT 0,
+		gas INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (contract, account, day)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreatePolicyTablesIndexes creates the indexes for the usage of the paymasters in the given db
+func (db *PolicyDB) CreatePolicyTablesIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// sum up the usage of a paymaster in a day
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_sponsor_usage_%s_contract_day ON t_sponsor_usage_%s (contract, day);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetPolicy sets the policy of a paymaster, it replaces the previous one
+func (db *PolicyDB) SetPolicy(policy *indexer.Policy) error {
+	b, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	INSERT INTO t_sponsor_policies_%s(contract, policy, created_at, updated_at)
+	VALUES($1, $2, $3, $4)
+	ON CONFLICT(contract) DO UPDATE SET
+		policy = excluded.policy,
+		updated_at = excluded.updated_at
+	`, db.suffix), policy.Contract, string(b), policy.CreatedAt, policy.UpdatedAt)
+
+	return err
+}
+
+// GetPolicy gets the policy of a paymaster, sql.ErrNoRows is returned if it has none
+func (db *PolicyDB) GetPolicy(contract string) (*indexer.Policy, error) {
+	var b string
+	var createdAt, updatedAt string
+
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT policy, created_at, updated_at
+	FROM t_sponsor_policies_%s
+	WHERE contract = $1
+	`, db.suffix), contract).Scan(&b, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy indexer.Policy
+	err = json.Unmarshal([]byte(b), &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Contract = contract
+	policy.CreatedAt = createdAt
+	policy.UpdatedAt = updatedAt
+
+	return &policy, nil
+}
+
+// RemovePolicy removes the policy of a paymaster, sql.ErrNoRows is returned if it has none
+func (db *PolicyDB) RemovePolicy(contract string) error {
+	res, err := db.db.Exec(fmt.Sprintf(`
+	DELETE FROM t_sponsor_policies_%s
+	WHERE contract = $1
+	`, db.suffix), contract)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetUsage gets what a paymaster sponsored for an account and in total on a day
+func (db *PolicyDB) GetUsage(contract, account, day string) (*indexer.PolicyUsage, *indexer.PolicyUsage, error) {
+	var accountUsage, dailyUsage indexer.PolicyUsage
+
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT COALESCE(SUM(ops), 0), COALESCE(SUM(gas), 0),
+		COALESCE(SUM(CASE WHEN account = $1 THEN ops END), 0),
+		COALESCE(SUM(CASE WHEN account = $1 THEN gas END), 0)
+	FROM t_sponsor_usage_%s
+	WHERE contract = $2 AND day = $3
+	`, db.suffix), account, contract, day).Scan(&dailyUsage.Ops, &dailyUsage.Gas, &accountUsage.Ops, &accountUsage.Gas)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &accountUsage, &dailyUsage, nil
+}
+
+// AddUsage adds to what a paymaster sponsored for an account on a day
+func (db *PolicyDB) AddUsage(contract, account, day string, ops, gas int64) error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	INSERT INTO t_sponsor_usage_%s(contract, account, day, ops, gas)
+	VALUES($1, $2, $3, $4, $5)
+	ON CONFLICT(contract, account, day) DO UPDATE SET
+		ops = ops + excluded.ops,
+		gas = gas + excluded.gas
+	`, db.suffix), contract, account, day, ops, gas)
+
+	return err
+}
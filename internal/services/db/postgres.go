@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/citizenwallet/indexer/internal/common"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	_ "github.com/lib/pq"
 )
@@ -100,7 +101,7 @@ func NewPostgresDB(chainID *big.Int, username, password, name, host, rhost, secr
 
 		log.Default().Println("creating transfer db for: ", name)
 
-		txdb[name], err = NewTransferDB(db, db, name)
+		txdb[name], err = NewTransferDB(db, db, name, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -125,6 +126,12 @@ func NewPostgresDB(chainID *big.Int, username, password, name, host, rhost, secr
 			}
 		}
 
+		// descriptions are searched using a tsvector index
+		err = pdb.CreateTransferSearchIndex(name)
+		if err != nil {
+			return nil, err
+		}
+
 		log.Default().Println("creating push token db for: ", name)
 
 		ptdb[name], err = NewPushTokenDB(db, db, name)
@@ -231,6 +238,84 @@ func (db *PostgresDB) PushTokenTableExists(suffix string) (bool, error) {
 	return exists, nil
 }
 
+// CreateTransferSearchIndex creates a tsvector index over the descriptions of transfers
+// postgres maintains expression indexes on insert and update
+func (db *PostgresDB) CreateTransferSearchIndex(suffix string) error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_transfers_%s_description_search ON t_transfers_%s USING GIN (to_tsvector('simple', COALESCE(data->>'description', '')));
+	`, common.ShortenName(suffix, 6), suffix))
+
+	return err
+}
+
+// tsQuery builds a tsquery for the given terms, all terms need to match and the last one can be incomplete
+func tsQuery(terms []string) string {
+	return strings.Join(terms, " & ") + ":*"
+}
+
+// SearchTransfers returns the transfers whose description matches the query, best matches first
+// results can optionally be scoped to an account by providing addr
+func (db *PostgresDB) SearchTransfers(suffix string, tokenId int64, addr, query string, limit, offset int) ([]*indexer.TransferSearchResult, error) {
+	results := []*indexer.TransferSearchResult{}
+
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	q := &queryArgs{}
+
+	tsq := fmt.Sprintf("to_tsquery('simple', %s)", q.add(tsQuery(terms)))
+
+	conds := []string{
+		fmt.Sprintf("to_tsvector('simple', COALESCE(data->>'description', '')) @@ %s", tsq),
+		fmt.Sprintf("token_id = %s", q.add(tokenId)),
+	}
+
+	if addr != "" {
+		a := q.add(addr)
+		conds = append(conds, fmt.Sprintf("(from_addr = %s OR to_addr = %s)", a, a))
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status,
+			ts_rank(to_tsvector('simple', COALESCE(data->>'description', '')), %[2]s) AS rank,
+			ts_headline('simple', COALESCE(data->>'description', ''), %[2]s, 'StartSel=<b>, StopSel=</b>, MaxWords=16, MinWords=8')
+		FROM t_transfers_%[1]s
+		WHERE %[3]s
+		ORDER BY rank DESC, created_at DESC
+		LIMIT %[4]s OFFSET %[5]s
+		`, suffix, tsq, strings.Join(conds, " AND "), q.add(limit), q.add(offset)), q.args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return results, nil
+		}
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transfer indexer.Transfer
+		var value string
+		var result indexer.TransferSearchResult
+
+		err := rows.Scan(&transfer.Hash, &transfer.TxHash, &transfer.TokenID, &transfer.CreatedAt, &transfer.FromTo, &transfer.From, &transfer.To, &transfer.Nonce, &value, &transfer.Data, &transfer.Status, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer.Value = new(big.Int)
+		transfer.Value.SetString(value, 10)
+
+		result.Transfer = &transfer
+
+		results = append(results, &result)
+	}
+
+	return results, nil
+}
+
 // TableNameSuffix returns the name of the transfer db for the given contract
 func (d *PostgresDB) TableNameSuffix(contract string) (string, error) {
 	re := regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
@@ -286,7 +371,7 @@ func (d *PostgresDB) AddTransferDB(contract string) (*TransferDB, error) {
 	if txdb, ok := d.TransferDB[name]; ok {
 		return txdb, nil
 	}
-	txdb, err := NewTransferDB(d.db, d.rdb, name)
+	txdb, err := NewTransferDB(d.db, d.rdb, name, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -375,7 +460,7 @@ func (d *PostgresDB) Migrate(sqdb *DB, token, paymaster string, txBatchSize int)
 
 	txdb, exists := sqdb.GetTransferDB(token)
 	if !exists {
-		t, err := NewTransferDB(sqdb.db, sqdb.rdb, name)
+		t, err := NewTransferDB(sqdb.db, sqdb.rdb, name, sqdb.Broker)
 		if err != nil {
 			return err
 		}
@@ -390,6 +475,16 @@ func (d *PostgresDB) Migrate(sqdb *DB, token, paymaster string, txBatchSize int)
 			return err
 		}
 
+		err = t.CreateTransferSearchTable()
+		if err != nil {
+			return err
+		}
+
+		err = t.CreateBalanceCheckpointTable()
+		if err != nil {
+			return err
+		}
+
 		txdb = t
 	}
 
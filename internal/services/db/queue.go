@@ -0,0 +1,220 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type QueueDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// queuedUserOp is how a user operation message is stored while it waits in a queue
+type queuedUserOp struct {
+	Paymaster    ethcommon.Address         `json:"paymaster"`
+	EntryPoint   ethcommon.Address         `json:"entryPoint"`
+	Version      indexer.EntryPointVersion `json:"version,omitempty"`
+	ChainId      *hexutil.Big              `json:"chainId"`
+	UserOp       *indexer.UserOp           `json:"userOp"`
+	TransferData *indexer.TransferData     `json:"transferData,omitempty"`
+}
+
+// NewQueueDB creates a new DB
+func NewQueueDB(db, rdb *sql.DB, name string) (*QueueDB, error) {
+	qdb := &QueueDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return qdb, nil
+}
+
+// Close closes the db
+func (db *QueueDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *QueueDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreateQueueTable creates a table to store the messages of the queues until they are processed
+func (db *QueueDB) CreateQueueTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_queue_%s(
+		id TEXT NOT NULL,
+		queue TEXT NOT NULL,
+		message TEXT NOT NULL,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		leased_until timestamp,
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		PRIMARY KEY (queue, id)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreateQueueTableIndexes creates the indexes for queued messages in the given db
+func (db *QueueDB) CreateQueueTableIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// fetch the messages of a queue in order
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_queue_%s_queue_created_at ON t_queue_%s (queue, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Save stores a message of a queue, a message that already exists keeps its place in the queue
+func (db *QueueDB) Save(queue string, m indexer.Message) error {
+	txm, ok := m.Message.(indexer.UserOpMessage)
+	if !ok {
+		return fmt.Errorf("cannot store message %s of type %T", m.ID, m.Message)
+	}
+
+	q := &queuedUserOp{
+		Paymaster:  txm.Paymaster,
+		EntryPoint: txm.EntryPoint,
+		Version:    txm.Version,
+		ChainId:    (*hexutil.Big)(txm.ChainId),
+		UserOp:     &txm.UserOp,
+	}
+
+	if txdata, ok := txm.ExtraData.(*indexer.TransferData); ok {
+		q.TransferData = txdata
+	}
+
+	b, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	INSERT INTO t_queue_%s (id, queue, message, retry_count, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT(queue, id) DO UPDATE SET
+		message = excluded.message,
+		retry_count = excluded.retry_count,
+		leased_until = NULL
+	`, db.suffix), m.ID, queue, string(b), m.RetryCount, m.CreatedAt.UTC())
+
+	return err
+}
+
+// Lease marks the messages as being processed until the given time, after that they can be taken again
+func (db *QueueDB) Lease(queue string, ids []string, until time.Time) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err := tx.Exec(fmt.Sprintf(`
+		UPDATE t_queue_%s SET leased_until = $1 WHERE queue = $2 AND id = $3
+		`, db.suffix), until.UTC(), queue, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Release puts a message that will be retried back in the queue with its new retry count
+func (db *QueueDB) Release(queue, id string, retryCount int) error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	UPDATE t_queue_%s SET retry_count = $1, leased_until = NULL WHERE queue = $2 AND id = $3
+	`, db.suffix), retryCount, queue, id)
+
+	return err
+}
+
+// Done removes a message that was processed or dropped from the queue
+func (db *QueueDB) Done(queue, id string) error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	DELETE FROM t_queue_%s WHERE queue = $1 AND id = $2
+	`, db.suffix), queue, id)
+
+	return err
+}
+
+// Pending returns the messages of a queue that are not leased or whose lease expired before the given time,
+// in the order they were queued
+func (db *QueueDB) Pending(queue string, expired time.Time) ([]indexer.Message, error) {
+	return db.queued(queue, "leased_until IS NULL OR leased_until <= $2", expired)
+}
+
+// Expired returns the leased messages of a queue whose lease expired before the given time,
+// these were taken by a consumer that did not finish processing them
+func (db *QueueDB) Expired(queue string, expired time.Time) ([]indexer.Message, error) {
+	return db.queued(queue, "leased_until IS NOT NULL AND leased_until <= $2", expired)
+}
+
+// queued returns the messages of a queue that match the condition
+func (db *QueueDB) queued(queue, cond string, expired time.Time) ([]indexer.Message, error) {
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT id, message, retry_count, created_at
+	FROM t_queue_%s
+	WHERE queue = $1 AND (%s)
+	ORDER BY created_at ASC
+	`, db.suffix, cond), queue, expired.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []indexer.Message{}
+	for rows.Next() {
+		var m indexer.Message
+		var message string
+
+		err := rows.Scan(&m.ID, &message, &m.RetryCount, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		var q queuedUserOp
+		err = json.Unmarshal([]byte(message), &q)
+		if err != nil {
+			return nil, err
+		}
+
+		txm := indexer.UserOpMessage{
+			Paymaster:  q.Paymaster,
+			EntryPoint: q.EntryPoint,
+			Version:    q.Version,
+			ChainId:    (*big.Int)(q.ChainId),
+		}
+
+		if q.UserOp != nil {
+			txm.UserOp = *q.UserOp
+		}
+
+		if q.TransferData != nil {
+			txm.ExtraData = q.TransferData
+		}
+
+		m.Message = txm
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, rows.Err()
+}
@@ -0,0 +1,256 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+type ScheduleDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// NewScheduleDB creates a new DB
+func NewScheduleDB(db, rdb *sql.DB, name string) (*ScheduleDB, error) {
+	sdb := &ScheduleDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return sdb, nil
+}
+
+// Close closes the db
+func (db *ScheduleDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *ScheduleDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreateScheduledUserOpsTable creates a table to store the user operations that are submitted once they are due.
+// The balance condition and the extra data are stored as json, empty if there are none.
+func (db *ScheduleDB) CreateScheduledUserOpsTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_scheduled_userops_%s(
+		hash TEXT NOT NULL PRIMARY KEY,
+		paymaster TEXT NOT NULL,
+		entry_point TEXT NOT NULL,
+		version TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		userop TEXT NOT NULL,
+		extra_data TEXT NOT NULL DEFAULT '',
+		not_before INTEGER NOT NULL,
+		balance TEXT NOT NULL DEFAULT '',
+		valid_until INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		updated_at timestamp NOT NULL DEFAULT current_timestamp
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreateScheduledUserOpsTableIndexes creates the indexes for the scheduled user operations in the given db
+func (db *ScheduleDB) CreateScheduledUserOpsTableIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// fetch the ops that are due
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_scheduled_userops_%s_status_updated_at ON t_scheduled_userops_%s (status, updated_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// list the ops of an account
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_scheduled_userops_%s_paymaster_sender ON t_scheduled_userops_%s (paymaster, sender, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddScheduledUserOp adds a scheduled user operation, an op with the same hash cannot be added twice
+func (db *ScheduleDB) AddScheduledUserOp(s *indexer.ScheduledUserOp) error {
+	now := time.Now().UTC()
+
+	op, err := json.Marshal(&s.UserOp)
+	if err != nil {
+		return err
+	}
+
+	extraData := ""
+	if s.ExtraData != nil {
+		b, err := json.Marshal(s.ExtraData)
+		if err != nil {
+			return err
+		}
+
+		extraData = string(b)
+	}
+
+	balance := ""
+	if s.Balance != nil {
+		b, err := json.Marshal(s.Balance)
+		if err != nil {
+			return err
+		}
+
+		balance = string(b)
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	INSERT INTO t_scheduled_userops_%s(hash, paymaster, entry_point, version, sender, userop, extra_data, not_before, balance, valid_until, status, created_at, updated_at)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, db.suffix), s.Hash, s.Paymaster, s.EntryPoint, s.Version, s.UserOp.Sender.Hex(), string(op), extraData, s.NotBefore, balance, s.ValidUntil, indexer.ScheduleStatusScheduled, now, now)
+
+	return err
+}
+
+// GetScheduledUserOp gets the scheduled user operation with the given hash, sql.ErrNoRows is returned if there is none
+func (db *ScheduleDB) GetScheduledUserOp(hash string) (*indexer.ScheduledUserOp, error) {
+	row := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT hash, paymaster, entry_point, version, userop, extra_data, not_before, balance, valid_until, status, error, created_at, updated_at
+	FROM t_scheduled_userops_%s
+	WHERE hash = $1
+	`, db.suffix), hash)
+
+	return scanScheduledUserOp(row)
+}
+
+// GetDueScheduledUserOps gets the scheduled user operations whose time has come, the ones that were checked the longest ago first
+func (db *ScheduleDB) GetDueScheduledUserOps(now int64, limit int) ([]*indexer.ScheduledUserOp, error) {
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT hash, paymaster, entry_point, version, userop, extra_data, not_before, balance, valid_until, status, error, created_at, updated_at
+	FROM t_scheduled_userops_%s
+	WHERE status = $1 AND not_before <= $2
+	ORDER BY updated_at ASC
+	LIMIT $3
+	`, db.suffix), indexer.ScheduleStatusScheduled, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []*indexer.ScheduledUserOp{}
+	for rows.Next() {
+		op, err := scanScheduledUserOp(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, rows.Err()
+}
+
+// GetScheduledUserOps gets the scheduled user operations of an account for a paymaster, newest first, and how many there are in total.
+// The ops can be limited to a status, an empty status includes all of them.
+func (db *ScheduleDB) GetScheduledUserOps(paymaster, sender string, status indexer.ScheduleStatus, limit, offset int) ([]*indexer.ScheduledUserOp, int, error) {
+	var total int
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT COUNT(*)
+	FROM t_scheduled_userops_%s
+	WHERE paymaster = $1 AND sender = $2 AND ($3 = '' OR status = $3)
+	`, db.suffix), paymaster, sender, status).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT hash, paymaster, entry_point, version, userop, extra_data, not_before, balance, valid_until, status, error, created_at, updated_at
+	FROM t_scheduled_userops_%s
+	WHERE paymaster = $1 AND sender = $2 AND ($3 = '' OR status = $3)
+	ORDER BY created_at DESC
+	LIMIT $4 OFFSET $5
+	`, db.suffix), paymaster, sender, status, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	ops := []*indexer.ScheduledUserOp{}
+	for rows.Next() {
+		op, err := scanScheduledUserOp(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, total, rows.Err()
+}
+
+// SetScheduledUserOpStatus changes the status of a scheduled user operation if it still has the expected status,
+// false is returned if it did not
+func (db *ScheduleDB) SetScheduledUserOpStatus(hash string, from, to indexer.ScheduleStatus, reason string) (bool, error) {
+	res, err := db.db.Exec(fmt.Sprintf(`
+	UPDATE t_scheduled_userops_%s SET
+		status = $1,
+		error = $2,
+		updated_at = $3
+	WHERE hash = $4 AND status = $5
+	`, db.suffix), to, reason, time.Now().UTC(), hash, from)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+type scheduledUserOpScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanScheduledUserOp scans a row of the scheduled user operations
+func scanScheduledUserOp(row scheduledUserOpScanner) (*indexer.ScheduledUserOp, error) {
+	var s indexer.ScheduledUserOp
+	var op, extraData, balance string
+
+	err := row.Scan(&s.Hash, &s.Paymaster, &s.EntryPoint, &s.Version, &op, &extraData, &s.NotBefore, &balance, &s.ValidUntil, &s.Status, &s.Error, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(op), &s.UserOp)
+	if err != nil {
+		return nil, err
+	}
+
+	if extraData != "" {
+		err = json.Unmarshal([]byte(extraData), &s.ExtraData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if balance != "" {
+		err = json.Unmarshal([]byte(balance), &s.Balance)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
@@ -121,3 +121,96 @@ func (db *SponsorDB) UpdateSponsor(sponsor *indexer.Sponsor) error {
 
 	return nil
 }
+
+// CreateSponsorKeysTable creates a table to store the keys that submit the bundles of the paymasters
+func (db *SponsorDB) CreateSponsorKeysTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_sponsor_keys_%s(
+		contract TEXT NOT NULL,
+		address TEXT NOT NULL,
+		pk text NOT NULL,
+		retired INTEGER NOT NULL DEFAULT 0,
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		updated_at timestamp NOT NULL DEFAULT current_timestamp,
+		PRIMARY KEY (contract, address)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// AddSponsorKey adds a key that submits the bundles of a paymaster
+func (db *SponsorDB) AddSponsorKey(key *indexer.SponsorKey) error {
+	encrypted, err := common.Encrypt(key.PrivateKey, db.secret)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	INSERT INTO t_sponsor_keys_%s(contract, address, pk, retired, created_at, updated_at)
+	VALUES($1, $2, $3, $4, $5, $6)
+	`, db.suffix), key.Contract, key.Address, encrypted, key.Retired, key.CreatedAt, key.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RetireSponsorKey stops a key from submitting the bundles of a paymaster, it is kept so that its funds can be recovered
+func (db *SponsorDB) RetireSponsorKey(contract, address, updatedAt string) error {
+	res, err := db.db.Exec(fmt.Sprintf(`
+	UPDATE t_sponsor_keys_%s
+	SET retired = 1, updated_at = $1
+	WHERE contract = $2 AND address = $3
+	`, db.suffix), updatedAt, contract, address)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetSponsorKeys gets the keys of a paymaster ordered by address, retired keys are only included if retired is true
+func (db *SponsorDB) GetSponsorKeys(contract string, retired bool) ([]*indexer.SponsorKey, error) {
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT contract, address, pk, retired, created_at, updated_at
+	FROM t_sponsor_keys_%s
+	WHERE contract = $1 AND (retired = 0 OR $2)
+	ORDER BY address
+	`, db.suffix), contract, retired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*indexer.SponsorKey{}
+	for rows.Next() {
+		var key indexer.SponsorKey
+
+		err := rows.Scan(&key.Contract, &key.Address, &key.PrivateKey, &key.Retired, &key.CreatedAt, &key.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		decrypted, err := common.Decrypt(key.PrivateKey, db.secret)
+		if err != nil {
+			return nil, err
+		}
+
+		key.PrivateKey = decrypted
+
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+type SponsorshipDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// NewSponsorshipDB creates a new DB
+func NewSponsorshipDB(db, rdb *sql.DB, name string) (*SponsorshipDB, error) {
+	sdb := &SponsorshipDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return sdb, nil
+}
+
+// Close closes the db
+func (db *SponsorshipDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *SponsorshipDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreateSponsorshipsTable creates a table to store the out of order sponsorships that the paymasters issued
+func (db *SponsorshipDB) CreateSponsorshipsTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_oo_sponsorships_%s(
+		contract TEXT NOT NULL,
+		account TEXT NOT NULL,
+		nonce_key TEXT NOT NULL,
+		batch TEXT NOT NULL,
+		entry_point TEXT NOT NULL,
+		intended_use TEXT NOT NULL DEFAULT '',
+		valid_after INTEGER NOT NULL,
+		valid_until INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		user_op_hash TEXT NOT NULL DEFAULT '',
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		updated_at timestamp NOT NULL DEFAULT current_timestamp,
+		PRIMARY KEY (contract, account, nonce_key)
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreateSponsorshipsTableIndexes creates the indexes for the sponsorships in the given db
+func (db *SponsorshipDB) CreateSponsorshipsTableIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// revoke the sponsorships of a batch
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_oo_sponsorships_%s_contract_batch ON t_oo_sponsorships_%s (contract, batch);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// list the sponsorships of a paymaster by status
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_oo_sponsorships_%s_contract_status ON t_oo_sponsorships_%s (contract, status, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddSponsorships adds the sponsorships of a batch, either all of them are added or none
+func (db *SponsorshipDB) AddSponsorships(sponsorships []*indexer.Sponsorship) error {
+	now := time.Now().UTC()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range sponsorships {
+		_, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO t_oo_sponsorships_%s(contract, account, nonce_key, batch, entry_point, intended_use, valid_after, valid_until, status, created_at, updated_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, db.suffix), s.Contract, s.Account, s.NonceKey, s.Batch, s.EntryPoint, s.Use, s.ValidAfter, s.ValidUntil, indexer.SponsorshipStatusOutstanding, now, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSponsorship gets the sponsorship of a paymaster for an account and a nonce key, sql.ErrNoRows is returned if there is none
+func (db *SponsorshipDB) GetSponsorship(contract, account, nonceKey string) (*indexer.Sponsorship, error) {
+	var s indexer.Sponsorship
+
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT contract, batch, account, nonce_key, entry_point, intended_use, valid_after, valid_until, status, user_op_hash, created_at, updated_at
+	FROM t_oo_sponsorships_%s
+	WHERE contract = $1 AND account = $2 AND nonce_key = $3
+	`, db.suffix), contract, account, nonceKey).Scan(&s.Contract, &s.Batch, &s.Account, &s.NonceKey, &s.EntryPoint, &s.Use, &s.ValidAfter, &s.ValidUntil, &s.Status, &s.UserOpHash, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Status = sponsorshipStatus(s.Status, s.ValidUntil, time.Now().Unix())
+
+	return &s, nil
+}
+
+// GetSponsorships gets the sponsorships of a paymaster that match the filter, newest first, and how many match in total
+func (db *SponsorshipDB) GetSponsorships(contract string, f indexer.SponsorshipFilter, limit, offset int) ([]*indexer.Sponsorship, int, error) {
+	now := time.Now().Unix()
+
+	// an outstanding sponsorship whose validity has passed is expired
+	where := `
+	WHERE contract = $1 AND ($2 = '' OR batch = $2) AND ($3 = '' OR account = $3) AND ($4 = '' OR nonce_key = $4)
+	AND ($5 = '' OR (CASE WHEN status = 'outstanding' AND valid_until <= $6 THEN 'expired' ELSE status END) = $5)
+	`
+
+	var total int
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT COUNT(*)
+	FROM t_oo_sponsorships_%s
+	%s
+	`, db.suffix, where), contract, f.Batch, f.Account, f.NonceKey, f.Status, now).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT contract, batch, account, nonce_key, entry_point, intended_use, valid_after, valid_until, status, user_op_hash, created_at, updated_at
+	FROM t_oo_sponsorships_%s
+	%s
+	ORDER BY created_at DESC, nonce_key
+	LIMIT $7 OFFSET $8
+	`, db.suffix, where), contract, f.Batch, f.Account, f.NonceKey, f.Status, now, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sponsorships := []*indexer.Sponsorship{}
+	for rows.Next() {
+		var s indexer.Sponsorship
+
+		err := rows.Scan(&s.Contract, &s.Batch, &s.Account, &s.NonceKey, &s.EntryPoint, &s.Use, &s.ValidAfter, &s.ValidUntil, &s.Status, &s.UserOpHash, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		s.Status = sponsorshipStatus(s.Status, s.ValidUntil, now)
+
+		sponsorships = append(sponsorships, &s)
+	}
+
+	return sponsorships, total, rows.Err()
+}
+
+// RevokeSponsorships revokes the sponsorships of a paymaster that match the filter, the status of the filter is ignored.
+// Outstanding sponsorships and the used ones whose op was not sent yet are revoked, the number of revoked sponsorships is returned.
+func (db *SponsorshipDB) RevokeSponsorships(contract string, f indexer.SponsorshipFilter) (int64, error) {
+	res, err := db.db.Exec(fmt.Sprintf(`
+	UPDATE t_oo_sponsorships_%s SET
+		status = $1,
+		updated_at = $2
+	WHERE contract = $3 AND ($4 = '' OR batch = $4) AND ($5 = '' OR account = $5) AND ($6 = '' OR nonce_key = $6) AND %s
+	`, db.suffix, db.changeableCond()), indexer.SponsorshipStatusRevoked, time.Now().UTC(), contract, f.Batch, f.Account, f.NonceKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ExpireSponsorships ends the validity of the sponsorships of a paymaster that match the filter now, the status of the filter is ignored.
+// Outstanding sponsorships and the used ones whose op was not sent yet are expired, the number of expired sponsorships is returned.
+func (db *SponsorshipDB) ExpireSponsorships(contract string, f indexer.SponsorshipFilter) (int64, error) {
+	now := time.Now()
+
+	res, err := db.db.Exec(fmt.Sprintf(`
+	UPDATE t_oo_sponsorships_%s SET
+		status = $1,
+		valid_until = min(valid_until, $2),
+		updated_at = $3
+	WHERE contract = $4 AND ($5 = '' OR batch = $5) AND ($6 = '' OR account = $6) AND ($7 = '' OR nonce_key = $7) AND %s
+	`, db.suffix, db.changeableCond()), indexer.SponsorshipStatusExpired, now.Unix(), now.UTC(), contract, f.Batch, f.Account, f.NonceKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// changeableCond matches the sponsorships that can still be revoked or expired, the bundler rejects a queued op whose sponsorship changed
+func (db *SponsorshipDB) changeableCond() string {
+	return fmt.Sprintf(`(status = 'outstanding' OR (status = 'used' AND user_op_hash IN (SELECT hash FROM t_userops_%s WHERE tx_hash = '')))`, db.suffix)
+}
+
+// SetSponsorshipUsed marks an outstanding sponsorship as used by the op with the given hash
+func (db *SponsorshipDB) SetSponsorshipUsed(contract, account, nonceKey, userOpHash string) error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	UPDATE t_oo_sponsorships_%s SET
+		status = $1,
+		user_op_hash = $2,
+		updated_at = $3
+	WHERE contract = $4 AND account = $5 AND nonce_key = $6 AND status = 'outstanding'
+	`, db.suffix), indexer.SponsorshipStatusUsed, userOpHash, time.Now().UTC(), contract, account, nonceKey)
+
+	return err
+}
+
+// sponsorshipStatus returns the status of a sponsorship at a time, an outstanding sponsorship whose validity has passed is expired
+func sponsorshipStatus(status indexer.SponsorshipStatus, validUntil, now int64) indexer.SponsorshipStatus {
+	if status == indexer.SponsorshipStatusOutstanding && validUntil <= now {
+		return indexer.SponsorshipStatusExpired
+	}
+
+	return status
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+const testSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI="
+
+const (
+	testPaymaster = "0x0000000000000000000000000000000000000001"
+	testAlice     = "0x0000000000000000000000000000000000000002"
+	testBob       = "0x0000000000000000000000000000000000000003"
+)
+
+func newTestDB(t *testing.T) *DB {
+	d, err := NewDB(big.NewInt(1337), t.TempDir(), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		d.Close()
+	})
+
+	return d
+}
+
+// addTestSponsorships adds the sponsorships of two batches: batch 0x01 for alice with nonce keys 0x1 and 0x2, batch 0x02 for bob with 0x3
+func addTestSponsorships(t *testing.T, d *DB) {
+	validUntil := time.Now().Add(time.Hour).Unix()
+
+	sponsorship := func(batch, account, nonceKey string) *indexer.Sponsorship {
+		return &indexer.Sponsorship{
+			Contract:   testPaymaster,
+			Batch:      batch,
+			Account:    account,
+			NonceKey:   nonceKey,
+			EntryPoint: indexer.EntryPointV06Address.Hex(),
+			ValidUntil: validUntil,
+		}
+	}
+
+	err := d.SponsorshipDB.AddSponsorships([]*indexer.Sponsorship{
+		sponsorship("0x01", testAlice, "0x1"),
+		sponsorship("0x01", testAlice, "0x2"),
+		sponsorship("0x02", testBob, "0x3"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddSponsorships(t *testing.T) {
+	d := newTestDB(t)
+	addTestSponsorships(t, d)
+
+	sp, err := d.SponsorshipDB.GetSponsorship(testPaymaster, testAlice, "0x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sp.Batch != "0x01" || sp.Status != indexer.SponsorshipStatusOutstanding {
+		t.Errorf("got batch %s with status %s, want an outstanding sponsorship of batch 0x01", sp.Batch, sp.Status)
+	}
+
+	cases := []struct {
+		name   string
+		filter indexer.SponsorshipFilter
+		total  int
+	}{
+		{"all", indexer.SponsorshipFilter{}, 3},
+		{"batch", indexer.SponsorshipFilter{Batch: "0x01"}, 2},
+		{"account", indexer.SponsorshipFilter{Account: testBob}, 1},
+		{"nonce key", indexer.SponsorshipFilter{NonceKey: "0x2"}, 1},
+		{"status", indexer.SponsorshipFilter{Status: indexer.SponsorshipStatusUsed}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sponsorships, total, err := d.SponsorshipDB.GetSponsorships(testPaymaster, c.filter, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if total != c.total || len(sponsorships) != c.total {
+				t.Errorf("got %d sponsorships and a total of %d, want %d", len(sponsorships), total, c.total)
+			}
+		})
+	}
+}
+
+func TestUpdateSponsorships(t *testing.T) {
+	cases := []struct {
+		name    string
+		filter  indexer.SponsorshipFilter
+		updated []string // the nonce keys that are updated
+	}{
+		{"batch", indexer.SponsorshipFilter{Batch: "0x01"}, []string{"0x1", "0x2"}},
+		{"account", indexer.SponsorshipFilter{Account: testBob}, []string{"0x3"}},
+		{"nonce key", indexer.SponsorshipFilter{NonceKey: "0x1"}, []string{"0x1"}},
+		{"batch and account", indexer.SponsorshipFilter{Batch: "0x01", Account: testBob}, nil},
+	}
+
+	accounts := map[string]string{"0x1": testAlice, "0x2": testAlice, "0x3": testBob}
+
+	for _, update := range []struct {
+		name   string
+		status indexer.SponsorshipStatus
+		apply  func(d *DB) func(string, indexer.SponsorshipFilter) (int64, error)
+	}{
+		{"revoke", indexer.SponsorshipStatusRevoked, func(d *DB) func(string, indexer.SponsorshipFilter) (int64, error) {
+			return d.SponsorshipDB.RevokeSponsorships
+		}},
+		{"expire", indexer.SponsorshipStatusExpired, func(d *DB) func(string, indexer.SponsorshipFilter) (int64, error) {
+			return d.SponsorshipDB.ExpireSponsorships
+		}},
+	} {
+		for _, c := range cases {
+			t.Run(update.name+" "+c.name, func(t *testing.T) {
+				d := newTestDB(t)
+				addTestSponsorships(t, d)
+
+				n, err := update.apply(d)(testPaymaster, c.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if n != int64(len(c.updated)) {
+					t.Errorf("updated %d sponsorships, want %d", n, len(c.updated))
+				}
+
+				changed := map[string]bool{}
+				for _, key := range c.updated {
+					changed[key] = true
+				}
+
+				for key, account := range accounts {
+					sp, err := d.SponsorshipDB.GetSponsorship(testPaymaster, account, key)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					expected := indexer.SponsorshipStatusOutstanding
+					if changed[key] {
+						expected = update.status
+					}
+
+					if sp.Status != expected {
+						t.Errorf("sponsorship %s is %s, want %s", key, sp.Status, expected)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRevokeUsedSponsorships(t *testing.T) {
+	d := newTestDB(t)
+	addTestSponsorships(t, d)
+
+	op := indexer.UserOp{
+		Nonce:                big.NewInt(0),
+		CallGasLimit:         big.NewInt(0),
+		VerificationGasLimit: big.NewInt(0),
+		PreVerificationGas:   big.NewInt(0),
+		MaxFeePerGas:         big.NewInt(0),
+		MaxPriorityFeePerGas: big.NewInt(0),
+	}
+
+	// the op of 0x1 is still queued, the op of 0x2 was sent
+	for _, r := range []*indexer.UserOpRecord{
+		{Hash: "0xa1", Status: indexer.UserOpStatusQueued},
+		{Hash: "0xa2", TxHash: "0xb2", Status: indexer.UserOpStatusSubmitted},
+	} {
+		r.EntryPoint = indexer.EntryPointV06Address.Hex()
+		r.Paymaster = testPaymaster
+		r.UserOp = op
+
+		_, err := d.UserOpDB.SetUserOp(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for key, hash := range map[string]string{"0x1": "0xa1", "0x2": "0xa2"} {
+		err := d.SponsorshipDB.SetSponsorshipUsed(testPaymaster, testAlice, key, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := d.SponsorshipDB.RevokeSponsorships(testPaymaster, indexer.SponsorshipFilter{Account: testAlice})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("revoked %d sponsorships, want 1", n)
+	}
+
+	for key, expected := range map[string]indexer.SponsorshipStatus{"0x1": indexer.SponsorshipStatusRevoked, "0x2": indexer.SponsorshipStatusUsed} {
+		sp, err := d.SponsorshipDB.GetSponsorship(testPaymaster, testAlice, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sp.Status != expected {
+			t.Errorf("sponsorship %s is %s, want %s", key, sp.Status, expected)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+var statsPeriods = []indexer.StatsPeriod{indexer.StatsPeriodDay, indexer.StatsPeriodHour}
+
+type StatsDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// NewStatsDB creates a new DB
+func NewStatsDB(db, rdb *sql.DB, name string) (*StatsDB, error) {
+	sdb := &StatsDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return sdb, nil
+}
+
+// Close closes the db
+func (db *StatsDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *StatsDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreateStatsTables creates the tables to store aggregated stats in the given db
+// t_stats_accounts keeps track of when an address first and last received or sent the token,
+// t_stats_transfers of the transfers that were counted
+func (db *StatsDB) CreateStatsTables() error {
+	for _, period := range statsPeriods {
+		_, err := db.db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS t_stats_%s_%s(
+			token_id integer NOT NULL,
+			start timestamp NOT NULL,
+			transfer_count integer NOT NULL DEFAULT 0,
+			volume text NOT NULL DEFAULT '0',
+			unique_senders integer NOT NULL DEFAULT 0,
+			unique_receivers integer NOT NULL DEFAULT 0,
+			new_accounts integer NOT NULL DEFAULT 0,
+			mint_count integer NOT NULL DEFAULT 0,
+			mint_volume text NOT NULL DEFAULT '0',
+			burn_count integer NOT NULL DEFAULT 0,
+			burn_volume text NOT NULL DEFAULT '0',
+			updated_at timestamp NOT NULL DEFAULT current_timestamp,
+			PRIMARY KEY (token_id, start)
+		);
+		`, period, db.suffix))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_stats_accounts_%s(
+		token_id integer NOT NULL,
+		address text NOT NULL,
+		first_seen timestamp NOT NULL,
+		last_sent timestamp,
+		last_received timestamp,
+		PRIMARY KEY (token_id, address)
+	);
+	`, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_stats_transfers_%s(
+		hash text NOT NULL PRIMARY KEY
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreateStatsTablesIndexes creates the indexes for stats in the given db
+func (db *StatsDB) CreateStatsTablesIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// counting new accounts
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_stats_accounts_%s_token_id_first_seen ON t_stats_accounts_%s (token_id, first_seen);
+	`, suffix, db.suffix))
+
+	return err
+}
+
+// statsBucket is a bucket of the stats of a token
+type statsBucket struct {
+	tokenId int64
+	period  indexer.StatsPeriod
+	start   time.Time
+}
+
+// UpdateStats adds the given transfers to the stats of the buckets that contain them, only successful transfers are counted
+// and a transfer is only counted once. The buckets are updated with the difference that the transfers make, except for the
+// buckets where a transfer arrives that is older than the last one of its sender or receiver: whether they were already counted
+// in the bucket is not known, those buckets are recomputed from the transfers.
+func (db *StatsDB) UpdateStats(txs []*indexer.Transfer) error {
+	deltas := map[statsBucket]*indexer.Stats{}
+	refresh := map[statsBucket]bool{}
+
+	delta := func(tokenId int64, period indexer.StatsPeriod, t time.Time) *indexer.Stats {
+		b := statsBucket{tokenId, period, period.Start(t)}
+
+		s, ok := deltas[b]
+		if !ok {
+			s = indexer.NewStats(tokenId, period, b.start)
+			deltas[b] = s
+		}
+
+		return s
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range txs {
+		if t.Status != indexer.TransferStatusSuccess {
+			continue
+		}
+
+		// a transfer that is indexed again was already counted
+		res, err := tx.Exec(fmt.Sprintf(`
+		INSERT OR IGNORE INTO t_stats_transfers_%s (hash) VALUES ($1)
+		`, db.suffix), t.Hash)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		value := t.Value
+		if value == nil {
+			value = big.NewInt(0)
+		}
+
+		plain := t.From != common.ZeroAddress && t.To != common.ZeroAddress
+
+		for _, period := range statsPeriods {
+			s := delta(t.TokenID, period, t.CreatedAt)
+
+			switch {
+			case t.From == common.ZeroAddress:
+				s.MintCount++
+				s.MintVolume.Add(s.MintVolume, value)
+			case t.To == common.ZeroAddress:
+				s.BurnCount++
+				s.BurnVolume.Add(s.BurnVolume, value)
+			default:
+				s.TransferCount++
+				s.Volume.Add(s.Volume, value)
+			}
+		}
+
+		for _, role := range []string{"sent", "received"} {
+			addr := t.From
+			if role == "received" {
+				addr = t.To
+			}
+
+			if addr == common.ZeroAddress {
+				continue
+			}
+
+			err := db.countAccount(tx, t, addr, role, plain, delta, refresh)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for b, s := range deltas {
+		if refresh[b] {
+			continue
+		}
+
+		err = db.addToBucket(tx, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	for b := range refresh {
+		err = db.refreshBucket(tx, b.tokenId, b.period, b.start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// countAccount counts the sender or the receiver of a transfer as a new account and as a unique sender or receiver of the buckets of the transfer,
+// only the senders and receivers of transfers that are not mints or burns are unique senders and receivers
+func (db *StatsDB) countAccount(tx *sql.Tx, t *indexer.Transfer, addr, role string, plain bool, delta func(int64, indexer.StatsPeriod, time.Time) *indexer.Stats, refresh map[statsBucket]bool) error {
+	var firstSeen time.Time
+	var last sql.NullTime
+
+	err := tx.QueryRow(fmt.Sprintf(`
+	SELECT first_seen, last_%s FROM t_stats_accounts_%s WHERE token_id = $1 AND address = $2
+	`, role, db.suffix), t.TokenID, addr).Scan(&firstSeen, &last)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	known := err == nil
+
+	switch {
+	case !known:
+		for _, period := range statsPeriods {
+			delta(t.TokenID, period, t.CreatedAt).NewAccounts++
+		}
+
+		firstSeen = t.CreatedAt
+	case t.CreatedAt.Before(firstSeen):
+		// an account that is seen earlier than before moves to another bucket
+		for _, period := range statsPeriods {
+			delta(t.TokenID, period, firstSeen).NewAccounts--
+			delta(t.TokenID, period, t.CreatedAt).NewAccounts++
+		}
+
+		firstSeen = t.CreatedAt
+	}
+
+	if plain {
+		for _, period := range statsPeriods {
+			start := period.Start(t.CreatedAt)
+
+			switch {
+			case !last.Valid || last.Time.Before(start):
+				// the account did not send or receive in the bucket yet
+				s := delta(t.TokenID, period, t.CreatedAt)
+				if role == "sent" {
+					s.UniqueSenders++
+				} else {
+					s.UniqueReceivers++
+				}
+			case t.CreatedAt.Before(last.Time):
+				refresh[statsBucket{t.TokenID, period, start}] = true
+			}
+		}
+
+		if !last.Valid || last.Time.Before(t.CreatedAt) {
+			last = sql.NullTime{Time: t.CreatedAt.UTC(), Valid: true}
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`
+	INSERT INTO t_stats_accounts_%[1]s (token_id, address, first_seen, last_%[2]s)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (token_id, address) DO UPDATE SET first_seen = excluded.first_seen, last_%[2]s = excluded.last_%[2]s
+	`, db.suffix, role), t.TokenID, addr, firstSeen.UTC(), last)
+
+	return err
+}
+
+// addToBucket adds the difference that transfers make to the stats of a bucket, a bucket that ends up empty is not stored
+func (db *StatsDB) addToBucket(tx *sql.Tx, d *indexer.Stats) error {
+	s := indexer.NewStats(d.TokenID, d.Period, d.Start)
+
+	var volume, mintVolume, burnVolume string
+
+	err := tx.QueryRow(fmt.Sprintf(`
+	SELECT transfer_count, volume, unique_senders, unique_receivers, new_accounts, mint_count, mint_volume, burn_count, burn_volume
+	FROM t_stats_%s_%s
+	WHERE token_id = $1 AND start = $2
+	`, d.Period, db.suffix), d.TokenID, d.Start).Scan(&s.TransferCount, &volume, &s.UniqueSenders, &s.UniqueReceivers, &s.NewAccounts, &s.MintCount, &mintVolume, &s.BurnCount, &burnVolume)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == nil {
+		s.Volume.SetString(volume, 10)
+		s.MintVolume.SetString(mintVolume, 10)
+		s.BurnVolume.SetString(burnVolume, 10)
+	}
+
+	s.Add(d)
+
+	return db.setBucket(tx, s)
+}
+
+// refreshBucket recomputes the stats of a bucket from the transfers, empty buckets are not stored
+func (db *StatsDB) refreshBucket(tx *sql.Tx, tokenId int64, period indexer.StatsPeriod, start time.Time) error {
+	end := start.Add(period.Duration())
+
+	s := indexer.NewStats(tokenId, period, start)
+
+	rows, err := tx.Query(fmt.Sprintf(`
+	SELECT from_addr, to_addr, value
+	FROM t_transfers_%s
+	WHERE token_id = $1 AND status = 'success' AND created_at >= $2 AND created_at < $3
+	`, db.suffix), tokenId, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	senders := map[string]bool{}
+	receivers := map[string]bool{}
+
+	for rows.Next() {
+		var from, to, value string
+
+		err := rows.Scan(&from, &to, &value)
+		if err != nil {
+			return err
+		}
+
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return fmt.Errorf("invalid transfer value: %s", value)
+		}
+
+		switch {
+		case from == common.ZeroAddress:
+			s.MintCount++
+			s.MintVolume.Add(s.MintVolume, v)
+		case to == common.ZeroAddress:
+			s.BurnCount++
+			s.BurnVolume.Add(s.BurnVolume, v)
+		default:
+			s.TransferCount++
+			s.Volume.Add(s.Volume, v)
+			senders[from] = true
+			receivers[to] = true
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	s.UniqueSenders = int64(len(senders))
+	s.UniqueReceivers = int64(len(receivers))
+
+	err = tx.QueryRow(fmt.Sprintf(`
+	SELECT COUNT(*) FROM t_stats_accounts_%s WHERE token_id = $1 AND first_seen >= $2 AND first_seen < $3
+	`, db.suffix), tokenId, start, end).Scan(&s.NewAccounts)
+	if err != nil {
+		return err
+	}
+
+	return db.setBucket(tx, s)
+}
+
+// setBucket stores the stats of a bucket, empty buckets are not stored
+func (db *StatsDB) setBucket(tx *sql.Tx, s *indexer.Stats) error {
+	if s.IsEmpty() {
+		_, err := tx.Exec(fmt.Sprintf(`
+		DELETE FROM t_stats_%s_%s WHERE token_id = $1 AND start = $2
+		`, s.Period, db.suffix), s.TokenID, s.Start)
+
+		return err
+	}
+
+	_, err := tx.Exec(fmt.Sprintf(`
+	INSERT INTO t_stats_%s_%s (token_id, start, transfer_count, volume, unique_senders, unique_receivers, new_accounts, mint_count, mint_volume, burn_count, burn_volume, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (token_id, start) DO UPDATE SET
+		transfer_count = excluded.transfer_count,
+		volume = excluded.volume,
+		unique_senders = excluded.unique_senders,
+		unique_receivers = excluded.unique_receivers,
+		new_accounts = excluded.new_accounts,
+		mint_count = excluded.mint_count,
+		mint_volume = excluded.mint_volume,
+		burn_count = excluded.burn_count,
+		burn_volume = excluded.burn_volume,
+		updated_at = excluded.updated_at
+	`, s.Period, db.suffix), s.TokenID, s.Start, s.TransferCount, s.Volume.String(), s.UniqueSenders, s.UniqueReceivers, s.NewAccounts, s.MintCount, s.MintVolume.String(), s.BurnCount, s.BurnVolume.String(), time.Now().UTC())
+
+	return err
+}
+
+// RebuildStats clears the stats and computes them again from all the transfers
+func (db *StatsDB) RebuildStats() error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, period := range statsPeriods {
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM t_stats_%s_%s`, period, db.suffix))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM t_stats_accounts_%s`, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM t_stats_transfers_%s`, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`
+	INSERT INTO t_stats_transfers_%[1]s (hash)
+	SELECT hash FROM t_transfers_%[1]s WHERE status = 'success'
+	`, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// only transfers that are not mints or burns count for the last time an account sent or received
+	_, err = tx.Exec(fmt.Sprintf(`
+	INSERT INTO t_stats_accounts_%[1]s (token_id, address, first_seen, last_sent, last_received)
+	SELECT token_id, address, MIN(created_at), MAX(CASE WHEN plain AND sent THEN created_at END), MAX(CASE WHEN plain AND NOT sent THEN created_at END)
+	FROM (
+		SELECT token_id, from_addr AS address, created_at, 1 AS sent, to_addr != $1 AS plain FROM t_transfers_%[1]s WHERE status = 'success'
+		UNION ALL
+		SELECT token_id, to_addr AS address, created_at, 0 AS sent, from_addr != $1 AS plain FROM t_transfers_%[1]s WHERE status = 'success'
+	)
+	WHERE address != $1
+	GROUP BY token_id, address
+	`, db.suffix), common.ZeroAddress)
+	if err != nil {
+		return err
+	}
+
+	// every bucket that contains a transfer or a new account
+	rows, err := tx.Query(fmt.Sprintf(`
+	SELECT DISTINCT token_id, created_at FROM t_transfers_%s WHERE status = 'success'
+	`, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	buckets := map[statsBucket]bool{}
+	for rows.Next() {
+		var tokenId int64
+		var createdAt time.Time
+
+		err := rows.Scan(&tokenId, &createdAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		for _, period := range statsPeriods {
+			buckets[statsBucket{tokenId, period, period.Start(createdAt)}] = true
+		}
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for b := range buckets {
+		err = db.refreshBucket(tx, b.tokenId, b.period, b.start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetStats returns the stats of a token for every bucket of the period between from and to
+// buckets without activity are returned empty so that the series has no gaps
+func (db *StatsDB) GetStats(tokenId int64, period indexer.StatsPeriod, from, to time.Time) ([]*indexer.Stats, error) {
+	from = period.Start(from)
+	to = period.Start(to)
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT token_id, start, transfer_count, volume, unique_senders, unique_receivers, new_accounts, mint_count, mint_volume, burn_count, burn_volume
+		FROM t_stats_%s_%s
+		WHERE token_id = $1 AND start >= $2 AND start <= $3
+		ORDER BY start ASC
+		`, period, db.suffix), tokenId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := map[time.Time]*indexer.Stats{}
+	for rows.Next() {
+		var volume, mintVolume, burnVolume string
+
+		s := indexer.NewStats(tokenId, period, time.Time{})
+
+		err := rows.Scan(&s.TokenID, &s.Start, &s.TransferCount, &volume, &s.UniqueSenders, &s.UniqueReceivers, &s.NewAccounts, &s.MintCount, &mintVolume, &s.BurnCount, &burnVolume)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Volume.SetString(volume, 10)
+		s.MintVolume.SetString(mintVolume, 10)
+		s.BurnVolume.SetString(burnVolume, 10)
+
+		s.Start = s.Start.UTC()
+		stored[s.Start] = s
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []*indexer.Stats{}
+	for t := from; !t.After(to); t = t.Add(period.Duration()) {
+		s, ok := stored[t]
+		if !ok {
+			s = indexer.NewStats(tokenId, period, t)
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+const testToken = "0x0000000000000000000000000000000000000100"
+
+func newTestStatsDB(t *testing.T, d *DB) (*TransferDB, *StatsDB) {
+	txdb, err := d.AddTransferDB(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, create := range []func() error{txdb.CreateTransferTable, txdb.CreateTransferSearchTable, txdb.CreateBalanceCheckpointTable} {
+		err = create()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sdb, err := d.AddStatsDB(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sdb.CreateStatsTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return txdb, sdb
+}
+
+func testTransfer(i int, from, to string, value int64, createdAt time.Time) *indexer.Transfer {
+	t := &indexer.Transfer{
+		TxHash:    big.NewInt(int64(i)).String(),
+		CreatedAt: createdAt,
+		From:      from,
+		To:        to,
+		Value:     big.NewInt(value),
+		Status:    indexer.TransferStatusSuccess,
+	}
+
+	t.Hash = t.GenerateUniqueHash(0)
+	t.FromTo = t.CombineFromTo()
+
+	return t
+}
+
+// statsSnapshot returns the stats of every hour and day of the test transfers
+func statsSnapshot(t *testing.T, sdb *StatsDB, from, to time.Time) map[indexer.StatsPeriod][]*indexer.Stats {
+	snapshot := map[indexer.StatsPeriod][]*indexer.Stats{}
+	for _, period := range statsPeriods {
+		stats, err := sdb.GetStats(0, period, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot[period] = stats
+	}
+
+	return snapshot
+}
+
+func TestUpdateStats(t *testing.T) {
+	d := newTestDB(t)
+	txdb, sdb := newTestStatsDB(t, d)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// transfers are indexed one at a time, the transfer of alice to carol arrives after later ones
+	txs := []*indexer.Transfer{
+		testTransfer(1, common.ZeroAddress, testAlice, 100, day.Add(10*time.Hour)),
+		testTransfer(2, testAlice, testBob, 10, day.Add(10*time.Hour+30*time.Minute)),
+		testTransfer(3, testAlice, testBob, 5, day.Add(11*time.Hour+10*time.Minute)),
+		testTransfer(4, testBob, testPaymaster, 3, day.Add(12*time.Hour)),
+		testTransfer(5, testAlice, testPaymaster, 7, day.Add(9*time.Hour+15*time.Minute)),
+		testTransfer(6, testBob, common.ZeroAddress, 1, day.Add(13*time.Hour)),
+		testTransfer(7, testPaymaster, testAlice, 2, day.Add(32*time.Hour)),
+	}
+
+	for _, tx := range txs {
+		err := txdb.AddTransfers([]*indexer.Transfer{tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = sdb.UpdateStats([]*indexer.Transfer{tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// transfers that are indexed again are not counted twice
+	err := sdb.UpdateStats(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := day.Add(47 * time.Hour)
+
+	updated := statsSnapshot(t, sdb, day, to)
+
+	first := updated[indexer.StatsPeriodDay][0]
+	if first.TransferCount != 4 || first.Volume.Int64() != 25 || first.UniqueSenders != 2 || first.UniqueReceivers != 2 || first.NewAccounts != 3 ||
+		first.MintCount != 1 || first.MintVolume.Int64() != 100 || first.BurnCount != 1 || first.BurnVolume.Int64() != 1 {
+		t.Errorf("unexpected stats for the first day: %+v", first)
+	}
+
+	err = sdb.RebuildStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt := statsSnapshot(t, sdb, day, to)
+
+	if !reflect.DeepEqual(updated, rebuilt) {
+		for _, period := range statsPeriods {
+			for i := range updated[period] {
+				if !reflect.DeepEqual(updated[period][i], rebuilt[period][i]) {
+					t.Errorf("%s %s: updated %+v, rebuilt %+v", period, updated[period][i].Start, updated[period][i], rebuilt[period][i])
+				}
+			}
+		}
+	}
+}
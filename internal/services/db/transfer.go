@@ -3,10 +3,14 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"math/big"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 )
 
@@ -14,14 +18,21 @@ type TransferDB struct {
 	suffix string
 	db     *sql.DB
 	rdb    *sql.DB
+
+	// fts is true when descriptions are indexed in an fts5 table
+	fts bool
+
+	// broker is notified of every write, it is optional
+	broker *pubsub.Broker
 }
 
-// NewTransferDB creates a new DB
-func NewTransferDB(db, rdb *sql.DB, name string) (*TransferDB, error) {
+// NewTransferDB creates a new DB, writes are published on the broker if there is one
+func NewTransferDB(db, rdb *sql.DB, name string, broker *pubsub.Broker) (*TransferDB, error) {
 	txdb := &TransferDB{
 		suffix: name,
 		db:     db,
 		rdb:    rdb,
+		broker: broker,
 	}
 
 	return txdb, nil
@@ -115,25 +126,328 @@ func (db *TransferDB) CreateTransferTableIndexes() error {
 		return err
 	}
 
+	// filtering by direction and date
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_transfers_%s_from_addr_token_id_date ON t_transfers_%s (from_addr, token_id, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_transfers_%s_to_addr_token_id_date ON t_transfers_%s (to_addr, token_id, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// filtering by counterparty
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_transfers_%s_from_addr_to_addr_date ON t_transfers_%s (from_addr, to_addr, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// filtering by status
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_transfers_%s_token_id_status_date ON t_transfers_%s (token_id, status, created_at);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// filtering by value, values are stored as text so they are compared by length first
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_transfers_%s_value_length_value ON t_transfers_%s (length(value), value);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// descriptions are filtered with the search table, an index on them cannot be used by a LIKE that starts with a wildcard
+	_, err = db.db.Exec(fmt.Sprintf(`
+	DROP INDEX IF EXISTS idx_transfers_%s_description;
+	`, suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateTransferSearchTable creates an fts5 table that indexes the descriptions of transfers
+// the table is kept in sync with triggers, existing transfers are indexed when it is created
+// if sqlite was built without fts5, e.g. in tests, searching falls back to scanning descriptions, the binaries refuse to start without it
+func (db *TransferDB) CreateTransferSearchTable() error {
+	var name string
+	err := db.db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", fmt.Sprintf("t_transfers_fts_%s", db.suffix)).Scan(&name)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	exists := err == nil
+
+	// the hash column is only used to find the row again when the transfer changes
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS t_transfers_fts_%s USING fts5(hash, description);
+	`, db.suffix))
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Default().Println("fts5 is not available, transfer search will scan descriptions for: ", db.suffix)
+			db.fts = false
+			return nil
+		}
+
+		return err
+	}
+
+	suffix := common.ShortenName(db.suffix, 6)
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS trg_transfers_%[1]s_fts_insert AFTER INSERT ON t_transfers_%[2]s
+	WHEN json_extract(CAST(NEW.data AS TEXT), '$.description') != ''
+	BEGIN
+		INSERT INTO t_transfers_fts_%[2]s (hash, description) VALUES (NEW.hash, json_extract(CAST(NEW.data AS TEXT), '$.description'));
+	END;
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS trg_transfers_%[1]s_fts_update AFTER UPDATE OF hash, data ON t_transfers_%[2]s
+	WHEN OLD.hash != NEW.hash OR json_extract(CAST(OLD.data AS TEXT), '$.description') IS NOT json_extract(CAST(NEW.data AS TEXT), '$.description')
+	BEGIN
+		DELETE FROM t_transfers_fts_%[2]s WHERE t_transfers_fts_%[2]s MATCH 'hash : "' || OLD.hash || '"';
+		INSERT INTO t_transfers_fts_%[2]s (hash, description)
+		SELECT NEW.hash, json_extract(CAST(NEW.data AS TEXT), '$.description')
+		WHERE json_extract(CAST(NEW.data AS TEXT), '$.description') != '';
+	END;
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS trg_transfers_%[1]s_fts_delete AFTER DELETE ON t_transfers_%[2]s
+	WHEN json_extract(CAST(OLD.data AS TEXT), '$.description') != ''
+	BEGIN
+		DELETE FROM t_transfers_fts_%[2]s WHERE t_transfers_fts_%[2]s MATCH 'hash : "' || OLD.hash || '"';
+	END;
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		// index the transfers that were stored before the search table existed
+		_, err = db.db.Exec(fmt.Sprintf(`
+		INSERT INTO t_transfers_fts_%[1]s (hash, description)
+		SELECT hash, json_extract(CAST(data AS TEXT), '$.description')
+		FROM t_transfers_%[1]s
+		WHERE json_extract(CAST(data AS TEXT), '$.description') != '';
+		`, db.suffix))
+		if err != nil {
+			return err
+		}
+	}
+
+	db.fts = true
+
 	return nil
 }
 
+// TransferFilter narrows down the transfers of an account
+type TransferFilter struct {
+	Direction    indexer.TransferDirection
+	Counterparty string
+	MinValue     *big.Int
+	MaxValue     *big.Int
+	Statuses     []indexer.TransferStatus
+	StartDate    *time.Time
+	EndDate      *time.Time
+	Description  string
+}
+
+// queryArgs keeps track of the arguments of a query that is built dynamically
+type queryArgs struct {
+	args []any
+}
+
+// add appends an argument and returns its placeholder
+func (q *queryArgs) add(v any) string {
+	q.args = append(q.args, v)
+
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// conditions returns the sql conditions for the filter, cpCol is the column that contains the counterparty.
+// Descriptions are matched with the fts5 table ftsTable, or scanned if it is empty.
+func (f *TransferFilter) conditions(q *queryArgs, cpCol, ftsTable string) []string {
+	conds := []string{}
+	if f == nil {
+		return conds
+	}
+
+	if f.Counterparty != "" {
+		conds = append(conds, fmt.Sprintf("%s = %s", cpCol, q.add(f.Counterparty)))
+	}
+
+	if f.MinValue != nil {
+		v := q.add(f.MinValue.String())
+		conds = append(conds, fmt.Sprintf("(length(value) > length(%s) OR (length(value) = length(%s) AND value >= %s))", v, v, v))
+	}
+
+	if f.MaxValue != nil {
+		v := q.add(f.MaxValue.String())
+		conds = append(conds, fmt.Sprintf("(length(value) < length(%s) OR (length(value) = length(%s) AND value <= %s))", v, v, v))
+	}
+
+	if len(f.Statuses) > 0 {
+		placeholders := []string{}
+		for _, status := range f.Statuses {
+			placeholders = append(placeholders, q.add(status))
+		}
+
+		conds = append(conds, fmt.Sprintf("status IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	if f.StartDate != nil {
+		conds = append(conds, fmt.Sprintf("created_at >= %s", q.add(*f.StartDate)))
+	}
+
+	if f.EndDate != nil {
+		conds = append(conds, fmt.Sprintf("created_at <= %s", q.add(*f.EndDate)))
+	}
+
+	if f.Description != "" {
+		terms := searchTerms(f.Description)
+		if ftsTable != "" && len(terms) > 0 {
+			conds = append(conds, fmt.Sprintf("hash IN (SELECT hash FROM %[1]s WHERE %[1]s MATCH %[2]s)", ftsTable, q.add(ftsQuery(terms))))
+		} else {
+			conds = append(conds, fmt.Sprintf("json_extract(CAST(data AS TEXT), '$.description') LIKE %s ESCAPE '\\'", q.add("%"+escapeLike(f.Description)+"%")))
+		}
+	}
+
+	return conds
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern
+func escapeLike(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+	return r.Replace(s)
+}
+
+// filteredTransfersQuery builds a query for the transfers of an account with a filter applied
+// dateCond is the condition used for pagination, it is applied with the date argument
+func (db *TransferDB) filteredTransfersQuery(dateCond string, date time.Time, tokenId int64, addr string, f *TransferFilter, limit, offset int) (string, []any) {
+	q := &queryArgs{}
+
+	sides := []struct {
+		direction indexer.TransferDirection
+		addrCol   string
+		cpCol     string
+	}{
+		{indexer.TransferDirectionOut, "from_addr", "to_addr"},
+		{indexer.TransferDirectionIn, "to_addr", "from_addr"},
+	}
+
+	branches := []string{}
+	for _, side := range sides {
+		if f != nil && f.Direction != indexer.TransferDirectionAny && f.Direction != side.direction {
+			continue
+		}
+
+		conds := []string{
+			fmt.Sprintf("created_at %s %s", dateCond, q.add(date)),
+			fmt.Sprintf("token_id = %s", q.add(tokenId)),
+			fmt.Sprintf("%s = %s", side.addrCol, q.add(addr)),
+		}
+
+		conds = append(conds, f.conditions(q, side.cpCol, db.ftsTable())...)
+
+		branches = append(branches, fmt.Sprintf(`
+		SELECT hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status
+		FROM t_transfers_%s
+		WHERE %s`, db.suffix, strings.Join(conds, " AND ")))
+	}
+
+	query := fmt.Sprintf(`%s
+		ORDER BY created_at DESC
+		LIMIT %s OFFSET %s
+		`, strings.Join(branches, "\n\t\tUNION ALL"), q.add(limit), q.add(offset))
+
+	return query, q.args
+}
+
+// Topics returns the topics that a transfer is published on
+func (db *TransferDB) Topics(tx *indexer.Transfer) []string {
+	return []string{
+		pubsub.TopicTransfers,
+		pubsub.TransferTopic(db.suffix, ""),
+		pubsub.TransferTopic(db.suffix, tx.From),
+		pubsub.TransferTopic(db.suffix, tx.To),
+	}
+}
+
+// publish notifies the subscribers of a transfer with the transfer as it is stored in the db
+func (db *TransferDB) publish(hash string) {
+	if db.broker == nil {
+		return
+	}
+
+	tx, err := db.GetTransfer(hash)
+	if err != nil {
+		return
+	}
+
+	db.broker.Publish(pubsub.EventTypeTransfer, db.Topics(tx), tx)
+}
+
+// publishRemoved notifies the subscribers of a transfer that it was removed
+func (db *TransferDB) publishRemoved(tx *indexer.Transfer) {
+	if db.broker == nil {
+		return
+	}
+
+	db.broker.Publish(pubsub.EventTypeTransferRemoved, db.Topics(tx), tx)
+}
+
 // AddTransfer adds a transfer to the db
 func (db *TransferDB) AddTransfer(tx *indexer.Transfer) error {
 
 	// insert transfer on conflict do nothing
-	_, err := db.db.Exec(fmt.Sprintf(`
+	res, err := db.db.Exec(fmt.Sprintf(`
 	INSERT OR IGNORE INTO t_transfers_%s (hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status)
 	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 	`, db.suffix), tx.Hash, tx.TxHash, tx.TokenID, tx.CreatedAt, tx.CombineFromTo(), tx.From, tx.To, tx.Nonce, tx.Value.String(), tx.Data, tx.Status)
+	if err != nil {
+		return err
+	}
 
-	return err
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows > 0 {
+		db.publish(tx.Hash)
+	}
+
+	return nil
 }
 
 // AddTransfers adds a list of transfers to the db
 func (db *TransferDB) AddTransfers(tx []*indexer.Transfer) error {
+	var oldest *time.Time
 
 	for _, t := range tx {
+		if t.Status == indexer.TransferStatusSuccess && (oldest == nil || t.CreatedAt.Before(*oldest)) {
+			oldest = &t.CreatedAt
+		}
+
 		// insert transfer on conflict update
 		res, err := db.db.Exec(fmt.Sprintf(`
 			INSERT OR IGNORE INTO t_transfers_%s (hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status)
@@ -151,6 +465,7 @@ func (db *TransferDB) AddTransfers(tx []*indexer.Transfer) error {
 
 		if rows > 0 {
 			// something was inserted, no need to update
+			db.publish(t.Hash)
 			continue
 		}
 
@@ -178,6 +493,15 @@ func (db *TransferDB) AddTransfers(tx []*indexer.Transfer) error {
 		if err != nil {
 			return err
 		}
+
+		if rows > 0 {
+			db.publish(t.Hash)
+		}
+	}
+
+	if oldest != nil {
+		// balance checkpoints after the oldest transfer don't include it
+		return db.invalidateBalanceCheckpoints(*oldest)
 	}
 
 	return nil
@@ -186,31 +510,99 @@ func (db *TransferDB) AddTransfers(tx []*indexer.Transfer) error {
 // SetStatus sets the status of a transfer to pending
 func (db *TransferDB) SetStatus(status, hash string) error {
 	// if status is success, don't update
-	_, err := db.db.Exec(fmt.Sprintf(`
+	res, err := db.db.Exec(fmt.Sprintf(`
 	UPDATE t_transfers_%s SET status = $1 WHERE hash = $2 AND status != 'success'
 	`, db.suffix), status, hash)
+	if err != nil {
+		return err
+	}
 
-	return err
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows > 0 {
+		db.publish(hash)
+	}
+
+	return nil
 }
 
 // RemoveTransfer removes a sending transfer from the db
 func (db *TransferDB) RemoveTransfer(hash string) error {
-	_, err := db.db.Exec(fmt.Sprintf(`
+	var tx *indexer.Transfer
+	if db.broker != nil {
+		// keep the transfer to notify subscribers once it is removed
+		tx, _ = db.GetTransfer(hash)
+	}
+
+	res, err := db.db.Exec(fmt.Sprintf(`
 	DELETE FROM t_transfers_%s WHERE hash = $1 AND status != 'success'
 	`, db.suffix), hash)
+	if err != nil {
+		return err
+	}
 
-	return err
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows > 0 && tx != nil {
+		db.publishRemoved(tx)
+	}
+
+	return nil
 }
 
 // RemoveOldInProgressTransfers removes any transfer that is not success or fail from the db
 func (db *TransferDB) RemoveOldInProgressTransfers() error {
 	old := time.Now().UTC().Add(-30 * time.Second)
 
-	_, err := db.db.Exec(fmt.Sprintf(`
-	DELETE FROM t_transfers_%s WHERE created_at <= $1 AND status IN ('sending', 'pending')
-	`, db.suffix), old)
+	if db.broker == nil {
+		_, err := db.db.Exec(fmt.Sprintf(`
+		DELETE FROM t_transfers_%s WHERE created_at <= $1 AND status IN ('sending', 'pending')
+		`, db.suffix), old)
 
-	return err
+		return err
+	}
+
+	// remove them one by one to notify subscribers
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT hash FROM t_transfers_%s WHERE created_at <= $1 AND status IN ('sending', 'pending')
+		`, db.suffix), old)
+	if err != nil {
+		return err
+	}
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+
+		err := rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		err := db.RemoveTransfer(hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // GetTransfer returns the transfer for a given hash
@@ -273,21 +665,13 @@ func (db *TransferDB) GetAllPaginatedTransfers(tokenId int64, maxDate time.Time,
 	return transfers, nil
 }
 
-// GetPaginatedTransfers returns the transfers for a given from_addr or to_addr paginated
-func (db *TransferDB) GetPaginatedTransfers(tokenId int64, addr string, maxDate time.Time, limit, offset int) ([]*indexer.Transfer, error) {
+// GetPaginatedTransfers returns the transfers for a given from_addr or to_addr paginated, an optional filter can be applied
+func (db *TransferDB) GetPaginatedTransfers(tokenId int64, addr string, maxDate time.Time, f *TransferFilter, limit, offset int) ([]*indexer.Transfer, error) {
 	transfers := []*indexer.Transfer{}
 
-	rows, err := db.rdb.Query(fmt.Sprintf(`
-		SELECT hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status
-		FROM t_transfers_%s
-		WHERE created_at <= $1 AND token_id = $2 AND from_addr = $3
-		UNION ALL
-		SELECT hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status
-		FROM t_transfers_%s
-		WHERE created_at <= $4 AND token_id = $5 AND to_addr = $6
-		ORDER BY created_at DESC
-		LIMIT $7 OFFSET $8
-		`, db.suffix, db.suffix), maxDate, tokenId, addr, maxDate, tokenId, addr, limit, offset)
+	query, args := db.filteredTransfersQuery("<=", maxDate, tokenId, addr, f, limit, offset)
+
+	rows, err := db.rdb.Query(query, args...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return transfers, nil
@@ -353,24 +737,197 @@ func (db *TransferDB) GetAllNewTransfers(tokenId int64, fromDate time.Time, limi
 	return transfers, nil
 }
 
-// GetNewTransfers returns the transfers for a given from_addr or to_addr from a given date
-func (db *TransferDB) GetNewTransfers(tokenId int64, addr string, fromDate time.Time, limit, offset int) ([]*indexer.Transfer, error) {
+// GetNewTransfers returns the transfers for a given from_addr or to_addr from a given date, an optional filter can be applied
+func (db *TransferDB) GetNewTransfers(tokenId int64, addr string, fromDate time.Time, f *TransferFilter, limit, offset int) ([]*indexer.Transfer, error) {
 	transfers := []*indexer.Transfer{}
 
+	query, args := db.filteredTransfersQuery(">=", fromDate, tokenId, addr, f, limit, offset)
+
+	rows, err := db.rdb.Query(query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return transfers, nil
+		}
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transfer indexer.Transfer
+		var value string
+
+		err := rows.Scan(&transfer.Hash, &transfer.TxHash, &transfer.TokenID, &transfer.CreatedAt, &transfer.FromTo, &transfer.From, &transfer.To, &transfer.Nonce, &value, &transfer.Data, &transfer.Status)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer.Value = new(big.Int)
+		transfer.Value.SetString(value, 10)
+
+		transfers = append(transfers, &transfer)
+	}
+
+	return transfers, nil
+}
+
+// ForEachSuccessfulTransfer calls fn for every successful transfer of an address between two dates, oldest first
+// the transfers are read one by one so that all of them never need to be in memory, iteration stops at the first error
+func (db *TransferDB) ForEachSuccessfulTransfer(tokenId int64, addr string, fromDate, toDate time.Time, fn func(tx *indexer.Transfer) error) error {
 	rows, err := db.rdb.Query(fmt.Sprintf(`
 		SELECT hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status
 		FROM t_transfers_%s
-		WHERE created_at >= $1 AND token_id = $2 AND from_addr = $3
-		UNION ALL
+		WHERE token_id = $1 AND status = 'success' AND created_at >= $2 AND created_at <= $3 AND (from_addr = $4 OR to_addr = $4)
+		ORDER BY created_at ASC
+		`, db.suffix), tokenId, fromDate, toDate, addr)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transfer indexer.Transfer
+		var value string
+
+		err := rows.Scan(&transfer.Hash, &transfer.TxHash, &transfer.TokenID, &transfer.CreatedAt, &transfer.FromTo, &transfer.From, &transfer.To, &transfer.Nonce, &value, &transfer.Data, &transfer.Status)
+		if err != nil {
+			return err
+		}
+
+		transfer.Value = new(big.Int)
+		transfer.Value.SetString(value, 10)
+
+		err = fn(&transfer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ftsTable returns the name of the fts5 table that indexes the descriptions, or an empty string if there is none
+func (db *TransferDB) ftsTable() string {
+	if !db.fts {
+		return ""
+	}
+
+	return fmt.Sprintf("t_transfers_fts_%s", db.suffix)
+}
+
+// searchTerms splits a search query into the words that are indexed
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// ftsQuery builds an fts5 match expression on the description column for the given terms
+// all terms need to match, the last one can be incomplete
+func ftsQuery(terms []string) string {
+	phrases := []string{}
+	for _, term := range terms {
+		phrases = append(phrases, fmt.Sprintf(`"%s"`, term))
+	}
+
+	return fmt.Sprintf("description : (%s*)", strings.Join(phrases, " "))
+}
+
+// SearchTransfers returns the transfers whose description matches the query, best matches first
+// results can optionally be scoped to an account by providing addr
+func (db *TransferDB) SearchTransfers(tokenId int64, addr, query string, limit, offset int) ([]*indexer.TransferSearchResult, error) {
+	results := []*indexer.TransferSearchResult{}
+
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	if !db.fts {
+		return db.scanTransfers(tokenId, addr, terms, limit, offset)
+	}
+
+	q := &queryArgs{}
+
+	conds := []string{
+		fmt.Sprintf("t_transfers_fts_%s MATCH %s", db.suffix, q.add(ftsQuery(terms))),
+		fmt.Sprintf("t.token_id = %s", q.add(tokenId)),
+	}
+
+	if addr != "" {
+		a := q.add(addr)
+		conds = append(conds, fmt.Sprintf("(t.from_addr = %s OR t.to_addr = %s)", a, a))
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+		SELECT t.hash, t.tx_hash, t.token_id, t.created_at, t.from_to_addr, t.from_addr, t.to_addr, t.nonce, t.value, t.data, t.status,
+			-bm25(t_transfers_fts_%[1]s) AS rank,
+			snippet(t_transfers_fts_%[1]s, 1, '<b>', '</b>', '...', 16)
+		FROM t_transfers_fts_%[1]s
+		JOIN t_transfers_%[1]s t ON t.hash = t_transfers_fts_%[1]s.hash
+		WHERE %[2]s
+		ORDER BY rank DESC, t.created_at DESC
+		LIMIT %[3]s OFFSET %[4]s
+		`, db.suffix, strings.Join(conds, " AND "), q.add(limit), q.add(offset)), q.args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return results, nil
+		}
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transfer indexer.Transfer
+		var value string
+		var result indexer.TransferSearchResult
+
+		err := rows.Scan(&transfer.Hash, &transfer.TxHash, &transfer.TokenID, &transfer.CreatedAt, &transfer.FromTo, &transfer.From, &transfer.To, &transfer.Nonce, &value, &transfer.Data, &transfer.Status, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer.Value = new(big.Int)
+		transfer.Value.SetString(value, 10)
+
+		result.Transfer = &transfer
+
+		results = append(results, &result)
+	}
+
+	return results, nil
+}
+
+// scanTransfers searches descriptions without an fts5 index, results are ordered by date
+func (db *TransferDB) scanTransfers(tokenId int64, addr string, terms []string, limit, offset int) ([]*indexer.TransferSearchResult, error) {
+	results := []*indexer.TransferSearchResult{}
+
+	q := &queryArgs{}
+
+	conds := []string{
+		fmt.Sprintf("token_id = %s", q.add(tokenId)),
+	}
+
+	if addr != "" {
+		a := q.add(addr)
+		conds = append(conds, fmt.Sprintf("(from_addr = %s OR to_addr = %s)", a, a))
+	}
+
+	for _, term := range terms {
+		conds = append(conds, fmt.Sprintf("json_extract(CAST(data AS TEXT), '$.description') LIKE %s ESCAPE '\\'", q.add("%"+escapeLike(term)+"%")))
+	}
+
+	rows, err := db.rdb.Query(fmt.Sprintf(`
 		SELECT hash, tx_hash, token_id, created_at, from_to_addr, from_addr, to_addr, nonce, value, data, status
 		FROM t_transfers_%s
-		WHERE created_at >= $4 AND token_id = $5 AND to_addr = $6
+		WHERE %s
 		ORDER BY created_at DESC
-		LIMIT $7 OFFSET $8
-		`, db.suffix, db.suffix), fromDate, tokenId, addr, fromDate, tokenId, addr, limit, offset)
+		LIMIT %s OFFSET %s
+		`, db.suffix, strings.Join(conds, " AND "), q.add(limit), q.add(offset)), q.args...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return transfers, nil
+			return results, nil
 		}
 
 		return nil, err
@@ -389,10 +946,18 @@ func (db *TransferDB) GetNewTransfers(tokenId int64, addr string, fromDate time.
 		transfer.Value = new(big.Int)
 		transfer.Value.SetString(value, 10)
 
-		transfers = append(transfers, &transfer)
+		result := &indexer.TransferSearchResult{
+			Transfer: &transfer,
+		}
+
+		if transfer.Data != nil {
+			result.Snippet = common.HighlightTerms(transfer.Data.Description, terms, "<b>", "</b>")
+		}
+
+		results = append(results, result)
 	}
 
-	return transfers, nil
+	return results, nil
 }
 
 // UpdateTransfersWithDB returns the transfers with data updated from the db
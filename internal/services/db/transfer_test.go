@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+func TestDescriptionFilter(t *testing.T) {
+	d := newTestDB(t)
+	txdb, _ := newTestStatsDB(t, d)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	descriptions := []string{"Invoice table 12", "table 3", "coffee", ""}
+
+	txs := []*indexer.Transfer{}
+	for i, description := range descriptions {
+		tx := testTransfer(i+1, testAlice, testBob, 1, day.Add(time.Duration(i)*time.Hour))
+		tx.Data = &indexer.TransferData{Description: description}
+
+		txs = append(txs, tx)
+	}
+
+	err := txdb.AddTransfers(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		description string
+		want        int
+	}{
+		{"table 12", 1},
+		{"table", 2},
+		{"TAB", 2},
+		{"tea", 0},
+	}
+
+	// the fts table is used when sqlite has fts5, descriptions are scanned otherwise
+	for _, fts := range []bool{txdb.fts, false} {
+		txdb.fts = fts
+
+		for _, c := range cases {
+			transfers, err := txdb.GetPaginatedTransfers(0, testAlice, day.Add(24*time.Hour), &TransferFilter{Description: c.description}, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(transfers) != c.want {
+				t.Errorf("fts %t, %q: got %d transfers, want %d", fts, c.description, len(transfers), c.want)
+			}
+		}
+	}
+}
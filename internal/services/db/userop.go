@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+type UserOpDB struct {
+	suffix string
+	db     *sql.DB
+	rdb    *sql.DB
+}
+
+// NewUserOpDB creates a new DB
+func NewUserOpDB(db, rdb *sql.DB, name string) (*UserOpDB, error) {
+	uodb := &UserOpDB{
+		suffix: name,
+		db:     db,
+		rdb:    rdb,
+	}
+
+	return uodb, nil
+}
+
+// Close closes the db
+func (db *UserOpDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *UserOpDB) CloseR() error {
+	return db.rdb.Close()
+}
+
+// CreateUserOpsTable creates a table to store the user operations submitted through the bundler
+func (db *UserOpDB) CreateUserOpsTable() error {
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS t_userops_%s(
+		hash TEXT NOT NULL PRIMARY KEY,
+		tx_hash TEXT NOT NULL DEFAULT '',
+		entry_point TEXT NOT NULL,
+		paymaster TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		userop TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at timestamp NOT NULL DEFAULT current_timestamp,
+		updated_at timestamp NOT NULL DEFAULT current_timestamp
+	);
+	`, db.suffix))
+
+	return err
+}
+
+// CreateUserOpsTableIndexes creates the indexes for user operations in the given db
+func (db *UserOpDB) CreateUserOpsTableIndexes() error {
+	suffix := common.ShortenName(db.suffix, 6)
+
+	// fetch the ops of a bundle
+	_, err := db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_userops_%s_tx_hash ON t_userops_%s (tx_hash);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	// fetch the entry points used by a paymaster
+	_, err = db.db.Exec(fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS idx_userops_%s_paymaster_entry_point ON t_userops_%s (paymaster, entry_point);
+	`, suffix, db.suffix))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetUserOp inserts a user operation, an op that already exists gets the tx hash, status and error of the new one.
+// An op that was sent keeps its tx hash and status unless the new one was sent as well, false is returned if it was kept.
+func (db *UserOpDB) SetUserOp(r *indexer.UserOpRecord) (bool, error) {
+	now := time.Now().UTC()
+
+	op, err := json.Marshal(&r.UserOp)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := db.db.Exec(fmt.Sprintf(`
+	INSERT INTO t_userops_%[1]s (hash, tx_hash, entry_point, paymaster, sender, userop, status, error, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	ON CONFLICT(hash) DO UPDATE SET
+		tx_hash = excluded.tx_hash,
+		status = excluded.status,
+		error = excluded.error,
+		updated_at = excluded.updated_at
+	WHERE excluded.tx_hash != '' OR t_userops_%[1]s.tx_hash = ''
+	`, db.suffix), r.Hash, r.TxHash, r.EntryPoint, r.Paymaster, r.UserOp.Sender.Hex(), string(op), r.Status, r.Error, now, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetUserOp returns the user operation with the given hash, sql.ErrNoRows is returned if there is none
+func (db *UserOpDB) GetUserOp(hash string) (*indexer.UserOpRecord, error) {
+	var r indexer.UserOpRecord
+	var op string
+
+	err := db.rdb.QueryRow(fmt.Sprintf(`
+	SELECT hash, tx_hash, entry_point, paymaster, userop, status, error, created_at, updated_at
+	FROM t_userops_%s
+	WHERE hash = $1
+	`, db.suffix), hash).Scan(&r.Hash, &r.TxHash, &r.EntryPoint, &r.Paymaster, &op, &r.Status, &r.Error, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(op), &r.UserOp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// GetUserOpsByStatus returns the user operations with the given status, oldest first
+func (db *UserOpDB) GetUserOpsByStatus(status indexer.UserOpStatus) ([]*indexer.UserOpRecord, error) {
+	rows, err := db.rdb.Query(fmt.Sprintf(`
+	SELECT hash, tx_hash, entry_point, paymaster, userop, status, error, created_at, updated_at
+	FROM t_userops_%s
+	WHERE status = $1
+	ORDER BY created_at ASC
+	`, db.suffix), status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recs := []*indexer.UserOpRecord{}
+	for rows.Next() {
+		var r indexer.UserOpRecord
+		var op string
+
+		err := rows.Scan(&r.Hash, &r.TxHash, &r.EntryPoint, &r.Paymaster, &op, &r.Status, &r.Error, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(op), &r.UserOp)
+		if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, &r)
+	}
+
+	return recs, rows.Err()
+}
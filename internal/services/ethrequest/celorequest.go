@@ -108,6 +108,14 @@ func (e *CeloService) NonceAt(ctx context.Context, account common.Address, block
 	return e.client.NonceAt(e.ctx, account, blockNumber)
 }
 
+func (e *CeloService) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return e.client.PendingNonceAt(e.ctx, account)
+}
+
+func (e *CeloService) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return e.client.BalanceAt(e.ctx, account, blockNumber)
+}
+
 func (e *CeloService) BaseFee() (*big.Int, error) {
 	// Get the latest block header
 	header, err := e.client.HeaderByNumber(context.Background(), nil)
@@ -198,6 +206,14 @@ func (e *CeloService) MaxPriorityFeePerGas() (*big.Int, error) {
 	return fee, nil
 }
 
+func (e *CeloService) TransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	return e.client.TransactionReceipt(e.ctx, hash)
+}
+
+func (e *CeloService) TransactionByHash(hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	return e.client.TransactionByHash(e.ctx, hash)
+}
+
 func (e *CeloService) StorageAt(addr common.Address, slot common.Hash) ([]byte, error) {
 	return e.client.StorageAt(e.ctx, addr, slot, nil)
 }
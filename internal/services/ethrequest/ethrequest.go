@@ -106,6 +106,14 @@ func (e *EthService) NonceAt(ctx context.Context, account common.Address, blockN
 	return e.client.NonceAt(e.ctx, account, blockNumber)
 }
 
+func (e *EthService) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return e.client.PendingNonceAt(e.ctx, account)
+}
+
+func (e *EthService) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return e.client.BalanceAt(e.ctx, account, blockNumber)
+}
+
 func (e *EthService) BaseFee() (*big.Int, error) {
 	// Get the latest block header
 	header, err := e.client.HeaderByNumber(context.Background(), nil)
@@ -196,6 +204,10 @@ func (e *EthService) MaxPriorityFeePerGas() (*big.Int, error) {
 	return fee, nil
 }
 
+func (e *EthService) TransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	return e.client.TransactionReceipt(e.ctx, hash)
+}
+
 func (e *EthService) StorageAt(addr common.Address, slot common.Hash) ([]byte, error) {
 	return e.client.StorageAt(e.ctx, addr, slot, nil)
 }
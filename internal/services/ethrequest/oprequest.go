@@ -106,6 +106,14 @@ func (e *OPService) NonceAt(ctx context.Context, account common.Address, blockNu
 	return e.client.NonceAt(e.ctx, account, blockNumber)
 }
 
+func (e *OPService) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return e.client.PendingNonceAt(e.ctx, account)
+}
+
+func (e *OPService) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return e.client.BalanceAt(e.ctx, account, blockNumber)
+}
+
 func (e *OPService) BaseFee() (*big.Int, error) {
 	// Get the latest block header
 	header, err := e.client.HeaderByNumber(context.Background(), nil)
@@ -196,6 +204,14 @@ func (e *OPService) MaxPriorityFeePerGas() (*big.Int, error) {
 	return fee, nil
 }
 
+func (e *OPService) TransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	return e.client.TransactionReceipt(e.ctx, hash)
+}
+
+func (e *OPService) TransactionByHash(hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	return e.client.TransactionByHash(e.ctx, hash)
+}
+
 func (e *OPService) StorageAt(addr common.Address, slot common.Hash) ([]byte, error) {
 	return e.client.StorageAt(e.ctx, addr, slot, nil)
 }
@@ -0,0 +1,231 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	subscriptionBufferSize = 100 // events a subscriber can fall behind before it is closed
+)
+
+// Event is a message published on one or more topics
+// ids are increasing so that a subscriber can resume after the last event it received
+type Event struct {
+	ID     int64    `json:"id"`
+	Type   string   `json:"type"`
+	Topics []string `json:"topics"`
+	Data   any      `json:"data"`
+}
+
+// Subscription receives the events of its topics on C
+// C is closed when the subscriber unsubscribes or falls too far behind
+type Subscription struct {
+	C <-chan Event
+
+	ch     chan Event
+	topics map[string]bool
+}
+
+// Matches returns true if the event was published on one of the topics of the subscription
+func (s *Subscription) Matches(e Event) bool {
+	for _, t := range e.Topics {
+		if s.topics[t] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Broker fans out published events to the subscribers of their topics
+// the latest events are kept in memory so that subscribers can catch up on what they missed
+type Broker struct {
+	mu     sync.Mutex
+	lastID int64
+	subs   map[string]map[*Subscription]bool
+
+	history   []Event
+	next      int
+	full      bool
+	forgotten int64 // events up to this id are not in the history
+}
+
+// NewBroker creates a broker that remembers the last historySize events
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		subs:      map[string]map[*Subscription]bool{},
+		history:   make([]Event, historySize),
+		forgotten: time.Now().UnixNano(),
+	}
+}
+
+// Publish sends an event to all the subscribers of the given topics, it never blocks
+func (b *Broker) Publish(eventType string, topics []string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := time.Now().UnixNano()
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+
+	e := Event{
+		ID:     id,
+		Type:   eventType,
+		Topics: topics,
+		Data:   data,
+	}
+
+	if len(b.history) == 0 {
+		b.forgotten = id
+	} else {
+		if b.full {
+			b.forgotten = b.history[b.next].ID
+		}
+
+		b.history[b.next] = e
+		b.next = (b.next + 1) % len(b.history)
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+
+	// a subscriber to several of the topics only receives the event once
+	sent := map[*Subscription]bool{}
+	for _, t := range topics {
+		for s := range b.subs[t] {
+			if sent[s] {
+				continue
+			}
+			sent[s] = true
+
+			select {
+			case s.ch <- e:
+			default:
+				// the subscriber is too slow, it can resume from the last event it received
+				b.unsubscribe(s)
+			}
+		}
+	}
+
+	return e
+}
+
+// Subscribe returns a subscription to the given topics
+func (b *Broker) Subscribe(topics ...string) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriptionBufferSize)
+	s := &Subscription{
+		C:      ch,
+		ch:     ch,
+		topics: map[string]bool{},
+	}
+
+	for _, t := range topics {
+		s.topics[t] = true
+
+		if _, ok := b.subs[t]; !ok {
+			b.subs[t] = map[*Subscription]bool{}
+		}
+
+		b.subs[t][s] = true
+	}
+
+	return s
+}
+
+// Unsubscribe removes the subscription and closes its channel
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unsubscribe(s)
+}
+
+func (b *Broker) unsubscribe(s *Subscription) {
+	removed := false
+	for t := range s.topics {
+		subs, ok := b.subs[t]
+		if !ok || !subs[s] {
+			continue
+		}
+
+		removed = true
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(b.subs, t)
+		}
+	}
+
+	if removed {
+		close(s.ch)
+	}
+}
+
+// Since returns the remembered events after the given id that match the subscription
+// ok is false if events after the id might have been forgotten already
+func (b *Broker) Since(id int64, s *Subscription) (events []Event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// oldest first
+	ordered := b.history[:b.next]
+	if b.full {
+		ordered = append(append([]Event{}, b.history[b.next:]...), b.history[:b.next]...)
+	}
+
+	ok = id >= b.forgotten
+
+	events = []Event{}
+	for _, e := range ordered {
+		if e.ID > id && s.Matches(e) {
+			events = append(events, e)
+		}
+	}
+
+	return events, ok
+}
+
+const (
+	EventTypeTransfer        = "transfer"         // a transfer was added or updated
+	EventTypeTransferRemoved = "transfer_removed" // an optimistic transfer was removed
+
+	TopicTransfers = "transfers" // all transfers of all tokens
+)
+
+// TransferTopic returns the topic of the transfers of a token, or of an account of a token if addr is set
+func TransferTopic(token, addr string) string {
+	if addr == "" {
+		return fmt.Sprintf("%s:%s", TopicTransfers, strings.ToLower(token))
+	}
+
+	return fmt.Sprintf("%s:%s:%s", TopicTransfers, strings.ToLower(token), strings.ToLower(addr))
+}
+
+const (
+	EventTypeUserOp = "userop" // the status of a user operation sent through the bundler changed
+
+	TopicUserOps = "userops" // all user operations
+)
+
+// UserOpTopic returns the topic of the user operations of a sender
+func UserOpTopic(sender string) string {
+	return fmt.Sprintf("%s:%s", TopicUserOps, strings.ToLower(sender))
+}
+
+const (
+	EventTypeIndexer = "indexer" // an event was indexed up to a new block
+
+	TopicIndexer = "indexer" // the progress of all events
+)
+
+// IndexerTopic returns the topic of the progress of a token
+func IndexerTopic(token string) string {
+	return fmt.Sprintf("%s:%s", TopicIndexer, strings.ToLower(token))
+}
@@ -0,0 +1,115 @@
+package pubsub
+
+import (
+	"testing"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	b := NewBroker(10)
+
+	s := b.Subscribe("a", "b")
+	other := b.Subscribe("c")
+
+	// published on both topics of the subscriber, it should only be received once
+	e := b.Publish(EventTypeTransfer, []string{"a", "b"}, 1)
+
+	select {
+	case got := <-s.C:
+		if got.ID != e.ID || got.Data != 1 {
+			t.Errorf("received %+v, want %+v", got, e)
+		}
+	default:
+		t.Fatal("expected an event")
+	}
+
+	select {
+	case got := <-s.C:
+		t.Errorf("received a duplicate event %+v", got)
+	default:
+	}
+
+	select {
+	case got := <-other.C:
+		t.Errorf("received an event of another topic %+v", got)
+	default:
+	}
+
+	b.Unsubscribe(s)
+
+	if _, ok := <-s.C; ok {
+		t.Error("expected the channel to be closed")
+	}
+
+	// publishing after unsubscribing should not panic
+	b.Publish(EventTypeTransfer, []string{"a"}, 2)
+}
+
+func TestIncreasingIDs(t *testing.T) {
+	b := NewBroker(0)
+
+	var last int64
+	for i := 0; i < 1000; i++ {
+		e := b.Publish(EventTypeTransfer, []string{"a"}, i)
+		if e.ID <= last {
+			t.Fatalf("id %d is not greater than %d", e.ID, last)
+		}
+
+		last = e.ID
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	b := NewBroker(0)
+
+	s := b.Subscribe("a")
+
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		b.Publish(EventTypeTransfer, []string{"a"}, i)
+	}
+
+	count := 0
+	for range s.C {
+		count++
+	}
+
+	if count != subscriptionBufferSize {
+		t.Errorf("received %d events before the channel was closed, want %d", count, subscriptionBufferSize)
+	}
+}
+
+func TestSince(t *testing.T) {
+	b := NewBroker(3)
+
+	s := b.Subscribe("a")
+	defer b.Unsubscribe(s)
+
+	first := b.Publish(EventTypeTransfer, []string{"a"}, 1)
+	b.Publish(EventTypeTransfer, []string{"b"}, 2)
+	third := b.Publish(EventTypeTransfer, []string{"a"}, 3)
+
+	events, ok := b.Since(first.ID, s)
+	if !ok {
+		t.Fatal("expected the history to contain all events after the first one")
+	}
+
+	if len(events) != 1 || events[0].ID != third.ID {
+		t.Errorf("Since(%d) = %+v, want only %d", first.ID, events, third.ID)
+	}
+
+	// the first event is forgotten
+	b.Publish(EventTypeTransfer, []string{"a"}, 4)
+
+	_, ok = b.Since(first.ID-1, s)
+	if ok {
+		t.Error("expected events before the first one to be forgotten")
+	}
+
+	events, ok = b.Since(first.ID, s)
+	if !ok {
+		t.Fatal("expected the history to contain all events after the first one")
+	}
+
+	if len(events) != 2 || events[0].ID != third.ID {
+		t.Errorf("Since(%d) = %+v, want 2 events starting with %d", first.ID, events, third.ID)
+	}
+}
@@ -0,0 +1,106 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"database/sql"
+	"fmt"
+	"math/big"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// LocalSigner signs with the keys stored encrypted in the sponsor db, they are decrypted for each signature
+type LocalSigner struct {
+	db *db.DB
+}
+
+// NewLocalSigner creates a new LocalSigner
+func NewLocalSigner(db *db.DB) *LocalSigner {
+	return &LocalSigner{
+		db: db,
+	}
+}
+
+// sponsorKey fetches and decrypts the sponsor key of a paymaster
+func (s *LocalSigner) sponsorKey(paymaster common.Address) (*ecdsa.PrivateKey, error) {
+	sponsor, err := s.db.SponsorDB.GetSponsor(paymaster.Hex())
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: no sponsor for %s", ErrUnknownKey, paymaster.Hex())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return comm.HexToPrivateKey(sponsor.PrivateKey)
+}
+
+// Sponsor returns the address of the key that signs for a paymaster
+func (s *LocalSigner) Sponsor(paymaster common.Address) (common.Address, error) {
+	key, err := s.sponsorKey(paymaster)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return crypto.PubkeyToAddress(key.PublicKey), nil
+}
+
+// Submitters returns the addresses of the active keys that submit the bundles of a paymaster
+func (s *LocalSigner) Submitters(paymaster common.Address) ([]common.Address, error) {
+	keys, err := s.db.SponsorDB.GetSponsorKeys(paymaster.Hex(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []common.Address{}
+	for _, key := range keys {
+		addrs = append(addrs, common.HexToAddress(key.Address))
+	}
+
+	return addrs, nil
+}
+
+// SignHash signs a hash as an EIP-191 message with the sponsor key of a paymaster
+func (s *LocalSigner) SignHash(paymaster common.Address, hash []byte) ([]byte, error) {
+	key, err := s.sponsorKey(paymaster)
+	if err != nil {
+		return nil, err
+	}
+
+	return signHash(key, hash)
+}
+
+// SignTx signs a transaction with the sponsor key or a submitter key of a paymaster, retired submitter keys can still sign
+func (s *LocalSigner) SignTx(paymaster, from common.Address, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	key, err := s.sponsorKey(paymaster)
+	if err != nil {
+		return nil, err
+	}
+
+	if crypto.PubkeyToAddress(key.PublicKey) == from {
+		return signTx(key, from, tx, chainId)
+	}
+
+	keys, err := s.db.SponsorDB.GetSponsorKeys(paymaster.Hex(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if common.HexToAddress(k.Address) != from {
+			continue
+		}
+
+		key, err := comm.HexToPrivateKey(k.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return signTx(key, from, tx, chainId)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, from.Hex())
+}
@@ -0,0 +1,117 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MockSigner signs with keys generated in memory, it is meant for tests
+type MockSigner struct {
+	mu         sync.Mutex
+	sponsors   map[common.Address]*ecdsa.PrivateKey
+	submitters map[common.Address][]*ecdsa.PrivateKey
+}
+
+// NewMockSigner creates a new MockSigner without keys
+func NewMockSigner() *MockSigner {
+	return &MockSigner{
+		sponsors:   map[common.Address]*ecdsa.PrivateKey{},
+		submitters: map[common.Address][]*ecdsa.PrivateKey{},
+	}
+}
+
+// AddSponsor generates the sponsor key of a paymaster
+func (s *MockSigner) AddSponsor(paymaster common.Address) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sponsors[paymaster] = key
+
+	return key, nil
+}
+
+// AddSubmitter generates a submitter key for a paymaster
+func (s *MockSigner) AddSubmitter(paymaster common.Address) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.submitters[paymaster] = append(s.submitters[paymaster], key)
+
+	return key, nil
+}
+
+func (s *MockSigner) sponsorKey(paymaster common.Address) (*ecdsa.PrivateKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.sponsors[paymaster]
+	if !ok {
+		return nil, fmt.Errorf("%w: no sponsor for %s", ErrUnknownKey, paymaster.Hex())
+	}
+
+	return key, nil
+}
+
+// Sponsor returns the address of the key that signs for a paymaster
+func (s *MockSigner) Sponsor(paymaster common.Address) (common.Address, error) {
+	key, err := s.sponsorKey(paymaster)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return crypto.PubkeyToAddress(key.PublicKey), nil
+}
+
+// Submitters returns the addresses of the submitter keys of a paymaster
+func (s *MockSigner) Submitters(paymaster common.Address) ([]common.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := []common.Address{}
+	for _, key := range s.submitters[paymaster] {
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+
+	return addrs, nil
+}
+
+// SignHash signs a hash as an EIP-191 message with the sponsor key of a paymaster
+func (s *MockSigner) SignHash(paymaster common.Address, hash []byte) ([]byte, error) {
+	key, err := s.sponsorKey(paymaster)
+	if err != nil {
+		return nil, err
+	}
+
+	return signHash(key, hash)
+}
+
+// SignTx signs a transaction with the sponsor key or a submitter key of a paymaster
+func (s *MockSigner) SignTx(paymaster, from common.Address, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	s.mu.Lock()
+	keys := append([]*ecdsa.PrivateKey{s.sponsors[paymaster]}, s.submitters[paymaster]...)
+	s.mu.Unlock()
+
+	for _, key := range keys {
+		if key != nil && crypto.PubkeyToAddress(key.PublicKey) == from {
+			return signTx(key, from, tx, chainId)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, from.Hex())
+}
@@ -0,0 +1,234 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const remoteTimeout = 10 * time.Second
+
+type keysResponse struct {
+	Sponsor    common.Address   `json:"sponsor"`
+	Submitters []common.Address `json:"submitters"`
+}
+
+type signHashRequest struct {
+	Hash hexutil.Bytes `json:"hash"`
+}
+
+type signHashResponse struct {
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+type signTxRequest struct {
+	From    common.Address `json:"from"`
+	ChainId *hexutil.Big   `json:"chainId"`
+	Tx      hexutil.Bytes  `json:"tx"`
+}
+
+type signTxResponse struct {
+	Tx hexutil.Bytes `json:"tx"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// RemoteSigner asks a signer process for signatures over HTTP, so that the keys never reach this process.
+//
+//	GET  /paymasters/{paymaster}      -> {"sponsor": "0x...", "submitters": ["0x..."]}
+//	POST /paymasters/{paymaster}/hash {"hash": "0x..."} -> {"signature": "0x..."}
+//	POST /paymasters/{paymaster}/tx   {"from": "0x...", "chainId": "0x...", "tx": "0x..."} -> {"tx": "0x..."}
+//
+// Transactions are sent in their binary encoding, unsigned, and come back signed.
+type RemoteSigner struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewRemoteSigner creates a new RemoteSigner, the token is sent as a bearer token if it is not empty.
+// The signer is reached over https unless it is on a loopback address, its certificate is verified with the
+// certificate in the PEM file at capath or with the roots of the system if capath is empty.
+func NewRemoteSigner(signerURL, token, capath string) (*RemoteSigner, error) {
+	u, err := url.Parse(signerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "https" && !(u.Scheme == "http" && IsLoopback(u.Hostname())) {
+		return nil, fmt.Errorf("the signer should be reached over https: %s", signerURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if capath != "" {
+		ca, err := os.ReadFile(capath)
+		if err != nil {
+			return nil, err
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", capath)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+
+	return &RemoteSigner{
+		url:    strings.TrimSuffix(signerURL, "/"),
+		token:  token,
+		client: &http.Client{Timeout: remoteTimeout, Transport: transport},
+	}, nil
+}
+
+// do sends a request to the signer and decodes its response into result
+func (s *RemoteSigner) do(method, path string, body, result any) error {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, s.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return ErrUnauthorized
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrUnknownKey, strings.TrimPrefix(e.Error, ErrUnknownKey.Error()+": "))
+		}
+
+		return fmt.Errorf("signer responded with %d: %s", resp.StatusCode, e.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (s *RemoteSigner) keys(paymaster common.Address) (*keysResponse, error) {
+	var keys keysResponse
+
+	err := s.do(http.MethodGet, fmt.Sprintf("/paymasters/%s", paymaster.Hex()), nil, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+// Sponsor returns the address of the key that signs for a paymaster
+func (s *RemoteSigner) Sponsor(paymaster common.Address) (common.Address, error) {
+	keys, err := s.keys(paymaster)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return keys.Sponsor, nil
+}
+
+// Submitters returns the addresses of the active keys that submit the bundles of a paymaster
+func (s *RemoteSigner) Submitters(paymaster common.Address) ([]common.Address, error) {
+	keys, err := s.keys(paymaster)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys.Submitters == nil {
+		return []common.Address{}, nil
+	}
+
+	return keys.Submitters, nil
+}
+
+// SignHash signs a hash as an EIP-191 message with the sponsor key of a paymaster
+func (s *RemoteSigner) SignHash(paymaster common.Address, hash []byte) ([]byte, error) {
+	var resp signHashResponse
+
+	err := s.do(http.MethodPost, fmt.Sprintf("/paymasters/%s/hash", paymaster.Hex()), &signHashRequest{Hash: hash}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Signature) != 65 {
+		return nil, fmt.Errorf("invalid signature length %d", len(resp.Signature))
+	}
+
+	return resp.Signature, nil
+}
+
+// SignTx signs a transaction with the sponsor key or a submitter key of a paymaster,
+// the signed transaction is checked to be the same transaction, sent from the given address
+func (s *RemoteSigner) SignTx(paymaster, from common.Address, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	b, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp signTxResponse
+
+	err = s.do(http.MethodPost, fmt.Sprintf("/paymasters/%s/tx", paymaster.Hex()), &signTxRequest{
+		From:    from,
+		ChainId: (*hexutil.Big)(chainId),
+		Tx:      b,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	signedTx := new(types.Transaction)
+	err = signedTx.UnmarshalBinary(resp.Tx)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := types.NewLondonSigner(chainId)
+	if signer.Hash(signedTx) != signer.Hash(tx) {
+		return nil, errors.New("the signer returned a different transaction")
+	}
+
+	sender, err := types.Sender(signer, signedTx)
+	if err != nil {
+		return nil, err
+	}
+
+	if sender != from {
+		return nil, fmt.Errorf("the transaction was signed by %s instead of %s", sender.Hex(), from.Hex())
+	}
+
+	return signedTx, nil
+}
@@ -0,0 +1,183 @@
+package signer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// Handler serves the keys of a Signer over the protocol that RemoteSigner speaks
+type Handler struct {
+	signer indexer.Signer
+	token  string
+}
+
+// NewHandler creates a new http.Handler for a Signer, requests must carry the token as a bearer token
+func NewHandler(s indexer.Signer, token string) http.Handler {
+	h := &Handler{
+		signer: s,
+		token:  token,
+	}
+
+	cr := chi.NewRouter()
+
+	cr.Use(h.authorize)
+
+	cr.Route("/paymasters/{paymaster}", func(cr chi.Router) {
+		cr.Get("/", h.Keys)
+		cr.Post("/hash", h.SignHash)
+		cr.Post("/tx", h.SignTx)
+	})
+
+	return cr
+}
+
+// authorize rejects requests that do not carry the token
+func (h *Handler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			respondError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func paymasterParam(r *http.Request) (common.Address, bool) {
+	pm := chi.URLParam(r, "paymaster")
+	if !common.IsHexAddress(pm) {
+		return common.Address{}, false
+	}
+
+	return common.HexToAddress(pm), true
+}
+
+// Keys returns the sponsor and submitter addresses of a paymaster
+func (h *Handler) Keys(w http.ResponseWriter, r *http.Request) {
+	pm, ok := paymasterParam(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, errors.New("invalid paymaster address"))
+		return
+	}
+
+	sponsor, err := h.signer.Sponsor(pm)
+	if err != nil {
+		respondSignerError(w, err)
+		return
+	}
+
+	submitters, err := h.signer.Submitters(pm)
+	if err != nil {
+		respondSignerError(w, err)
+		return
+	}
+
+	respond(w, &keysResponse{
+		Sponsor:    sponsor,
+		Submitters: submitters,
+	})
+}
+
+// SignHash signs a hash with the sponsor key of a paymaster
+func (h *Handler) SignHash(w http.ResponseWriter, r *http.Request) {
+	pm, ok := paymasterParam(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, errors.New("invalid paymaster address"))
+		return
+	}
+
+	var req signHashRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(req.Hash) == 0 {
+		respondError(w, http.StatusBadRequest, errors.New("hash is required"))
+		return
+	}
+
+	sig, err := h.signer.SignHash(pm, req.Hash)
+	if err != nil {
+		respondSignerError(w, err)
+		return
+	}
+
+	respond(w, &signHashResponse{
+		Signature: sig,
+	})
+}
+
+// SignTx signs a transaction with a key of a paymaster
+func (h *Handler) SignTx(w http.ResponseWriter, r *http.Request) {
+	pm, ok := paymasterParam(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, errors.New("invalid paymaster address"))
+		return
+	}
+
+	var req signTxRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.ChainId == nil {
+		respondError(w, http.StatusBadRequest, errors.New("chainId is required"))
+		return
+	}
+
+	tx := new(types.Transaction)
+	err = tx.UnmarshalBinary(req.Tx)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	signedTx, err := h.signer.SignTx(pm, req.From, tx, req.ChainId.ToInt())
+	if err != nil {
+		respondSignerError(w, err)
+		return
+	}
+
+	b, err := signedTx.MarshalBinary()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	respond(w, &signTxResponse{
+		Tx: b,
+	})
+}
+
+func respond(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func respondSignerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnknownKey) {
+		respondError(w, http.StatusNotFound, err)
+		return
+	}
+
+	respondError(w, http.StatusInternalServerError, err)
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&errorResponse{Error: err.Error()})
+}
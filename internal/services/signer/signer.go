@@ -0,0 +1,54 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// IsLoopback returns true if a host is only reachable from the machine itself
+func IsLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// signHash signs a hash as an EIP-191 message, v is 27 or 28 as the paymaster contracts expect
+func signHash(key *ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	sig, err := crypto.Sign(accounts.TextHash(hash), key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the v value is 27 or 28, this is because of the way Ethereum signature recovery works
+	if sig[crypto.RecoveryIDOffset] == 0 || sig[crypto.RecoveryIDOffset] == 1 {
+		sig[crypto.RecoveryIDOffset] += 27
+	}
+
+	return sig, nil
+}
+
+// signTx signs a transaction with the key of the given address
+func signTx(key *ecdsa.PrivateKey, from common.Address, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	if crypto.PubkeyToAddress(key.PublicKey) != from {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, from.Hex())
+	}
+
+	return types.SignTx(tx, types.NewLondonSigner(chainId), key)
+}
@@ -0,0 +1,193 @@
+package signer
+
+import (
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	mock := NewMockSigner()
+
+	key, err := mock.AddSponsor(paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submitterKey, err := mock.AddSubmitter(paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sponsor := crypto.PubkeyToAddress(key.PublicKey)
+	submitter := crypto.PubkeyToAddress(submitterKey.PublicKey)
+
+	server := httptest.NewServer(NewHandler(mock, "token"))
+	defer server.Close()
+
+	s, err := NewRemoteSigner(server.URL, "token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		addr, err := s.Sponsor(paymaster)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if addr != sponsor {
+			t.Fatalf("expected sponsor %s, got %s", sponsor.Hex(), addr.Hex())
+		}
+
+		addrs, err := s.Submitters(paymaster)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(addrs) != 1 || addrs[0] != submitter {
+			t.Fatalf("expected submitter %s, got %v", submitter.Hex(), addrs)
+		}
+	})
+
+	t.Run("SignHash", func(t *testing.T) {
+		hash := crypto.Keccak256([]byte("hello"))
+
+		sig, err := s.SignHash(paymaster, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sig[crypto.RecoveryIDOffset] != 27 && sig[crypto.RecoveryIDOffset] != 28 {
+			t.Fatalf("expected v to be 27 or 28, got %d", sig[crypto.RecoveryIDOffset])
+		}
+
+		sig[crypto.RecoveryIDOffset] -= 27
+
+		pubKey, err := crypto.SigToPub(accounts.TextHash(hash), sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if crypto.PubkeyToAddress(*pubKey) != sponsor {
+			t.Fatalf("expected the signature to recover to %s, got %s", sponsor.Hex(), crypto.PubkeyToAddress(*pubKey).Hex())
+		}
+	})
+
+	t.Run("SignTx", func(t *testing.T) {
+		chainId := big.NewInt(1337)
+
+		tx := types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainId,
+			Nonce:     1,
+			GasTipCap: big.NewInt(800),
+			GasFeeCap: big.NewInt(1600),
+			Gas:       21000,
+			To:        &paymaster,
+			Value:     big.NewInt(0),
+		})
+
+		for _, from := range []common.Address{sponsor, submitter} {
+			signedTx, err := s.SignTx(paymaster, from, tx, chainId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sender, err := types.Sender(types.NewLondonSigner(chainId), signedTx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sender != from {
+				t.Fatalf("expected the tx to be sent from %s, got %s", from.Hex(), sender.Hex())
+			}
+		}
+
+		_, err := s.SignTx(paymaster, common.HexToAddress("0x0000000000000000000000000000000000000002"), tx, chainId)
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("expected an unknown key error, got %v", err)
+		}
+	})
+
+	t.Run("Unknown paymaster", func(t *testing.T) {
+		_, err := s.Sponsor(common.HexToAddress("0x0000000000000000000000000000000000000003"))
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("expected an unknown key error, got %v", err)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		wrong, err := NewRemoteSigner(server.URL, "wrong", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = wrong.Sponsor(paymaster)
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expected an unauthorized error, got %v", err)
+		}
+	})
+}
+
+func TestRemoteSignerTLS(t *testing.T) {
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	mock := NewMockSigner()
+
+	key, err := mock.AddSponsor(paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewTLSServer(NewHandler(mock, "token"))
+	defer server.Close()
+
+	// the certificate of the test server is self-signed, it is its own ca
+	capath := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(capath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewRemoteSigner(server.URL, "token", capath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := s.Sponsor(paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("expected sponsor %s, got %s", crypto.PubkeyToAddress(key.PublicKey).Hex(), addr.Hex())
+	}
+
+	// without the ca the certificate is not trusted
+	untrusted, err := NewRemoteSigner(server.URL, "token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = untrusted.Sponsor(paymaster)
+	if err == nil {
+		t.Fatal("expected the certificate of the signer to be rejected")
+	}
+
+	// a signer that is not on the machine is only reached over https
+	_, err = NewRemoteSigner("http://signer:3002", "token", "")
+	if err == nil {
+		t.Fatal("expected a plain http signer url to be rejected")
+	}
+}
@@ -0,0 +1,227 @@
+package sponsorships
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxSponsorshipsLimit = 100
+)
+
+type Service struct {
+	db     *db.DB
+	admins []common.Address
+}
+
+func NewService(db *db.DB, admins []common.Address) *Service {
+	return &Service{
+		db:     db,
+		admins: admins,
+	}
+}
+
+type sponsorshipsUpdate struct {
+	Updated int64 `json:"updated"`
+}
+
+// GetAll godoc
+//
+//	@Summary		Fetch out of order sponsorships of a paymaster
+//	@Description	get the signatures that pm_ooSponsorUserOperation issued for a paymaster, newest first. The request should be signed by an admin.
+//	@Tags			sponsorships
+//	@Accept			json
+//	@Produce		json
+//	@Param			pm_address	path		string	true	"Paymaster Contract Address"
+//	@Param			batch	query		string	false	"Only include the sponsorships of a batch"
+//	@Param			account	query		string	false	"Only include the sponsorships of an account"
+//	@Param			nonce_key	query		string	false	"Only include the sponsorship of a nonce key"
+//	@Param			status	query		string	false	"Only include the sponsorships with a status (outstanding, used, revoked or expired)"
+//	@Param			limit	query		int	false	"Limit"
+//	@Param			offset	query		int	false	"Offset"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/sponsorships/{pm_address} [get]
+func (s *Service) GetAll(w http.ResponseWriter, r *http.Request) {
+	paymaster, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+
+	f, ok := parseFilter(w, indexer.SponsorshipFilter{
+		Batch:    q.Get("batch"),
+		Account:  q.Get("account"),
+		NonceKey: q.Get("nonce_key"),
+		Status:   indexer.SponsorshipStatus(q.Get("status")),
+	})
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > maxSponsorshipsLimit {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	sponsorships, total, err := s.db.SponsorshipDB.GetSponsorships(paymaster, f, limit, offset)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the sponsorships", nil)
+		return
+	}
+
+	err = com.BodyMultiple(w, sponsorships, com.Pagination{Limit: limit, Offset: offset, Total: total})
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// Revoke godoc
+//
+//	@Summary		Revoke out of order sponsorships of a paymaster
+//	@Description	revoke the outstanding sponsorships that match a batch, an account or a nonce key, and the used ones whose op was not sent yet. The bundler rejects their ops. The request should be signed by an admin.
+//	@Tags			sponsorships
+//	@Accept			json
+//	@Produce		json
+//	@Param			pm_address	path		string	true	"Paymaster Contract Address"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/sponsorships/{pm_address}/revoke [post]
+func (s *Service) Revoke(w http.ResponseWriter, r *http.Request) {
+	s.update(w, r, s.db.SponsorshipDB.RevokeSponsorships)
+}
+
+// Expire godoc
+//
+//	@Summary		Expire out of order sponsorships of a paymaster
+//	@Description	end the validity of the outstanding sponsorships that match a batch, an account or a nonce key now, and of the used ones whose op was not sent yet. The request should be signed by an admin.
+//	@Tags			sponsorships
+//	@Accept			json
+//	@Produce		json
+//	@Param			pm_address	path		string	true	"Paymaster Contract Address"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/sponsorships/{pm_address}/expire [post]
+func (s *Service) Expire(w http.ResponseWriter, r *http.Request) {
+	s.update(w, r, s.db.SponsorshipDB.ExpireSponsorships)
+}
+
+// update applies a change to the sponsorships that match the filter of the body and can still change
+func (s *Service) update(w http.ResponseWriter, r *http.Request, apply func(string, indexer.SponsorshipFilter) (int64, error)) {
+	paymaster, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var body indexer.SponsorshipFilter
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeBadRequest, "invalid request body", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	// the sponsorships that can change are chosen by the update
+	body.Status = ""
+
+	f, ok := parseFilter(w, body)
+	if !ok {
+		return
+	}
+
+	// an empty filter would match every sponsorship of the paymaster
+	if f.Batch == "" && f.Account == "" && f.NonceKey == "" {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "a batch, an account or a nonce key is required", com.ParamDetails{Param: "batch"})
+		return
+	}
+
+	n, err := apply(paymaster, f)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not update the sponsorships", nil)
+		return
+	}
+
+	err = com.Body(w, &sponsorshipsUpdate{Updated: n}, nil)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// authorize checks that the request was signed by one of the configured admins, an error response is written if not
+func (s *Service) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	addr, ok := com.GetContextAddress(r.Context())
+	if !ok {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeMissingSignature, "missing signed address", nil)
+		return "", false
+	}
+
+	// parse paymaster address from url params
+	pmq := chi.URLParam(r, "pm_address")
+	if !common.IsHexAddress(pmq) {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid paymaster address", com.ParamDetails{Param: "pm_address"})
+		return "", false
+	}
+
+	if !slices.Contains(s.admins, common.HexToAddress(addr)) {
+		com.ErrorBody(w, http.StatusUnauthorized, com.ErrorCodeUnauthorized, "the signed address is not an admin", nil)
+		return "", false
+	}
+
+	return common.HexToAddress(pmq).Hex(), true
+}
+
+// parseFilter normalizes the addresses and the nonce key of a filter, an error response is written if they are invalid
+func parseFilter(w http.ResponseWriter, f indexer.SponsorshipFilter) (indexer.SponsorshipFilter, bool) {
+	if f.Account != "" {
+		if !common.IsHexAddress(f.Account) {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidAddress, "invalid account address", com.ParamDetails{Param: "account"})
+			return f, false
+		}
+
+		f.Account = common.HexToAddress(f.Account).Hex()
+	}
+
+	if f.NonceKey != "" {
+		key, err := hexutil.DecodeBig(f.NonceKey)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid nonce key, expected a hex number", com.ParamDetails{Param: "nonce_key"})
+			return f, false
+		}
+
+		f.NonceKey = hexutil.EncodeBig(key)
+	}
+
+	if f.Status != "" {
+		_, err := indexer.SponsorshipStatusFromString(string(f.Status))
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, err.Error(), com.ParamDetails{Param: "status"})
+			return f, false
+		}
+	}
+
+	return f, true
+}
@@ -0,0 +1,63 @@
+package sponsorships
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestUpdate(t *testing.T) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	admin := common.HexToAddress("0x0000000000000000000000000000000000000003")
+
+	s := NewService(d, []common.Address{admin})
+
+	cases := []struct {
+		name   string
+		signed string
+		body   string
+		status int
+	}{
+		{"empty filter", admin.Hex(), `{}`, http.StatusBadRequest},
+		{"status only", admin.Hex(), `{"status": "outstanding"}`, http.StatusBadRequest},
+		{"invalid account", admin.Hex(), `{"account": "0x01"}`, http.StatusBadRequest},
+		{"not an admin", "0x0000000000000000000000000000000000000002", `{"batch": "0x01"}`, http.StatusUnauthorized},
+		{"batch", admin.Hex(), `{"batch": "0x01"}`, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		for name, handler := range map[string]http.HandlerFunc{"revoke": s.Revoke, "expire": s.Expire} {
+			t.Run(name+" "+c.name, func(t *testing.T) {
+				rctx := chi.NewRouteContext()
+				rctx.URLParams.Add("pm_address", paymaster.Hex())
+
+				ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+				ctx = context.WithValue(ctx, indexer.ContextKeyAddress, c.signed)
+
+				req := httptest.NewRequest(http.MethodPost, "/sponsorships/"+paymaster.Hex()+"/"+name, strings.NewReader(c.body)).WithContext(ctx)
+				w := httptest.NewRecorder()
+
+				handler(w, req)
+
+				if w.Code != c.status {
+					t.Errorf("status = %d, want %d", w.Code, c.status)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxStatsBuckets = 1000
+)
+
+type Service struct {
+	db *db.DB
+}
+
+func NewService(db *db.DB) *Service {
+	return &Service{
+		db: db,
+	}
+}
+
+// Get godoc
+//
+//	@Summary		Fetch token stats
+//	@Description	get aggregated stats of a token for every day or hour of a window
+//	@Tags			stats
+//	@Accept			json
+//	@Produce		json
+//	@Param			token_address	path		string	true	"Token Contract Address"
+//	@Param			period	query		string	false	"Size of the buckets (day or hour)"
+//	@Param			from	query		string	false	"Start of the window (RFC3339)"
+//	@Param			to	query		string	false	"End of the window (RFC3339)"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/stats/{token_address} [get]
+func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
+	// parse contract address from url params
+	contractAddr := chi.URLParam(r, "token_address")
+
+	// parse period from url query
+	periodq := r.URL.Query().Get("period")
+	if periodq == "" {
+		periodq = string(indexer.StatsPeriodDay)
+	}
+
+	period, err := indexer.StatsPeriodFromString(periodq)
+	if err != nil {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, err.Error(), com.ParamDetails{Param: "period"})
+		return
+	}
+
+	// parse window from url query, defaults to the last 30 buckets
+	to := time.Now().UTC()
+	if toq, _ := url.QueryUnescape(r.URL.Query().Get("to")); toq != "" {
+		t, err := time.Parse(time.RFC3339, toq)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid to date, expected RFC3339", com.ParamDetails{Param: "to"})
+			return
+		}
+
+		to = t.UTC()
+	}
+
+	from := to.Add(-29 * period.Duration())
+	if fromq, _ := url.QueryUnescape(r.URL.Query().Get("from")); fromq != "" {
+		t, err := time.Parse(time.RFC3339, fromq)
+		if err != nil {
+			com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, "invalid from date, expected RFC3339", com.ParamDetails{Param: "from"})
+			return
+		}
+
+		from = t.UTC()
+	}
+
+	if from.After(to) || to.Sub(from)/period.Duration() >= maxStatsBuckets {
+		com.ErrorBody(w, http.StatusBadRequest, com.ErrorCodeInvalidParam, fmt.Sprintf("from must be before to and the window at most %d periods", maxStatsBuckets), com.ParamDetails{Param: "from"})
+		return
+	}
+
+	tokenIdq := r.URL.Query().Get("tokenId")
+	tokenId, err := strconv.Atoi(tokenIdq)
+	if err != nil {
+		tokenId = 0
+	}
+
+	sdb, ok := s.db.GetStatsDB(contractAddr)
+	if !ok {
+		com.ErrorBody(w, http.StatusNotFound, com.ErrorCodeTokenNotFound, "token is not indexed", nil)
+		return
+	}
+
+	stats, err := sdb.GetStats(int64(tokenId), period, from, to)
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not get the stats", nil)
+		return
+	}
+
+	err = com.BodyMultiple(w, stats, com.Pagination{Limit: len(stats), Offset: 0, Total: len(stats)})
+	if err != nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
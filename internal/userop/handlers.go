@@ -1,9 +1,10 @@
 package userop
 
 import (
-	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"math/big"
 	"net/http"
 	"time"
@@ -12,7 +13,6 @@ import (
 	"github.com/citizenwallet/indexer/internal/services/db"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	"github.com/citizenwallet/indexer/pkg/queue"
-	pay "github.com/citizenwallet/smartcontracts/pkg/contracts/paymaster"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
@@ -23,21 +23,150 @@ import (
 type Service struct {
 	evm     indexer.EVMRequester
 	db      *db.DB
+	signer  indexer.Signer
 	useropq *queue.Service
 	chainId *big.Int
+	eps     indexer.EntryPoints
 }
 
 // NewService
-func NewService(evm indexer.EVMRequester, db *db.DB, useropq *queue.Service, chid *big.Int) *Service {
+func NewService(evm indexer.EVMRequester, db *db.DB, signer indexer.Signer, useropq *queue.Service, chid *big.Int, eps indexer.EntryPoints) *Service {
 	return &Service{
 		evm,
 		db,
+		signer,
 		useropq,
 		chid,
+		eps,
 	}
 }
 
-func (s *Service) Send(r *http.Request) (any, int) {
+// Send validates a user operation and waits for it to be submitted, the hash of the bundle transaction is returned
+func (s *Service) Send(r *http.Request) (any, error) {
+	txm, err := s.validate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	txdata, _ := txm.ExtraData.(*indexer.TransferData)
+
+	// Create a new message
+	message := indexer.NewTxMessage(txm.Paymaster, txm.EntryPoint, txm.Version, txm.ChainId, txm.UserOp, txdata)
+
+	hash, rec, err := s.enqueue(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec != nil {
+		if rec.TxHash != "" {
+			return rec.TxHash, nil
+		}
+
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInternal, "the user operation is still queued", map[string]string{"userOpHash": hash})
+	}
+
+	resp, err := message.WaitForResponse()
+	if errors.Is(err, indexer.ErrResponseTimeout) {
+		// the op can still be sent, clients can follow it by its hash
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInternal, "the user operation is still queued", map[string]string{"userOpHash": hash})
+	}
+	if err != nil {
+		return nil, comm.RejectedError(err.Error(), err)
+	}
+
+	txHash, ok := resp.(string)
+	if !ok {
+		return nil, errors.New("unexpected response from the queue")
+	}
+
+	// Return the message ID
+	return txHash, nil
+}
+
+// SendAsync validates and enqueues a user operation, the hash of the op is returned without waiting for it to be submitted
+func (s *Service) SendAsync(r *http.Request) (any, error) {
+	txm, err := s.validate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	txdata, _ := txm.ExtraData.(*indexer.TransferData)
+
+	message := indexer.NewAsyncTxMessage(txm.Paymaster, txm.EntryPoint, txm.Version, txm.ChainId, txm.UserOp, txdata)
+
+	hash, _, err := s.enqueue(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// enqueue marks the user operation of the message as queued and adds it to the queue, the hash of the op is returned.
+// An op that is already known is not queued again unless it can be resent, its record is returned instead.
+func (s *Service) enqueue(message *indexer.Message) (string, *indexer.UserOpRecord, error) {
+	txm, ok := message.Message.(indexer.UserOpMessage)
+	if !ok {
+		return "", nil, errors.New("invalid user operation message")
+	}
+
+	hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rec, err := s.db.UserOpDB.GetUserOp(hash.Hex())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", nil, err
+	}
+
+	// a scheduled op is submitted by the scheduler once it is due
+	if err == nil && rec.Status == indexer.UserOpStatusScheduled {
+		return "", nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "the user operation is scheduled", map[string]string{"userOpHash": hash.Hex()})
+	}
+
+	if err == nil && !resendable(rec) {
+		return hash.Hex(), rec, nil
+	}
+
+	// the op counts towards the budgets of the policy of its paymaster once it is submitted
+	err = queue.UseBudget(s.db, txm)
+	if err != nil {
+		return "", nil, err
+	}
+
+	queue.SetUserOpStatus(s.db, txm, "", indexer.UserOpStatusQueued, nil)
+
+	// Enqueue the message, it is stored before the client gets its hash
+	err = s.useropq.Enqueue(*message)
+	if err != nil {
+		queue.SetUserOpStatus(s.db, txm, "", indexer.UserOpStatusFail, err)
+		return "", nil, err
+	}
+
+	// an out of order sponsorship is used once its op is queued
+	queue.SetSponsorshipUsed(s.db, txm, hash.Hex())
+
+	return hash.Hex(), nil, nil
+}
+
+// resendable returns true if a known user operation can be queued again, only ops that were never sent and are no longer queued can
+func resendable(rec *indexer.UserOpRecord) bool {
+	if rec.TxHash != "" {
+		return false
+	}
+
+	switch rec.Status {
+	case indexer.UserOpStatusFail, indexer.UserOpStatusCancelled:
+		return true
+	}
+
+	return false
+}
+
+// validate parses the params of a user operation and checks its paymaster signature
+func (s *Service) validate(r *http.Request) (*indexer.UserOpMessage, error) {
 	// parse contract address from url params
 	contractAddr := chi.URLParam(r, "pm_address")
 
@@ -46,18 +175,12 @@ func (s *Service) Send(r *http.Request) (any, int) {
 	// Get the contract's bytecode
 	bytecode, err := s.evm.CodeAt(context.Background(), addr, nil)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
 	// Check if the contract is deployed
 	if len(bytecode) == 0 {
-		return nil, http.StatusBadRequest
-	}
-
-	// instantiate paymaster contract
-	pm, err := pay.NewPaymaster(addr, s.evm.Backend())
-	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "paymaster is not deployed", nil)
 	}
 
 	// parse the incoming params
@@ -65,7 +188,7 @@ func (s *Service) Send(r *http.Request) (any, int) {
 	var params []any
 	err = json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "params should be an array", nil)
 	}
 
 	var userop indexer.UserOp
@@ -77,93 +200,77 @@ func (s *Service) Send(r *http.Request) (any, int) {
 		case 0:
 			v, ok := param.(map[string]any)
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 			b, err := json.Marshal(v)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 
 			err = json.Unmarshal(b, &userop)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
 			}
 		case 1:
 			v, ok := param.(string)
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid entry point", nil)
 			}
 
 			epAddr = v
 		case 2:
+			if param == nil {
+				// the transfer data is optional
+				continue
+			}
+
 			v, ok := param.(map[string]any)
 			if !ok {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid transfer data", nil)
 			}
 
 			b, err := json.Marshal(v)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid transfer data", nil)
 			}
 
 			err = json.Unmarshal(b, &txdata)
 			if err != nil {
-				return nil, http.StatusBadRequest
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid transfer data", nil)
 			}
 		}
 	}
 
 	if epAddr == "" {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "missing entry point", nil)
 	}
 
-	// check the paymaster signature, make sure it matches the paymaster address
-
-	// unpack the validity and check if it is valid
-	// Define the arguments
-	uint48Ty, _ := abi.NewType("uint48", "uint48", nil)
-	args := abi.Arguments{
-		abi.Argument{
-			Type: uint48Ty,
-		},
-		abi.Argument{
-			Type: uint48Ty,
-		},
-	}
+	entryPoint := common.HexToAddress(epAddr)
+	version := s.eps.Version(entryPoint)
 
-	// Encode the values
-	validity, err := args.Unpack(userop.PaymasterAndData[20:84])
+	// check the paymaster signature, make sure it matches the paymaster address
+	validUntil, validAfter, paymasterSig, err := paymasterValidity(version, userop.PaymasterAndData)
 	if err != nil {
-		return nil, http.StatusInternalServerError
-	}
-
-	validUntil, ok := validity[0].(*big.Int)
-	if !ok {
-		return nil, http.StatusInternalServerError
-	}
-
-	validAfter, ok := validity[1].(*big.Int)
-	if !ok {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
 	// check if the signature is theoretically still valid
 	now := time.Now().Unix()
 	if validUntil.Int64() < now || validAfter.Int64() > now {
-		return nil, http.StatusBadRequest
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeShortDeadline, "the paymaster signature has expired or is not valid yet", nil)
 	}
 
 	// Get the hash of the message that was signed
-	hash, err := pm.GetHash(nil, pay.UserOperation(userop), validUntil, validAfter)
+	hash, err := comm.PaymasterHash(s.evm, addr, version, userop, validUntil, validAfter)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
 	// Convert the hash to an Ethereum signed message hash
 	hhash := accounts.TextHash(hash[:])
 
-	sig := make([]byte, len(userop.PaymasterAndData[84:]))
-	copy(sig, userop.PaymasterAndData[84:])
+	sig := make([]byte, len(paymasterSig))
+	copy(sig, paymasterSig)
 
 	// update the signature v to undo the 27/28 addition
 	sig[crypto.RecoveryIDOffset] -= 27
@@ -171,47 +278,111 @@ func (s *Service) Send(r *http.Request) (any, int) {
 	// recover the public key from the signature
 	sigPublicKey, err := crypto.Ecrecover(hhash, sig)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "invalid paymaster signature", nil)
 	}
 
-	// fetch the sponsor's corresponding private key from the db
-	sponsorKey, err := s.db.SponsorDB.GetSponsor(addr.Hex())
+	pubKey, err := crypto.UnmarshalPubkey(sigPublicKey)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "invalid paymaster signature", nil)
 	}
 
-	// Generate ecdsa.PrivateKey from bytes
-	privateKey, err := comm.HexToPrivateKey(sponsorKey.PrivateKey)
+	// fetch the address of the sponsor from the signer
+	sponsor, err := s.signer.Sponsor(addr)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, err
 	}
 
-	publicKeyBytes := crypto.FromECDSAPub(&privateKey.PublicKey)
+	// check if the sponsor matches the recovered address
+	if crypto.PubkeyToAddress(*pubKey) != sponsor {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "invalid paymaster signature", nil)
+	}
 
-	// check if the public key matches the recovered public key
-	matches := bytes.Equal(sigPublicKey, publicKeyBytes)
-	if !matches {
-		return nil, http.StatusBadRequest
+	txm := &indexer.UserOpMessage{
+		Paymaster:  addr,
+		EntryPoint: entryPoint,
+		Version:    version,
+		ChainId:    s.chainId,
+		UserOp:     userop,
+		ExtraData:  txdata,
 	}
 
-	entryPoint := common.HexToAddress(epAddr)
+	// out of order sponsorships can be revoked or expired before their signature expires
+	err = queue.CheckSponsorship(s.db, *txm)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create a new message
-	message := indexer.NewTxMessage(addr, entryPoint, s.chainId, userop, txdata)
+	return txm, nil
+}
 
-	// Enqueue the message
-	s.useropq.Enqueue(*message)
+// paymasterValidity returns the validity and the signature in the paymaster data of an op
+func paymasterValidity(version indexer.EntryPointVersion, paymasterAndData []byte) (*big.Int, *big.Int, []byte, error) {
+	validityData, paymasterSig, err := comm.SplitPaymasterData(version, paymasterAndData)
+	if err != nil {
+		return nil, nil, nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "paymaster data could not be decoded", nil)
+	}
 
-	resp, err := message.WaitForResponse()
+	// unpack the validity
+	// Define the arguments
+	uint48Ty, _ := abi.NewType("uint48", "uint48", nil)
+	args := abi.Arguments{
+		abi.Argument{
+			Type: uint48Ty,
+		},
+		abi.Argument{
+			Type: uint48Ty,
+		},
+	}
+
+	// Decode the values
+	validity, err := args.Unpack(validityData)
 	if err != nil {
-		return nil, http.StatusInternalServerError
+		return nil, nil, nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "paymaster data could not be decoded", nil)
 	}
 
-	txHash, ok := resp.(string)
+	validUntil, ok := validity[0].(*big.Int)
 	if !ok {
-		return nil, http.StatusInternalServerError
+		return nil, nil, nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "paymaster data could not be decoded", nil)
 	}
 
-	// Return the message ID
-	return txHash, http.StatusOK
+	validAfter, ok := validity[1].(*big.Int)
+	if !ok {
+		return nil, nil, nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodePaymasterRejected, "paymaster data could not be decoded", nil)
+	}
+
+	return validUntil, validAfter, paymasterSig, nil
+}
+
+// Estimate estimates the gas values of a user operation by simulating it against the entry point
+func (s *Service) Estimate(r *http.Request) (any, error) {
+	var params []json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "params should be an array", nil)
+	}
+
+	if len(params) < 2 {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "missing entry point", nil)
+	}
+
+	var userop indexer.UserOp
+	err = json.Unmarshal(params[0], &userop)
+	if err != nil {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation", nil)
+	}
+
+	var epAddr string
+	err = json.Unmarshal(params[1], &epAddr)
+	if err != nil || !common.IsHexAddress(epAddr) {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid entry point", nil)
+	}
+
+	entryPoint := common.HexToAddress(epAddr)
+
+	estimate, err := comm.EstimateUserOpGas(s.evm, entryPoint, s.eps.Version(entryPoint), userop)
+	if err != nil {
+		return nil, err
+	}
+
+	return estimate, nil
 }
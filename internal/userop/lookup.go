@@ -0,0 +1,226 @@
+package userop
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"time"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type userOpByHash struct {
+	UserOperation   any            `json:"userOperation"` // the op is encoded for the version of its entry point
+	EntryPoint      common.Address `json:"entryPoint"`
+	BlockNumber     *hexutil.Big   `json:"blockNumber"`
+	BlockHash       *common.Hash   `json:"blockHash"`
+	TransactionHash *common.Hash   `json:"transactionHash"`
+}
+
+type userOpReceipt struct {
+	UserOpHash    common.Hash    `json:"userOpHash"`
+	EntryPoint    common.Address `json:"entryPoint"`
+	Sender        common.Address `json:"sender"`
+	Nonce         *hexutil.Big   `json:"nonce"`
+	Paymaster     common.Address `json:"paymaster"`
+	ActualGasCost *hexutil.Big   `json:"actualGasCost"`
+	ActualGasUsed *hexutil.Big   `json:"actualGasUsed"`
+	Success       bool           `json:"success"`
+	Reason        string         `json:"reason,omitempty"`
+	Logs          []*types.Log   `json:"logs"`
+	Receipt       *types.Receipt `json:"receipt"`
+}
+
+type userOpStatus struct {
+	UserOpHash      common.Hash          `json:"userOpHash"`
+	Status          indexer.UserOpStatus `json:"status"`
+	TransactionHash *common.Hash         `json:"transactionHash"`
+	Error           string               `json:"error,omitempty"`
+	UpdatedAt       time.Time            `json:"updatedAt"`
+}
+
+// Status returns the status of a user operation, null if it is unknown
+func (s *Service) Status(r *http.Request) (any, error) {
+	rec, err := s.getRecord(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, nil
+	}
+
+	res := &userOpStatus{
+		UserOpHash: common.HexToHash(rec.Hash),
+		Status:     rec.Status,
+		Error:      rec.Error,
+		UpdatedAt:  rec.UpdatedAt,
+	}
+
+	if rec.TxHash != "" {
+		txHash := common.HexToHash(rec.TxHash)
+		res.TransactionHash = &txHash
+	}
+
+	return res, nil
+}
+
+// GetByHash returns a user operation that was submitted through the bundler, null if it is unknown
+func (s *Service) GetByHash(r *http.Request) (any, error) {
+	rec, err := s.getRecord(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, nil
+	}
+
+	res := &userOpByHash{
+		UserOperation: &rec.UserOp,
+		EntryPoint:    common.HexToAddress(rec.EntryPoint),
+	}
+
+	if s.eps.Version(res.EntryPoint) == indexer.EntryPointV07 {
+		res.UserOperation = (*indexer.UserOpV07)(&rec.UserOp)
+	}
+
+	rcpt, err := s.receipt(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if rcpt != nil {
+		res.BlockNumber = (*hexutil.Big)(rcpt.BlockNumber)
+		res.BlockHash = &rcpt.BlockHash
+		res.TransactionHash = &rcpt.TxHash
+	}
+
+	return res, nil
+}
+
+// GetReceipt returns the receipt of a user operation, null if it is unknown or not mined yet
+func (s *Service) GetReceipt(r *http.Request) (any, error) {
+	rec, err := s.getRecord(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, nil
+	}
+
+	rcpt, err := s.receipt(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if rcpt == nil {
+		return nil, nil
+	}
+
+	return parseReceipt(rec, rcpt)
+}
+
+// SupportedEntryPoints returns the entry points that the bundler is configured with
+func (s *Service) SupportedEntryPoints(r *http.Request) (any, error) {
+	return s.eps.Addresses(), nil
+}
+
+// getRecord parses the hash in the params and fetches the op, nil if it is unknown
+func (s *Service) getRecord(r *http.Request) (*indexer.UserOpRecord, error) {
+	var params []string
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || len(params) == 0 {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "params should be an array with a user operation hash", nil)
+	}
+
+	b, err := hexutil.Decode(params[0])
+	if err != nil || len(b) != common.HashLength {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid user operation hash", nil)
+	}
+
+	rec, err := s.db.UserOpDB.GetUserOp(common.BytesToHash(b).Hex())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// receipt fetches the receipt of the bundle of the op, nil if it was not sent or is not mined yet
+func (s *Service) receipt(rec *indexer.UserOpRecord) (*types.Receipt, error) {
+	if rec.TxHash == "" {
+		return nil, nil
+	}
+
+	rcpt, err := s.evm.TransactionReceipt(common.HexToHash(rec.TxHash))
+	if err != nil {
+		if errors.Is(err, ethereum.NotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return rcpt, nil
+}
+
+// parseReceipt reads the result of the op from the UserOperationEvent of the bundle,
+// entry points which do not emit it are given the result of the bundle
+func parseReceipt(rec *indexer.UserOpRecord, rcpt *types.Receipt) (*userOpReceipt, error) {
+	hash := common.HexToHash(rec.Hash)
+
+	res := &userOpReceipt{
+		UserOpHash: hash,
+		EntryPoint: common.HexToAddress(rec.EntryPoint),
+		Sender:     rec.UserOp.Sender,
+		Nonce:      (*hexutil.Big)(rec.UserOp.Nonce),
+		Paymaster:  common.HexToAddress(rec.Paymaster),
+		Success:    rcpt.Status == types.ReceiptStatusSuccessful,
+		Logs:       rcpt.Logs,
+		Receipt:    rcpt,
+	}
+
+	result, err := comm.ParseUserOpResult(rcpt.Logs, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if result != nil {
+		res.Paymaster = result.Paymaster
+		res.Success = result.Success
+		res.ActualGasCost = (*hexutil.Big)(result.ActualGasCost)
+		res.ActualGasUsed = (*hexutil.Big)(result.ActualGasUsed)
+		res.Reason = result.Reason
+		res.Logs = result.Logs
+
+		return res, nil
+	}
+
+	gasPrice := rcpt.EffectiveGasPrice
+	if gasPrice == nil {
+		gasPrice = big.NewInt(0)
+	}
+
+	gasUsed := new(big.Int).SetUint64(rcpt.GasUsed)
+	res.ActualGasUsed = (*hexutil.Big)(gasUsed)
+	res.ActualGasCost = (*hexutil.Big)(new(big.Int).Mul(gasUsed, gasPrice))
+
+	if !res.Success {
+		res.Reason = rec.Error
+	}
+
+	return res, nil
+}
@@ -0,0 +1,275 @@
+package userop
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/indexer/pkg/queue"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxScheduledUserOps = 100 // how many ops an account can have scheduled with a paymaster at a time
+)
+
+type scheduleParams struct {
+	NotBefore int64 `json:"notBefore"`
+	Balance   *struct {
+		Token  string       `json:"token"`
+		Amount *hexutil.Big `json:"amount"`
+	} `json:"balance"`
+}
+
+// Schedule validates a user operation and stores it to be submitted once it is due, the hash of the op is returned.
+// The params are the ones of eth_sendUserOperation followed by when the op is due.
+func (s *Service) Schedule(r *http.Request) (any, error) {
+	// the body is read twice, the op is validated like any other before the schedule is parsed
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "params should be an array", nil)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	txm, err := s.validate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var params []json.RawMessage
+	err = json.Unmarshal(body, &params)
+	if err != nil || len(params) < 4 {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "missing schedule", nil)
+	}
+
+	var sp scheduleParams
+	err = json.Unmarshal(params[3], &sp)
+	if err != nil {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid schedule", nil)
+	}
+
+	if sp.NotBefore <= 0 && sp.Balance == nil {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "a schedule needs a notBefore time or a balance", nil)
+	}
+
+	var balance *indexer.ScheduleBalance
+	if sp.Balance != nil {
+		if !common.IsHexAddress(sp.Balance.Token) {
+			return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid balance token", nil)
+		}
+
+		if sp.Balance.Amount == nil || sp.Balance.Amount.ToInt().Sign() <= 0 {
+			return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid balance amount", nil)
+		}
+
+		balance = &indexer.ScheduleBalance{
+			Token:  common.HexToAddress(sp.Balance.Token).Hex(),
+			Amount: sp.Balance.Amount,
+		}
+	}
+
+	// the op can only be submitted while the paymaster signature is valid
+	validUntil, _, _, err := paymasterValidity(txm.Version, txm.UserOp.PaymasterAndData)
+	if err != nil {
+		return nil, err
+	}
+
+	if validUntil.Int64() <= sp.NotBefore {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeShortDeadline, "the paymaster signature expires before the user operation is due", nil)
+	}
+
+	hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+	if err != nil {
+		return nil, err
+	}
+
+	// an op that was already scheduled or sent keeps its status
+	_, err = s.db.UserOpDB.GetUserOp(hash.Hex())
+	if err == nil {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "the user operation is already known", map[string]string{"userOpHash": hash.Hex()})
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	_, total, err := s.db.ScheduleDB.GetScheduledUserOps(txm.Paymaster.Hex(), txm.UserOp.Sender.Hex(), indexer.ScheduleStatusScheduled, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if total >= maxScheduledUserOps {
+		return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "too many scheduled user operations", nil)
+	}
+
+	txdata, _ := txm.ExtraData.(*indexer.TransferData)
+
+	err = s.db.ScheduleDB.AddScheduledUserOp(&indexer.ScheduledUserOp{
+		Hash:       hash.Hex(),
+		Paymaster:  txm.Paymaster.Hex(),
+		EntryPoint: txm.EntryPoint.Hex(),
+		Version:    txm.Version,
+		UserOp:     txm.UserOp,
+		ExtraData:  txdata,
+		NotBefore:  sp.NotBefore,
+		Balance:    balance,
+		ValidUntil: validUntil.Int64(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	queue.SetUserOpStatus(s.db, *txm, "", indexer.UserOpStatusScheduled, nil)
+
+	return hash.Hex(), nil
+}
+
+// GetScheduled godoc
+//
+//	@Summary		Fetch scheduled user operations of an account
+//	@Description	get the user operations that an account scheduled with a paymaster, newest first. The request should be signed by the account.
+//	@Tags			userops
+//	@Accept			json
+//	@Produce		json
+//	@Param			pm_address	path		string	true	"Paymaster Contract Address"
+//	@Param			acc_addr	path		string	true	"Account Address"
+//	@Param			status	query		string	false	"Only include the ops with a status (scheduled, submitted, cancelled or failed)"
+//	@Param			limit	query		int	false	"Limit"
+//	@Param			offset	query		int	false	"Offset"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/scheduled/{pm_address}/{acc_addr} [get]
+func (s *Service) GetScheduled(w http.ResponseWriter, r *http.Request) {
+	paymaster, account, ok := scheduleAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var status indexer.ScheduleStatus
+	if statusq := r.URL.Query().Get("status"); statusq != "" {
+		st, err := indexer.ScheduleStatusFromString(statusq)
+		if err != nil {
+			comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeInvalidParam, err.Error(), comm.ParamDetails{Param: "status"})
+			return
+		}
+
+		status = st
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxScheduledUserOps {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	ops, total, err := s.db.ScheduleDB.GetScheduledUserOps(paymaster, account, status, limit, offset)
+	if err != nil {
+		comm.ErrorBody(w, http.StatusInternalServerError, comm.ErrorCodeInternal, "could not get the scheduled user operations", nil)
+		return
+	}
+
+	err = comm.BodyMultiple(w, ops, comm.Pagination{Limit: limit, Offset: offset, Total: total})
+	if err != nil {
+		comm.ErrorBody(w, http.StatusInternalServerError, comm.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// CancelScheduled godoc
+//
+//	@Summary		Cancel a scheduled user operation
+//	@Description	cancel a user operation that an account scheduled, as long as it was not submitted. The request should be signed by the account.
+//	@Tags			userops
+//	@Accept			json
+//	@Produce		json
+//	@Param			pm_address	path		string	true	"Paymaster Contract Address"
+//	@Param			acc_addr	path		string	true	"Account Address"
+//	@Param			hash	path		string	true	"User Operation Hash"
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		200	{object}	common.Response
+//	@Failure		400	{object}	common.Response
+//	@Failure		401	{object}	common.Response
+//	@Failure		404	{object}	common.Response
+//	@Failure		409	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/scheduled/{pm_address}/{acc_addr}/{hash} [delete]
+func (s *Service) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	paymaster, account, ok := scheduleAccount(w, r)
+	if !ok {
+		return
+	}
+
+	hash := common.HexToHash(chi.URLParam(r, "hash")).Hex()
+
+	op, err := s.db.ScheduleDB.GetScheduledUserOp(hash)
+	if err == sql.ErrNoRows || (err == nil && (op.Paymaster != paymaster || op.UserOp.Sender.Hex() != account)) {
+		comm.ErrorBody(w, http.StatusNotFound, comm.ErrorCodeNotFound, "scheduled user operation not found", nil)
+		return
+	}
+	if err != nil {
+		comm.ErrorBody(w, http.StatusInternalServerError, comm.ErrorCodeInternal, "could not get the scheduled user operation", nil)
+		return
+	}
+
+	cancelled, err := s.db.ScheduleDB.SetScheduledUserOpStatus(hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusCancelled, "")
+	if err != nil {
+		comm.ErrorBody(w, http.StatusInternalServerError, comm.ErrorCodeInternal, "could not cancel the scheduled user operation", nil)
+		return
+	}
+
+	if !cancelled {
+		comm.ErrorBody(w, http.StatusConflict, comm.ErrorCodeConflict, "the user operation is no longer scheduled", nil)
+		return
+	}
+
+	queue.SetUserOpStatus(s.db, op.Message(s.chainId), "", indexer.UserOpStatusCancelled, nil)
+
+	op.Status = indexer.ScheduleStatusCancelled
+
+	err = comm.Body(w, op, nil)
+	if err != nil {
+		comm.ErrorBody(w, http.StatusInternalServerError, comm.ErrorCodeInternal, "could not encode the response", nil)
+	}
+}
+
+// scheduleAccount returns the paymaster and the account in the url, an error response is written if the request was not signed by the account
+func scheduleAccount(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	addr, ok := comm.GetContextAddress(r.Context())
+	if !ok {
+		comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeMissingSignature, "missing signed address", nil)
+		return "", "", false
+	}
+
+	pmq := chi.URLParam(r, "pm_address")
+	if !common.IsHexAddress(pmq) {
+		comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeInvalidAddress, "invalid paymaster address", comm.ParamDetails{Param: "pm_address"})
+		return "", "", false
+	}
+
+	accq := chi.URLParam(r, "acc_addr")
+	if !common.IsHexAddress(accq) {
+		comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeInvalidAddress, "invalid account address", comm.ParamDetails{Param: "acc_addr"})
+		return "", "", false
+	}
+
+	account := common.HexToAddress(accq)
+	if common.HexToAddress(addr) != account {
+		comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeUnauthorized, "the signed address does not match the account", nil)
+		return "", "", false
+	}
+
+	return common.HexToAddress(pmq).Hex(), account.Hex(), true
+}
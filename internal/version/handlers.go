@@ -21,6 +21,6 @@ type response struct {
 func (s *Service) Current(w http.ResponseWriter, r *http.Request) {
 	err := common.Body(w, &response{Version: indexer.Version}, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		common.ErrorBody(w, http.StatusInternalServerError, common.ErrorCodeInternal, "could not encode the response", nil)
 	}
 }
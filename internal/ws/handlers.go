@@ -0,0 +1,421 @@
+package ws
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	com "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait        = 10 * time.Second  // how long writing a message can take
+	pongWait         = 60 * time.Second  // how long the client has to answer a ping
+	pingPeriod       = pongWait * 9 / 10 // pings are sent a little before the client would time out
+	maxMessageSize   = 16 << 10          // subscribe messages can carry a signed body
+	maxSubscriptions = 32                // per connection
+)
+
+type MessageType string
+
+const (
+	// sent by the client
+	MessageTypeSubscribe   MessageType = "subscribe"
+	MessageTypeUnsubscribe MessageType = "unsubscribe"
+
+	// sent by the server
+	MessageTypeSubscribed   MessageType = "subscribed"
+	MessageTypeUnsubscribed MessageType = "unsubscribed"
+	MessageTypeEvent        MessageType = "event"
+	MessageTypeClosed       MessageType = "closed" // the client fell behind, it can subscribe again from the last event it received
+	MessageTypeError        MessageType = "error"
+)
+
+const (
+	errBadRequest           = "bad_request"
+	errUnauthorized         = "unauthorized"
+	errNotFound             = "not_found"
+	errAlreadySubscribed    = "already_subscribed"
+	errTooManySubscriptions = "too_many_subscriptions"
+	errMissedEvents         = "missed_events" // the events after lastEventId are no longer in memory
+	errInternal             = "internal_error"
+)
+
+// Verifier returns the signed data of the body if it was signed by the owner of addr
+type Verifier func(addr, signature string, body json.RawMessage) ([]byte, bool)
+
+// minSignatureVersion is the oldest version of signed bodies that is accepted, older versions do not sign the expiry
+const minSignatureVersion = 2
+
+// signedEnvelope is the version and the expiry of a signed body, checked before its signature
+type signedEnvelope struct {
+	Expiry  int64 `json:"expiry"`
+	Version int   `json:"version"`
+}
+
+// signedSubscription is the data that is signed to subscribe to the data of an account,
+// a signature can only be used for the topic and the account it was made for
+type signedSubscription struct {
+	Topic   string `json:"topic"`
+	Account string `json:"account"`
+}
+
+// request is a message sent by the client
+// subscriptions to the user operations of an account must be signed with the same scheme as signed http requests
+type request struct {
+	ID          string          `json:"id"`
+	Type        MessageType     `json:"type"`
+	Topic       string          `json:"topic"`
+	Token       string          `json:"token"`
+	Account     string          `json:"account"`
+	LastEventID *int64          `json:"lastEventId"`
+	Signature   string          `json:"signature"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// response is a message sent by the server, the id is the one of the subscription it is about
+type response struct {
+	ID    string        `json:"id,omitempty"`
+	Type  MessageType   `json:"type"`
+	Event *pubsub.Event `json:"event,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+type Service struct {
+	db       *db.DB
+	verify   Verifier
+	upgrader websocket.Upgrader
+}
+
+func NewService(db *db.DB, verify Verifier) *Service {
+	return &Service{
+		db:     db,
+		verify: verify,
+		upgrader: websocket.Upgrader{
+			// the api is open to all origins, account data is protected by signatures and not by cookies
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// conn is a websocket connection and the subscriptions it made
+type conn struct {
+	ws *websocket.Conn
+	wm sync.Mutex // a websocket connection supports one writer at a time
+
+	mu   sync.Mutex
+	subs map[string]*pubsub.Subscription
+}
+
+// write sends a message to the client
+func (c *conn) write(resp response) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteJSON(resp)
+}
+
+// ping keeps the connection alive until done is closed
+func (c *conn) ping(done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.wm.Lock()
+			err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			c.wm.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Connect godoc
+//
+//	@Summary		Subscribe to updates
+//	@Description	upgrade to a websocket connection on which transfers, user operation status updates and indexer progress can be subscribed to
+//	@Description	subscriptions to the user operations of an account must be signed by the account
+//	@Tags			ws
+//	@Param			X-Response-Version	header		string	false	"Set to 2 to receive an error object in the body of error responses"
+//	@Success		101
+//	@Failure		400	{object}	common.Response
+//	@Failure		500	{object}	common.Response
+//	@Router			/ws [get]
+func (s *Service) Connect(w http.ResponseWriter, r *http.Request) {
+	if s.db.Broker == nil {
+		com.ErrorBody(w, http.StatusInternalServerError, com.ErrorCodeInternal, "subscriptions are not supported", nil)
+		return
+	}
+
+	// the upgrader responds to the client itself if the upgrade fails
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	c := &conn{
+		ws:   ws,
+		subs: map[string]*pubsub.Subscription{},
+	}
+	defer s.unsubscribeAll(c)
+
+	ws.SetReadLimit(maxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go c.ping(done)
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req request
+		err = json.Unmarshal(msg, &req)
+		if err != nil {
+			err = c.write(response{Type: MessageTypeError, Error: errBadRequest})
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		switch req.Type {
+		case MessageTypeSubscribe:
+			err = s.subscribe(c, req)
+		case MessageTypeUnsubscribe:
+			err = s.unsubscribe(c, req)
+		default:
+			err = c.write(response{ID: req.ID, Type: MessageTypeError, Error: errBadRequest})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// authorized returns true if the request is signed by its account for its topic
+func (s *Service) authorized(req request) bool {
+	if s.verify == nil {
+		return false
+	}
+
+	// a subscription that could be replayed forever is refused
+	var env signedEnvelope
+	err := json.Unmarshal(req.Body, &env)
+	if err != nil || env.Version < minSignatureVersion || env.Expiry < time.Now().UTC().Unix() {
+		return false
+	}
+
+	data, ok := s.verify(req.Account, req.Signature, req.Body)
+	if !ok {
+		return false
+	}
+
+	var sub signedSubscription
+	err = json.Unmarshal(data, &sub)
+	if err != nil || !common.IsHexAddress(sub.Account) {
+		return false
+	}
+
+	return sub.Topic == req.Topic && common.HexToAddress(sub.Account) == common.HexToAddress(req.Account)
+}
+
+// topic returns the topic of the broker that the request subscribes to, or the error to respond with
+func (s *Service) topic(req request) (string, string) {
+	if req.Account != "" && !common.IsHexAddress(req.Account) {
+		return "", errBadRequest
+	}
+
+	if req.Token != "" && !common.IsHexAddress(req.Token) {
+		return "", errBadRequest
+	}
+
+	switch req.Topic {
+	case pubsub.TopicTransfers:
+		if req.Token == "" {
+			if req.Account != "" {
+				return "", errBadRequest
+			}
+
+			return pubsub.TopicTransfers, ""
+		}
+
+		if _, ok := s.db.GetTransferDB(req.Token); !ok {
+			return "", errNotFound
+		}
+
+		name, err := s.db.TableNameSuffix(req.Token)
+		if err != nil {
+			return "", errBadRequest
+		}
+
+		return pubsub.TransferTopic(name, req.Account), ""
+	case pubsub.TopicUserOps:
+		if req.Account == "" || req.Token != "" {
+			return "", errBadRequest
+		}
+
+		// user operations are only sent to the account itself, transfers are public on chain
+		if !s.authorized(req) {
+			return "", errUnauthorized
+		}
+
+		return pubsub.UserOpTopic(req.Account), ""
+	case pubsub.TopicIndexer:
+		if req.Account != "" {
+			return "", errBadRequest
+		}
+
+		if req.Token == "" {
+			return pubsub.TopicIndexer, ""
+		}
+
+		_, err := s.db.EventDB.GetContractEvent(req.Token)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return "", errNotFound
+			}
+
+			return "", errInternal
+		}
+
+		return pubsub.IndexerTopic(req.Token), ""
+	}
+
+	return "", errBadRequest
+}
+
+// subscribe subscribes the connection to a topic and sends the events it missed since lastEventId if it is set
+func (s *Service) subscribe(c *conn, req request) error {
+	if req.ID == "" {
+		return c.write(response{Type: MessageTypeError, Error: errBadRequest})
+	}
+
+	topic, errCode := s.topic(req)
+	if errCode != "" {
+		return c.write(response{ID: req.ID, Type: MessageTypeError, Error: errCode})
+	}
+
+	c.mu.Lock()
+	if _, ok := c.subs[req.ID]; ok {
+		c.mu.Unlock()
+		return c.write(response{ID: req.ID, Type: MessageTypeError, Error: errAlreadySubscribed})
+	}
+
+	if len(c.subs) >= maxSubscriptions {
+		c.mu.Unlock()
+		return c.write(response{ID: req.ID, Type: MessageTypeError, Error: errTooManySubscriptions})
+	}
+
+	// subscribe before catching up so that nothing is missed in between
+	sub := s.db.Broker.Subscribe(topic)
+	c.subs[req.ID] = sub
+	c.mu.Unlock()
+
+	err := c.write(response{ID: req.ID, Type: MessageTypeSubscribed})
+	if err != nil {
+		return err
+	}
+
+	var lastSent int64
+	if req.LastEventID != nil {
+		lastSent = *req.LastEventID
+
+		events, ok := s.db.Broker.Since(*req.LastEventID, sub)
+		if !ok {
+			// the client has to fetch what it missed from the http api
+			err := c.write(response{ID: req.ID, Type: MessageTypeError, Error: errMissedEvents})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, e := range events {
+			err := c.write(response{ID: req.ID, Type: MessageTypeEvent, Event: &e})
+			if err != nil {
+				return err
+			}
+
+			lastSent = e.ID
+		}
+	}
+
+	go s.forward(c, req.ID, sub, lastSent)
+
+	return nil
+}
+
+// forward sends the events of a subscription to the client until the subscription is closed
+func (s *Service) forward(c *conn, id string, sub *pubsub.Subscription, lastSent int64) {
+	for e := range sub.C {
+		if e.ID <= lastSent {
+			continue
+		}
+
+		err := c.write(response{ID: id, Type: MessageTypeEvent, Event: &e})
+		if err != nil {
+			return
+		}
+	}
+
+	// the channel is closed when the client unsubscribes or when it fell behind
+	c.mu.Lock()
+	dropped := c.subs[id] == sub
+	if dropped {
+		delete(c.subs, id)
+	}
+	c.mu.Unlock()
+
+	if dropped {
+		c.write(response{ID: id, Type: MessageTypeClosed})
+	}
+}
+
+// unsubscribe removes a subscription of the connection
+func (s *Service) unsubscribe(c *conn, req request) error {
+	c.mu.Lock()
+	sub, ok := c.subs[req.ID]
+	delete(c.subs, req.ID)
+	c.mu.Unlock()
+
+	if !ok {
+		return c.write(response{ID: req.ID, Type: MessageTypeError, Error: errNotFound})
+	}
+
+	s.db.Broker.Unsubscribe(sub)
+
+	return c.write(response{ID: req.ID, Type: MessageTypeUnsubscribed})
+}
+
+// unsubscribeAll removes all the subscriptions of the connection
+func (s *Service) unsubscribeAll(c *conn) {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = map[string]*pubsub.Subscription{}
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		s.db.Broker.Unsubscribe(sub)
+	}
+}
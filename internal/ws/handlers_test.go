@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
+)
+
+func TestAuthorized(t *testing.T) {
+	account := "0x1234567890AbcdEF1234567890aBcdef12345678"
+
+	// the verifier accepts any body and returns its data as the signed data
+	s := &Service{
+		verify: func(addr, signature string, body json.RawMessage) ([]byte, bool) {
+			var b struct {
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(body, &b); err != nil {
+				return nil, false
+			}
+
+			return b.Data, signature == "ok"
+		},
+	}
+
+	envelope := func(data any, expiry time.Time, version int) json.RawMessage {
+		b, _ := json.Marshal(map[string]any{"data": data, "expiry": expiry.Unix(), "version": version})
+		return b
+	}
+
+	signed := func(topic, account string) json.RawMessage {
+		return envelope(signedSubscription{Topic: topic, Account: account}, time.Now().Add(time.Minute), 3)
+	}
+
+	tests := []struct {
+		name string
+		req  request
+		want bool
+	}{
+		{"valid", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: signed(pubsub.TopicUserOps, account)}, true},
+		{"address case", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: signed(pubsub.TopicUserOps, "0x1234567890abcdef1234567890abcdef12345678")}, true},
+		{"invalid signature", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ko", Body: signed(pubsub.TopicUserOps, account)}, false},
+		{"other topic", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: signed(pubsub.TopicTransfers, account)}, false},
+		{"other account", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: signed(pubsub.TopicUserOps, "0x0000000000000000000000000000000000000001")}, false},
+		{"no data", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: envelope(struct{}{}, time.Now().Add(time.Minute), 3)}, false},
+		{"legacy", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: envelope(signedSubscription{Topic: pubsub.TopicUserOps, Account: account}, time.Now().Add(time.Minute), 0)}, false},
+		{"expired", request{Topic: pubsub.TopicUserOps, Account: account, Signature: "ok", Body: envelope(signedSubscription{Topic: pubsub.TopicUserOps, Account: account}, time.Now().Add(-time.Minute), 3)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.authorized(tt.req); got != tt.want {
+				t.Errorf("authorized() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package index
+
+import (
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+const checkpointInterval = 24 * time.Hour // balances are checkpointed at the start of every day
+
+// checkpointBalances creates the balance checkpoint of the day of the block once the indexer has reached it
+// blocks are indexed in order, so every transfer before the start of the day is in the db at that point
+func (i *Indexer) checkpointBalances(ev *indexer.Event, blk *block, txdb *db.TransferDB) error {
+	at := time.Unix(int64(blk.Time), 0).UTC().Truncate(checkpointInterval)
+
+	i.cpMu.Lock()
+	done := !i.checkpoints[ev.Contract].Before(at)
+	i.cpMu.Unlock()
+
+	if done {
+		return nil
+	}
+
+	err := txdb.CheckpointBalances(at)
+	if err != nil {
+		return err
+	}
+
+	i.cpMu.Lock()
+	i.checkpoints[ev.Contract] = at
+	i.cpMu.Unlock()
+
+	return nil
+}
@@ -3,9 +3,11 @@ package index
 import (
 	"context"
 	"math/big"
+	"time"
 
 	"github.com/citizenwallet/indexer/internal/services/db"
 	"github.com/citizenwallet/indexer/internal/services/firebase"
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
@@ -34,6 +36,14 @@ func (i *Indexer) EventsFromBlock(ev *indexer.Event, blk *block) error {
 		}
 	}
 
+	sdb, ok := i.db.GetStatsDB(ev.Contract)
+	if !ok {
+		sdb, err = i.db.AddStatsDB(ev.Contract)
+		if err != nil {
+			return err
+		}
+	}
+
 	contractAddr := common.HexToAddress(ev.Contract)
 
 	// Calculate the starting block for the filter query
@@ -65,7 +75,7 @@ func (i *Indexer) EventsFromBlock(ev *indexer.Event, blk *block) error {
 		return ErrIndexingRecoverable
 	}
 
-	return i.processTransfersFromLogs(ev, blk, txdb, ptdb, logs)
+	return i.processTransfersFromLogs(ev, blk, txdb, ptdb, sdb, logs, &indexer.TransferCounter{})
 }
 
 func (i *Indexer) FilterQueryFromEvent(ev *indexer.Event) *ethereum.FilterQuery {
@@ -108,6 +118,14 @@ func (i *Indexer) EventsFromLogStream(ctx context.Context, quitAck chan error, e
 		}
 	}
 
+	sdb, ok := i.db.GetStatsDB(ev.Contract)
+	if !ok {
+		sdb, err = i.db.AddStatsDB(ev.Contract)
+		if err != nil {
+			return err
+		}
+	}
+
 	logch := make(chan types.Log)
 
 	q := i.FilterQueryFromEvent(ev)
@@ -121,6 +139,9 @@ func (i *Indexer) EventsFromLogStream(ctx context.Context, quitAck chan error, e
 	blks := map[uint64]*block{}
 	var toDelete []cleanup
 
+	// the logs of a transaction arrive one after the other
+	counter := &indexer.TransferCounter{}
+
 	for log := range logch {
 		blk, ok := blks[log.BlockNumber]
 		if !ok {
@@ -145,7 +166,7 @@ func (i *Indexer) EventsFromLogStream(ctx context.Context, quitAck chan error, e
 		}
 
 		// process transfers
-		err = i.processTransfersFromLogs(ev, blk, txdb, ptdb, []types.Log{log})
+		err = i.processTransfersFromLogs(ev, blk, txdb, ptdb, sdb, []types.Log{log}, counter)
 		if err != nil {
 			return err
 		}
@@ -160,11 +181,11 @@ func (i *Indexer) EventsFromLogStream(ctx context.Context, quitAck chan error, e
 	return nil
 }
 
-func (i *Indexer) processTransfersFromLogs(ev *indexer.Event, blk *block, txdb *db.TransferDB, ptdb *db.PushTokenDB, logs []types.Log) error {
+func (i *Indexer) processTransfersFromLogs(ev *indexer.Event, blk *block, txdb *db.TransferDB, ptdb *db.PushTokenDB, sdb *db.StatsDB, logs []types.Log, counter *indexer.TransferCounter) error {
 	contractAbi, err := GetContractABI(ev.Standard)
 
 	if len(logs) > 0 {
-		txs, err := parseTransfersFromLogs(i.evm, ev, contractAbi, blk, logs)
+		txs, err := parseTransfersFromLogs(i.evm, ev, contractAbi, blk, logs, counter)
 		if err != nil {
 			return err
 		}
@@ -175,6 +196,12 @@ func (i *Indexer) processTransfersFromLogs(ev *indexer.Event, blk *block, txdb *
 				return err
 			}
 
+			// update the aggregates of the buckets these transfers are in
+			err = sdb.UpdateStats(txs)
+			if err != nil {
+				return err
+			}
+
 			// enrich with data already in the db (e.g. tx_hash, data)
 			txs, err = txdb.UpdateTransfersWithDB(txs)
 			if err != nil {
@@ -189,11 +216,25 @@ func (i *Indexer) processTransfersFromLogs(ev *indexer.Event, blk *block, txdb *
 		}
 	}
 
+	err = i.checkpointBalances(ev, blk, txdb)
+	if err != nil {
+		return err
+	}
+
 	err = i.db.EventDB.SetEventLastBlock(ev.Contract, ev.Standard, int64(blk.Number))
 	if err != nil {
 		return err
 	}
 
+	if i.db.Broker != nil {
+		i.db.Broker.Publish(pubsub.EventTypeIndexer, []string{pubsub.TopicIndexer, pubsub.IndexerTopic(ev.Contract)}, &indexer.EventProgress{
+			Contract:  ev.Contract,
+			Standard:  ev.Standard,
+			LastBlock: int64(blk.Number),
+			UpdatedAt: time.Now().UTC(),
+		})
+	}
+
 	// set the event state to indexed
 	err = i.db.EventDB.SetEventState(ev.Contract, ev.Standard, indexer.EventStateIndexed)
 	if err != nil {
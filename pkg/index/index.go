@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/citizenwallet/indexer/internal/services/db"
@@ -24,6 +25,9 @@ type Indexer struct {
 	db      *db.DB
 	evm     indexer.EVMRequester
 	fb      *firebase.PushService
+
+	cpMu        sync.Mutex
+	checkpoints map[string]time.Time // the latest balance checkpoint of each contract
 }
 
 func New(rate int, chainID *big.Int, db *db.DB, evm indexer.EVMRequester, fb *firebase.PushService) (*Indexer, error) {
@@ -33,6 +37,8 @@ func New(rate int, chainID *big.Int, db *db.DB, evm indexer.EVMRequester, fb *fi
 		db:      db,
 		evm:     evm,
 		fb:      fb,
+
+		checkpoints: map[string]time.Time{},
 	}, nil
 }
 
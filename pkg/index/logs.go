@@ -37,8 +37,6 @@ func parseERC20Log(blktime time.Time, contractAbi abi.ABI, log types.Log) (*inde
 		Status:    indexer.TransferStatusSuccess,
 	}
 
-	tx.Hash = tx.GenerateUniqueHash()
-
 	return tx, nil
 }
 
@@ -64,8 +62,6 @@ func parseERC721Log(blktime time.Time, contractAbi abi.ABI, log types.Log) (*ind
 		Status:    indexer.TransferStatusSuccess,
 	}
 
-	tx.Hash = tx.GenerateUniqueHash()
-
 	return tx, nil
 }
 
@@ -97,8 +93,6 @@ func parseERC1155Logs(blktime time.Time, contractAbi abi.ABI, log types.Log) ([]
 			Status:    indexer.TransferStatusSuccess,
 		}
 
-		tx.Hash = tx.GenerateUniqueHash()
-
 		txs = append(txs, tx)
 	case crypto.Keccak256Hash([]byte(sc.ERC1155TransferBatch)).Hex():
 		var trsf erc1155.Erc1155TransferBatch
@@ -127,8 +121,6 @@ func parseERC1155Logs(blktime time.Time, contractAbi abi.ABI, log types.Log) ([]
 				Status:    indexer.TransferStatusSuccess,
 			}
 
-			tx.Hash = tx.GenerateUniqueHash()
-
 			txs = append(txs, tx)
 		}
 	default:
@@ -139,8 +131,8 @@ func parseERC1155Logs(blktime time.Time, contractAbi abi.ABI, log types.Log) ([]
 }
 
 // parseTransfersFromLogs function takes an EVM requester, an event, a contract ABI, and a slice of logs,
-// and returns a slice of transfers and an error if any.
-func parseTransfersFromLogs(evm indexer.EVMRequester, ev *indexer.Event, contractAbi *abi.ABI, blk *block, logs []types.Log) ([]*indexer.Transfer, error) {
+// and returns a slice of transfers and an error if any. The transfers are hashed with the counter, in the order of the logs.
+func parseTransfersFromLogs(evm indexer.EVMRequester, ev *indexer.Event, contractAbi *abi.ABI, blk *block, logs []types.Log, counter *indexer.TransferCounter) ([]*indexer.Transfer, error) {
 	// Initialize an empty slice of transfers
 	txs := []*indexer.Transfer{}
 
@@ -184,6 +176,10 @@ func parseTransfersFromLogs(evm indexer.EVMRequester, ev *indexer.Event, contrac
 		}
 	}
 
+	for _, tx := range txs {
+		tx.Hash = counter.Hash(tx)
+	}
+
 	// Return the slice of transfers and no error
 	return txs, nil
 }
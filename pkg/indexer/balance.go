@@ -0,0 +1,26 @@
+package indexer
+
+import (
+	"math/big"
+	"time"
+)
+
+// Balance is the balance of an address at a point in time
+type Balance struct {
+	Address string    `json:"address"`
+	TokenID int64     `json:"token_id"`
+	Balance *big.Int  `json:"balance"`
+	At      time.Time `json:"at"`
+}
+
+// Distribution describes how a token is spread over its holders at a block
+type Distribution struct {
+	TokenID       int64      `json:"token_id"`
+	Block         int64      `json:"block"`
+	HolderCount   int        `json:"holder_count"`
+	Circulating   *big.Int   `json:"circulating"`
+	MedianBalance *big.Int   `json:"median_balance"`
+	Gini          float64    `json:"gini"`
+	Top10Share    float64    `json:"top10_share"`
+	TopHolders    []*Balance `json:"top_holders"`
+}
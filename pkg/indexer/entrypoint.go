@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EntryPointVersion is the version of an ERC-4337 entry point, it decides how user operations are packed and hashed
+type EntryPointVersion string
+
+const (
+	EntryPointV06 EntryPointVersion = "v0.6"
+	EntryPointV07 EntryPointVersion = "v0.7" // PackedUserOperation, the gas limits and fees are packed in pairs of uint128
+)
+
+var (
+	// EntryPointV06Address is the canonical deployment of the v0.6 entry point, it is always supported
+	EntryPointV06Address = common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	// EntryPointV07Address is the canonical deployment of the v0.7 entry point, it is always known as v0.7
+	EntryPointV07Address = common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")
+)
+
+const (
+	paymasterDataOffsetV06 = 20 // the paymaster address
+	paymasterDataOffsetV07 = 52 // the paymaster address, its verification gas limit and its post op gas limit
+)
+
+// PaymasterDataOffset is where the data of the paymaster starts in the paymasterAndData of an op
+func (v EntryPointVersion) PaymasterDataOffset() int {
+	if v == EntryPointV07 {
+		return paymasterDataOffsetV07
+	}
+
+	return paymasterDataOffsetV06
+}
+
+// EntryPoints is the version of each entry point that the bundler is configured with, any other entry point is v0.6
+type EntryPoints map[common.Address]EntryPointVersion
+
+// NewEntryPoints creates the entry points from comma separated lists of v0.6 and v0.7 entry point addresses,
+// the canonical deployments are always included
+func NewEntryPoints(v06, v07 string) (EntryPoints, error) {
+	eps := EntryPoints{
+		EntryPointV06Address: EntryPointV06,
+		EntryPointV07Address: EntryPointV07,
+	}
+
+	for _, list := range []struct {
+		addrs   string
+		version EntryPointVersion
+	}{
+		{v06, EntryPointV06},
+		{v07, EntryPointV07},
+	} {
+		for _, addr := range strings.Split(list.addrs, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+
+			if !common.IsHexAddress(addr) {
+				return nil, fmt.Errorf("invalid entry point address: %s", addr)
+			}
+
+			eps[common.HexToAddress(addr)] = list.version
+		}
+	}
+
+	return eps, nil
+}
+
+// Addresses returns the configured entry points, the v0.6 ones first
+func (e EntryPoints) Addresses() []string {
+	addrs := []string{}
+	for addr := range e {
+		addrs = append(addrs, addr.Hex())
+	}
+
+	sort.Slice(addrs, func(i, j int) bool {
+		vi, vj := e[common.HexToAddress(addrs[i])], e[common.HexToAddress(addrs[j])]
+		if vi != vj {
+			return vi < vj
+		}
+
+		return addrs[i] < addrs[j]
+	})
+
+	return addrs
+}
+
+// Version returns the version of an entry point
+func (e EntryPoints) Version(addr common.Address) EntryPointVersion {
+	v, ok := e[addr]
+	if !ok {
+		return EntryPointV06
+	}
+
+	return v
+}
@@ -30,3 +30,11 @@ type Event struct {
 	Symbol     string     `json:"symbol"`
 	Decimals   int64      `json:"decimals"`
 }
+
+// EventProgress is published every time an event has been indexed up to a new block
+type EventProgress struct {
+	Contract  string    `json:"contract"`
+	Standard  Standard  `json:"standard"`
+	LastBlock int64     `json:"last_block"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
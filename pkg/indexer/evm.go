@@ -24,6 +24,8 @@ type EVMRequester interface {
 
 	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
 	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
 	BaseFee() (*big.Int, error)
 	EstimateGasPrice() (*big.Int, error)
 	EstimateGasLimit(msg ethereum.CallMsg) (uint64, error)
@@ -36,6 +38,8 @@ type EVMRequester interface {
 	FilterLogs(q ethereum.FilterQuery) ([]types.Log, error)
 	BlockTime(number *big.Int) (uint64, error)
 	CallContract(call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	TransactionReceipt(hash common.Hash) (*types.Receipt, error)
+	TransactionByHash(hash common.Hash) (*types.Transaction, bool, error)
 	ListenForLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) error
 
 	WaitForTx(tx *types.Transaction, timeout int) error
@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+// GasEntry is the share of the gas of a bundle that is attributed to one of its user operations
+type GasEntry struct {
+	TxHash     string    `json:"tx_hash"`
+	UserOpHash string    `json:"user_op_hash"`
+	Paymaster  string    `json:"paymaster"`
+	Sender     string    `json:"sender"`
+	GasUsed    int64     `json:"gas_used"`
+	GasPrice   *big.Int  `json:"gas_price"`
+	Cost       *big.Int  `json:"cost"`
+	Day        string    `json:"day"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// GasDayLayout is the layout of the days of the gas ledger, in UTC
+const GasDayLayout = "2006-01-02"
+
+// GasGroup is what the gas spend of a report is grouped by
+type GasGroup string
+
+const (
+	GasGroupPaymaster GasGroup = "paymaster"
+	GasGroupAccount   GasGroup = "account"
+	GasGroupDay       GasGroup = "day"
+)
+
+// GasGroupFromString returns the GasGroup of a string
+func GasGroupFromString(s string) (GasGroup, error) {
+	switch GasGroup(s) {
+	case GasGroupPaymaster, GasGroupAccount, GasGroupDay:
+		return GasGroup(s), nil
+	}
+
+	return "", errors.New("invalid group, expected paymaster, account or day")
+}
+
+// GasSpend is the gas that was spent for a paymaster, an account or on a day, the cost is in wei
+type GasSpend struct {
+	Key     string `json:"key"`
+	Ops     int64  `json:"ops"`
+	GasUsed int64  `json:"gas_used"`
+	Cost    string `json:"cost"`
+}
+
+// SpendTotals is what the bundles of a paymaster cost in wei on a day and in its month, in total and for one account
+type SpendTotals struct {
+	Day          *big.Int
+	Month        *big.Int
+	AccountDay   *big.Int
+	AccountMonth *big.Int
+}
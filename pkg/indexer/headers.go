@@ -11,6 +11,8 @@ const (
 	AddressHeader = "X-Address"
 	// AppVersionHeader is the header that contains the app version of the sender
 	AppVersionHeader = "X-App-Version"
+	// ResponseVersionHeader is the header with which the sender opts in to a version of the responses
+	ResponseVersionHeader = "X-Response-Version"
 )
 
 type ContextKey string
@@ -1,27 +1,117 @@
 package indexer
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	JSONRPCVersion = "2.0"
+
+	// JSON-RPC 2.0 error codes
+	JSONRPCErrorCodeParse          = -32700
+	JSONRPCErrorCodeInvalidRequest = -32600
+	JSONRPCErrorCodeMethodNotFound = -32601
+	JSONRPCErrorCodeInvalidParams  = -32602
+	JSONRPCErrorCodeInternal       = -32603
+
+	// ERC-4337 error codes
+	JSONRPCErrorCodeRejected              = -32500 // rejected by the entry point or the account during validation
+	JSONRPCErrorCodePaymasterRejected     = -32501 // rejected by the paymaster
+	JSONRPCErrorCodeBannedOpcode          = -32502 // validation used a banned opcode
+	JSONRPCErrorCodeShortDeadline         = -32503 // the op expires too soon or is not valid yet
+	JSONRPCErrorCodeBannedOrThrottled     = -32504 // an entity of the op is banned or throttled
+	JSONRPCErrorCodeStakeTooLow           = -32505 // an entity of the op has an insufficient stake
+	JSONRPCErrorCodeUnsupportedAggregator = -32506
+	JSONRPCErrorCodeInvalidSignature      = -32507
+)
+
+// jsonRPCNullID is the id of responses to requests whose id could not be read
+var jsonRPCNullID = json.RawMessage("null")
 
 type JsonRPCRequest struct {
 	Version string          `json:"jsonrpc"`
-	ID      int             `json:"id"`
+	ID      json.RawMessage `json:"id"`
 	Method  string          `json:"method"`
 	Params  json.RawMessage `json:"params"`
 }
 
-func (r *JsonRPCRequest) isValid() bool {
-	return r.Version == "2.0" && r.ID > 0 && r.Method != ""
+func (r *JsonRPCRequest) IsValid() bool {
+	return r.Version == JSONRPCVersion && r.Method != ""
 }
 
 type JSONRPCError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
-	Data    any    `json:"data"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// NewJSONRPCError creates an error that is returned to the caller as is, data is optional
+func NewJSONRPCError(code int, message string, data any) *JSONRPCError {
+	return &JSONRPCError{
+		Code:    code,
+		Message: message,
+		Data:    data,
+	}
+}
+
+// NewJSONRPCErrorf creates an error with a formatted message
+func NewJSONRPCErrorf(code int, format string, a ...any) *JSONRPCError {
+	return NewJSONRPCError(code, fmt.Sprintf(format, a...), nil)
+}
+
+// JSONRPCError implements the error interface
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
 }
 
 type JsonRPCResponse struct {
-	Version string        `json:"jsonrpc"`
-	ID      int           `json:"id"`
-	Result  any           `json:"result"`
-	Error   *JSONRPCError `json:"error,omitempty"`
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+// NewJSONRPCResult creates the response to a successful call
+func NewJSONRPCResult(id json.RawMessage, result any) *JsonRPCResponse {
+	return &JsonRPCResponse{
+		Version: JSONRPCVersion,
+		ID:      id,
+		Result:  result,
+	}
+}
+
+// NewJSONRPCErrorResponse creates the response to a failed call, a nil id is sent as null
+func NewJSONRPCErrorResponse(id json.RawMessage, err *JSONRPCError) *JsonRPCResponse {
+	if len(id) == 0 {
+		id = jsonRPCNullID
+	}
+
+	return &JsonRPCResponse{
+		Version: JSONRPCVersion,
+		ID:      id,
+		Error:   err,
+	}
+}
+
+// MarshalJSON omits the result of failed calls, a successful call always has a result even if it is null
+func (r JsonRPCResponse) MarshalJSON() ([]byte, error) {
+	id := r.ID
+	if len(id) == 0 {
+		id = jsonRPCNullID
+	}
+
+	if r.Error != nil {
+		return json.Marshal(struct {
+			Version string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *JSONRPCError   `json:"error"`
+		}{r.Version, id, r.Error})
+	}
+
+	return json.Marshal(struct {
+		Version string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result"`
+	}{r.Version, id, r.Result})
 }
@@ -0,0 +1,288 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// PolicyReason is the machine-readable reason a paymaster rejected a user operation, it is in the data of the error
+type PolicyReason string
+
+const (
+	PolicyReasonInvalidCallData    PolicyReason = "invalid_call_data"      // the call data could not be decoded
+	PolicyReasonCallNotAllowed     PolicyReason = "call_not_allowed"       // the account function that is called is not supported
+	PolicyReasonValueNotAllowed    PolicyReason = "value_not_allowed"      // the op sends native currency
+	PolicyReasonInvalidInitCode    PolicyReason = "invalid_init_code"      // the init code is not allowed with the nonce of the op
+	PolicyReasonFactoryNotDeployed PolicyReason = "factory_not_deployed"   // the account factory of the init code is not deployed
+	PolicyReasonSenderDenied       PolicyReason = "sender_denied"          // the sender is on the deny list
+	PolicyReasonOutsideWindow      PolicyReason = "outside_window"         // ops are not sponsored at this time
+	PolicyReasonTargetNotAllowed   PolicyReason = "target_not_allowed"     // the contract that is called is not allowed
+	PolicyReasonSelectorNotAllowed PolicyReason = "selector_not_allowed"   // the function that is called is not allowed on the contract
+	PolicyReasonAccountOps         PolicyReason = "account_ops_exceeded"   // the sender used its operations for the day
+	PolicyReasonAccountGas         PolicyReason = "account_gas_exceeded"   // the sender used its gas for the day
+	PolicyReasonDailyOps           PolicyReason = "daily_ops_exceeded"     // the paymaster used its operations for the day
+	PolicyReasonDailyGas           PolicyReason = "daily_gas_exceeded"     // the paymaster used its gas for the day
+	PolicyReasonSpend              PolicyReason = "spend_exceeded"         // the bundles of the paymaster cost its spend budget
+	PolicyReasonAccountSpend       PolicyReason = "account_spend_exceeded" // the bundles of the sender cost its spend budget
+	PolicyReasonSponsorshipRevoked PolicyReason = "sponsorship_revoked"    // the out of order sponsorship of the op was revoked
+	PolicyReasonSponsorshipExpired PolicyReason = "sponsorship_expired"    // the out of order sponsorship of the op was expired early
+)
+
+// PolicyRejection is the data of the error of a user operation that a paymaster rejected
+type PolicyRejection struct {
+	Reason PolicyReason `json:"reason"`
+}
+
+// NewPolicyRejection creates a paymaster rejection error with a reason
+func NewPolicyRejection(reason PolicyReason, message string) *JSONRPCError {
+	return NewJSONRPCError(JSONRPCErrorCodePaymasterRejected, message, &PolicyRejection{Reason: reason})
+}
+
+// Policy decides which user operations a paymaster sponsors. Days are in UTC and a limit of 0 means no limit.
+type Policy struct {
+	Contract string `json:"contract"`
+	// Targets are the contracts that ops may call with the function selectors allowed on each,
+	// a target without selectors allows any function and any target is allowed if there are none
+	Targets map[string][]string `json:"targets,omitempty"`
+	// Denied are the senders whose ops are never sponsored
+	Denied []string `json:"denied,omitempty"`
+	// Account is the budget of each sender per day
+	Account PolicyBudget `json:"account"`
+	// Daily is the budget of the paymaster per day
+	Daily PolicyBudget `json:"daily"`
+	// Windows are the times at which ops are sponsored, ops are sponsored at any time if there are none
+	Windows []PolicyWindow `json:"windows,omitempty"`
+	// Spend is the budget of what the bundles of the paymaster cost, from the gas ledger
+	Spend PolicySpend `json:"spend"`
+	// OOValidity is how many seconds the signatures of pm_ooSponsorUserOperation are valid, 0 means the default of 7 days
+	OOValidity int64  `json:"oo_validity,omitempty"`
+	CreatedAt  string `json:"created_at,omitempty"`
+	UpdatedAt  string `json:"updated_at,omitempty"`
+}
+
+// PolicyBudget limits the operations and the gas, the gas of an op is the sum of its gas limits
+type PolicyBudget struct {
+	Ops int64 `json:"ops"`
+	Gas int64 `json:"gas"`
+}
+
+// PolicyUsage is what was sponsored in a day
+type PolicyUsage struct {
+	Ops int64 `json:"ops"`
+	Gas int64 `json:"gas"`
+}
+
+// OpsGas is the gas that a number of copies of a user operation can use at most, a missing limit counts as 0.
+// It is -1 if the gas does not fit in an int64.
+func OpsGas(op *UserOp, ops int64) int64 {
+	gas := new(big.Int)
+	for _, limit := range []*big.Int{op.CallGasLimit, op.VerificationGasLimit, op.PreVerificationGas} {
+		if limit != nil {
+			gas.Add(gas, limit)
+		}
+	}
+
+	gas.Mul(gas, big.NewInt(ops))
+
+	if !gas.IsInt64() {
+		return -1
+	}
+
+	return gas.Int64()
+}
+
+// CheckBudgets returns the rejection of ops that do not fit in what is left of the budgets of the day, or nil if they do
+func (p *Policy) CheckBudgets(ops, gas int64, account, daily *PolicyUsage) *JSONRPCError {
+	if gas < 0 {
+		return NewPolicyRejection(PolicyReasonAccountGas, "the gas limits of the op are too high")
+	}
+
+	if p.Account.Ops > 0 && account.Ops+ops > p.Account.Ops {
+		return NewPolicyRejection(PolicyReasonAccountOps, "the sender has no sponsored ops left today")
+	}
+
+	if p.Account.Gas > 0 && account.Gas+gas > p.Account.Gas {
+		return NewPolicyRejection(PolicyReasonAccountGas, "the sender has no sponsored gas left today")
+	}
+
+	if p.Daily.Ops > 0 && daily.Ops+ops > p.Daily.Ops {
+		return NewPolicyRejection(PolicyReasonDailyOps, "the paymaster has no sponsored ops left today")
+	}
+
+	if p.Daily.Gas > 0 && daily.Gas+gas > p.Daily.Gas {
+		return NewPolicyRejection(PolicyReasonDailyGas, "the paymaster has no sponsored gas left today")
+	}
+
+	return nil
+}
+
+// PolicySpend limits what the bundles of a paymaster cost in wei per day and per month, in total and for each account.
+// A warning is sent once warn percent of a budget was spent, ops are only refused if refuse is true. An empty budget means no limit.
+type PolicySpend struct {
+	Day          string `json:"day,omitempty"`
+	Month        string `json:"month,omitempty"`
+	AccountDay   string `json:"account_day,omitempty"`
+	AccountMonth string `json:"account_month,omitempty"`
+	Warn         int64  `json:"warn,omitempty"`
+	Refuse       bool   `json:"refuse,omitempty"`
+}
+
+// Over returns the names of the budgets of which at least percent was spent: day, month, account_day or account_month
+func (p *PolicySpend) Over(t *SpendTotals, percent int64) []string {
+	over := []string{}
+
+	budgets := []struct {
+		name   string
+		budget string
+		spent  *big.Int
+	}{
+		{"day", p.Day, t.Day},
+		{"month", p.Month, t.Month},
+		{"account_day", p.AccountDay, t.AccountDay},
+		{"account_month", p.AccountMonth, t.AccountMonth},
+	}
+
+	for _, b := range budgets {
+		budget, ok := new(big.Int).SetString(b.budget, 10)
+		if !ok || budget.Sign() <= 0 || b.spent == nil {
+			continue
+		}
+
+		// spent * 100 >= budget * percent
+		spent := new(big.Int).Mul(b.spent, big.NewInt(100))
+		if spent.Cmp(new(big.Int).Mul(budget, big.NewInt(percent))) >= 0 {
+			over = append(over, b.name)
+		}
+	}
+
+	return over
+}
+
+// PolicyWindow is a time of day, as 15:04 in UTC, on some days of the week or every day if there are none.
+// The end is excluded and a window whose end is before its start goes past midnight.
+type PolicyWindow struct {
+	Days  []time.Weekday `json:"days,omitempty"`
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+}
+
+const policyWindowLayout = "15:04"
+
+// Contains returns true if the time is in the window
+func (w *PolicyWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+
+	start, err := time.Parse(policyWindowLayout, w.Start)
+	if err != nil {
+		return false
+	}
+
+	end, err := time.Parse(policyWindowLayout, w.End)
+	if err != nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	day := t.Weekday()
+	in := minute >= startMinute && minute < endMinute
+	if endMinute <= startMinute {
+		// the window goes past midnight, the early part belongs to the day before
+		in = minute >= startMinute || minute < endMinute
+		if minute < endMinute {
+			day = (day + 6) % 7
+		}
+	}
+
+	if !in {
+		return false
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks that the addresses, selectors, budgets and windows of a policy can be read
+func (p *Policy) Validate() error {
+	if !common.IsHexAddress(p.Contract) {
+		return fmt.Errorf("invalid paymaster address: %s", p.Contract)
+	}
+
+	for target, selectors := range p.Targets {
+		if !common.IsHexAddress(target) {
+			return fmt.Errorf("invalid target address: %s", target)
+		}
+
+		for _, selector := range selectors {
+			b, err := hexutil.Decode(selector)
+			if err != nil || len(b) != 4 {
+				return fmt.Errorf("invalid selector for %s: %s", target, selector)
+			}
+		}
+	}
+
+	for _, sender := range p.Denied {
+		if !common.IsHexAddress(sender) {
+			return fmt.Errorf("invalid denied address: %s", sender)
+		}
+	}
+
+	if p.Account.Ops < 0 || p.Account.Gas < 0 || p.Daily.Ops < 0 || p.Daily.Gas < 0 {
+		return errors.New("budgets cannot be negative")
+	}
+
+	for _, budget := range []string{p.Spend.Day, p.Spend.Month, p.Spend.AccountDay, p.Spend.AccountMonth} {
+		if budget == "" {
+			continue
+		}
+
+		b, ok := new(big.Int).SetString(budget, 10)
+		if !ok || b.Sign() < 0 {
+			return fmt.Errorf("invalid spend budget, expected an amount in wei: %s", budget)
+		}
+	}
+
+	if p.Spend.Warn < 0 || p.Spend.Warn > 100 {
+		return errors.New("spend warn should be a percentage")
+	}
+
+	if p.OOValidity < 0 {
+		return errors.New("oo validity cannot be negative")
+	}
+
+	for _, w := range p.Windows {
+		_, err := time.Parse(policyWindowLayout, w.Start)
+		if err != nil {
+			return fmt.Errorf("invalid window start: %s", w.Start)
+		}
+
+		_, err = time.Parse(policyWindowLayout, w.End)
+		if err != nil {
+			return fmt.Errorf("invalid window end: %s", w.End)
+		}
+
+		for _, d := range w.Days {
+			if d < time.Sunday || d > time.Saturday {
+				return fmt.Errorf("invalid window day: %d", d)
+			}
+		}
+	}
+
+	return nil
+}
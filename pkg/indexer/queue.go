@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"time"
@@ -8,6 +9,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// ErrResponseTimeout is returned when a message was not processed in time
+var ErrResponseTimeout = errors.New("request timeout")
+
 type MessageResponse struct {
 	Data any
 	Err  error
@@ -32,9 +36,9 @@ func (m *Message) Respond(data any, err error) {
 	}
 }
 
+// WaitForResponse waits for the queue to process the message, ErrResponseTimeout is returned if it takes too long.
+// The message can still be processed after a timeout, the response channel has room for the late response.
 func (m *Message) WaitForResponse() (any, error) {
-	defer m.Close()
-
 	select {
 	case resp, ok := <-*m.Response:
 		if !ok {
@@ -47,7 +51,7 @@ func (m *Message) WaitForResponse() (any, error) {
 
 		return resp.Data, nil
 	case <-time.After(time.Second * 12): // timeout so that we don't block the request forever in case the queue is stuck
-		return nil, fmt.Errorf("request timeout")
+		return nil, ErrResponseTimeout
 	}
 }
 
@@ -62,6 +66,7 @@ func (m *Message) Close() {
 type UserOpMessage struct {
 	Paymaster  common.Address
 	EntryPoint common.Address
+	Version    EntryPointVersion // the version of the entry point, an empty version is v0.6
 	ChainId    *big.Int
 	UserOp     UserOp
 	ExtraData  any
@@ -77,15 +82,30 @@ func newMessage(id string, message any, response *chan MessageResponse) *Message
 	}
 }
 
-func NewTxMessage(pm, entrypoint common.Address, chainId *big.Int, userop UserOp, txdata *TransferData) *Message {
+func NewTxMessage(pm, entrypoint common.Address, version EntryPointVersion, chainId *big.Int, userop UserOp, txdata *TransferData) *Message {
 	op := UserOpMessage{
 		Paymaster:  pm,
 		EntryPoint: entrypoint,
+		Version:    version,
 		ChainId:    chainId,
 		UserOp:     userop,
 		ExtraData:  txdata,
 	}
 
-	respch := make(chan MessageResponse)
+	respch := make(chan MessageResponse, 1)
 	return newMessage(common.Bytes2Hex(userop.Signature), op, &respch)
 }
+
+// NewAsyncTxMessage creates a message for a user operation that nobody waits for, its status is followed through its hash
+func NewAsyncTxMessage(pm, entrypoint common.Address, version EntryPointVersion, chainId *big.Int, userop UserOp, txdata *TransferData) *Message {
+	op := UserOpMessage{
+		Paymaster:  pm,
+		EntryPoint: entrypoint,
+		Version:    version,
+		ChainId:    chainId,
+		UserOp:     userop,
+		ExtraData:  txdata,
+	}
+
+	return newMessage(common.Bytes2Hex(userop.Signature), op, nil)
+}
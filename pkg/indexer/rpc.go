@@ -2,4 +2,6 @@ package indexer
 
 import "net/http"
 
-type RPCHandlerFunc func(r *http.Request) (any, int)
+// RPCHandlerFunc handles a JSON-RPC call, the params are the body of the request
+// returning a *JSONRPCError sends it to the caller as is, any other error is sent as an internal error
+type RPCHandlerFunc func(r *http.Request) (any, error)
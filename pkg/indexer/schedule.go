@@ -0,0 +1,72 @@
+package indexer
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ScheduleStatus is the state of a scheduled user operation
+type ScheduleStatus string
+
+const (
+	ScheduleStatusScheduled ScheduleStatus = "scheduled" // waiting for its time or its condition
+	ScheduleStatusSubmitted ScheduleStatus = "submitted" // added to the queue, the op is followed like any other from then on
+	ScheduleStatusCancelled ScheduleStatus = "cancelled" // cancelled by the account
+	ScheduleStatusFailed    ScheduleStatus = "failed"    // the op could not be submitted, see error
+)
+
+// ScheduleStatusFromString returns the ScheduleStatus of a string
+func ScheduleStatusFromString(s string) (ScheduleStatus, error) {
+	switch ScheduleStatus(s) {
+	case ScheduleStatusScheduled, ScheduleStatusSubmitted, ScheduleStatusCancelled, ScheduleStatusFailed:
+		return ScheduleStatus(s), nil
+	}
+
+	return "", errors.New("invalid status, expected scheduled, submitted, cancelled or failed")
+}
+
+// ScheduleBalance is a condition on the balance of a token of the sender of an op
+type ScheduleBalance struct {
+	Token  string       `json:"token"`
+	Amount *hexutil.Big `json:"amount"`
+}
+
+// ScheduledUserOp is a signed user operation that is submitted once it is due: not before a time, in unix seconds,
+// and once its sender holds a balance of a token if there is one. The paymaster signature is valid until valid_until.
+type ScheduledUserOp struct {
+	Hash       string            `json:"hash"`
+	Paymaster  string            `json:"paymaster"`
+	EntryPoint string            `json:"entry_point"`
+	Version    EntryPointVersion `json:"version"`
+	UserOp     UserOp            `json:"user_op"`
+	ExtraData  *TransferData     `json:"extra_data,omitempty"`
+	NotBefore  int64             `json:"not_before"`
+	Balance    *ScheduleBalance  `json:"balance,omitempty"`
+	ValidUntil int64             `json:"valid_until"`
+	Status     ScheduleStatus    `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Message returns the queue message of the op
+func (s *ScheduledUserOp) Message(chainId *big.Int) UserOpMessage {
+	txm := UserOpMessage{
+		Paymaster:  common.HexToAddress(s.Paymaster),
+		EntryPoint: common.HexToAddress(s.EntryPoint),
+		Version:    s.Version,
+		ChainId:    chainId,
+		UserOp:     s.UserOp,
+	}
+
+	// a nil pointer would not be a nil interface
+	if s.ExtraData != nil {
+		txm.ExtraData = s.ExtraData
+	}
+
+	return txm
+}
@@ -0,0 +1,21 @@
+package indexer
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Signer holds the keys of the paymasters, the sponsor key signs for a paymaster and
+// the sponsor or submitter keys send its transactions. The keys can be kept by another process.
+type Signer interface {
+	// Sponsor returns the address of the key that signs for a paymaster
+	Sponsor(paymaster common.Address) (common.Address, error)
+	// Submitters returns the addresses of the active keys that submit the bundles of a paymaster
+	Submitters(paymaster common.Address) ([]common.Address, error)
+	// SignHash signs a hash as an EIP-191 message with the sponsor key of a paymaster, v is 27 or 28
+	SignHash(paymaster common.Address, hash []byte) ([]byte, error)
+	// SignTx signs a transaction with the sponsor key or a submitter key of a paymaster
+	SignTx(paymaster, from common.Address, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error)
+}
@@ -6,3 +6,13 @@ type Sponsor struct {
 	CreatedAt  string `json:"created_at"`
 	UpdatedAt  string `json:"updated_at"`
 }
+
+// SponsorKey is a key that submits the bundles of a paymaster, it is separate from the key that signs for the paymaster
+type SponsorKey struct {
+	Contract   string `json:"contract"`
+	Address    string `json:"address"`
+	PrivateKey string `json:"private_key"`
+	Retired    bool   `json:"retired"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SponsorshipStatus is the state of an out of order sponsorship
+type SponsorshipStatus string
+
+const (
+	SponsorshipStatusOutstanding SponsorshipStatus = "outstanding" // signed and not used yet
+	SponsorshipStatusUsed        SponsorshipStatus = "used"        // an op with the sponsorship was sent through the bundler
+	SponsorshipStatusRevoked     SponsorshipStatus = "revoked"     // ops with the sponsorship are rejected
+	SponsorshipStatusExpired     SponsorshipStatus = "expired"     // the validity has passed or it was expired early
+)
+
+// SponsorshipStatusFromString returns the SponsorshipStatus of a string
+func SponsorshipStatusFromString(s string) (SponsorshipStatus, error) {
+	switch SponsorshipStatus(s) {
+	case SponsorshipStatusOutstanding, SponsorshipStatusUsed, SponsorshipStatusRevoked, SponsorshipStatusExpired:
+		return SponsorshipStatus(s), nil
+	}
+
+	return "", errors.New("invalid status, expected outstanding, used, revoked or expired")
+}
+
+// Sponsorship is a paymaster signature that pm_ooSponsorUserOperation issued for an account and a nonce key.
+// The signatures of a call share a batch, validity is in unix seconds.
+type Sponsorship struct {
+	Contract   string            `json:"contract"`
+	Batch      string            `json:"batch"`
+	Account    string            `json:"account"`
+	NonceKey   string            `json:"nonce_key"`
+	EntryPoint string            `json:"entry_point"`
+	Use        string            `json:"use,omitempty"`
+	ValidAfter int64             `json:"valid_after"`
+	ValidUntil int64             `json:"valid_until"`
+	Status     SponsorshipStatus `json:"status"`
+	UserOpHash string            `json:"user_op_hash,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// SponsorshipFilter selects the sponsorships of a paymaster, empty fields match anything
+type SponsorshipFilter struct {
+	Batch    string            `json:"batch,omitempty"`
+	Account  string            `json:"account,omitempty"`
+	NonceKey string            `json:"nonce_key,omitempty"`
+	Status   SponsorshipStatus `json:"status,omitempty"`
+}
+
+// SponsorshipNonceKey returns the key of the nonce of an op as hex, the sequence in the lower 64 bits is left out
+func SponsorshipNonceKey(nonce *big.Int) string {
+	if nonce == nil {
+		return hexutil.EncodeBig(big.NewInt(0))
+	}
+
+	return hexutil.EncodeBig(new(big.Int).Rsh(nonce, 64))
+}
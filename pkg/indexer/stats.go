@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+type StatsPeriod string
+
+const (
+	StatsPeriodDay  StatsPeriod = "day"
+	StatsPeriodHour StatsPeriod = "hour"
+)
+
+func StatsPeriodFromString(s string) (StatsPeriod, error) {
+	switch s {
+	case "day":
+		return StatsPeriodDay, nil
+	case "hour":
+		return StatsPeriodHour, nil
+	}
+
+	return StatsPeriodDay, errors.New("unknown period: " + s)
+}
+
+// Duration returns the length of a bucket of the period
+func (p StatsPeriod) Duration() time.Duration {
+	if p == StatsPeriodHour {
+		return time.Hour
+	}
+
+	return 24 * time.Hour
+}
+
+// Start returns the start of the bucket of the period that contains t
+func (p StatsPeriod) Start(t time.Time) time.Time {
+	return t.UTC().Truncate(p.Duration())
+}
+
+// Stats are the aggregated numbers of a token for one bucket of a period
+// mints and burns are not included in the transfer count and volume
+type Stats struct {
+	TokenID         int64       `json:"token_id"`
+	Period          StatsPeriod `json:"period"`
+	Start           time.Time   `json:"start"`
+	TransferCount   int64       `json:"transfer_count"`
+	Volume          *big.Int    `json:"volume"`
+	UniqueSenders   int64       `json:"unique_senders"`
+	UniqueReceivers int64       `json:"unique_receivers"`
+	NewAccounts     int64       `json:"new_accounts"`
+	MintCount       int64       `json:"mint_count"`
+	MintVolume      *big.Int    `json:"mint_volume"`
+	BurnCount       int64       `json:"burn_count"`
+	BurnVolume      *big.Int    `json:"burn_volume"`
+}
+
+// NewStats returns empty stats for a bucket
+func NewStats(tokenId int64, period StatsPeriod, start time.Time) *Stats {
+	return &Stats{
+		TokenID:    tokenId,
+		Period:     period,
+		Start:      start,
+		Volume:     big.NewInt(0),
+		MintVolume: big.NewInt(0),
+		BurnVolume: big.NewInt(0),
+	}
+}
+
+// Add adds the counts and the volumes of other stats of the same bucket
+func (s *Stats) Add(o *Stats) {
+	s.TransferCount += o.TransferCount
+	s.Volume.Add(s.Volume, o.Volume)
+	s.UniqueSenders += o.UniqueSenders
+	s.UniqueReceivers += o.UniqueReceivers
+	s.NewAccounts += o.NewAccounts
+	s.MintCount += o.MintCount
+	s.MintVolume.Add(s.MintVolume, o.MintVolume)
+	s.BurnCount += o.BurnCount
+	s.BurnVolume.Add(s.BurnVolume, o.BurnVolume)
+}
+
+// IsEmpty returns true if nothing happened in the bucket
+func (s *Stats) IsEmpty() bool {
+	return s.TransferCount == 0 && s.MintCount == 0 && s.BurnCount == 0 && s.NewAccounts == 0
+}
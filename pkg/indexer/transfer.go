@@ -41,6 +41,27 @@ func TransferStatusFromString(s string) (TransferStatus, error) {
 	return TransferStatusUnknown, errors.New("unknown role: " + s)
 }
 
+type TransferDirection string
+
+const (
+	TransferDirectionAny TransferDirection = ""
+	TransferDirectionIn  TransferDirection = "in"
+	TransferDirectionOut TransferDirection = "out"
+)
+
+func TransferDirectionFromString(s string) (TransferDirection, error) {
+	switch s {
+	case "":
+		return TransferDirectionAny, nil
+	case "in":
+		return TransferDirectionIn, nil
+	case "out":
+		return TransferDirectionOut, nil
+	}
+
+	return TransferDirectionAny, errors.New("unknown direction: " + s)
+}
+
 type Transfer struct {
 	Hash      string         `json:"hash"`
 	TxHash    string         `json:"tx_hash"`
@@ -55,6 +76,13 @@ type Transfer struct {
 	Status    TransferStatus `json:"status"`
 }
 
+// TransferSearchResult is a transfer that matched a search, higher ranks are better matches
+type TransferSearchResult struct {
+	*Transfer
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
 type TransferData struct {
 	Description string `json:"description"`
 }
@@ -82,20 +110,47 @@ func (t *Transfer) CombineFromTo() string {
 	return fmt.Sprintf("%s_%s", t.From, t.To)
 }
 
-// generate hash for transfer using a provided index, from, to and the tx hash
-func (t *Transfer) GenerateUniqueHash() string {
+// GenerateUniqueHash generates the hash of a transfer from its from, to and tx hash, the value is not part of it.
+// Transfers of a transaction between the same from and to are told apart by how many came before them, the occurrence.
+// The first one keeps the hash that transfers always had.
+func (t *Transfer) GenerateUniqueHash(occurrence int) string {
 	buf := new(bytes.Buffer)
 
 	// Write each value to the buffer as bytes
 	buf.Write(common.FromHex(t.From))
 	buf.Write(common.FromHex(t.To))
-	binary.Write(buf, binary.BigEndian, t.Value)
 	buf.Write(common.FromHex(t.TxHash))
 
+	if occurrence > 0 {
+		binary.Write(buf, binary.BigEndian, uint64(occurrence))
+	}
+
 	hash := crypto.Keccak256Hash(buf.Bytes())
 	return hash.Hex()
 }
 
+// TransferCounter hashes the transfers of a transaction in the order they are made.
+// The transfers that are sent and the ones that are indexed once they are mined get the same hashes.
+type TransferCounter struct {
+	txHash string
+	seen   map[string]int
+}
+
+// Hash returns the unique hash of the next transfer, the count starts over when the transaction changes
+func (c *TransferCounter) Hash(t *Transfer) string {
+	if c.seen == nil || c.txHash != t.TxHash {
+		c.txHash = t.TxHash
+		c.seen = map[string]int{}
+	}
+
+	k := t.CombineFromTo()
+
+	occurrence := c.seen[k]
+	c.seen[k]++
+
+	return t.GenerateUniqueHash(occurrence)
+}
+
 func (t *Transfer) ToRounded(decimals int64) float64 {
 	v, _ := t.Value.Float64()
 
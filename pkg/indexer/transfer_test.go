@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestTransferCounter(t *testing.T) {
+	alice := "0x29d755C17df3ED2eCAE6e42d694fb4F7E2ff6010"
+	bob := "0xcfa21B33D304D57c4E964e3819588Eb5ac06B4D9"
+	txHash := "0x6e2b6d0e1b1b0c0e2a3b1f5d6b8e9c0a1b2c3d4e5f60718293a4b5c6d7e8f901"
+
+	transfer := func(to string, value int64) *Transfer {
+		return &Transfer{TxHash: txHash, From: alice, To: to, Value: big.NewInt(value)}
+	}
+
+	// a batch that pays bob twice the same amount
+	sent := []*Transfer{transfer(bob, 1), transfer(alice, 1), transfer(bob, 1), transfer(bob, 2)}
+
+	counter := &TransferCounter{}
+	seen := map[string]bool{}
+	for i, tr := range sent {
+		tr.Hash = counter.Hash(tr)
+		if seen[tr.Hash] {
+			t.Fatalf("transfer %d has the hash of an earlier transfer", i)
+		}
+
+		seen[tr.Hash] = true
+	}
+
+	if sent[0].Hash != sent[0].GenerateUniqueHash(0) {
+		t.Errorf("expected the first transfer of its kind to have occurrence 0")
+	}
+
+	// the logs of the transaction are hashed the same way, one at a time
+	indexed := &TransferCounter{}
+	for i, tr := range []*Transfer{transfer(bob, 1), transfer(alice, 1), transfer(bob, 1), transfer(bob, 2)} {
+		if hash := indexed.Hash(tr); hash != sent[i].Hash {
+			t.Errorf("transfer %d: indexed hash %s, want %s", i, hash, sent[i].Hash)
+		}
+	}
+
+	// the count starts over with the next transaction
+	next := transfer(bob, 1)
+	next.TxHash = "0x01"
+	if indexed.Hash(next) != next.GenerateUniqueHash(0) {
+		t.Errorf("expected the count to start over for a new transaction")
+	}
+}
+
+func TestGenerateUniqueHash(t *testing.T) {
+	tr := &Transfer{
+		TxHash: "0x6e2b6d0e1b1b0c0e2a3b1f5d6b8e9c0a1b2c3d4e5f60718293a4b5c6d7e8f901",
+		From:   "0x29d755C17df3ED2eCAE6e42d694fb4F7E2ff6010",
+		To:     "0xcfa21B33D304D57c4E964e3819588Eb5ac06B4D9",
+		Value:  big.NewInt(1),
+	}
+
+	// the first transfer keeps the hash of the transfers that were indexed before occurrences were counted
+	baseline := crypto.Keccak256Hash(common.FromHex(tr.From), common.FromHex(tr.To), common.FromHex(tr.TxHash)).Hex()
+	if tr.GenerateUniqueHash(0) != baseline {
+		t.Errorf("expected the hash %s, got %s", baseline, tr.GenerateUniqueHash(0))
+	}
+
+	if tr.GenerateUniqueHash(1) == baseline {
+		t.Error("expected the next occurrence to have another hash")
+	}
+}
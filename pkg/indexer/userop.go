@@ -3,11 +3,49 @@ package indexer
 import (
 	"encoding/json"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
+type UserOpStatus string
+
+const (
+	UserOpStatusQueued    UserOpStatus = "queued"    // the op was validated and is waiting to be bundled
+	UserOpStatusSubmitted UserOpStatus = "submitted" // the bundle was sent to the chain
+	UserOpStatusMined     UserOpStatus = "mined"     // the bundle was mined, the result of the op is not known yet
+	UserOpStatusSuccess   UserOpStatus = "success"   // the op was executed successfully
+	UserOpStatusFail      UserOpStatus = "fail"      // the bundle could not be sent or mined, or the op reverted
+	UserOpStatusScheduled UserOpStatus = "scheduled" // the op was validated and is waiting to be due
+	UserOpStatusCancelled UserOpStatus = "cancelled" // the scheduled op was cancelled by its sender
+)
+
+// UserOpUpdate is published every time the status of a user operation sent through the bundler changes
+type UserOpUpdate struct {
+	Hash       string       `json:"hash"`
+	TxHash     string       `json:"tx_hash"`
+	EntryPoint string       `json:"entry_point"`
+	Sender     string       `json:"sender"`
+	Nonce      string       `json:"nonce"`
+	Status     UserOpStatus `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// UserOpRecord is a user operation that was submitted through the bundler, stored by its ERC-4337 hash
+type UserOpRecord struct {
+	Hash       string       `json:"hash"`
+	TxHash     string       `json:"tx_hash"`
+	EntryPoint string       `json:"entry_point"`
+	Paymaster  string       `json:"paymaster"`
+	UserOp     UserOp       `json:"user_op"`
+	Status     UserOpStatus `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
 type UserOp struct {
 	Sender               common.Address `json:"sender"               mapstructure:"sender"               validate:"required"`
 	Nonce                *big.Int       `json:"nonce"                mapstructure:"nonce"                validate:"required"`
@@ -52,6 +90,8 @@ func (op *UserOp) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON parses a JSON encoding of the UserOperation.
+// The unpacked fields of a v0.7 op are accepted as well, the factory and the paymaster fields are packed into
+// the init code and the paymaster data as the v0.7 entry point expects them.
 func (op *UserOp) UnmarshalJSON(input []byte) error {
 	type Alias struct {
 		Sender               string `json:"sender"`
@@ -65,6 +105,14 @@ func (op *UserOp) UnmarshalJSON(input []byte) error {
 		MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
 		PaymasterAndData     string `json:"paymasterAndData"`
 		Signature            string `json:"signature"`
+
+		// v0.7
+		Factory                       string `json:"factory"`
+		FactoryData                   string `json:"factoryData"`
+		Paymaster                     string `json:"paymaster"`
+		PaymasterVerificationGasLimit string `json:"paymasterVerificationGasLimit"`
+		PaymasterPostOpGasLimit       string `json:"paymasterPostOpGasLimit"`
+		PaymasterData                 string `json:"paymasterData"`
 	}
 
 	aux := &Alias{}
@@ -84,9 +132,109 @@ func (op *UserOp) UnmarshalJSON(input []byte) error {
 	op.PaymasterAndData, _ = hexutil.Decode(aux.PaymasterAndData)
 	op.Signature, _ = hexutil.Decode(aux.Signature)
 
+	if aux.Factory != "" && common.IsHexAddress(aux.Factory) {
+		factoryData, _ := hexutil.Decode(aux.FactoryData)
+
+		op.InitCode = append(common.HexToAddress(aux.Factory).Bytes(), factoryData...)
+	}
+
+	if aux.Paymaster != "" && common.IsHexAddress(aux.Paymaster) {
+		verificationGasLimit, _ := hexutil.DecodeBig(aux.PaymasterVerificationGasLimit)
+		postOpGasLimit, _ := hexutil.DecodeBig(aux.PaymasterPostOpGasLimit)
+		paymasterData, _ := hexutil.Decode(aux.PaymasterData)
+
+		op.PaymasterAndData = PackPaymasterAndData(common.HexToAddress(aux.Paymaster), verificationGasLimit, postOpGasLimit, paymasterData)
+	}
+
 	return nil
 }
 
+// PackPaymasterAndData packs the paymaster fields of a v0.7 op, the gas limits are uint128 and a missing limit is 0
+func PackPaymasterAndData(paymaster common.Address, verificationGasLimit, postOpGasLimit *big.Int, data []byte) []byte {
+	packed := make([]byte, paymasterDataOffsetV07, paymasterDataOffsetV07+len(data))
+	copy(packed, paymaster.Bytes())
+
+	if verificationGasLimit != nil && verificationGasLimit.BitLen() <= 128 {
+		verificationGasLimit.FillBytes(packed[20:36])
+	}
+
+	if postOpGasLimit != nil && postOpGasLimit.BitLen() <= 128 {
+		postOpGasLimit.FillBytes(packed[36:52])
+	}
+
+	return append(packed, data...)
+}
+
+// PaymasterGasLimits returns the verification and post op gas limits of the paymaster of a v0.7 op, they are 0 if there is no paymaster
+func (op *UserOp) PaymasterGasLimits() (*big.Int, *big.Int) {
+	if len(op.PaymasterAndData) < paymasterDataOffsetV07 {
+		return big.NewInt(0), big.NewInt(0)
+	}
+
+	return new(big.Int).SetBytes(op.PaymasterAndData[20:36]), new(big.Int).SetBytes(op.PaymasterAndData[36:52])
+}
+
+// UserOpV07 is a user operation of a v0.7 entry point, it is encoded in JSON with the unpacked v0.7 fields
+type UserOpV07 UserOp
+
+// MarshalJSON returns a JSON encoding of the v0.7 UserOperation.
+func (op *UserOpV07) MarshalJSON() ([]byte, error) {
+	u := (*UserOp)(op)
+
+	v := struct {
+		Sender                        string  `json:"sender"`
+		Nonce                         string  `json:"nonce"`
+		Factory                       *string `json:"factory"`
+		FactoryData                   *string `json:"factoryData"`
+		CallData                      string  `json:"callData"`
+		CallGasLimit                  string  `json:"callGasLimit"`
+		VerificationGasLimit          string  `json:"verificationGasLimit"`
+		PreVerificationGas            string  `json:"preVerificationGas"`
+		MaxFeePerGas                  string  `json:"maxFeePerGas"`
+		MaxPriorityFeePerGas          string  `json:"maxPriorityFeePerGas"`
+		Paymaster                     *string `json:"paymaster"`
+		PaymasterVerificationGasLimit *string `json:"paymasterVerificationGasLimit"`
+		PaymasterPostOpGasLimit       *string `json:"paymasterPostOpGasLimit"`
+		PaymasterData                 *string `json:"paymasterData"`
+		Signature                     string  `json:"signature"`
+	}{
+		Sender:               u.Sender.String(),
+		Nonce:                hexutil.EncodeBig(u.Nonce),
+		CallData:             hexutil.Encode(u.CallData),
+		CallGasLimit:         hexutil.EncodeBig(u.CallGasLimit),
+		VerificationGasLimit: hexutil.EncodeBig(u.VerificationGasLimit),
+		PreVerificationGas:   hexutil.EncodeBig(u.PreVerificationGas),
+		MaxFeePerGas:         hexutil.EncodeBig(u.MaxFeePerGas),
+		MaxPriorityFeePerGas: hexutil.EncodeBig(u.MaxPriorityFeePerGas),
+		Signature:            hexutil.Encode(u.Signature),
+	}
+
+	// fields that the op does not have are null
+	if len(u.InitCode) >= common.AddressLength {
+		factory := common.BytesToAddress(u.InitCode[:common.AddressLength]).String()
+		factoryData := hexutil.Encode(u.InitCode[common.AddressLength:])
+
+		v.Factory = &factory
+		v.FactoryData = &factoryData
+	}
+
+	if len(u.PaymasterAndData) >= paymasterDataOffsetV07 {
+		verificationGasLimit, postOpGasLimit := u.PaymasterGasLimits()
+
+		paymaster := common.BytesToAddress(u.PaymasterAndData[:common.AddressLength]).String()
+		pvgl := hexutil.EncodeBig(verificationGasLimit)
+		pogl := hexutil.EncodeBig(postOpGasLimit)
+		paymasterData := hexutil.Encode(u.PaymasterAndData[paymasterDataOffsetV07:])
+
+		v.Paymaster = &paymaster
+		v.PaymasterVerificationGasLimit = &pvgl
+		v.PaymasterPostOpGasLimit = &pogl
+		v.PaymasterData = &paymasterData
+	}
+
+	return json.Marshal(v)
+}
+
 func (u *UserOp) Copy() UserOp {
 	copy := UserOp{
 		Sender:               u.Sender,
@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const defaultSpendWarn = 80 // percentage of a spend budget at which a warning is sent if the policy does not set one
+
+// GasLedger records the gas that the mined bundles used, attributed to their user operations and senders.
+// A warning is sent through the webhook when a paymaster gets close to one of the spend budgets of its policy.
+type GasLedger struct {
+	db *db.DB
+	wm indexer.WebhookMessager
+
+	mu     sync.Mutex
+	warned map[string]bool // the budgets that a warning was sent for, by paymaster, budget and period
+}
+
+// NewGasLedger creates a new GasLedger
+func NewGasLedger(db *db.DB, wm indexer.WebhookMessager) *GasLedger {
+	return &GasLedger{
+		db:     db,
+		wm:     wm,
+		warned: map[string]bool{},
+	}
+}
+
+// Record adds the gas of a mined bundle to the ledger, the gas that each op used is read from its UserOperationEvent
+func (l *GasLedger) Record(txms []indexer.UserOpMessage, rcpt *types.Receipt) error {
+	if rcpt == nil || len(txms) == 0 {
+		return nil
+	}
+
+	price := rcpt.EffectiveGasPrice
+	if price == nil {
+		// some nodes do not return the effective gas price, the gas is still recorded
+		price = new(big.Int)
+	}
+
+	now := time.Now().UTC()
+	day := now.Format(indexer.GasDayLayout)
+
+	hashes := make([]common.Hash, len(txms))
+	opGas := make([]*big.Int, len(txms))
+	for i, txm := range txms {
+		hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+		if err != nil {
+			return err
+		}
+
+		hashes[i] = hash
+
+		result, err := comm.ParseUserOpResult(rcpt.Logs, hash)
+		if err == nil && result != nil {
+			opGas[i] = result.ActualGasUsed
+		}
+	}
+
+	gas := attributeGas(rcpt.GasUsed, opGas)
+
+	entries := []*indexer.GasEntry{}
+	for i, txm := range txms {
+		entries = append(entries, &indexer.GasEntry{
+			TxHash:     rcpt.TxHash.Hex(),
+			UserOpHash: hashes[i].Hex(),
+			Paymaster:  txm.Paymaster.Hex(),
+			Sender:     txm.UserOp.Sender.Hex(),
+			GasUsed:    gas[i],
+			GasPrice:   price,
+			Cost:       new(big.Int).Mul(big.NewInt(gas[i]), price),
+			Day:        day,
+			CreatedAt:  now,
+		})
+	}
+
+	err := l.db.GasDB.AddGasEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	// each sender of the bundle could have reached a budget
+	checked := map[string]bool{}
+	for _, e := range entries {
+		if checked[e.Paymaster+e.Sender] {
+			continue
+		}
+		checked[e.Paymaster+e.Sender] = true
+
+		err := l.warn(e.Paymaster, e.Sender, day)
+		if err != nil {
+			log.Default().Println("error checking spend budgets", e.Paymaster, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// warn sends a warning for each spend budget of the policy of a paymaster that is close to being spent
+func (l *GasLedger) warn(paymaster, sender, day string) error {
+	p, err := l.db.PolicyDB.GetPolicy(paymaster)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	totals, err := l.db.GasDB.GetSpendTotals(paymaster, sender, day)
+	if err != nil {
+		return err
+	}
+
+	percent := p.Spend.Warn
+	if percent == 0 {
+		percent = defaultSpendWarn
+	}
+
+	for _, budget := range p.Spend.Over(totals, percent) {
+		period := day
+		if strings.HasSuffix(budget, "month") {
+			period = day[:7]
+		}
+
+		scope := paymaster
+		if strings.HasPrefix(budget, "account") {
+			scope = fmt.Sprintf("%s for %s", paymaster, sender)
+		}
+
+		key := fmt.Sprintf("%s:%s:%s", scope, budget, period)
+
+		l.mu.Lock()
+		warned := l.warned[key]
+		l.warned[key] = true
+		l.mu.Unlock()
+
+		if warned {
+			continue
+		}
+
+		l.wm.NotifyWarning(context.Background(), fmt.Errorf("%d%% of the %s spend budget of paymaster %s was spent in %s", percent, budget, scope, period))
+	}
+
+	return nil
+}
+
+// attributeGas splits the gas that a bundle used between its ops, in proportion to the gas that each op used
+// according to its UserOperationEvent, or evenly if not every op has one. The shares add up to the gas of the bundle.
+func attributeGas(gasUsed uint64, opGas []*big.Int) []int64 {
+	shares := make([]int64, len(opGas))
+	if len(opGas) == 0 {
+		return shares
+	}
+
+	total := new(big.Int)
+	for _, g := range opGas {
+		if g == nil || g.Sign() <= 0 {
+			total = nil
+			break
+		}
+
+		total.Add(total, g)
+	}
+
+	bundle := new(big.Int).SetUint64(gasUsed)
+
+	assigned := new(big.Int)
+	for i, g := range opGas {
+		share := new(big.Int)
+		if total != nil {
+			share.Mul(bundle, g)
+			share.Div(share, total)
+		} else {
+			share.Div(bundle, big.NewInt(int64(len(opGas))))
+		}
+
+		shares[i] = share.Int64()
+		assigned.Add(assigned, share)
+	}
+
+	// what is left from rounding goes to the last op
+	shares[len(shares)-1] += new(big.Int).Sub(bundle, assigned).Int64()
+
+	return shares
+}
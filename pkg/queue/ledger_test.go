@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestAttributeGas(t *testing.T) {
+	cases := []struct {
+		name     string
+		gasUsed  uint64
+		opGas    []*big.Int
+		expected []int64
+	}{
+		{name: "proportional", gasUsed: 1000, opGas: []*big.Int{big.NewInt(100), big.NewInt(300)}, expected: []int64{250, 750}},
+		{name: "missing event", gasUsed: 1000, opGas: []*big.Int{big.NewInt(100), nil}, expected: []int64{500, 500}},
+		{name: "remainder", gasUsed: 1000, opGas: []*big.Int{nil, nil, nil}, expected: []int64{333, 333, 334}},
+		{name: "single", gasUsed: 1000, opGas: []*big.Int{big.NewInt(900)}, expected: []int64{1000}},
+		{name: "empty", gasUsed: 1000, opGas: []*big.Int{}, expected: []int64{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			shares := attributeGas(c.gasUsed, c.opGas)
+			if len(shares) != len(c.expected) {
+				t.Fatalf("expected %d shares, got %d", len(c.expected), len(shares))
+			}
+
+			for i := range shares {
+				if shares[i] != c.expected[i] {
+					t.Fatalf("expected %v, got %v", c.expected, shares)
+				}
+			}
+		})
+	}
+}
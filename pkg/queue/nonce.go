@@ -0,0 +1,522 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	nonceCheckInterval = 10 * time.Second // How often the pending transactions of the sponsors are checked
+	stuckAfter         = 60 * time.Second // How long a transaction can be pending before its fees are bumped
+	maxFeeBumps        = 5                // How many times the fees of a transaction are bumped, after that it is only re-broadcast
+	reservationTTL     = 2 * time.Minute  // How long a nonce can be reserved without a transaction being sent before it is filled
+)
+
+// ErrTxReplaced is given to a transaction whose nonce was used by a transaction that the nonce manager did not send
+var ErrTxReplaced = errors.New("transaction was replaced")
+
+// MinedHandler is called once the nonce of a transaction is used, with the receipt of the transaction that was mined for it
+type MinedHandler func(rcpt *types.Receipt, err error)
+
+// ReplacedHandler is called when a transaction is replaced with bumped fees, with the replacement
+type ReplacedHandler func(tx *types.Transaction)
+
+// pendingTx is a transaction of a sponsor that is not mined yet
+type pendingTx struct {
+	tx       *types.Transaction
+	hashes   []common.Hash // the hashes of the transaction and of its replacements
+	sentAt   time.Time
+	bumps    int
+	mined    MinedHandler
+	replaced ReplacedHandler
+}
+
+// sponsorNonces are the nonces handed out for a sponsor
+type sponsorNonces struct {
+	checkMu   sync.Mutex     // checks of a sponsor do not overlap
+	paymaster common.Address // the paymaster whose keys the signer signs with
+	next      uint64
+	reserved  map[uint64]time.Time // nonces that were handed out and that no transaction was sent for yet
+	pending   map[uint64]*pendingTx
+}
+
+// nonceJob is a nonce that a check sends a transaction for, the pending transaction is sent again or the nonce is filled if there is none
+type nonceJob struct {
+	nonce uint64
+	p     *pendingTx
+	tx    *types.Transaction // the transaction of p when the job was collected
+	bumps int
+}
+
+// NonceManager hands out the nonces of the sponsors and makes sure that their transactions get mined.
+// Stuck transactions are sent again with bumped fees and nonces that were released or whose reservation expired are filled with zero value self-sends.
+type NonceManager struct {
+	mu       sync.Mutex
+	evm      indexer.EVMRequester
+	signer   indexer.Signer
+	sponsors map[common.Address]*sponsorNonces
+}
+
+// NewNonceManager creates a new NonceManager, replacements and fills are signed by the signer
+func NewNonceManager(evm indexer.EVMRequester, signer indexer.Signer) *NonceManager {
+	return &NonceManager{
+		evm:      evm,
+		signer:   signer,
+		sponsors: map[common.Address]*sponsorNonces{},
+	}
+}
+
+// Start checks the pending transactions of the sponsors until the context is done
+func (m *NonceManager) Start(ctx context.Context) error {
+	ticker := time.NewTicker(nonceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			sponsors := make([]common.Address, 0, len(m.sponsors))
+			for sponsor := range m.sponsors {
+				sponsors = append(sponsors, sponsor)
+			}
+			m.mu.Unlock()
+
+			for _, sponsor := range sponsors {
+				err := m.Check(sponsor)
+				if err != nil {
+					log.Default().Println(fmt.Sprintf("error checking nonces of %s: %s", sponsor.Hex(), err))
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Next reserves the next nonce of a sponsor key of the paymaster, the nonces of a sponsor that was not used yet start at its pending nonce
+func (m *NonceManager) Next(sponsor, paymaster common.Address) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sn, ok := m.sponsors[sponsor]
+	if !ok {
+		next, err := m.evm.PendingNonceAt(context.Background(), sponsor)
+		if err != nil {
+			return 0, err
+		}
+
+		sn = &sponsorNonces{
+			next:     next,
+			reserved: map[uint64]time.Time{},
+			pending:  map[uint64]*pendingTx{},
+		}
+
+		m.sponsors[sponsor] = sn
+	}
+
+	sn.paymaster = paymaster
+
+	nonce := sn.next
+	sn.next++
+
+	sn.reserved[nonce] = time.Now()
+
+	return nonce, nil
+}
+
+// Release gives back a nonce whose transaction was not sent, a nonce that is followed by others is left as a hole to be filled
+func (m *NonceManager) Release(sponsor common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sn, ok := m.sponsors[sponsor]
+	if !ok {
+		return
+	}
+
+	delete(sn.reserved, nonce)
+
+	if nonce+1 == sn.next {
+		sn.next--
+	}
+}
+
+// Sent tracks a transaction that was sent until it is mined, mined is called once its nonce is used and replaced every time its fees are bumped
+func (m *NonceManager) Sent(sponsor common.Address, tx *types.Transaction, mined MinedHandler, replaced ReplacedHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sn, ok := m.sponsors[sponsor]
+	if !ok {
+		return
+	}
+
+	delete(sn.reserved, tx.Nonce())
+
+	sn.pending[tx.Nonce()] = &pendingTx{
+		tx:       tx,
+		hashes:   []common.Hash{tx.Hash()},
+		sentAt:   time.Now(),
+		mined:    mined,
+		replaced: replaced,
+	}
+}
+
+// Resume tracks a transaction that was sent before a restart like one that was just sent,
+// the nonces of its sponsor are not handed out again until it is mined
+func (m *NonceManager) Resume(sponsor, paymaster common.Address, tx *types.Transaction, mined MinedHandler, replaced ReplacedHandler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sn, ok := m.sponsors[sponsor]
+	if !ok {
+		next, err := m.evm.PendingNonceAt(context.Background(), sponsor)
+		if err != nil {
+			return err
+		}
+
+		sn = &sponsorNonces{
+			paymaster: paymaster,
+			next:      next,
+			reserved:  map[uint64]time.Time{},
+			pending:   map[uint64]*pendingTx{},
+		}
+
+		m.sponsors[sponsor] = sn
+	}
+
+	// the node could have dropped the transaction
+	if tx.Nonce() >= sn.next {
+		sn.next = tx.Nonce() + 1
+	}
+
+	sn.pending[tx.Nonce()] = &pendingTx{
+		tx:       tx,
+		hashes:   []common.Hash{tx.Hash()},
+		sentAt:   time.Now(),
+		mined:    mined,
+		replaced: replaced,
+	}
+
+	return nil
+}
+
+// Check goes through the nonces of a sponsor that are not mined yet.
+// Transactions that were mined are resolved, transactions that were dropped are sent again,
+// stuck transactions are replaced with bumped fees and nonces that were released or whose reservation expired are filled with zero value self-sends.
+// The nonce manager is only locked while its state is read or updated, not while the node or the signer are called.
+func (m *NonceManager) Check(sponsor common.Address) error {
+	m.mu.Lock()
+	sn, ok := m.sponsors[sponsor]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	sn.checkMu.Lock()
+	defer sn.checkMu.Unlock()
+
+	latest, err := m.evm.NonceAt(context.Background(), sponsor, nil)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+
+	for nonce, p := range sn.pending {
+		if nonce >= latest {
+			continue
+		}
+
+		delete(sn.pending, nonce)
+
+		if p.mined != nil {
+			go func(p *pendingTx) {
+				p.mined(m.minedReceipt(p))
+			}(p)
+		}
+	}
+
+	for nonce := range sn.reserved {
+		if nonce < latest {
+			delete(sn.reserved, nonce)
+		}
+	}
+
+	// transactions were sent without the nonce manager
+	if latest > sn.next {
+		sn.next = latest
+	}
+
+	done := latest == sn.next
+	paymaster := sn.paymaster
+
+	m.mu.Unlock()
+
+	if done {
+		return nil
+	}
+
+	pendingNonce, err := m.evm.PendingNonceAt(context.Background(), sponsor)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+
+	jobs := []nonceJob{}
+	for nonce := latest; nonce < sn.next; nonce++ {
+		p, ok := sn.pending[nonce]
+		if !ok {
+			reservedAt, ok := sn.reserved[nonce]
+			if ok && time.Since(reservedAt) < reservationTTL {
+				// the transaction for this nonce is still being sent
+				continue
+			}
+
+			if nonce < pendingNonce {
+				// the node knows a transaction for this nonce
+				continue
+			}
+
+			jobs = append(jobs, nonceJob{nonce: nonce})
+			continue
+		}
+
+		if nonce < pendingNonce && time.Since(p.sentAt) < stuckAfter {
+			continue
+		}
+
+		// the transaction was dropped or is stuck
+		jobs = append(jobs, nonceJob{nonce: nonce, p: p, tx: p.tx, bumps: p.bumps})
+	}
+
+	m.mu.Unlock()
+
+	for _, job := range jobs {
+		if job.p == nil {
+			err = m.fill(sponsor, paymaster, sn, job.nonce)
+		} else {
+			err = m.replace(sponsor, paymaster, sn, job)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// minedReceipt returns the receipt of the transaction that was mined for the nonce of a pending transaction
+func (m *NonceManager) minedReceipt(p *pendingTx) (*types.Receipt, error) {
+	for _, hash := range p.hashes {
+		rcpt, err := m.evm.TransactionReceipt(hash)
+		if err != nil {
+			if errors.Is(err, ethereum.NotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if rcpt.Status != types.ReceiptStatusSuccessful {
+			return rcpt, errors.New("tx failed")
+		}
+
+		return rcpt, nil
+	}
+
+	return nil, ErrTxReplaced
+}
+
+// replace sends a transaction again, with bumped fees until it was bumped too many times
+func (m *NonceManager) replace(sponsor, paymaster common.Address, sn *sponsorNonces, job nonceJob) error {
+	if job.bumps >= maxFeeBumps {
+		err := m.broadcast(job.tx)
+
+		m.replaced(sn, job, nil)
+
+		return err
+	}
+
+	baseFee, err := m.evm.BaseFee()
+	if err != nil {
+		return err
+	}
+
+	tip := bumpFee(job.tx.GasTipCap())
+
+	feeCap := bumpFee(job.tx.GasFeeCap())
+
+	// the fee cap should still cover the base fee if it went up
+	minFeeCap := new(big.Int).Add(tip, new(big.Int).Mul(baseFee, big.NewInt(2)))
+	if feeCap.Cmp(minFeeCap) < 0 {
+		feeCap = minFeeCap
+	}
+
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   job.tx.ChainId(),
+		Nonce:     job.tx.Nonce(),
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       job.tx.Gas(),
+		To:        job.tx.To(),
+		Value:     job.tx.Value(),
+		Data:      job.tx.Data(),
+	})
+
+	signedTx, err := m.signer.SignTx(paymaster, sponsor, tx, job.tx.ChainId())
+	if err != nil {
+		return err
+	}
+
+	err = m.broadcast(signedTx)
+	if err != nil {
+		return err
+	}
+
+	log.Default().Println(fmt.Sprintf("replaced transaction %s with %s for nonce %d", job.tx.Hash().Hex(), signedTx.Hash().Hex(), job.nonce))
+
+	if m.replaced(sn, job, signedTx) && job.p.replaced != nil {
+		job.p.replaced(signedTx)
+	}
+
+	return nil
+}
+
+// replaced records that the transaction of a job was sent again, with the replacement if its fees were bumped.
+// A pending transaction that changed in the meantime is left as is, false is returned if the replacement was not recorded.
+func (m *NonceManager) replaced(sn *sponsorNonces, job nonceJob, signedTx *types.Transaction) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sn.pending[job.nonce] != job.p || job.p.tx != job.tx {
+		return false
+	}
+
+	job.p.sentAt = time.Now()
+
+	if signedTx == nil {
+		return false
+	}
+
+	job.p.tx = signedTx
+	job.p.hashes = append(job.p.hashes, signedTx.Hash())
+	job.p.bumps++
+
+	return true
+}
+
+// fill sends a zero value transaction to the sponsor itself to use a nonce that no transaction was sent for
+func (m *NonceManager) fill(sponsor, paymaster common.Address, sn *sponsorNonces, nonce uint64) error {
+	chainId, err := m.evm.ChainID()
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.evm.NewTx(nonce, sponsor, sponsor, nil, false)
+	if err != nil {
+		return err
+	}
+
+	signedTx, err := m.signer.SignTx(paymaster, sponsor, tx, chainId)
+	if err != nil {
+		return err
+	}
+
+	err = m.broadcast(signedTx)
+	if err != nil {
+		return err
+	}
+
+	log.Default().Println(fmt.Sprintf("filled nonce %d of %s with %s", nonce, sponsor.Hex(), signedTx.Hash().Hex()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(sn.reserved, nonce)
+
+	// a transaction that was sent for the nonce in the meantime is the one that is tracked
+	if _, ok := sn.pending[nonce]; !ok {
+		sn.pending[nonce] = &pendingTx{
+			tx:     signedTx,
+			hashes: []common.Hash{signedTx.Hash()},
+			sentAt: time.Now(),
+		}
+	}
+
+	return nil
+}
+
+// broadcast sends a transaction, a transaction the node already has is not an error
+func (m *NonceManager) broadcast(tx *types.Transaction) error {
+	err := m.evm.SendTransaction(tx)
+	if err != nil && !isKnownTxError(err) {
+		return err
+	}
+
+	return nil
+}
+
+// bumpFee increases a fee by 12.5%, which is more than the 10% nodes require to replace a transaction
+func bumpFee(fee *big.Int) *big.Int {
+	return new(big.Int).Add(fee, new(big.Int).Div(fee, big.NewInt(8)))
+}
+
+// isKnownTxError returns true if the node rejected a transaction because it already has it
+func isKnownTxError(err error) bool {
+	msg := err.Error()
+
+	return strings.Contains(msg, "already known") || strings.Contains(msg, "known transaction")
+}
+
+// rejectedTxReasons are the errors of nodes that refused a transaction, it was not broadcast
+var rejectedTxReasons = []string{
+	"insufficient funds",
+	"intrinsic gas too low",
+	"exceeds block gas limit",
+	"underpriced",
+	"fee cap less than block base fee",
+	"max fee per gas less than block base fee",
+	"invalid sender",
+	"oversized data",
+}
+
+// isRejectedTxError returns true if the node definitely refused a transaction. A transaction that the node already knows,
+// that failed for another reason or whose request did not get an answer may have been broadcast.
+func isRejectedTxError(err error) bool {
+	if isKnownTxError(err) {
+		return false
+	}
+
+	e, ok := err.(rpc.Error)
+	if !ok {
+		return false
+	}
+
+	if e.ErrorCode() != -32000 {
+		return true
+	}
+
+	msg := err.Error()
+	for _, reason := range rejectedTxReasons {
+		if strings.Contains(msg, reason) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,371 @@
+package queue
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/signer"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestNonceEVM only implements what the nonce manager uses, other calls panic
+type TestNonceEVM struct {
+	indexer.EVMRequester
+
+	mu       sync.Mutex
+	latest   uint64
+	pending  uint64
+	sent     []*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	txs      map[common.Hash]*types.Transaction // the transactions the node knows
+	onSend   func()                             // called before a transaction is sent
+}
+
+func (e *TestNonceEVM) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return e.latest, nil
+}
+
+func (e *TestNonceEVM) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return e.pending, nil
+}
+
+func (e *TestNonceEVM) BaseFee() (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (e *TestNonceEVM) ChainID() (*big.Int, error) {
+	return big.NewInt(1337), nil
+}
+
+func (e *TestNonceEVM) NewTx(nonce uint64, from, to common.Address, data []byte, extraGas bool) (*types.Transaction, error) {
+	return newTestTx(nonce, to), nil
+}
+
+func (e *TestNonceEVM) SendTransaction(tx *types.Transaction) error {
+	if e.onSend != nil {
+		e.onSend()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sent = append(e.sent, tx)
+	return nil
+}
+
+func (e *TestNonceEVM) TransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	rcpt, ok := e.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+
+	return rcpt, nil
+}
+
+func (e *TestNonceEVM) TransactionByHash(hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := e.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+
+	return tx, true, nil
+}
+
+func newTestTx(nonce uint64, to common.Address) *types.Transaction {
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1337),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(800),
+		GasFeeCap: big.NewInt(1600),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(0),
+	})
+}
+
+func TestNonceManager(t *testing.T) {
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	mock := signer.NewMockSigner()
+
+	key, err := mock.AddSponsor(paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sponsor := crypto.PubkeyToAddress(key.PublicKey)
+
+	t.Run("Next starts at the pending nonce", func(t *testing.T) {
+		evm := &TestNonceEVM{latest: 3, pending: 5}
+		m := NewNonceManager(evm, mock)
+
+		for _, expected := range []uint64{5, 6, 7} {
+			nonce, err := m.Next(sponsor, paymaster)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if nonce != expected {
+				t.Fatalf("expected nonce %d, got %d", expected, nonce)
+			}
+		}
+
+		// the last nonce can be given back
+		m.Release(sponsor, 7)
+
+		nonce, _ := m.Next(sponsor, paymaster)
+		if nonce != 7 {
+			t.Fatalf("expected nonce 7 to be reused, got %d", nonce)
+		}
+	})
+
+	t.Run("Holes are filled with self-sends", func(t *testing.T) {
+		evm := &TestNonceEVM{latest: 0, pending: 0}
+		m := NewNonceManager(evm, mock)
+
+		m.Next(sponsor, paymaster)
+		m.Next(sponsor, paymaster)
+
+		// nonce 0 was not sent, nonce 1 was
+		m.Release(sponsor, 0)
+		m.Sent(sponsor, newTestTx(1, common.Address{}), nil, nil)
+		evm.sent = nil
+
+		err := m.Check(sponsor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(evm.sent) != 2 {
+			t.Fatalf("expected the hole to be filled and the dropped tx to be sent again, got %d txs", len(evm.sent))
+		}
+
+		if evm.sent[0].Nonce() != 0 || *evm.sent[0].To() != sponsor || evm.sent[0].Value().Sign() != 0 {
+			t.Fatalf("expected a zero value self-send with nonce 0, got %+v", evm.sent[0])
+		}
+	})
+
+	t.Run("Reserved nonces are not filled until their reservation expires", func(t *testing.T) {
+		evm := &TestNonceEVM{latest: 0, pending: 0}
+		m := NewNonceManager(evm, mock)
+
+		// nonce 0 is still being sent when nonce 1 is
+		m.Next(sponsor, paymaster)
+		m.Next(sponsor, paymaster)
+		m.Sent(sponsor, newTestTx(1, common.Address{}), nil, nil)
+		evm.sent = nil
+
+		err := m.Check(sponsor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(evm.sent) != 1 || evm.sent[0].Nonce() != 1 {
+			t.Fatalf("expected only the dropped tx to be sent again, got %d txs", len(evm.sent))
+		}
+
+		m.sponsors[sponsor].reserved[0] = time.Now().Add(-reservationTTL)
+		evm.sent = nil
+
+		err = m.Check(sponsor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(evm.sent) != 2 || evm.sent[0].Nonce() != 0 || *evm.sent[0].To() != sponsor {
+			t.Fatalf("expected the expired nonce to be filled, got %d txs", len(evm.sent))
+		}
+
+		if _, ok := m.sponsors[sponsor].reserved[0]; ok {
+			t.Fatal("expected the reservation to be removed once the nonce was filled")
+		}
+	})
+
+	t.Run("Check does not lock nonces while sending", func(t *testing.T) {
+		evm := &TestNonceEVM{latest: 0, pending: 0}
+		m := NewNonceManager(evm, mock)
+
+		m.Next(sponsor, paymaster)
+		m.Release(sponsor, 0)
+		m.Next(sponsor, paymaster)
+		m.Next(sponsor, paymaster)
+		m.Release(sponsor, 0)
+		m.Sent(sponsor, newTestTx(1, common.Address{}), nil, nil)
+
+		// a nonce is handed out while the hole is filled
+		next := make(chan uint64, 1)
+		evm.onSend = func() {
+			evm.onSend = nil
+
+			nonce, err := m.Next(sponsor, paymaster)
+			if err != nil {
+				t.Error(err)
+			}
+
+			next <- nonce
+		}
+
+		err := m.Check(sponsor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if nonce := <-next; nonce != 2 {
+			t.Fatalf("expected nonce 2, got %d", nonce)
+		}
+	})
+
+	t.Run("Stuck transactions are replaced with bumped fees", func(t *testing.T) {
+		evm := &TestNonceEVM{latest: 0, pending: 0}
+		m := NewNonceManager(evm, mock)
+
+		nonce, _ := m.Next(sponsor, paymaster)
+		tx := newTestTx(nonce, common.Address{})
+		m.Sent(sponsor, tx, nil, nil)
+		evm.pending = 1
+
+		// not stuck yet
+		m.Check(sponsor)
+		if len(evm.sent) != 0 {
+			t.Fatalf("expected no tx to be sent, got %d", len(evm.sent))
+		}
+
+		m.sponsors[sponsor].pending[nonce].sentAt = time.Now().Add(-stuckAfter)
+
+		m.Check(sponsor)
+		if len(evm.sent) != 1 {
+			t.Fatalf("expected the tx to be replaced, got %d txs", len(evm.sent))
+		}
+
+		replacement := evm.sent[0]
+		if replacement.Nonce() != nonce || replacement.GasTipCap().Int64() != 900 || replacement.GasFeeCap().Int64() != 1800 {
+			t.Fatalf("expected bumped fees for nonce %d, got tip %s and fee cap %s", nonce, replacement.GasTipCap(), replacement.GasFeeCap())
+		}
+	})
+
+	t.Run("The replacement that was mined is resolved", func(t *testing.T) {
+		evm := &TestNonceEVM{latest: 0, pending: 0, receipts: map[common.Hash]*types.Receipt{}}
+		m := NewNonceManager(evm, mock)
+
+		nonce, _ := m.Next(sponsor, paymaster)
+		evm.pending = 1
+
+		mined := make(chan *types.Receipt, 1)
+		var replaced common.Hash
+		m.Sent(sponsor, newTestTx(nonce, common.Address{}), func(rcpt *types.Receipt, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+
+			mined <- rcpt
+		}, func(tx *types.Transaction) {
+			replaced = tx.Hash()
+		})
+
+		m.sponsors[sponsor].pending[nonce].sentAt = time.Now().Add(-stuckAfter)
+		m.Check(sponsor)
+
+		replacement := evm.sent[0]
+		if replaced != replacement.Hash() {
+			t.Fatalf("expected the replaced handler to get %s, got %s", replacement.Hash(), replaced)
+		}
+		evm.receipts[replacement.Hash()] = &types.Receipt{TxHash: replacement.Hash(), Status: types.ReceiptStatusSuccessful}
+		evm.latest = 1
+
+		m.Check(sponsor)
+
+		select {
+		case rcpt := <-mined:
+			if rcpt.TxHash != replacement.Hash() {
+				t.Fatalf("expected the receipt of %s, got %s", replacement.Hash(), rcpt.TxHash)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the mined handler to be called")
+		}
+
+		if len(m.sponsors[sponsor].pending) != 0 {
+			t.Fatalf("expected no pending txs, got %d", len(m.sponsors[sponsor].pending))
+		}
+	})
+
+	t.Run("Resumed transactions are followed", func(t *testing.T) {
+		// the node dropped the transaction during the restart
+		evm := &TestNonceEVM{latest: 2, pending: 2, receipts: map[common.Hash]*types.Receipt{}}
+		m := NewNonceManager(evm, mock)
+
+		mined := make(chan error, 1)
+		err := m.Resume(sponsor, paymaster, newTestTx(2, common.Address{}), func(rcpt *types.Receipt, err error) {
+			mined <- err
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nonce, _ := m.Next(sponsor, paymaster)
+		if nonce != 3 {
+			t.Fatalf("expected the nonce after the resumed tx, got %d", nonce)
+		}
+		m.Release(sponsor, nonce)
+
+		m.Check(sponsor)
+
+		if len(evm.sent) != 1 || evm.sent[0].Nonce() != 2 {
+			t.Fatalf("expected the resumed tx to be sent again, got %d txs", len(evm.sent))
+		}
+
+		resent := evm.sent[0]
+		evm.receipts[resent.Hash()] = &types.Receipt{TxHash: resent.Hash(), Status: types.ReceiptStatusSuccessful}
+		evm.latest = 3
+
+		m.Check(sponsor)
+
+		select {
+		case err := <-mined:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the mined handler to be called")
+		}
+	})
+}
+
+// testRPCError is an error answered by the node
+type testRPCError struct {
+	code int
+	msg  string
+}
+
+func (e testRPCError) Error() string  { return e.msg }
+func (e testRPCError) ErrorCode() int { return e.code }
+
+func TestIsRejectedTxError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"insufficient funds", testRPCError{-32000, "insufficient funds for gas * price + value"}, true},
+		{"underpriced", testRPCError{-32000, "replacement transaction underpriced"}, true},
+		{"invalid params", testRPCError{-32602, "invalid argument 0"}, true},
+		{"already known", testRPCError{-32000, "already known"}, false},
+		{"nonce too low", testRPCError{-32000, "nonce too low"}, false},
+		{"other node error", testRPCError{-32000, "txpool is full"}, false},
+		{"timeout", context.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRejectedTxError(tt.err); got != tt.want {
+				t.Errorf("isRejectedTxError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
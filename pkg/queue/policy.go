@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+// budgetMu makes sure that the budgets are checked and counted one op at a time
+var budgetMu sync.Mutex
+
+// UseBudget counts an op that is submitted towards the budgets of the policy of its paymaster, it is rejected if
+// the budgets of the day are used. The paymaster only checks the budgets when it signs, so that signatures that
+// are never submitted do not use them. Paymasters without a policy are not checked.
+func UseBudget(d *db.DB, txm indexer.UserOpMessage) error {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	paymaster := txm.Paymaster.Hex()
+	sender := txm.UserOp.Sender.Hex()
+
+	p, err := d.PolicyDB.GetPolicy(paymaster)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	day := time.Now().UTC().Format(indexer.GasDayLayout)
+
+	account, daily, err := d.PolicyDB.GetUsage(paymaster, sender, day)
+	if err != nil {
+		return err
+	}
+
+	gas := indexer.OpsGas(&txm.UserOp, 1)
+
+	rejection := p.CheckBudgets(1, gas, account, daily)
+	if rejection != nil {
+		return rejection
+	}
+
+	return d.PolicyDB.AddUsage(paymaster, sender, day, 1, gas)
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestUseBudget(t *testing.T) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	alice := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	bob := common.HexToAddress("0x0000000000000000000000000000000000000003")
+
+	message := func(sender common.Address) indexer.UserOpMessage {
+		txm := testUserOpMessage(sender, 0, []byte{1})
+		txm.Paymaster = paymaster
+		return txm
+	}
+
+	// a paymaster without a policy sponsors every op
+	err = UseBudget(d, message(alice))
+	if err != nil {
+		t.Fatalf("expected an op of a paymaster without a policy to pass, got %v", err)
+	}
+
+	err = d.PolicyDB.SetPolicy(&indexer.Policy{
+		Contract: paymaster.Hex(),
+		Account:  indexer.PolicyBudget{Ops: 2},
+		Daily:    indexer.PolicyBudget{Ops: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		sender common.Address
+		reason indexer.PolicyReason
+	}{
+		{"first op", alice, ""},
+		{"second op", alice, ""},
+		{"account ops", alice, indexer.PolicyReasonAccountOps},
+		{"other sender", bob, ""},
+		{"daily ops", bob, indexer.PolicyReasonDailyOps},
+	}
+
+	for _, c := range cases {
+		err := UseBudget(d, message(c.sender))
+		if c.reason == "" {
+			if err != nil {
+				t.Errorf("%s: expected the op to pass, got %v", c.name, err)
+			}
+			continue
+		}
+
+		rejection, ok := err.(*indexer.JSONRPCError)
+		if !ok {
+			t.Errorf("%s: expected a rejection, got %v", c.name, err)
+			continue
+		}
+
+		if reason := rejection.Data.(*indexer.PolicyRejection).Reason; reason != c.reason {
+			t.Errorf("%s: got reason %s, want %s", c.name, reason, c.reason)
+		}
+	}
+
+	// rejected ops are not counted
+	account, daily, err := d.PolicyDB.GetUsage(paymaster.Hex(), alice.Hex(), time.Now().UTC().Format(indexer.GasDayLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Ops != 2 || account.Gas != 6 || daily.Ops != 3 {
+		t.Errorf("unexpected usage: account %+v, daily %+v", account, daily)
+	}
+}
@@ -10,7 +10,10 @@ import (
 	"github.com/citizenwallet/indexer/pkg/indexer"
 )
 
-const batchSize = 10 // Size of each batch
+const (
+	batchSize     = 10              // Size of each batch
+	leaseDuration = 2 * time.Minute // How long a stored message is held by the batch processing it
+)
 
 // Service struct represents a queue service with a queue channel, quit channel, maximum retries, context and a webhook messager.
 type Service struct {
@@ -20,8 +23,19 @@ type Service struct {
 	maxRetries int                  // Maximum number of retries for processing a message
 	bufferSize int                  // Buffer size of the queue channel
 
-	ctx context.Context         // Context to carry deadlines, cancellation signals, and other request-scoped values across API boundaries and between processes
-	wm  indexer.WebhookMessager // Webhook messager to notify errors
+	ctx   context.Context         // Context to carry deadlines, cancellation signals, and other request-scoped values across API boundaries and between processes
+	wm    indexer.WebhookMessager // Webhook messager to notify errors
+	store Store                   // Store that keeps the messages until they are processed, nil for an in-memory queue
+}
+
+// Store persists the messages of a queue so that they survive a restart
+type Store interface {
+	Save(queue string, message indexer.Message) error                   // Save stores a message before it is queued
+	Lease(queue string, ids []string, until time.Time) error            // Lease marks messages as being processed
+	Release(queue, id string, retryCount int) error                     // Release puts a message back in the queue to be retried
+	Done(queue, id string) error                                        // Done removes a message that was processed or dropped
+	Pending(queue string, expired time.Time) ([]indexer.Message, error) // Pending returns the messages that are not being processed
+	Expired(queue string, expired time.Time) ([]indexer.Message, error) // Expired returns the messages whose lease expired
 }
 
 // Processor is an interface that must be implemented by the consumer of the queue
@@ -29,6 +43,29 @@ type Processor interface {
 	Process([]indexer.Message) ([]indexer.Message, []error) // Process method to process a message
 }
 
+// noRetryError wraps the error of a message that will fail again if it is retried
+type noRetryError struct {
+	err error
+}
+
+func (e noRetryError) Error() string {
+	return e.err.Error()
+}
+
+func (e noRetryError) Unwrap() error {
+	return e.err
+}
+
+// noRetry marks the error of a message so that the message is dropped instead of retried
+func noRetry(err error) error {
+	return noRetryError{err}
+}
+
+// FailureHandler can be implemented by a Processor to be told when a message is dropped after its last retry
+type FailureHandler interface {
+	Failed(indexer.Message, error)
+}
+
 // NewService function initializes a new Service with provided maximum retries, context and webhook messager.
 func NewService(name string, maxRetries, bufferSize int, ctx context.Context, wm indexer.WebhookMessager) *Service {
 	return &Service{
@@ -42,8 +79,32 @@ func NewService(name string, maxRetries, bufferSize int, ctx context.Context, wm
 	}
 }
 
-// Enqueue method enqueues a message to the queue channel.
-func (s *Service) Enqueue(message indexer.Message) {
+// NewDurableService function initializes a new Service which stores its messages until they are processed.
+// Messages that were not processed are replayed when the service starts.
+func NewDurableService(name string, maxRetries, bufferSize int, ctx context.Context, wm indexer.WebhookMessager, store Store) *Service {
+	s := NewService(name, maxRetries, bufferSize, ctx, wm)
+	s.store = store
+
+	return s
+}
+
+// Enqueue method stores the message if the queue is durable and enqueues it to the queue channel.
+// An error is returned if the message could not be stored, it is not queued in that case.
+func (s *Service) Enqueue(message indexer.Message) error {
+	if s.store != nil {
+		err := s.store.Save(s.name, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.push(message)
+
+	return nil
+}
+
+// push method enqueues a message to the queue channel.
+func (s *Service) push(message indexer.Message) {
 	// if the queue channel is almost full, notify the webhook messager with a warning notification
 	bufferWarning := s.bufferSize - (s.bufferSize / 10)
 	if len(s.queue) > bufferWarning {
@@ -63,15 +124,56 @@ func (s *Service) Close() {
 	s.quit <- true
 }
 
+// replay method enqueues the stored messages which are not being processed, their lease is released so that they are not taken twice.
+func (s *Service) replay(msgs []indexer.Message) {
+	for _, msg := range msgs {
+		err := s.store.Release(s.name, msg.ID, msg.RetryCount)
+		if err != nil {
+			log.Default().Println(fmt.Sprintf("error releasing message %s of queue '%s': %s", msg.ID, s.name, err))
+			continue
+		}
+
+		s.push(msg)
+	}
+}
+
 // Start method starts the service and processes messages from the queue channel.
 // If processing a message fails, it requeues the message until the maximum retries is reached.
 // If the queue was empty, it waits for a duration before continuing to avoid a busy loop.
 // It also notifies errors using the webhook messager.
+// A durable service first replays the stored messages, and takes back the messages whose lease expired.
 // The service can be stopped by sending a signal to the quit channel.
 func (s *Service) Start(p Processor) error {
 	log.Default().Println(fmt.Sprintf("starting queue service '%s'", s.name))
+
+	var reclaim <-chan time.Time
+	if s.store != nil {
+		msgs, err := s.store.Pending(s.name, time.Now())
+		if err != nil {
+			return err
+		}
+
+		log.Default().Println(fmt.Sprintf("replaying %d messages of queue '%s'", len(msgs), s.name))
+
+		// the queue channel can be smaller than the stored messages
+		go s.replay(msgs)
+
+		ticker := time.NewTicker(leaseDuration / 2)
+		defer ticker.Stop()
+
+		reclaim = ticker.C
+	}
+
 	for {
 		select {
+		case <-reclaim:
+			msgs, err := s.store.Expired(s.name, time.Now())
+			if err != nil {
+				log.Default().Println(fmt.Sprintf("error reclaiming messages of queue '%s': %s", s.name, err))
+				continue
+			}
+
+			go s.replay(msgs)
 		case message := <-s.queue:
 			// Create a batch
 			batch := make([]indexer.Message, 0, batchSize)
@@ -94,28 +196,51 @@ func (s *Service) Start(p Processor) error {
 				}
 			}
 
+			s.lease(batch)
+
 			msgs, errs := p.Process(batch)
+
+			s.done(batch, msgs, errs)
+
 			for i, msg := range msgs {
 				err := errs[i]
 				if err != nil {
-					if msg.RetryCount < s.maxRetries {
+					var nr noRetryError
+					if msg.RetryCount < s.maxRetries && !errors.As(err, &nr) {
 						// Retry the message
 						msg.RetryCount++
 
+						if s.store != nil {
+							err := s.store.Release(s.name, msg.ID, msg.RetryCount)
+							if err != nil {
+								log.Default().Println(fmt.Sprintf("error releasing message %s of queue '%s': %s", msg.ID, s.name, err))
+							}
+						}
+
 						if len(s.queue) < 1 && len(msgs) == 1 {
 							extraWait := time.Duration(msg.RetryCount) * time.Second
 							time.Sleep(extraWait)
 						}
 
-						s.Enqueue(msg)
+						s.push(msg)
 						continue
 					}
 
 					// Message has exceeded the maximum retries
+					if s.store != nil {
+						err := s.store.Done(s.name, msg.ID)
+						if err != nil {
+							log.Default().Println(fmt.Sprintf("error removing message %s of queue '%s': %s", msg.ID, s.name, err))
+						}
+					}
 
 					// return the error to the response channel
 					msg.Respond(nil, err)
 
+					if fh, ok := p.(FailureHandler); ok {
+						fh.Failed(msg, err)
+					}
+
 					// Notify the webhook messager with an error notification
 					if s.wm != nil {
 						s.wm.NotifyError(s.ctx, err)
@@ -128,3 +253,45 @@ func (s *Service) Start(p Processor) error {
 		}
 	}
 }
+
+// lease method marks the stored messages of a batch as being processed
+func (s *Service) lease(batch []indexer.Message) {
+	if s.store == nil {
+		return
+	}
+
+	ids := make([]string, 0, len(batch))
+	for _, msg := range batch {
+		ids = append(ids, msg.ID)
+	}
+
+	err := s.store.Lease(s.name, ids, time.Now().Add(leaseDuration))
+	if err != nil {
+		log.Default().Println(fmt.Sprintf("error leasing messages of queue '%s': %s", s.name, err))
+	}
+}
+
+// done method removes the stored messages of a batch that were processed without an error
+func (s *Service) done(batch, failed []indexer.Message, errs []error) {
+	if s.store == nil {
+		return
+	}
+
+	failedIDs := map[string]bool{}
+	for i, msg := range failed {
+		if errs[i] != nil {
+			failedIDs[msg.ID] = true
+		}
+	}
+
+	for _, msg := range batch {
+		if failedIDs[msg.ID] {
+			continue
+		}
+
+		err := s.store.Done(s.name, msg.ID)
+		if err != nil {
+			log.Default().Println(fmt.Sprintf("error removing message %s of queue '%s': %s", msg.ID, s.name, err))
+		}
+	}
+}
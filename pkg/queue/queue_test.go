@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -35,6 +36,94 @@ func (p *TestTxProcessor) Process(messages []indexer.Message) ([]indexer.Message
 	return invalidMessages, messageErrors
 }
 
+type TestFailureProcessor struct {
+	TestTxProcessor
+	failed []string
+}
+
+func (p *TestFailureProcessor) Failed(message indexer.Message, err error) {
+	p.failed = append(p.failed, message.ID)
+}
+
+type TestStore struct {
+	mu       sync.Mutex
+	messages map[string]indexer.Message
+	leased   map[string]time.Time
+}
+
+func (st *TestStore) Save(queue string, message indexer.Message) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.messages[message.ID] = message
+	return nil
+}
+
+func (st *TestStore) Lease(queue string, ids []string, until time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, id := range ids {
+		st.leased[id] = until
+	}
+	return nil
+}
+
+func (st *TestStore) Release(queue, id string, retryCount int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	m := st.messages[id]
+	m.RetryCount = retryCount
+	st.messages[id] = m
+
+	delete(st.leased, id)
+	return nil
+}
+
+func (st *TestStore) Done(queue, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.messages, id)
+	delete(st.leased, id)
+	return nil
+}
+
+func (st *TestStore) Pending(queue string, expired time.Time) ([]indexer.Message, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	msgs := []indexer.Message{}
+	for id, m := range st.messages {
+		until, ok := st.leased[id]
+		if !ok || !until.After(expired) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+func (st *TestStore) Expired(queue string, expired time.Time) ([]indexer.Message, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	msgs := []indexer.Message{}
+	for id, until := range st.leased {
+		if !until.After(expired) {
+			msgs = append(msgs, st.messages[id])
+		}
+	}
+	return msgs, nil
+}
+
+func (st *TestStore) len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return len(st.messages)
+}
+
 type TestTxMessager struct {
 	t             *testing.T
 	expectedError error
@@ -64,12 +153,12 @@ func TestProcessMessages(t *testing.T) {
 
 	t.Run("TxMessages", func(t *testing.T) {
 		testCases := []indexer.Message{
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
 		}
 
 		m := &TestTxMessager{t, expectedTxError}
@@ -104,12 +193,12 @@ func TestProcessMessages(t *testing.T) {
 
 	t.Run("TxMessages with 1 invalid", func(t *testing.T) {
 		testCases := []indexer.Message{
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
 			{ID: "invalid", CreatedAt: time.Now(), RetryCount: 0, Message: "invalid"},
-			*indexer.NewTxMessage(common.Address{}, common.Address{}, common.Big0, indexer.UserOp{}, nil),
+			*indexer.NewTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
 		}
 
 		m := &TestTxMessager{t, expectedTxError}
@@ -142,6 +231,87 @@ func TestProcessMessages(t *testing.T) {
 		}
 	})
 
+	t.Run("Failed after the last retry", func(t *testing.T) {
+		testCases := []indexer.Message{
+			*indexer.NewAsyncTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{}, nil),
+			{ID: "invalid", CreatedAt: time.Now(), RetryCount: 0, Message: "invalid"},
+		}
+
+		m := &TestTxMessager{t, expectedTxError}
+		q := NewService("tx", 2, 10, nil, m)
+
+		p := &TestFailureProcessor{TestTxProcessor{t, len(testCases) + 2, 0, expectedTxError}, []string{}}
+
+		go func() {
+			for _, tc := range testCases {
+				q.Enqueue(tc)
+			}
+
+			for {
+				if p.count >= p.expectedCount {
+					break
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+			q.Close()
+		}()
+
+		err := q.Start(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.failed) != 1 || p.failed[0] != "invalid" {
+			t.Fatalf("expected only the invalid message to fail, got %v", p.failed)
+		}
+	})
+
+	t.Run("Durable queue replays and removes processed messages", func(t *testing.T) {
+		st := &TestStore{messages: map[string]indexer.Message{}, leased: map[string]time.Time{}}
+
+		// a message that was stored before a restart, and one that was being processed when it happened
+		stored := indexer.NewAsyncTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{Signature: []byte{1}}, nil)
+		leased := indexer.NewAsyncTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{Signature: []byte{2}}, nil)
+		st.Save("tx", *stored)
+		st.Save("tx", *leased)
+		st.Lease("tx", []string{leased.ID}, time.Now().Add(-time.Second))
+
+		invalid := indexer.Message{ID: "invalid", CreatedAt: time.Now(), RetryCount: 0, Message: "invalid"}
+
+		m := &TestTxMessager{t, expectedTxError}
+		q := NewDurableService("tx", 1, 10, nil, m, st)
+
+		p := &TestTxProcessor{t, 5, 0, expectedTxError}
+
+		go func() {
+			err := q.Enqueue(*indexer.NewAsyncTxMessage(common.Address{}, common.Address{}, indexer.EntryPointV06, common.Big0, indexer.UserOp{Signature: []byte{3}}, nil))
+			if err != nil {
+				t.Error(err)
+			}
+
+			q.Enqueue(invalid)
+
+			for {
+				if p.count >= p.expectedCount {
+					break
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+			q.Close()
+		}()
+
+		err := q.Start(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if st.len() != 0 {
+			t.Fatalf("expected the store to be empty, got %d messages", st.len())
+		}
+	})
+
 	t.Run("Push Notifications", func(t *testing.T) {
 		// TODO: implement
 	})
@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/erc20"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	scheduleCheckInterval = 15 * time.Second // How often the scheduled user operations are checked
+	scheduleBatchSize     = 100              // How many due user operations are checked at a time
+)
+
+var (
+	// ErrScheduleExpired is given to a scheduled op whose paymaster signature expired before it was due
+	ErrScheduleExpired = errors.New("the paymaster signature expired before the user operation was due")
+
+	// ErrScheduleCancelled is given to a scheduled op that was cancelled while it was being submitted
+	ErrScheduleCancelled = errors.New("the scheduled user operation was cancelled")
+)
+
+// Scheduler submits the scheduled user operations to the queue once they are due. An op whose balance condition
+// is not met stays scheduled until it is or until its paymaster signature expires, it is checked again after the other due ops.
+type Scheduler struct {
+	db      *db.DB
+	evm     indexer.EVMRequester
+	useropq *Service
+	chainId *big.Int
+}
+
+// NewScheduler creates a new Scheduler, due ops are added to the given queue
+func NewScheduler(db *db.DB, evm indexer.EVMRequester, useropq *Service, chainId *big.Int) *Scheduler {
+	return &Scheduler{
+		db:      db,
+		evm:     evm,
+		useropq: useropq,
+		chainId: chainId,
+	}
+}
+
+// Start checks the scheduled user operations until the context is done
+func (s *Scheduler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Check(time.Now())
+			if err != nil {
+				log.Default().Println(fmt.Sprintf("error checking scheduled user ops: %s", err))
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Check submits the user operations that are due at the given time
+func (s *Scheduler) Check(now time.Time) error {
+	ops, err := s.db.ScheduleDB.GetDueScheduledUserOps(now.Unix(), scheduleBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		err := s.submit(op, now)
+		if err != nil {
+			log.Default().Println(fmt.Sprintf("error submitting scheduled user op %s: %s", op.Hash, err))
+		}
+	}
+
+	return nil
+}
+
+// submit adds a due user operation to the queue if its condition is met, an op that can no longer be sent fails
+func (s *Scheduler) submit(op *indexer.ScheduledUserOp, now time.Time) error {
+	txm := op.Message(s.chainId)
+
+	if op.ValidUntil <= now.Unix() {
+		return s.fail(op, txm, ErrScheduleExpired)
+	}
+
+	if op.Balance != nil {
+		token, err := erc20.NewErc20(common.HexToAddress(op.Balance.Token), s.evm.Backend())
+		if err != nil {
+			return err
+		}
+
+		balance, err := token.BalanceOf(nil, op.UserOp.Sender)
+		if err != nil {
+			return err
+		}
+
+		if balance.Cmp(op.Balance.Amount.ToInt()) < 0 {
+			// checked again after the other due ops
+			_, err := s.db.ScheduleDB.SetScheduledUserOpStatus(op.Hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusScheduled, "")
+			return err
+		}
+	}
+
+	// an op that was already queued, e.g. before a restart, or sent is not queued again
+	rec, err := s.db.UserOpDB.GetUserOp(op.Hash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == nil && (rec.TxHash != "" || rec.Status == indexer.UserOpStatusQueued) {
+		_, err := s.db.ScheduleDB.SetScheduledUserOpStatus(op.Hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusSubmitted, "")
+		return err
+	}
+
+	// the sponsorship could have been revoked since the op was scheduled
+	err = CheckSponsorship(s.db, txm)
+	if err != nil {
+		return s.fail(op, txm, err)
+	}
+
+	err = UseBudget(s.db, txm)
+	if _, ok := err.(*indexer.JSONRPCError); ok {
+		return s.fail(op, txm, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	txdata, _ := txm.ExtraData.(*indexer.TransferData)
+
+	message := indexer.NewAsyncTxMessage(txm.Paymaster, txm.EntryPoint, txm.Version, txm.ChainId, txm.UserOp, txdata)
+
+	// the op is queued before it is marked as submitted, so that it is not lost if the bundler stops in between
+	err = s.useropq.Enqueue(*message)
+	if err != nil {
+		return s.fail(op, txm, err)
+	}
+
+	SetUserOpStatus(s.db, txm, "", indexer.UserOpStatusQueued, nil)
+
+	SetSponsorshipUsed(s.db, txm, op.Hash)
+
+	// an op that was cancelled in the meantime is dropped before it is bundled
+	_, err = s.db.ScheduleDB.SetScheduledUserOpStatus(op.Hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusSubmitted, "")
+
+	return err
+}
+
+// CheckSchedule rejects an op whose schedule was cancelled, ops that were not scheduled are not checked
+func CheckSchedule(d *db.DB, hash string) error {
+	op, err := d.ScheduleDB.GetScheduledUserOp(hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if op.Status == indexer.ScheduleStatusCancelled {
+		return ErrScheduleCancelled
+	}
+
+	return nil
+}
+
+// fail marks a scheduled user operation that can no longer be sent as failed
+func (s *Scheduler) fail(op *indexer.ScheduledUserOp, txm indexer.UserOpMessage, reason error) error {
+	ok, err := s.db.ScheduleDB.SetScheduledUserOpStatus(op.Hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusFailed, reason.Error())
+	if err != nil || !ok {
+		return err
+	}
+
+	SetUserOpStatus(s.db, txm, "", indexer.UserOpStatusFail, reason)
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// TestBalanceBackend answers balanceOf calls with the same balance for every account
+type TestBalanceBackend struct {
+	bind.ContractBackend
+
+	balance *big.Int
+}
+
+func (b *TestBalanceBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return common.LeftPadBytes(b.balance.Bytes(), 32), nil
+}
+
+type TestScheduleEVM struct {
+	indexer.EVMRequester
+
+	backend *TestBalanceBackend
+}
+
+func (e *TestScheduleEVM) Backend() bind.ContractBackend {
+	return e.backend
+}
+
+func newTestScheduler(t *testing.T, balance int64) (*Scheduler, *db.DB) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		d.Close()
+	})
+
+	evm := &TestScheduleEVM{backend: &TestBalanceBackend{balance: big.NewInt(balance)}}
+
+	return NewScheduler(d, evm, NewService("test", 1, 10, context.Background(), nil), big.NewInt(1337)), d
+}
+
+// addTestScheduledOp schedules an op of the sender that needs a balance of 100 tokens
+func addTestScheduledOp(t *testing.T, d *db.DB, sender common.Address, notBefore, validUntil time.Time) *indexer.ScheduledUserOp {
+	txm := testUserOpMessage(sender, 0, []byte{1})
+
+	op := &indexer.ScheduledUserOp{
+		Paymaster:  "0x0000000000000000000000000000000000000001",
+		EntryPoint: indexer.EntryPointV06Address.Hex(),
+		Version:    indexer.EntryPointV06,
+		UserOp:     txm.UserOp,
+		NotBefore:  notBefore.Unix(),
+		Balance: &indexer.ScheduleBalance{
+			Token:  "0x0000000000000000000000000000000000000100",
+			Amount: (*hexutil.Big)(big.NewInt(100)),
+		},
+		ValidUntil: validUntil.Unix(),
+	}
+
+	hash, err := comm.UserOpHash(op.UserOp, common.HexToAddress(op.EntryPoint), op.Version, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op.Hash = hash.Hex()
+
+	err = d.ScheduleDB.AddScheduledUserOp(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return op
+}
+
+func TestSchedulerCheck(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name       string
+		balance    int64
+		notBefore  time.Time
+		validUntil time.Time
+		cancel     bool
+		status     indexer.ScheduleStatus
+		queued     bool
+	}{
+		{"due", 100, now.Add(-time.Minute), now.Add(time.Hour), false, indexer.ScheduleStatusSubmitted, true},
+		{"not due", 100, now.Add(time.Minute), now.Add(time.Hour), false, indexer.ScheduleStatusScheduled, false},
+		{"balance not met", 99, now.Add(-time.Minute), now.Add(time.Hour), false, indexer.ScheduleStatusScheduled, false},
+		{"expired", 100, now.Add(-time.Minute), now, false, indexer.ScheduleStatusFailed, false},
+		{"cancelled", 100, now.Add(-time.Minute), now.Add(time.Hour), true, indexer.ScheduleStatusCancelled, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, d := newTestScheduler(t, c.balance)
+
+			op := addTestScheduledOp(t, d, common.HexToAddress("0x02"), c.notBefore, c.validUntil)
+
+			if c.cancel {
+				_, err := d.ScheduleDB.SetScheduledUserOpStatus(op.Hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusCancelled, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := s.Check(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sop, err := d.ScheduleDB.GetScheduledUserOp(op.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sop.Status != c.status {
+				t.Errorf("got status %s, want %s", sop.Status, c.status)
+			}
+
+			if queued := len(s.useropq.queue) > 0; queued != c.queued {
+				t.Errorf("got queued %t, want %t", queued, c.queued)
+			}
+
+			rec, err := d.UserOpDB.GetUserOp(op.Hash)
+			switch {
+			case c.queued && (err != nil || rec.Status != indexer.UserOpStatusQueued):
+				t.Errorf("expected the op to be stored as queued, got %v %v", rec, err)
+			case c.status == indexer.ScheduleStatusFailed && (err != nil || rec.Status != indexer.UserOpStatusFail):
+				t.Errorf("expected the op to be stored as failed, got %v %v", rec, err)
+			}
+		})
+	}
+}
+
+func TestSchedulerCheckSent(t *testing.T) {
+	s, d := newTestScheduler(t, 100)
+
+	op := addTestScheduledOp(t, d, common.HexToAddress("0x02"), time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+
+	// the op was already sent, e.g. by the bundler before a restart
+	_, err := d.UserOpDB.SetUserOp(&indexer.UserOpRecord{
+		Hash:       op.Hash,
+		TxHash:     "0x01",
+		EntryPoint: op.EntryPoint,
+		Paymaster:  op.Paymaster,
+		UserOp:     op.UserOp,
+		Status:     indexer.UserOpStatusSubmitted,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Check(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := d.ScheduleDB.GetScheduledUserOp(op.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sop.Status != indexer.ScheduleStatusSubmitted {
+		t.Errorf("got status %s, want %s", sop.Status, indexer.ScheduleStatusSubmitted)
+	}
+
+	if len(s.useropq.queue) != 0 {
+		t.Errorf("expected the op not to be queued again")
+	}
+}
+
+func TestCheckSchedule(t *testing.T) {
+	_, d := newTestScheduler(t, 100)
+
+	op := addTestScheduledOp(t, d, common.HexToAddress("0x02"), time.Now(), time.Now().Add(time.Hour))
+
+	err := CheckSchedule(d, op.Hash)
+	if err != nil {
+		t.Fatalf("expected a scheduled op to pass, got %v", err)
+	}
+
+	err = CheckSchedule(d, "0x01")
+	if err != nil {
+		t.Fatalf("expected an op that was not scheduled to pass, got %v", err)
+	}
+
+	_, err = d.ScheduleDB.SetScheduledUserOpStatus(op.Hash, indexer.ScheduleStatusScheduled, indexer.ScheduleStatusCancelled, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CheckSchedule(d, op.Hash)
+	if !errors.Is(err, ErrScheduleCancelled) {
+		t.Fatalf("expected a cancelled op to be rejected, got %v", err)
+	}
+}
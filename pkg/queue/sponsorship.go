@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+)
+
+// CheckSponsorship rejects an op whose out of order sponsorship was revoked or expired, ops without one are not checked
+func CheckSponsorship(d *db.DB, txm indexer.UserOpMessage) error {
+	sp, err := d.SponsorshipDB.GetSponsorship(txm.Paymaster.Hex(), txm.UserOp.Sender.Hex(), indexer.SponsorshipNonceKey(txm.UserOp.Nonce))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch sp.Status {
+	case indexer.SponsorshipStatusRevoked:
+		return indexer.NewPolicyRejection(indexer.PolicyReasonSponsorshipRevoked, "the sponsorship of the user operation was revoked")
+	case indexer.SponsorshipStatusExpired:
+		return indexer.NewPolicyRejection(indexer.PolicyReasonSponsorshipExpired, "the sponsorship of the user operation has expired")
+	}
+
+	return nil
+}
+
+// SetSponsorshipUsed marks the out of order sponsorship of an op that was queued as used, ops without one are ignored
+func SetSponsorshipUsed(d *db.DB, txm indexer.UserOpMessage, hash string) {
+	err := d.SponsorshipDB.SetSponsorshipUsed(txm.Paymaster.Hex(), txm.UserOp.Sender.Hex(), indexer.SponsorshipNonceKey(txm.UserOp.Nonce), hash)
+	if err != nil {
+		log.Default().Println("error marking sponsorship as used", hash, err.Error())
+	}
+}
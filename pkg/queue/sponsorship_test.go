@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCheckSponsorship(t *testing.T) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	account := common.HexToAddress("0x0000000000000000000000000000000000000002")
+
+	// the nonce key of an op is the upper 192 bits of its nonce
+	nonce := func(key int64) *big.Int {
+		return new(big.Int).Lsh(big.NewInt(key), 64)
+	}
+
+	sponsorship := func(key int64, validUntil time.Time) *indexer.Sponsorship {
+		return &indexer.Sponsorship{
+			Contract:   paymaster.Hex(),
+			Batch:      "0x01",
+			Account:    account.Hex(),
+			NonceKey:   indexer.SponsorshipNonceKey(nonce(key)),
+			EntryPoint: indexer.EntryPointV06Address.Hex(),
+			ValidUntil: validUntil.Unix(),
+		}
+	}
+
+	err = d.SponsorshipDB.AddSponsorships([]*indexer.Sponsorship{
+		sponsorship(1, time.Now().Add(time.Hour)),
+		sponsorship(2, time.Now().Add(time.Hour)),
+		sponsorship(3, time.Now().Add(time.Hour)),
+		sponsorship(4, time.Now().Add(-time.Minute)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.SponsorshipDB.RevokeSponsorships(paymaster.Hex(), indexer.SponsorshipFilter{NonceKey: indexer.SponsorshipNonceKey(nonce(2))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.SponsorshipDB.ExpireSponsorships(paymaster.Hex(), indexer.SponsorshipFilter{NonceKey: indexer.SponsorshipNonceKey(nonce(3))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		key    int64
+		reason indexer.PolicyReason // empty if the op is not rejected
+	}{
+		{"without sponsorship", 5, ""},
+		{"outstanding", 1, ""},
+		{"revoked", 2, indexer.PolicyReasonSponsorshipRevoked},
+		{"expired", 3, indexer.PolicyReasonSponsorshipExpired},
+		{"validity passed", 4, indexer.PolicyReasonSponsorshipExpired},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			txm := indexer.UserOpMessage{
+				Paymaster: paymaster,
+				UserOp:    indexer.UserOp{Sender: account, Nonce: new(big.Int).Add(nonce(c.key), big.NewInt(3))},
+			}
+
+			err := CheckSponsorship(d, txm)
+			if c.reason == "" {
+				if err != nil {
+					t.Fatalf("expected the op to be accepted, got %v", err)
+				}
+				return
+			}
+
+			rpcErr, ok := err.(*indexer.JSONRPCError)
+			if !ok {
+				t.Fatalf("expected a policy rejection, got %v", err)
+			}
+
+			rejection, ok := rpcErr.Data.(*indexer.PolicyRejection)
+			if !ok || rejection.Reason != c.reason {
+				t.Fatalf("expected the reason %s, got %+v", c.reason, rpcErr.Data)
+			}
+		})
+	}
+}
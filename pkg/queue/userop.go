@@ -1,39 +1,51 @@
 package queue
 
 import (
-	"context"
-	"crypto/ecdsa"
+	"errors"
 	"fmt"
+	"log"
+	"math/big"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	comm "github.com/citizenwallet/indexer/internal/common"
 	"github.com/citizenwallet/indexer/internal/services/db"
 	"github.com/citizenwallet/indexer/internal/services/firebase"
+	"github.com/citizenwallet/indexer/internal/services/pubsub"
 	"github.com/citizenwallet/indexer/pkg/indexer"
-	"github.com/citizenwallet/smartcontracts/pkg/contracts/tokenEntryPoint"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// ErrTxUnknown is given to the ops of a bundle that was sent before a restart and that the node no longer knows
+var ErrTxUnknown = errors.New("the bundle is no longer known to the node")
+
 type UserOpService struct {
-	inProgress map[common.Address][]string
-	mu         sync.Mutex
-	db         *db.DB
-	evm        indexer.EVMRequester
-	fb         *firebase.PushService
+	db     *db.DB
+	evm    indexer.EVMRequester
+	fb     *firebase.PushService
+	signer indexer.Signer
+	nonces *NonceManager
+	ledger *GasLedger
+	eps    indexer.EntryPoints
+	turns  map[common.Address]int // the submitter key of each paymaster that sends the next bundle
 }
 
 func NewUserOpService(db *db.DB,
-	evm indexer.EVMRequester, fb *firebase.PushService) *UserOpService {
+	evm indexer.EVMRequester, fb *firebase.PushService, signer indexer.Signer, nonces *NonceManager, ledger *GasLedger, eps indexer.EntryPoints) *UserOpService {
 	return &UserOpService{
-		inProgress: map[common.Address][]string{},
-		db:         db,
-		evm:        evm,
-		fb:         fb,
+		db:     db,
+		evm:    evm,
+		fb:     fb,
+		signer: signer,
+		nonces: nonces,
+		ledger: ledger,
+		eps:    eps,
+		turns:  map[common.Address]int{},
 	}
 }
 
@@ -56,45 +68,47 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 			continue
 		}
 
-		messagesByEntryPoint[txm.EntryPoint] = append(messagesByEntryPoint[txm.EntryPoint], message)
-		txmByEntryPoint[txm.EntryPoint] = append(txmByEntryPoint[txm.EntryPoint], txm)
-	}
-
-	// go through each entry point and process the messages
-	for entrypoint, txms := range txmByEntryPoint {
-		sampleTxm := txms[0] // use the first txm to get information we need to process the messages
-		msgs := messagesByEntryPoint[entrypoint]
+		// an op that was sent before a restart is not sent again
+		if txHash, ok := s.sent(txm); ok {
+			message.Respond(txHash, nil)
+			continue
+		}
 
-		// Fetch the sponsor's corresponding private key from the database
-		sponsorKey, err := s.db.SponsorDB.GetSponsor(sampleTxm.Paymaster.Hex())
+		// the sponsorship of an op can be revoked or expired while it is queued
+		err := CheckSponsorship(s.db, txm)
 		if err != nil {
-			invalid = append(invalid, msgs...)
-			for range msgs {
-				for range msgs {
-					errors = append(errors, err)
-				}
+			if _, ok := err.(*indexer.JSONRPCError); ok {
+				err = noRetry(err)
 			}
+
+			invalid = append(invalid, message)
+			errors = append(errors, err)
 			continue
 		}
 
-		// Generate ecdsa.PrivateKey from bytes
-		privateKey, err := comm.HexToPrivateKey(sponsorKey.PrivateKey)
+		// a scheduled op can be cancelled while it is submitted
+		err = s.checkSchedule(txm)
 		if err != nil {
-			invalid = append(invalid, msgs...)
-			for range msgs {
-				errors = append(errors, err)
+			if err == ErrScheduleCancelled {
+				err = noRetry(err)
 			}
+
+			invalid = append(invalid, message)
+			errors = append(errors, err)
 			continue
 		}
 
-		// Get the public key from the private key
-		publicKey := privateKey.Public().(*ecdsa.PublicKey)
+		messagesByEntryPoint[txm.EntryPoint] = append(messagesByEntryPoint[txm.EntryPoint], message)
+		txmByEntryPoint[txm.EntryPoint] = append(txmByEntryPoint[txm.EntryPoint], txm)
+	}
 
-		// Convert the public key to an Ethereum address
-		sponsor := crypto.PubkeyToAddress(*publicKey)
+	// go through each entry point and process the messages
+	for entrypoint, txms := range txmByEntryPoint {
+		sampleTxm := txms[0] // use the first txm to get information we need to process the messages
+		msgs := messagesByEntryPoint[entrypoint]
 
-		// Get the nonce for the sponsor's address
-		nonce, err := s.evm.NonceAt(context.Background(), sponsor, nil)
+		// Fetch the address that submits this bundle from the signer
+		sponsor, err := s.submitter(sampleTxm.Paymaster)
 		if err != nil {
 			invalid = append(invalid, msgs...)
 			for range msgs {
@@ -103,12 +117,20 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 			continue
 		}
 
-		// Get the in progress transactions for the entrypoint and increment the nonce
-		inProgress := s.inProgress[entrypoint]
-		nonce += uint64(len(inProgress))
+		// the ops of an entry point are packed for its version
+		version := s.eps.Version(entrypoint)
+
+		// ops that revert are dropped before they can make the whole bundle revert
+		txms, msgs, dropped, dropErrs := s.simulate(version, sponsor, entrypoint, txms, msgs)
+		invalid = append(invalid, dropped...)
+		errors = append(errors, dropErrs...)
 
-		// Parse the contract ABI
-		parsedABI, err := tokenEntryPoint.TokenEntryPointMetaData.GetAbi()
+		if len(txms) == 0 {
+			continue
+		}
+
+		// Pack the function name and arguments into calldata
+		data, err := handleOpsData(version, sponsor, entrypoint, txms)
 		if err != nil {
 			invalid = append(invalid, msgs...)
 			for range msgs {
@@ -117,14 +139,8 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 			continue
 		}
 
-		ops := []tokenEntryPoint.UserOperation{}
-
-		for _, txm := range txms {
-			ops = append(ops, tokenEntryPoint.UserOperation(txm.UserOp))
-		}
-
-		// Pack the function name and arguments into calldata
-		data, err := parsedABI.Pack("handleOps", ops, sampleTxm.EntryPoint)
+		// Reserve the next nonce of the sponsor
+		nonce, err := s.nonces.Next(sponsor, sampleTxm.Paymaster)
 		if err != nil {
 			invalid = append(invalid, msgs...)
 			for range msgs {
@@ -136,6 +152,8 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 		// Create a new transaction
 		tx, err := s.evm.NewTx(nonce, sponsor, sampleTxm.EntryPoint, data, false)
 		if err != nil {
+			s.nonces.Release(sponsor, nonce)
+
 			invalid = append(invalid, msgs...)
 			for range msgs {
 				errors = append(errors, err)
@@ -144,8 +162,10 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 		}
 
 		// Sign the transaction
-		signedTx, err := types.SignTx(tx, types.NewLondonSigner(sampleTxm.ChainId), privateKey)
+		signedTx, err := s.signer.SignTx(sampleTxm.Paymaster, sponsor, tx, sampleTxm.ChainId)
 		if err != nil {
+			s.nonces.Release(sponsor, nonce)
+
 			invalid = append(invalid, msgs...)
 			for range msgs {
 				errors = append(errors, err)
@@ -155,19 +175,13 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 
 		signedTxHash := signedTx.Hash().Hex()
 
-		// update inProgress
-		s.mu.Lock()
-		s.inProgress[entrypoint] = append(s.inProgress[entrypoint], signedTxHash)
-		s.mu.Unlock()
-
 		insertedTransfers := map[common.Address][]*indexer.Transfer{}
 
-		for _, txm := range txms {
-			// Detect if this user operation is a transfer using the call data
-			// Parse the contract ABI
-			var tdb *db.TransferDB
-			var log *indexer.Transfer
+		// the transfers of each token are hashed in the order the bundle makes them, like they are once indexed
+		counters := map[common.Address]*indexer.TransferCounter{}
 
+		for _, txm := range txms {
+			// Detect the transfers of this user operation using the call data
 			userop := txm.UserOp
 			txdata, ok := txm.ExtraData.(*indexer.TransferData)
 			if !ok {
@@ -175,51 +189,72 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 				txdata = nil
 			}
 
-			// Parse the ERC20 transfer from the call data
-			dest, fromaddr, toaddr, amount, parseErr := comm.ParseERC20Transfer(userop.CallData, s.evm)
-			if parseErr == nil {
-				// If the parsing is successful, this is an ERC20 transfer
+			// Parse the ERC20 transfers from the call data, a batch can make several
+			transfers, parseErr := comm.ParseERC20Transfers(userop.CallData, s.evm)
+			if parseErr != nil {
+				continue
+			}
 
+			for _, t := range transfers {
 				from := userop.Sender.Hex()
-				if fromaddr != (common.Address{}) {
-					from = fromaddr.Hex()
+				if t.From != (common.Address{}) {
+					from = t.From.Hex()
 				}
 
 				// Create a new transfer log
-				log = &indexer.Transfer{
+				log := &indexer.Transfer{
 					TxHash:    signedTxHash,
 					TokenID:   0,
 					CreatedAt: time.Now().UTC(),
 					From:      from,
-					To:        toaddr.Hex(),
+					To:        t.To.Hex(),
 					Nonce:     userop.Nonce.Int64(),
-					Value:     amount,
+					Value:     t.Amount,
 					Data:      txdata,
 					Status:    indexer.TransferStatusSending,
 				}
 
-				log.Hash = log.GenerateUniqueHash()
+				counter, ok := counters[t.Token]
+				if !ok {
+					counter = &indexer.TransferCounter{}
+					counters[t.Token] = counter
+				}
+
+				log.Hash = counter.Hash(log)
 
 				// Combine the From and To addresses into a single string
 				log.FromTo = log.CombineFromTo()
 
-				suffix, err := s.db.TableNameSuffix(dest.Hex())
-				if err == nil {
-					// Get the transfer database for the destination address
-					tdb, ok = s.db.TransferDB[suffix]
-					if ok {
-						// If the transfer database exists, add the transfer log to it
-						tdb.AddTransfer(log)
+				suffix, err := s.db.TableNameSuffix(t.Token.Hex())
+				if err != nil {
+					continue
+				}
 
-						insertedTransfers[dest] = append(insertedTransfers[dest], log)
-					}
+				// Get the transfer database for the token
+				tdb, ok := s.db.TransferDB[suffix]
+				if !ok {
+					continue
 				}
+
+				// If the transfer database exists, add the transfer log to it
+				tdb.AddTransfer(log)
+
+				insertedTransfers[t.Token] = append(insertedTransfers[t.Token], log)
 			}
 		}
 
 		// Send the signed transaction
 		err = s.evm.SendTransaction(signedTx)
+		if err != nil && !isRejectedTxError(err) {
+			// the node may have the transaction, e.g. it already knows it or the request timed out, the nonce manager follows it until it is mined
+			log.Default().Println("error sending bundle, following it until its nonce is used", signedTxHash, err.Error())
+			err = nil
+		}
+
 		if err != nil {
+			// the node refused the transaction, its nonce can be used again
+			s.nonces.Release(sponsor, nonce)
+
 			// If there's an error, check if it's an RPC error
 			e, ok := err.(rpc.Error)
 			if ok && e.ErrorCode() != -32000 {
@@ -236,21 +271,17 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 					}
 				}
 
+				s.setStatus(txms, signedTxHash, indexer.UserOpStatusFail, err)
+
 				invalid = append(invalid, msgs...)
 				for range msgs {
 					errors = append(errors, err)
 				}
 
-				// remove from inProgress
-				s.mu.Lock()
-				s.inProgress[entrypoint] = comm.Filter(s.inProgress[entrypoint], func(s string) bool {
-					return s != signedTxHash
-				})
-				s.mu.Unlock()
 				continue
 			}
 
-			if !strings.Contains(e.Error(), "insufficient funds") {
+			if !strings.Contains(err.Error(), "insufficient funds") {
 				// If the error is not about insufficient funds, remove the sending transfer and return the error
 				for dest, logs := range insertedTransfers {
 					suffix, err := s.db.TableNameSuffix(dest.Hex())
@@ -258,23 +289,19 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 						tdb, ok := s.db.TransferDB[suffix]
 						if ok {
 							for _, log := range logs {
-								tdb.SetStatus(log.Hash, string(indexer.TransferStatusFail))
+								tdb.SetStatus(string(indexer.TransferStatusFail), log.Hash)
 							}
 						}
 					}
 				}
 
+				s.setStatus(txms, signedTxHash, indexer.UserOpStatusFail, err)
+
 				invalid = append(invalid, msgs...)
 				for range msgs {
 					errors = append(errors, err)
 				}
 
-				// remove from inProgress
-				s.mu.Lock()
-				s.inProgress[entrypoint] = comm.Filter(s.inProgress[entrypoint], func(s string) bool {
-					return s != signedTxHash
-				})
-				s.mu.Unlock()
 				continue
 			}
 
@@ -285,24 +312,20 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 
 					if ok {
 						for _, log := range logs {
-							tdb.SetStatus(log.Hash, string(indexer.TransferStatusFail))
+							tdb.SetStatus(string(indexer.TransferStatusFail), log.Hash)
 						}
 					}
 				}
 			}
 
+			s.setStatus(txms, signedTxHash, indexer.UserOpStatusFail, err)
+
 			// Return the error about insufficient funds
 			invalid = append(invalid, msgs...)
 			for range msgs {
 				errors = append(errors, err)
 			}
 
-			// remove from inProgress
-			s.mu.Lock()
-			s.inProgress[entrypoint] = comm.Filter(s.inProgress[entrypoint], func(s string) bool {
-				return s != signedTxHash
-			})
-			s.mu.Unlock()
 			continue
 		}
 
@@ -311,13 +334,15 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 			msg.Respond(signedTxHash, nil)
 		}
 
+		s.setStatus(txms, signedTxHash, indexer.UserOpStatusSubmitted, nil)
+
 		for dest, logs := range insertedTransfers {
 			suffix, err := s.db.TableNameSuffix(dest.Hex())
 			if err == nil {
 				tdb, ok := s.db.TransferDB[suffix]
 				if ok {
 					for _, log := range logs {
-						err := tdb.SetStatus(log.Hash, string(indexer.TransferStatusPending))
+						err := tdb.SetStatus(string(indexer.TransferStatusPending), log.Hash)
 						if err != nil {
 							tdb.RemoveTransfer(log.Hash)
 						}
@@ -326,31 +351,449 @@ func (s *UserOpService) Process(messages []indexer.Message) (invalid []indexer.M
 			}
 		}
 
+		// the nonce manager follows the transaction and its replacements until one of them is mined
+		s.nonces.Sent(sponsor, signedTx, s.mined(txms, signedTx.Hash(), insertedTransfers), s.replaced(txms))
+
 		go func() {
-			// async wait for the transaction to be mined
-			err = s.evm.WaitForTx(signedTx, 16)
+			// async wait for the transaction to be mined, a transaction that takes longer is checked by the nonce manager
+			s.evm.WaitForTx(signedTx, 16)
+
+			err := s.nonces.Check(sponsor)
 			if err != nil {
-				for dest, logs := range insertedTransfers {
-					suffix, err := s.db.TableNameSuffix(dest.Hex())
-					if err == nil {
-						tdb, ok := s.db.TransferDB[suffix]
-						if ok {
-							for _, log := range logs {
-								tdb.RemoveTransfer(log.Hash)
-							}
+				log.Default().Println("error checking nonces", sponsor.Hex(), err.Error())
+			}
+		}()
+	}
+
+	return invalid, errors
+}
+
+// submitter returns the address of the key that submits the next bundle of a paymaster.
+// The submitter keys of the paymaster take turns, each with its own nonces, the sponsor key is used if there are none.
+func (s *UserOpService) submitter(paymaster common.Address) (common.Address, error) {
+	keys, err := s.signer.Submitters(paymaster)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	if len(keys) == 0 {
+		return s.signer.Sponsor(paymaster)
+	}
+
+	i := s.turns[paymaster] % len(keys)
+	s.turns[paymaster] = i + 1
+
+	return keys[i], nil
+}
+
+// Resume reloads the bundles that were sent but not mined before a restart. The nonce manager follows them and their replacements
+// like the bundles that are sent, the status of their ops is updated once their nonce is used.
+func (s *UserOpService) Resume() error {
+	recs, err := s.db.UserOpDB.GetUserOpsByStatus(indexer.UserOpStatusSubmitted)
+	if err != nil {
+		return err
+	}
+
+	if len(recs) == 0 {
+		return nil
+	}
+
+	chainId, err := s.evm.ChainID()
+	if err != nil {
+		return err
+	}
+
+	txHashes := []string{}
+	bundles := map[string][]indexer.UserOpMessage{}
+	for _, rec := range recs {
+		if rec.TxHash == "" {
+			continue
+		}
+
+		if _, ok := bundles[rec.TxHash]; !ok {
+			txHashes = append(txHashes, rec.TxHash)
+		}
+
+		bundles[rec.TxHash] = append(bundles[rec.TxHash], indexer.UserOpMessage{
+			Paymaster:  common.HexToAddress(rec.Paymaster),
+			EntryPoint: common.HexToAddress(rec.EntryPoint),
+			Version:    s.eps.Version(common.HexToAddress(rec.EntryPoint)),
+			ChainId:    chainId,
+			UserOp:     rec.UserOp,
+		})
+	}
+
+	log.Default().Println(fmt.Sprintf("resuming %d bundles that were sent", len(txHashes)))
+
+	for _, txHash := range txHashes {
+		err := s.resume(common.HexToHash(txHash), bundles[txHash], chainId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resume hands a bundle that was reloaded to the nonce manager, a bundle that the node does not know and that was not mined can no longer be followed
+func (s *UserOpService) resume(txHash common.Hash, txms []indexer.UserOpMessage, chainId *big.Int) error {
+	tx, _, err := s.evm.TransactionByHash(txHash)
+	if errors.Is(err, ethereum.NotFound) {
+		// the node may only keep the receipts of old transactions
+		rcpt, rerr := s.evm.TransactionReceipt(txHash)
+		if rerr == nil {
+			if rcpt.Status != types.ReceiptStatusSuccessful {
+				rerr = errors.New("tx failed")
+			}
+
+			s.mined(txms, txHash, nil)(rcpt, rerr)
+			return nil
+		}
+		if !errors.Is(rerr, ethereum.NotFound) {
+			return rerr
+		}
+
+		s.setStatus(txms, txHash.Hex(), indexer.UserOpStatusFail, ErrTxUnknown)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sponsor, err := types.Sender(types.LatestSignerForChainID(chainId), tx)
+	if err != nil {
+		return err
+	}
+
+	return s.nonces.Resume(sponsor, txms[0].Paymaster, tx, s.mined(txms, txHash, nil), s.replaced(txms))
+}
+
+// mined returns the handler that sets the status of the ops of a bundle once its nonce is used, the sending transfers of a bundle that failed are removed
+func (s *UserOpService) mined(txms []indexer.UserOpMessage, txHash common.Hash, insertedTransfers map[common.Address][]*indexer.Transfer) MinedHandler {
+	return func(rcpt *types.Receipt, err error) {
+		minedTxHash := txHash
+		if rcpt != nil {
+			minedTxHash = rcpt.TxHash
+
+			s.record(txms, rcpt)
+		}
+
+		if err != nil {
+			s.setStatus(txms, minedTxHash.Hex(), indexer.UserOpStatusFail, err)
+
+			for dest, logs := range insertedTransfers {
+				suffix, err := s.db.TableNameSuffix(dest.Hex())
+				if err == nil {
+					tdb, ok := s.db.TransferDB[suffix]
+					if ok {
+						for _, log := range logs {
+							tdb.RemoveTransfer(log.Hash)
 						}
 					}
 				}
 			}
+			return
+		}
 
-			// remove from inProgress
-			s.mu.Lock()
-			s.inProgress[entrypoint] = comm.Filter(s.inProgress[entrypoint], func(s string) bool {
-				return s != signedTxHash
-			})
-			s.mu.Unlock()
-		}()
+		s.setStatus(txms, minedTxHash.Hex(), indexer.UserOpStatusMined, nil)
+
+		s.setResults(txms, minedTxHash)
 	}
+}
 
-	return invalid, errors
+// replaced returns the handler that stores the hash of the replacement of a bundle, so that it can be resumed after a restart
+func (s *UserOpService) replaced(txms []indexer.UserOpMessage) ReplacedHandler {
+	return func(tx *types.Transaction) {
+		s.setStatus(txms, tx.Hash().Hex(), indexer.UserOpStatusSubmitted, nil)
+	}
+}
+
+// record adds the gas of a mined bundle to the gas ledger, a bundle that reverted still used gas
+func (s *UserOpService) record(txms []indexer.UserOpMessage, rcpt *types.Receipt) {
+	err := s.ledger.Record(txms, rcpt)
+	if err != nil {
+		log.Default().Println("error recording gas", rcpt.TxHash.Hex(), err.Error())
+	}
+}
+
+// checkSchedule rejects an op whose schedule was cancelled
+func (s *UserOpService) checkSchedule(txm indexer.UserOpMessage) error {
+	hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+	if err != nil {
+		return nil
+	}
+
+	return CheckSchedule(s.db, hash.Hex())
+}
+
+// sent returns the hash of the bundle of a user operation that was already sent
+func (s *UserOpService) sent(txm indexer.UserOpMessage) (string, bool) {
+	hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+	if err != nil {
+		return "", false
+	}
+
+	rec, err := s.db.UserOpDB.GetUserOp(hash.Hex())
+	if err != nil || rec.TxHash == "" {
+		return "", false
+	}
+
+	switch rec.Status {
+	case indexer.UserOpStatusSubmitted, indexer.UserOpStatusMined, indexer.UserOpStatusSuccess:
+		return rec.TxHash, true
+	}
+
+	return "", false
+}
+
+// simulate calls handleOps with the ops of each sender on their own, in nonce order, so that the ops of a sender that follow
+// each other can be bundled. Each op is simulated after the ops of its sender that were kept, an op that reverts is dropped
+// with the decoded reason. When the remaining ops still revert together, the bundle is split in halves, without splitting
+// the ops of a sender, until the senders whose ops make it revert are found.
+func (s *UserOpService) simulate(version indexer.EntryPointVersion, sponsor, entryPoint common.Address, txms []indexer.UserOpMessage, msgs []indexer.Message) ([]indexer.UserOpMessage, []indexer.Message, []indexer.Message, []error) {
+	dropped := []indexer.Message{}
+	errs := []error{}
+
+	groups := [][]int{}
+	for _, group := range senderGroups(txms) {
+		kept := []int{}
+		for _, i := range group {
+			err := s.callHandleOps(version, sponsor, entryPoint, opsAt(txms, append(kept, i)))
+			if comm.IsRevert(err) {
+				dropped = append(dropped, msgs[i])
+				errs = append(errs, noRetry(comm.RevertError(err)))
+				continue
+			}
+
+			kept = append(kept, i)
+		}
+
+		if len(kept) > 0 {
+			groups = append(groups, kept)
+		}
+	}
+
+	if len(groups) > 1 {
+		var reverted []int
+		var revertErrs map[int]error
+		groups, reverted, revertErrs = s.bisect(version, sponsor, entryPoint, txms, groups)
+
+		for _, i := range reverted {
+			dropped = append(dropped, msgs[i])
+			errs = append(errs, noRetry(comm.RevertError(revertErrs[i])))
+		}
+	}
+
+	validTxms := []indexer.UserOpMessage{}
+	validMsgs := []indexer.Message{}
+	for _, group := range groups {
+		for _, i := range group {
+			validTxms = append(validTxms, txms[i])
+			validMsgs = append(validMsgs, msgs[i])
+		}
+	}
+
+	return validTxms, validMsgs, dropped, errs
+}
+
+// bisect returns the groups of ops of the bundle that can be sent together, a bundle that reverts is split in halves
+// until the groups that revert are isolated. The ops of these groups are returned with their error.
+func (s *UserOpService) bisect(version indexer.EntryPointVersion, sponsor, entryPoint common.Address, txms []indexer.UserOpMessage, groups [][]int) ([][]int, []int, map[int]error) {
+	idx := []int{}
+	for _, group := range groups {
+		idx = append(idx, group...)
+	}
+
+	err := s.callHandleOps(version, sponsor, entryPoint, opsAt(txms, idx))
+	if !comm.IsRevert(err) {
+		return groups, []int{}, map[int]error{}
+	}
+
+	if len(groups) == 1 {
+		errs := map[int]error{}
+		for _, i := range idx {
+			errs[i] = err
+		}
+
+		return [][]int{}, idx, errs
+	}
+
+	mid := len(groups) / 2
+
+	valid, reverted, errs := s.bisect(version, sponsor, entryPoint, txms, groups[:mid])
+	rvalid, rreverted, rerrs := s.bisect(version, sponsor, entryPoint, txms, groups[mid:])
+
+	valid = append(valid, rvalid...)
+	reverted = append(reverted, rreverted...)
+	for i, err := range rerrs {
+		errs[i] = err
+	}
+
+	return valid, reverted, errs
+}
+
+// senderGroups returns the indexes of the ops of each sender in nonce order, the senders are in the order of their first op
+func senderGroups(txms []indexer.UserOpMessage) [][]int {
+	groups := [][]int{}
+	senders := map[common.Address]int{}
+
+	for i, txm := range txms {
+		g, ok := senders[txm.UserOp.Sender]
+		if !ok {
+			g = len(groups)
+			senders[txm.UserOp.Sender] = g
+			groups = append(groups, []int{})
+		}
+
+		groups[g] = append(groups[g], i)
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group, func(a, b int) bool {
+			return txms[group[a]].UserOp.Nonce.Cmp(txms[group[b]].UserOp.Nonce) < 0
+		})
+	}
+
+	return groups
+}
+
+// opsAt returns the ops at the given indexes
+func opsAt(txms []indexer.UserOpMessage, idx []int) []indexer.UserOpMessage {
+	ops := []indexer.UserOpMessage{}
+	for _, i := range idx {
+		ops = append(ops, txms[i])
+	}
+
+	return ops
+}
+
+// handleOpsData packs the ops in a call to handleOps. The v0.6 token entry point is given itself as the beneficiary
+// while the fees that a v0.7 entry point collects are paid back to the sponsor.
+func handleOpsData(version indexer.EntryPointVersion, sponsor, entryPoint common.Address, txms []indexer.UserOpMessage) ([]byte, error) {
+	ops := []indexer.UserOp{}
+	for _, txm := range txms {
+		ops = append(ops, txm.UserOp)
+	}
+
+	beneficiary := entryPoint
+	if version == indexer.EntryPointV07 {
+		beneficiary = sponsor
+	}
+
+	return comm.HandleOpsData(version, ops, beneficiary)
+}
+
+// callHandleOps calls handleOps on the entry point with the given ops as the sponsor would send them
+func (s *UserOpService) callHandleOps(version indexer.EntryPointVersion, sponsor, entryPoint common.Address, txms []indexer.UserOpMessage) error {
+	data, err := handleOpsData(version, sponsor, entryPoint, txms)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.evm.CallContract(ethereum.CallMsg{
+		From: sponsor,
+		To:   &entryPoint,
+		Data: data,
+	}, nil)
+
+	return err
+}
+
+// Failed marks a user operation that was dropped after its last retry as failed
+func (s *UserOpService) Failed(message indexer.Message, err error) {
+	txm, ok := message.Message.(indexer.UserOpMessage)
+	if !ok {
+		return
+	}
+
+	// a scheduled op that was cancelled while it was submitted stays cancelled
+	if errors.Is(err, ErrScheduleCancelled) {
+		s.setStatus([]indexer.UserOpMessage{txm}, "", indexer.UserOpStatusCancelled, nil)
+		return
+	}
+
+	s.setStatus([]indexer.UserOpMessage{txm}, "", indexer.UserOpStatusFail, err)
+}
+
+// setResults sets the status of the user operations of a mined bundle from the UserOperationEvent of each op,
+// ops without an event succeeded along with their bundle
+func (s *UserOpService) setResults(txms []indexer.UserOpMessage, txHash common.Hash) {
+	var logs []*types.Log
+
+	rcpt, err := s.evm.TransactionReceipt(txHash)
+	if err == nil {
+		logs = rcpt.Logs
+	}
+
+	for _, txm := range txms {
+		var opErr error
+
+		hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+		if err == nil {
+			result, err := comm.ParseUserOpResult(logs, hash)
+			if err == nil && result != nil && !result.Success {
+				opErr = fmt.Errorf("user operation reverted: %s", result.Reason)
+			}
+		}
+
+		if opErr != nil {
+			s.setStatus([]indexer.UserOpMessage{txm}, txHash.Hex(), indexer.UserOpStatusFail, opErr)
+			continue
+		}
+
+		s.setStatus([]indexer.UserOpMessage{txm}, txHash.Hex(), indexer.UserOpStatusSuccess, nil)
+	}
+}
+
+// setStatus stores the status of the user operations and notifies the subscribers of their senders that it changed
+func (s *UserOpService) setStatus(txms []indexer.UserOpMessage, txHash string, status indexer.UserOpStatus, err error) {
+	for _, txm := range txms {
+		SetUserOpStatus(s.db, txm, txHash, status, err)
+	}
+}
+
+// SetUserOpStatus stores the status of a user operation and notifies the subscribers of its sender that it changed
+func SetUserOpStatus(d *db.DB, txm indexer.UserOpMessage, txHash string, status indexer.UserOpStatus, err error) {
+	update := &indexer.UserOpUpdate{
+		TxHash:     txHash,
+		EntryPoint: txm.EntryPoint.Hex(),
+		Sender:     txm.UserOp.Sender.Hex(),
+		Nonce:      hexutil.EncodeBig(txm.UserOp.Nonce),
+		Status:     status,
+		UpdatedAt:  time.Now().UTC(),
+	}
+
+	if err != nil {
+		update.Error = err.Error()
+	}
+
+	hash, herr := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+	if herr == nil {
+		update.Hash = hash.Hex()
+
+		// failing to store the op should not keep its subscribers from being notified
+		stored, serr := d.UserOpDB.SetUserOp(&indexer.UserOpRecord{
+			Hash:       update.Hash,
+			TxHash:     txHash,
+			EntryPoint: update.EntryPoint,
+			Paymaster:  txm.Paymaster.Hex(),
+			UserOp:     txm.UserOp,
+			Status:     status,
+			Error:      update.Error,
+		})
+		if serr != nil {
+			log.Default().Println("error storing user op", update.Hash, serr.Error())
+		}
+
+		// an op that was sent does not go back to an earlier status
+		if serr == nil && !stored {
+			return
+		}
+	}
+
+	if d.Broker == nil {
+		return
+	}
+
+	d.Broker.Publish(pubsub.EventTypeUserOp, []string{pubsub.TopicUserOps, pubsub.UserOpTopic(update.Sender)}, update)
 }
@@ -0,0 +1,318 @@
+package queue
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/internal/services/signer"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/entrypoint"
+	"github.com/citizenwallet/smartcontracts/pkg/contracts/tokenEntryPoint"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var badCallData = []byte{0xba, 0xd0}
+
+// testRevertError is a revert returned by the node, with its revert data
+type testRevertError struct {
+	data string
+}
+
+func (e testRevertError) Error() string          { return "execution reverted" }
+func (e testRevertError) ErrorCode() int         { return 3 }
+func (e testRevertError) ErrorData() interface{} { return e.data }
+
+// TestBundleEVM simulates handleOps on a v0.6 entry point: the nonce of each op must be the next one of its sender
+// and ops with badCallData revert. Other calls panic.
+type TestBundleEVM struct {
+	indexer.EVMRequester
+
+	nonces map[common.Address]int64 // the next nonce of each sender
+}
+
+func (e *TestBundleEVM) CallContract(call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	epAbi, err := tokenEntryPoint.TokenEntryPointMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := epAbi.Methods["handleOps"].Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	ops := *abi.ConvertType(values[0], new([]tokenEntryPoint.UserOperation)).(*[]tokenEntryPoint.UserOperation)
+
+	// the state only changes if the whole bundle succeeds
+	nonces := map[common.Address]int64{}
+	for i, op := range ops {
+		next, ok := nonces[op.Sender]
+		if !ok {
+			next = e.nonces[op.Sender]
+		}
+
+		if op.Nonce.Int64() != next {
+			return nil, failedOp(i, "AA25 invalid account nonce")
+		}
+
+		if bytes.Equal(op.CallData, badCallData) {
+			return nil, failedOp(i, "AA23 reverted (or OOG)")
+		}
+
+		nonces[op.Sender] = next + 1
+	}
+
+	return nil, nil
+}
+
+// failedOp returns the revert of the entry point for the op at the index
+func failedOp(i int, reason string) error {
+	epAbi, err := entrypoint.EntrypointMetaData.GetAbi()
+	if err != nil {
+		panic(err)
+	}
+
+	e := epAbi.Errors["FailedOp"]
+
+	data, err := e.Inputs.Pack(big.NewInt(int64(i)), reason)
+	if err != nil {
+		panic(err)
+	}
+
+	return testRevertError{data: hexutil.Encode(append(e.ID[:4], data...))}
+}
+
+func testUserOpMessage(sender common.Address, nonce int64, callData []byte) indexer.UserOpMessage {
+	return indexer.UserOpMessage{
+		UserOp: indexer.UserOp{
+			Sender:               sender,
+			Nonce:                big.NewInt(nonce),
+			InitCode:             []byte{},
+			CallData:             callData,
+			CallGasLimit:         big.NewInt(1),
+			VerificationGasLimit: big.NewInt(1),
+			PreVerificationGas:   big.NewInt(1),
+			MaxFeePerGas:         big.NewInt(1),
+			MaxPriorityFeePerGas: big.NewInt(1),
+			PaymasterAndData:     []byte{},
+			Signature:            []byte{},
+		},
+	}
+}
+
+func simulateTest(evm *TestBundleEVM, txms []indexer.UserOpMessage) ([]indexer.UserOpMessage, []indexer.Message, []error) {
+	s := &UserOpService{evm: evm}
+
+	msgs := []indexer.Message{}
+	for i, txm := range txms {
+		msgs = append(msgs, indexer.Message{ID: string(rune('a' + i)), Message: txm})
+	}
+
+	valid, _, dropped, errs := s.simulate(indexer.EntryPointV06, common.Address{}, common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"), txms, msgs)
+
+	return valid, dropped, errs
+}
+
+func TestSimulateSameSender(t *testing.T) {
+	alice := common.HexToAddress("0x01")
+	bob := common.HexToAddress("0x02")
+
+	evm := &TestBundleEVM{nonces: map[common.Address]int64{alice: 5, bob: 0}}
+
+	// the ops of alice are not in nonce order and are bundled with an op of bob in between
+	txms := []indexer.UserOpMessage{
+		testUserOpMessage(alice, 6, []byte{1}),
+		testUserOpMessage(bob, 0, []byte{1}),
+		testUserOpMessage(alice, 5, []byte{1}),
+		testUserOpMessage(alice, 7, []byte{1}),
+	}
+
+	valid, dropped, errs := simulateTest(evm, txms)
+	if len(dropped) != 0 {
+		t.Fatalf("expected no dropped ops, got %d: %v", len(dropped), errs)
+	}
+
+	// the ops of a sender are kept together in nonce order
+	expected := []struct {
+		sender common.Address
+		nonce  int64
+	}{{alice, 5}, {alice, 6}, {alice, 7}, {bob, 0}}
+
+	if len(valid) != len(expected) {
+		t.Fatalf("expected %d ops, got %d", len(expected), len(valid))
+	}
+
+	for i, e := range expected {
+		if valid[i].UserOp.Sender != e.sender || valid[i].UserOp.Nonce.Int64() != e.nonce {
+			t.Errorf("op %d: expected %s %d, got %s %d", i, e.sender.Hex(), e.nonce, valid[i].UserOp.Sender.Hex(), valid[i].UserOp.Nonce.Int64())
+		}
+	}
+}
+
+func TestSimulateBadOp(t *testing.T) {
+	senders := []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.HexToAddress("0x03")}
+
+	evm := &TestBundleEVM{nonces: map[common.Address]int64{}}
+
+	txms := []indexer.UserOpMessage{
+		testUserOpMessage(senders[0], 0, []byte{1}),
+		testUserOpMessage(senders[1], 0, badCallData),
+		testUserOpMessage(senders[2], 0, []byte{1}),
+	}
+
+	valid, dropped, errs := simulateTest(evm, txms)
+	if len(dropped) != 1 || dropped[0].ID != "b" {
+		t.Fatalf("expected the op in the middle to be dropped, got %v", dropped)
+	}
+
+	if len(errs) != 1 || !isNoRetry(errs[0]) {
+		t.Fatalf("expected an error that is not retried, got %v", errs)
+	}
+
+	if len(valid) != 2 || valid[0].UserOp.Sender != senders[0] || valid[1].UserOp.Sender != senders[2] {
+		t.Fatalf("expected the other ops to be kept, got %v", valid)
+	}
+}
+
+func TestSimulateBadOpOfSender(t *testing.T) {
+	alice := common.HexToAddress("0x01")
+
+	evm := &TestBundleEVM{nonces: map[common.Address]int64{alice: 0}}
+
+	// the op after a bad op of the same sender can no longer be valid
+	txms := []indexer.UserOpMessage{
+		testUserOpMessage(alice, 0, []byte{1}),
+		testUserOpMessage(alice, 1, badCallData),
+		testUserOpMessage(alice, 2, []byte{1}),
+	}
+
+	valid, dropped, _ := simulateTest(evm, txms)
+	if len(valid) != 1 || valid[0].UserOp.Nonce.Int64() != 0 {
+		t.Fatalf("expected only the first op to be kept, got %v", valid)
+	}
+
+	if len(dropped) != 2 {
+		t.Fatalf("expected 2 dropped ops, got %d", len(dropped))
+	}
+}
+
+func TestBisect(t *testing.T) {
+	alice := common.HexToAddress("0x01")
+	bob := common.HexToAddress("0x02")
+	carol := common.HexToAddress("0x03")
+
+	evm := &TestBundleEVM{nonces: map[common.Address]int64{}}
+	s := &UserOpService{evm: evm}
+
+	txms := []indexer.UserOpMessage{
+		testUserOpMessage(alice, 0, []byte{1}),
+		testUserOpMessage(alice, 1, []byte{1}),
+		testUserOpMessage(bob, 0, badCallData),
+		testUserOpMessage(carol, 0, []byte{1}),
+		testUserOpMessage(carol, 1, []byte{1}),
+	}
+
+	groups := [][]int{{0, 1}, {2}, {3, 4}}
+
+	valid, reverted, errs := s.bisect(indexer.EntryPointV06, common.Address{}, common.Address{}, txms, groups)
+
+	// the ops of a sender are never split, which would make the second one revert
+	if len(valid) != 2 || len(valid[0]) != 2 || valid[0][0] != 0 || len(valid[1]) != 2 || valid[1][0] != 3 {
+		t.Fatalf("expected the groups of alice and carol to be kept, got %v", valid)
+	}
+
+	if len(reverted) != 1 || reverted[0] != 2 || errs[2] == nil {
+		t.Fatalf("expected the op of bob to revert, got %v", reverted)
+	}
+}
+
+func isNoRetry(err error) bool {
+	_, ok := err.(noRetryError)
+	return ok
+}
+
+func TestResume(t *testing.T) {
+	d, err := db.NewDB(big.NewInt(1337), t.TempDir(), "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	paymaster := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	mock := signer.NewMockSigner()
+
+	key, err := mock.AddSponsor(paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sponsor := crypto.PubkeyToAddress(key.PublicKey)
+
+	// the bundle of alice is still known to the node, the one of bob was dropped
+	tx, err := types.SignTx(newTestTx(4, common.Address{}), types.LatestSignerForChainID(big.NewInt(1337)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evm := &TestNonceEVM{latest: 4, pending: 5, receipts: map[common.Hash]*types.Receipt{}, txs: map[common.Hash]*types.Transaction{tx.Hash(): tx}}
+
+	eps, err := indexer.NewEntryPoints("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewUserOpService(d, evm, nil, mock, NewNonceManager(evm, mock), nil, eps)
+
+	alice := testUserOpMessage(common.HexToAddress("0x02"), 0, []byte{1})
+	bob := testUserOpMessage(common.HexToAddress("0x03"), 0, []byte{1})
+
+	ops := map[string]indexer.UserOpMessage{tx.Hash().Hex(): alice, "0x01": bob}
+	for txHash, txm := range ops {
+		txm.Paymaster = paymaster
+		txm.EntryPoint = indexer.EntryPointV06Address
+		txm.Version = indexer.EntryPointV06
+		txm.ChainId = big.NewInt(1337)
+
+		SetUserOpStatus(d, txm, txHash, indexer.UserOpStatusSubmitted, nil)
+
+		ops[txHash] = txm
+	}
+
+	err = s.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bundle that is known is followed by the nonce manager, its nonce is not handed out again
+	p, ok := s.nonces.sponsors[sponsor].pending[4]
+	if !ok || p.tx.Hash() != tx.Hash() {
+		t.Fatal("expected the known bundle to be followed by the nonce manager")
+	}
+
+	for txHash, status := range map[string]indexer.UserOpStatus{tx.Hash().Hex(): indexer.UserOpStatusSubmitted, "0x01": indexer.UserOpStatusFail} {
+		txm := ops[txHash]
+
+		hash, err := comm.UserOpHash(txm.UserOp, txm.EntryPoint, txm.Version, txm.ChainId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec, err := d.UserOpDB.GetUserOp(hash.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Status != status {
+			t.Errorf("bundle %s: got status %s, want %s", txHash, rec.Status, status)
+		}
+	}
+}
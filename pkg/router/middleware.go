@@ -1,8 +1,10 @@
 package router
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"math/big"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/internal/ws"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	"github.com/citizenwallet/smartcontracts/pkg/contracts/account"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -44,11 +47,23 @@ var (
 		indexer.SignatureHeader,
 		indexer.AddressHeader,
 		indexer.AppVersionHeader,
+		indexer.ResponseVersionHeader,
 	}
 
 	MAGIC_VALUE = [4]byte{0x16, 0x26, 0xba, 0x7e}
 )
 
+// ResponseVersionMiddleware echoes the response version that the client opted in to, error bodies are only sent to clients that did
+func ResponseVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(indexer.ResponseVersionHeader) == comm.ResponseVersionErrors {
+			w.Header().Set(indexer.ResponseVersionHeader, comm.ResponseVersionErrors)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // HealthMiddleware is a middleware that responds to health checks
 func HealthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -144,13 +159,13 @@ func withSignature(evm indexer.EVMRequester, h http.HandlerFunc) http.HandlerFun
 		// check signature
 		signature := r.Header.Get(indexer.SignatureHeader)
 		if signature == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Signature header", nil)
 			return
 		}
 
 		var req signedBody
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeBadRequest, "invalid signed body", nil)
 			return
 		}
 		defer r.Body.Close()
@@ -158,33 +173,21 @@ func withSignature(evm indexer.EVMRequester, h http.HandlerFunc) http.HandlerFun
 		// get address
 		addr := r.Header.Get(indexer.AddressHeader)
 		if addr == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Address header", nil)
 			return
 		}
 
 		haccaddr := common.HexToAddress(addr)
 
+		if req.Expiry < time.Now().UTC().Unix() {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeExpiredSignature, "the signed body has expired", nil)
+			return
+		}
+
 		// check signature
-		switch req.Version {
-		case 0:
-			// LEGACY: remove 3 months from 22/10/2023
-			// reason: verifySignature only verifies the data and not the entire request, the expiry time can be manipulated
-			if !verifySignature(req, haccaddr, signature) {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-		case 2:
-			// DEPRECATED: remove 3 months from 14/11/2023
-			// reason: does not support ERC1271
-			if !verifyV2Signature(req, haccaddr, signature) {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-		default:
-			if !verify1271Signature(evm, req, haccaddr, signature) {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
+		if !verifySignedBody(evm, req, haccaddr, signature) {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeInvalidSignature, "invalid signature", nil)
+			return
 		}
 
 		r.Body = io.NopCloser(strings.NewReader(string(req.Data)))
@@ -204,7 +207,7 @@ func withMultiPartSignature(evm indexer.EVMRequester, h http.HandlerFunc) http.H
 		// check signature
 		signature := r.Header.Get(indexer.SignatureHeader)
 		if signature == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Signature header", nil)
 			return
 		}
 
@@ -212,40 +215,28 @@ func withMultiPartSignature(evm indexer.EVMRequester, h http.HandlerFunc) http.H
 
 		var req signedBody
 		if err := json.Unmarshal([]byte(body), &req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeBadRequest, "invalid signed body", nil)
 			return
 		}
 
 		// get address
 		addr := r.Header.Get(indexer.AddressHeader)
 		if addr == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Address header", nil)
 			return
 		}
 
 		haccaddr := common.HexToAddress(addr)
 
+		if req.Expiry < time.Now().UTC().Unix() {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeExpiredSignature, "the signed body has expired", nil)
+			return
+		}
+
 		// check signature
-		switch req.Version {
-		case 0:
-			// LEGACY: remove 3 months from 22/10/2023
-			// reason: verifySignature only verifies the data and not the entire request, the expiry time can be manipulated
-			if !verifySignature(req, haccaddr, signature) {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-		case 2:
-			// DEPRECATED: remove 3 months from 14/11/2023
-			// reason: does not support ERC1271
-			if !verifyV2Signature(req, haccaddr, signature) {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-		default:
-			if !verify1271Signature(evm, req, haccaddr, signature) {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
+		if !verifySignedBody(evm, req, haccaddr, signature) {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeInvalidSignature, "invalid signature", nil)
+			return
 		}
 
 		r.MultipartForm.Value["body"] = []string{string(req.Data)}
@@ -257,19 +248,112 @@ func withMultiPartSignature(evm indexer.EVMRequester, h http.HandlerFunc) http.H
 	})
 }
 
+// signedQuery is the data of the signed body of a request without a body, the signature is only valid for that request
+type signedQuery struct {
+	Path  string            `json:"path"`
+	Query map[string]string `json:"query"`
+}
+
+// matchesSignedQuery returns true if the signed data is for the path and the query params of the request, apart from the signed body itself
+func matchesSignedQuery(data []byte, r *http.Request) bool {
+	var sq signedQuery
+	if err := json.Unmarshal(data, &sq); err != nil {
+		return false
+	}
+
+	if sq.Path != r.URL.Path {
+		return false
+	}
+
+	q := r.URL.Query()
+	q.Del("body")
+
+	if len(q) != len(sq.Query) {
+		return false
+	}
+
+	for k, v := range q {
+		sv, ok := sq.Query[k]
+		if !ok || len(v) != 1 || v[0] != sv {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withQuerySignature is a middleware that checks the signature of a request without a body, the signed body is sent in the "body" query param
+// and its data is the path and the other query params of the request. Only version 3 signatures are accepted.
+func withQuerySignature(evm indexer.EVMRequester, h http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// check signature
+		signature := r.Header.Get(indexer.SignatureHeader)
+		if signature == "" {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Signature header", nil)
+			return
+		}
+
+		body := r.URL.Query().Get("body")
+
+		var req signedBody
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeBadRequest, "invalid signed body", nil)
+			return
+		}
+
+		// get address
+		addr := r.Header.Get(indexer.AddressHeader)
+		if addr == "" {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Address header", nil)
+			return
+		}
+
+		haccaddr := common.HexToAddress(addr)
+
+		// older versions do not sign the expiry, a captured signature would be valid forever
+		if req.Version < 3 {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeInvalidSignature, "unsupported signature version, version 3 is required", nil)
+			return
+		}
+
+		if req.Expiry < time.Now().UTC().Unix() {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeExpiredSignature, "the signed body has expired", nil)
+			return
+		}
+
+		// check signature
+		if !verify1271Signature(evm, req, haccaddr, signature) {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeInvalidSignature, "invalid signature", nil)
+			return
+		}
+
+		// a signature cannot be used for another request
+		if !matchesSignedQuery(req.Data, r) {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeInvalidSignature, "the signed body does not match the request", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), indexer.ContextKeyAddress, addr)
+		ctx = context.WithValue(ctx, indexer.ContextKeySignature, signature)
+
+		h(w, r.WithContext(ctx))
+		return
+	})
+}
+
 // with1271Signature is a middleware that checks the owner's signature of the request against the request headers and the actual account on-chain
 func with1271Signature(evm indexer.EVMRequester, h http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// parse signature from header
 		signature := r.Header.Get(indexer.SignatureHeader)
 		if signature == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Signature header", nil)
 			return
 		}
 
 		var req signedBody
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			comm.ErrorBody(w, http.StatusBadRequest, comm.ErrorCodeBadRequest, "invalid signed body", nil)
 			return
 		}
 		defer r.Body.Close()
@@ -277,15 +361,20 @@ func with1271Signature(evm indexer.EVMRequester, h http.HandlerFunc) http.Handle
 		// get address
 		addr := r.Header.Get(indexer.AddressHeader)
 		if addr == "" {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeMissingSignature, "missing X-Address header", nil)
 			return
 		}
 
 		haccaddr := common.HexToAddress(addr)
 
+		if req.Expiry < time.Now().UTC().Unix() {
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeExpiredSignature, "the signed body has expired", nil)
+			return
+		}
+
 		// check signature
 		if !verify1271Signature(evm, req, haccaddr, signature) {
-			w.WriteHeader(http.StatusUnauthorized)
+			comm.ErrorBody(w, http.StatusUnauthorized, comm.ErrorCodeInvalidSignature, "invalid signature", nil)
 			return
 		}
 
@@ -300,84 +389,118 @@ func with1271Signature(evm indexer.EVMRequester, h http.HandlerFunc) http.Handle
 	})
 }
 
-// withJSONRPCRequest is a middleware that handles a JSON RPC request
+// withJSONRPCRequest is a middleware that handles a JSON RPC request, every call of a batch gets its own result or error
 func withJSONRPCRequest(hmap map[string]indexer.RPCHandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		var raw json.RawMessage
 		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			comm.JSONRPCBody(w, indexer.NewJSONRPCErrorResponse(nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeParse, "parse error", nil)))
 			return
 		}
 		defer r.Body.Close()
 
-		var singleReq indexer.JsonRPCRequest
-		var multiReq []indexer.JsonRPCRequest
+		// a single call
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '[' {
+			comm.JSONRPCBody(w, handleJSONRPCCall(r, hmap, raw))
+			return
+		}
 
-		// parse request
-		err := json.Unmarshal(raw, &singleReq)
-		if err == nil {
-			multiReq = append(multiReq, singleReq)
-		} else {
-			err = json.Unmarshal(raw, &multiReq)
-			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
+		// handle multi requests
+		var calls []json.RawMessage
+		if err := json.Unmarshal(raw, &calls); err != nil {
+			comm.JSONRPCBody(w, indexer.NewJSONRPCErrorResponse(nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeParse, "parse error", nil)))
+			return
 		}
-		defer r.Body.Close()
 
-		if len(multiReq) == 1 {
-			req := multiReq[0]
+		if len(calls) == 0 {
+			comm.JSONRPCBody(w, indexer.NewJSONRPCErrorResponse(nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidRequest, "empty batch", nil)))
+			return
+		}
 
-			// check if the method is available
-			h, ok := hmap[req.Method]
-			if !ok {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
+		resps := make([]*indexer.JsonRPCResponse, len(calls))
+		for i, call := range calls {
+			resps[i] = handleJSONRPCCall(r, hmap, call)
+		}
 
-			r.Body = io.NopCloser(strings.NewReader(string(req.Params)))
-			r.ContentLength = int64(len([]byte(req.Params)))
+		comm.JSONRPCMultiBody(w, resps)
+	})
+}
 
-			body, statusCode := h(r)
-			if statusCode != 200 {
-				w.WriteHeader(statusCode)
-				return
-			}
+// handleJSONRPCCall calls the handler of a JSON RPC method with the params as the request body
+func handleJSONRPCCall(r *http.Request, hmap map[string]indexer.RPCHandlerFunc, raw json.RawMessage) *indexer.JsonRPCResponse {
+	var req indexer.JsonRPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil || !req.IsValid() {
+		return indexer.NewJSONRPCErrorResponse(req.ID, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidRequest, "invalid request", nil))
+	}
 
-			comm.JSONRPCBody(w, req.ID, body, nil)
-			return
+	// check if the method is available
+	h, ok := hmap[req.Method]
+	if !ok {
+		return indexer.NewJSONRPCErrorResponse(req.ID, indexer.NewJSONRPCErrorf(indexer.JSONRPCErrorCodeMethodNotFound, "method %s does not exist", req.Method))
+	}
+
+	// every call gets its own request so that the body can be read again
+	cr := r.Clone(r.Context())
+	cr.Body = io.NopCloser(bytes.NewReader(req.Params))
+	cr.ContentLength = int64(len(req.Params))
+
+	result, err := h(cr)
+	if err != nil {
+		var rpcErr *indexer.JSONRPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInternal, "internal error", nil)
 		}
 
-		// handle multi requests
-		var ids []int
-		var bodies []any
+		return indexer.NewJSONRPCErrorResponse(req.ID, rpcErr)
+	}
 
-		for _, req := range multiReq {
+	return indexer.NewJSONRPCResult(req.ID, result)
+}
 
-			// check if the method is available
-			h, ok := hmap[req.Method]
-			if !ok {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
+// verifySignedBody verifies the signature of the request with the scheme of its version
+func verifySignedBody(evm indexer.EVMRequester, req signedBody, addr common.Address, signature string) bool {
+	switch req.Version {
+	case 0:
+		// LEGACY: remove 3 months from 22/10/2023
+		// reason: verifySignature only verifies the data and not the entire request, the expiry time can be manipulated
+		return verifySignature(req, addr, signature)
+	case 2:
+		// DEPRECATED: remove 3 months from 14/11/2023
+		// reason: does not support ERC1271
+		return verifyV2Signature(req, addr, signature)
+	default:
+		return verify1271Signature(evm, req, addr, signature)
+	}
+}
 
-			r.Body = io.NopCloser(strings.NewReader(string(req.Params)))
-			r.ContentLength = int64(len([]byte(req.Params)))
+// signedBodyVerifier returns a function that verifies signed requests which are not sent as http requests, legacy and expired signatures are refused
+func signedBodyVerifier(evm indexer.EVMRequester) ws.Verifier {
+	return func(addr, signature string, body json.RawMessage) ([]byte, bool) {
+		if addr == "" || signature == "" {
+			return nil, false
+		}
 
-			body, statusCode := h(r)
-			if statusCode != 200 {
-				w.WriteHeader(statusCode)
-				return
-			}
+		var req signedBody
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, false
+		}
 
-			ids = append(ids, req.ID)
-			bodies = append(bodies, body)
+		// legacy signatures do not cover the expiry, they could be replayed forever
+		if req.Version < 2 {
+			return nil, false
 		}
 
-		comm.JSONRPCMultiBody(w, ids, bodies, nil)
-	})
+		if req.Expiry < time.Now().UTC().Unix() {
+			return nil, false
+		}
+
+		if !verifySignedBody(evm, req, common.HexToAddress(addr), signature) {
+			return nil, false
+		}
+
+		return req.Data, true
+	}
 }
 
 // verifySignature verifies the signature of the request against the request body
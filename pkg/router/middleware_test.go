@@ -1,10 +1,20 @@
 package router
 
 import (
+	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	comm "github.com/citizenwallet/indexer/internal/common"
+	"github.com/citizenwallet/indexer/pkg/indexer"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -73,3 +83,299 @@ func TestSignatureVerification(t *testing.T) {
 		}
 	})
 }
+
+func TestJSONRPCRequest(t *testing.T) {
+	h := withJSONRPCRequest(map[string]indexer.RPCHandlerFunc{
+		"echo": func(r *http.Request) (any, error) {
+			var params []string
+			err := json.NewDecoder(r.Body).Decode(&params)
+			if err != nil || len(params) == 0 {
+				return nil, indexer.NewJSONRPCError(indexer.JSONRPCErrorCodeInvalidParams, "invalid params", nil)
+			}
+
+			return params[0], nil
+		},
+		"null": func(r *http.Request) (any, error) {
+			return nil, nil
+		},
+		"fail": func(r *http.Request) (any, error) {
+			return nil, errors.New("database is down")
+		},
+	})
+
+	call := func(body string) []byte {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		return w.Body.Bytes()
+	}
+
+	t.Run("single", func(t *testing.T) {
+		b := call(`{"jsonrpc":"2.0","id":"a","method":"echo","params":["hello"]}`)
+
+		expected := `{"jsonrpc":"2.0","id":"a","result":"hello"}`
+		if string(b) != expected {
+			t.Fatalf("expected %s, got %s", expected, b)
+		}
+	})
+
+	t.Run("null result", func(t *testing.T) {
+		b := call(`{"jsonrpc":"2.0","id":1,"method":"null","params":[]}`)
+
+		expected := `{"jsonrpc":"2.0","id":1,"result":null}`
+		if string(b) != expected {
+			t.Fatalf("expected %s, got %s", expected, b)
+		}
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		b := call(`{"jsonrpc":`)
+
+		expected := `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`
+		if string(b) != expected {
+			t.Fatalf("expected %s, got %s", expected, b)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		b := call(`[
+			{"jsonrpc":"2.0","id":1,"method":"echo","params":["hello"]},
+			{"jsonrpc":"2.0","id":2,"method":"echo","params":[]},
+			{"jsonrpc":"2.0","id":3,"method":"unknown","params":[]},
+			{"jsonrpc":"2.0","id":4,"method":"fail","params":[]},
+			{"id":5,"method":"echo","params":["hello"]}
+		]`)
+
+		var resps []struct {
+			ID     int                   `json:"id"`
+			Result any                   `json:"result"`
+			Error  *indexer.JSONRPCError `json:"error"`
+		}
+
+		err := json.Unmarshal(b, &resps)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resps) != 5 {
+			t.Fatalf("expected 5 responses, got %d", len(resps))
+		}
+
+		if resps[0].ID != 1 || resps[0].Result != "hello" || resps[0].Error != nil {
+			t.Errorf("unexpected response %+v", resps[0])
+		}
+
+		codes := []int{indexer.JSONRPCErrorCodeInvalidParams, indexer.JSONRPCErrorCodeMethodNotFound, indexer.JSONRPCErrorCodeInternal, indexer.JSONRPCErrorCodeInvalidRequest}
+		for i, code := range codes {
+			resp := resps[i+1]
+			if resp.ID != i+2 || resp.Error == nil || resp.Error.Code != code {
+				t.Errorf("expected error %d for id %d, got %+v", code, i+2, resp)
+			}
+		}
+
+		// internal errors are not sent to the caller
+		if resps[3].Error.Message != "internal error" {
+			t.Errorf("expected internal error, got %s", resps[3].Error.Message)
+		}
+	})
+}
+
+func TestMatchesSignedQuery(t *testing.T) {
+	path := "/logs/v2/transfers/0x01/0x02/export"
+
+	data, err := json.Marshal(signedQuery{
+		Path:  path,
+		Query: map[string]string{"format": "csv", "from": "2024-01-01T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"same request", path + "?format=csv&from=2024-01-01T00:00:00Z&body=x", true},
+		{"other path", "/logs/v2/transfers/0x03/0x02/export?format=csv&from=2024-01-01T00:00:00Z&body=x", false},
+		{"other value", path + "?format=json&from=2024-01-01T00:00:00Z&body=x", false},
+		{"extra param", path + "?format=csv&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&body=x", false},
+		{"missing param", path + "?format=csv&body=x", false},
+		{"repeated param", path + "?format=csv&format=json&from=2024-01-01T00:00:00Z&body=x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if got := matchesSignedQuery(data, r); got != tt.want {
+				t.Errorf("matchesSignedQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if matchesSignedQuery([]byte("not json"), r) {
+		t.Error("matchesSignedQuery() accepted invalid data")
+	}
+}
+
+// signTestBody signs a body with the scheme of its version
+func signTestBody(t *testing.T, k *ecdsa.PrivateKey, body signedBody) string {
+	var h []byte
+	switch body.Version {
+	case 0:
+		h = crypto.Keccak256(body.Data)
+	default:
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h = crypto.Keccak256(b)
+		if body.Version == 3 {
+			h = accounts.TextHash(h)
+		}
+	}
+
+	sig, err := crypto.Sign(h, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Version == 3 {
+		return hexutil.Encode(sig)
+	}
+
+	return compactSignature(sig)
+}
+
+func TestQuerySignatureVersion(t *testing.T) {
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := crypto.PubkeyToAddress(k.PublicKey)
+
+	path := "/gas/0x01"
+
+	data, err := json.Marshal(signedQuery{Path: path, Query: map[string]string{"group": "day"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := withQuerySignature(nil, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		version int
+		want    int
+	}{
+		{"legacy", 0, http.StatusUnauthorized},
+		{"v2", 2, http.StatusUnauthorized},
+		{"v3", 3, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := signedBody{
+				Data:     data,
+				Encoding: BodyEncodingBase64,
+				Expiry:   time.Now().Add(time.Minute).Unix(),
+				Version:  tt.version,
+			}
+
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, path+"?group=day&body="+url.QueryEscape(string(b)), nil)
+			r.Header.Set(indexer.SignatureHeader, signTestBody(t, k, body))
+			r.Header.Set(indexer.AddressHeader, addr.Hex())
+
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestSignedBodyVerifier(t *testing.T) {
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := crypto.PubkeyToAddress(k.PublicKey)
+
+	verify := signedBodyVerifier(nil)
+
+	tests := []struct {
+		name    string
+		version int
+		expiry  time.Time
+		want    bool
+	}{
+		{"legacy", 0, time.Now().Add(time.Minute), false},
+		{"v2", 2, time.Now().Add(time.Minute), true},
+		{"expired", 2, time.Now().Add(-time.Minute), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := signedBody{
+				Data:     []byte(`{"topic":"userops"}`),
+				Encoding: BodyEncodingBase64,
+				Expiry:   tt.expiry.Unix(),
+				Version:  tt.version,
+			}
+
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := verify(addr.Hex(), signTestBody(t, k, body), b); ok != tt.want {
+				t.Errorf("verify() = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseVersionMiddleware(t *testing.T) {
+	h := ResponseVersionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comm.ErrorBody(w, http.StatusNotFound, comm.ErrorCodeNotFound, "not found", nil)
+	}))
+
+	for _, version := range []string{"", comm.ResponseVersionErrors} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if version != "" {
+			r.Header.Set(indexer.ResponseVersionHeader, version)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+
+		if (w.Body.Len() > 0) != (version != "") {
+			t.Fatalf("version %q: unexpected body %q", version, w.Body.String())
+		}
+
+		if w.Header().Get(indexer.ResponseVersionHeader) != version {
+			t.Fatalf("version %q: unexpected response version %q", version, w.Header().Get(indexer.ResponseVersionHeader))
+		}
+	}
+}
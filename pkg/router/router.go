@@ -7,18 +7,25 @@ import (
 	"net/http"
 
 	"github.com/citizenwallet/indexer/internal/accounts"
+	"github.com/citizenwallet/indexer/internal/balances"
 	"github.com/citizenwallet/indexer/internal/chain"
 	"github.com/citizenwallet/indexer/internal/events"
+	"github.com/citizenwallet/indexer/internal/gas"
+	"github.com/citizenwallet/indexer/internal/graph"
 	"github.com/citizenwallet/indexer/internal/logs"
 	"github.com/citizenwallet/indexer/internal/paymaster"
 	"github.com/citizenwallet/indexer/internal/profiles"
 	"github.com/citizenwallet/indexer/internal/push"
 	"github.com/citizenwallet/indexer/internal/services/bucket"
 	"github.com/citizenwallet/indexer/internal/services/db"
+	"github.com/citizenwallet/indexer/internal/sponsorships"
+	"github.com/citizenwallet/indexer/internal/stats"
 	"github.com/citizenwallet/indexer/internal/userop"
 	"github.com/citizenwallet/indexer/internal/version"
+	"github.com/citizenwallet/indexer/internal/ws"
 	"github.com/citizenwallet/indexer/pkg/indexer"
 	"github.com/citizenwallet/indexer/pkg/queue"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -27,13 +34,19 @@ type Router struct {
 	chainId *big.Int
 	evm     indexer.EVMRequester
 	db      *db.DB
+	signer  indexer.Signer
+	eps     indexer.EntryPoints
+	admins  []common.Address
 }
 
-func NewServer(chainId *big.Int, evm indexer.EVMRequester, db *db.DB) *Router {
+func NewServer(chainId *big.Int, evm indexer.EVMRequester, db *db.DB, signer indexer.Signer, eps indexer.EntryPoints, admins []common.Address) *Router {
 	return &Router{
 		chainId,
 		evm,
 		db,
+		signer,
+		eps,
+		admins,
 	}
 }
 
@@ -49,6 +62,7 @@ func (r *Router) AddMiddleware(cr *chi.Mux) *chi.Mux {
 	cr.Use(middleware.Logger)
 
 	// configure custom middleware
+	cr.Use(ResponseVersionMiddleware)
 	cr.Use(OptionsMiddleware)
 	cr.Use(HealthMiddleware)
 	cr.Use(RequestSizeLimitMiddleware(10 << 20)) // Limit request bodies to 10MB
@@ -65,7 +79,9 @@ func (r *Router) AddIndexerRoutes(cr *chi.Mux, b *bucket.Bucket) *chi.Mux {
 	ev := events.NewService(r.db)
 	pr := profiles.NewService(b, r.evm)
 	pu := push.NewService(r.db)
-	acc := accounts.NewService(r.evm, r.db)
+	acc := accounts.NewService(r.evm, r.db, r.signer)
+	st := stats.NewService(r.db)
+	bal := balances.NewService(r.evm, r.db)
 
 	// configure routes
 	cr.Route("/version", func(cr chi.Router) {
@@ -77,8 +93,12 @@ func (r *Router) AddIndexerRoutes(cr *chi.Mux, b *bucket.Bucket) *chi.Mux {
 			cr.Get("/", l.GetAll)
 			cr.Get("/tx/{hash}", l.GetSingle)
 			cr.Get("/new", l.GetAllNew)
+			cr.Get("/search", l.Search)
 			cr.Get("/{acc_addr}", l.Get)
 			cr.Get("/{acc_addr}/new", l.GetNew)
+			cr.Get("/{acc_addr}/search", l.SearchAccount)
+			cr.Get("/{acc_addr}/stream", l.Stream)
+			cr.Get("/{acc_addr}/export", withQuerySignature(r.evm, l.Export))
 
 			cr.Post("/{acc_addr}", withSignature(r.evm, l.AddSending))
 
@@ -86,6 +106,19 @@ func (r *Router) AddIndexerRoutes(cr *chi.Mux, b *bucket.Bucket) *chi.Mux {
 		})
 	})
 
+	cr.Route("/stats", func(cr chi.Router) {
+		cr.Get("/{token_address}", st.Get)
+	})
+
+	cr.Route("/balances", func(cr chi.Router) {
+		cr.Get("/{token_address}/{acc_addr}", bal.Get)
+	})
+
+	cr.Route("/holders", func(cr chi.Router) {
+		cr.Get("/{token_address}", bal.GetHolders)
+		cr.Get("/{token_address}/distribution", bal.GetDistribution)
+	})
+
 	cr.Route("/events", func(cr chi.Router) {
 		cr.Post("/", ev.AddEvent) // TODO: add auth
 	})
@@ -116,19 +149,65 @@ func (r *Router) AddIndexerRoutes(cr *chi.Mux, b *bucket.Bucket) *chi.Mux {
 
 func (r *Router) AddBundlerRoutes(cr *chi.Mux, useropq *queue.Service) *chi.Mux {
 
-	pm := paymaster.NewService(r.evm, r.db)
-	uop := userop.NewService(r.evm, r.db, useropq, r.chainId)
+	pm := paymaster.NewService(r.evm, r.db, r.signer, r.eps)
+	uop := userop.NewService(r.evm, r.db, r.signer, useropq, r.chainId, r.eps)
 	ch := chain.NewService(r.evm, r.chainId)
+	g := gas.NewService(r.db, r.signer)
+	sp := sponsorships.NewService(r.db, r.admins)
 
 	cr.Route("/rpc/{pm_address}", func(cr chi.Router) {
 		cr.Post("/", withJSONRPCRequest(map[string]indexer.RPCHandlerFunc{
-			"pm_sponsorUserOperation":   pm.Sponsor,
-			"pm_ooSponsorUserOperation": pm.OOSponsor,
-			"eth_sendUserOperation":     uop.Send,
-			"eth_chainId":               ch.ChainId,
+			"pm_sponsorUserOperation":      pm.Sponsor,
+			"pm_ooSponsorUserOperation":    pm.OOSponsor,
+			"eth_sendUserOperation":        uop.Send,
+			"eth_estimateUserOperationGas": uop.Estimate,
+			"eth_getUserOperationByHash":   uop.GetByHash,
+			"eth_getUserOperationReceipt":  uop.GetReceipt,
+			"eth_supportedEntryPoints":     uop.SupportedEntryPoints,
+			"cw_sendUserOperationAsync":    uop.SendAsync,
+			"cw_getUserOperationStatus":    uop.Status,
+			"cw_scheduleUserOperation":     uop.Schedule,
+			"eth_chainId":                  ch.ChainId,
 		}))
 	})
 
+	cr.Route("/gas", func(cr chi.Router) {
+		cr.Get("/", withQuerySignature(r.evm, g.GetAll))
+		cr.Get("/{pm_address}", withQuerySignature(r.evm, g.Get))
+	})
+
+	cr.Route("/scheduled/{pm_address}/{acc_addr}", func(cr chi.Router) {
+		cr.Get("/", withQuerySignature(r.evm, uop.GetScheduled))
+		cr.Delete("/{hash}", with1271Signature(r.evm, uop.CancelScheduled))
+	})
+
+	cr.Route("/sponsorships/{pm_address}", func(cr chi.Router) {
+		cr.Get("/", withQuerySignature(r.evm, sp.GetAll))
+		cr.Post("/revoke", with1271Signature(r.evm, sp.Revoke))
+		cr.Post("/expire", with1271Signature(r.evm, sp.Expire))
+	})
+
+	return cr
+}
+
+func (r *Router) AddSubscriptionRoutes(cr *chi.Mux) *chi.Mux {
+
+	sock := ws.NewService(r.db, signedBodyVerifier(r.evm))
+
+	cr.Get("/ws", sock.Connect)
+
+	return cr
+}
+
+func (r *Router) AddGraphQLRoutes(cr *chi.Mux, ipfsURL string) *chi.Mux {
+
+	gr := graph.NewService(r.evm, r.db, ipfsURL)
+
+	cr.Route("/graphql", func(cr chi.Router) {
+		cr.Get("/", gr.Query)
+		cr.Post("/", gr.Query)
+	})
+
 	return cr
 }
 